@@ -23,6 +23,7 @@ import (
 	ethereum "github.com/tomochain/tomochain"
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/crypto"
 	"github.com/tomochain/tomochain/event"
 )
 
@@ -128,6 +129,32 @@ type Wallet interface {
 	SignTxWithPassphrase(account Account, passphrase string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
 }
 
+// HeaderSigner is implemented by wallets which refuse to sign raw hashes for
+// block seals and need the header itself, e.g. to enforce slashing protection.
+type HeaderSigner interface {
+	// SignHeader requests the wallet to sign the seal hash of the given header.
+	SignHeader(account Account, header *types.Header) ([]byte, error)
+}
+
+// OverlaySigner is implemented by wallets which refuse to sign raw hashes and
+// need the node ids bound by a masternode overlay proof to sign it.
+type OverlaySigner interface {
+	// SignOverlayProof requests the wallet to sign the proof the node with id
+	// from sends to the node with id to, see OverlayProofHash.
+	SignOverlayProof(account Account, from, to []byte) ([]byte, error)
+}
+
+// overlayProofPrefix domain-separates the masternode overlay proofs from any
+// other hash signed with a masternode key.
+var overlayProofPrefix = []byte("tomochain masternode overlay")
+
+// OverlayProofHash returns the hash a masternode signs to prove its key to the
+// peer it is talking to. Binding both node ids keeps the proof from being
+// replayed on any other connection.
+func OverlayProofHash(from, to []byte) []byte {
+	return crypto.Keccak256(overlayProofPrefix, from, to)
+}
+
 // Backend is a "wallet provider" that may contain a batch of accounts they can
 // sign transactions with and upon request, do so.
 type Backend interface {
@@ -0,0 +1,142 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tomochain/tomochain/accounts"
+	"github.com/tomochain/tomochain/accounts/keystore"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/rpc"
+)
+
+func newTestWallet(t *testing.T, chainID *big.Int) (*wallet, accounts.Account, func()) {
+	dir, err := ioutil.TempDir("", "remote-signer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Unlock(account, ""); err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	for _, api := range NewSigner(ks, rawdb.NewMemoryDatabase(), chainID).APIs() {
+		if err := server.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := newWallet("test", rpc.DialInProc(server))
+	return w, account, func() {
+		server.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSignHeader(t *testing.T) {
+	w, account, cleanup := newTestWallet(t, nil)
+	defer cleanup()
+
+	if !w.Contains(account) {
+		t.Fatalf("wallet doesn't contain the signer account")
+	}
+	header := &types.Header{Number: big.NewInt(10), Time: big.NewInt(1), Extra: make([]byte, 32+65)}
+	sig, err := w.SignHeader(account, header)
+	if err != nil {
+		t.Fatalf("failed to sign header: %v", err)
+	}
+	pubkey, err := crypto.SigToPub(posv.SigHash(header).Bytes(), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer := crypto.PubkeyToAddress(*pubkey); signer != account.Address {
+		t.Fatalf("signer mismatch: have %x, want %x", signer, account.Address)
+	}
+	// Signing the same header again is harmless
+	if _, err := w.SignHeader(account, header); err != nil {
+		t.Fatalf("failed to sign the same header again: %v", err)
+	}
+	// Signing a different header at the same height is not
+	fork := types.CopyHeader(header)
+	fork.Time = big.NewInt(2)
+	if _, err := w.SignHeader(account, fork); err == nil || !strings.Contains(err.Error(), ErrDoubleSign.Error()) {
+		t.Fatalf("double sign error mismatch: have %v, want %v", err, ErrDoubleSign)
+	}
+	fork.Number = big.NewInt(11)
+	if _, err := w.SignHeader(account, fork); err != nil {
+		t.Fatalf("failed to sign the next header: %v", err)
+	}
+	if _, err := w.SignHash(account, make([]byte, 32)); err != ErrHashSigning {
+		t.Fatalf("hash signing error mismatch: have %v, want %v", err, ErrHashSigning)
+	}
+}
+
+func TestSignTx(t *testing.T) {
+	chainID := big.NewInt(88)
+	w, account, cleanup := newTestWallet(t, chainID)
+	defer cleanup()
+
+	tx := types.NewTransaction(0, common.HexToAddress(common.BlockSigners), big.NewInt(0), 200000, big.NewInt(0), nil)
+	signed, err := w.SignTx(account, tx, chainID)
+	if err != nil {
+		t.Fatalf("failed to sign block signer tx: %v", err)
+	}
+	if from, _ := types.Sender(types.NewEIP155Signer(chainID), signed); from != account.Address {
+		t.Fatalf("sender mismatch: have %x, want %x", from, account.Address)
+	}
+	if _, err := w.SignTx(account, tx, big.NewInt(89)); err == nil || !strings.Contains(err.Error(), ErrWrongChain.Error()) {
+		t.Fatalf("wrong chain error mismatch: have %v, want %v", err, ErrWrongChain)
+	}
+	transfer := types.NewTransaction(0, common.HexToAddress("0x01"), big.NewInt(1), 21000, big.NewInt(0), nil)
+	if _, err := w.SignTx(account, transfer, chainID); err == nil || !strings.Contains(err.Error(), ErrForbiddenTx.Error()) {
+		t.Fatalf("forbidden tx error mismatch: have %v, want %v", err, ErrForbiddenTx)
+	}
+}
+
+func TestSignOverlayProof(t *testing.T) {
+	w, account, cleanup := newTestWallet(t, nil)
+	defer cleanup()
+
+	from, to := make([]byte, 64), make([]byte, 64)
+	from[0], to[0] = 1, 2
+	sig, err := w.SignOverlayProof(account, from, to)
+	if err != nil {
+		t.Fatalf("failed to sign overlay proof: %v", err)
+	}
+	pubkey, err := crypto.SigToPub(accounts.OverlayProofHash(from, to), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer := crypto.PubkeyToAddress(*pubkey); signer != account.Address {
+		t.Fatalf("signer mismatch: have %x, want %x", signer, account.Address)
+	}
+	// Anything but two node ids is refused, raw hashes can't be smuggled in
+	if _, err := w.SignOverlayProof(account, make([]byte, 32), nil); err == nil {
+		t.Fatalf("malformed overlay proof signed")
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/tomochain/tomochain/accounts"
+	"github.com/tomochain/tomochain/accounts/keystore"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/common/hexutil"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/ethdb"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/rlp"
+	"github.com/tomochain/tomochain/rpc"
+)
+
+const (
+	// extraSeal is the length of the seal signature at the end of the extra data.
+	extraSeal = 65
+
+	// nodeIDLength is the length of the p2p node ids bound by overlay proofs.
+	nodeIDLength = 64
+)
+
+// signedHeaderPrefix + account + number (uint64 big endian) -> seal hash
+var signedHeaderPrefix = []byte("remote-signer-header-")
+
+var (
+	// ErrDoubleSign is returned if the account already signed a different
+	// header at the same height.
+	ErrDoubleSign = errors.New("refusing to sign a second header at the same height")
+
+	// ErrForbiddenTx is returned for transactions a masternode doesn't send.
+	ErrForbiddenTx = errors.New("transaction not allowed by the signer policy")
+
+	// ErrWrongChain is returned for transactions signed for another chain.
+	ErrWrongChain = errors.New("transaction for another chain")
+)
+
+// Signer is the reference remote signer. It signs with the unlocked accounts of
+// a keystore and records every header it signs, so an account never signs two
+// different headers at the same height, even across restarts.
+type Signer struct {
+	ks      *keystore.KeyStore
+	db      ethdb.KeyValueStore // Slashing protection records
+	chainID *big.Int            // Chain the transactions must be signed for, nil for any
+
+	lock sync.Mutex // Serializes the slashing protection check and record
+}
+
+// NewSigner creates a signer using the keys of the keystore and keeping the
+// slashing protection records in db.
+func NewSigner(ks *keystore.KeyStore, db ethdb.KeyValueStore, chainID *big.Int) *Signer {
+	return &Signer{ks: ks, db: db, chainID: chainID}
+}
+
+// APIs returns the RPC services of the signer.
+func (s *Signer) APIs() []rpc.API {
+	return []rpc.API{{
+		Namespace: "signer",
+		Version:   "1.0",
+		Service:   &PublicSignerAPI{s},
+		Public:    true,
+	}}
+}
+
+// Accounts returns the addresses of the keys held by the signer.
+func (s *Signer) Accounts() []common.Address {
+	var addrs []common.Address
+	for _, account := range s.ks.Accounts() {
+		addrs = append(addrs, account.Address)
+	}
+	return addrs
+}
+
+// SignHeader signs the seal hash of the header with the account, unless the
+// account already signed a different header at the same height.
+func (s *Signer) SignHeader(account common.Address, header *types.Header) ([]byte, error) {
+	if header.Number == nil || len(header.Extra) < extraSeal {
+		return nil, errors.New("malformed header")
+	}
+	hash := posv.SigHash(header)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := signedHeaderKey(account, header.Number.Uint64())
+	if prev, err := s.db.Get(key); err == nil {
+		if common.BytesToHash(prev) != hash {
+			log.Warn("Refused to double sign", "account", account, "number", header.Number, "signed", common.BytesToHash(prev), "requested", hash)
+			return nil, ErrDoubleSign
+		}
+	} else if err := s.db.Put(key, hash.Bytes()); err != nil {
+		// Never sign anything that isn't recorded
+		return nil, err
+	}
+	sig, err := s.ks.SignHash(accounts.Account{Address: account}, hash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Signed header", "account", account, "number", header.Number, "hash", hash)
+	return sig, nil
+}
+
+// SignOverlayProof signs the masternode overlay proof the node with id from
+// sends to the node with id to. Only the proof hash of two node ids is ever
+// signed, so the request can't be used to sign anything else.
+func (s *Signer) SignOverlayProof(account common.Address, from, to []byte) ([]byte, error) {
+	if len(from) != nodeIDLength || len(to) != nodeIDLength {
+		return nil, errors.New("malformed node id")
+	}
+	sig, err := s.ks.SignHash(accounts.Account{Address: account}, accounts.OverlayProofHash(from, to))
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Signed overlay proof", "account", account, "from", hexutil.Bytes(from[:8]), "to", hexutil.Bytes(to[:8]))
+	return sig, nil
+}
+
+// SignTx signs a block signer or randomize transaction of the account. Other
+// transactions are refused, a masternode doesn't need to send them.
+func (s *Signer) SignTx(account common.Address, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	if s.chainID != nil && (chainID == nil || chainID.Cmp(s.chainID) != 0) {
+		return nil, ErrWrongChain
+	}
+	if !tx.IsSpecialTransaction() || tx.Value().Sign() != 0 {
+		return nil, ErrForbiddenTx
+	}
+	signed, err := s.ks.SignTx(accounts.Account{Address: account}, tx, chainID)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Signed transaction", "account", account, "to", tx.To(), "nonce", tx.Nonce())
+	return signed, nil
+}
+
+func signedHeaderKey(account common.Address, number uint64) []byte {
+	key := append(append([]byte{}, signedHeaderPrefix...), account.Bytes()...)
+	return append(key, encodeNumber(number)...)
+}
+
+func encodeNumber(number uint64) []byte {
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, number)
+	return enc
+}
+
+// PublicSignerAPI is the RPC interface of the signer served to nodes.
+type PublicSignerAPI struct {
+	s *Signer
+}
+
+// Accounts returns the addresses the signer can sign for.
+func (api *PublicSignerAPI) Accounts() []common.Address {
+	return api.s.Accounts()
+}
+
+// SignHeader signs the seal hash of the RLP encoded header.
+func (api *PublicSignerAPI) SignHeader(account common.Address, data hexutil.Bytes) (hexutil.Bytes, error) {
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(data, header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	return api.s.SignHeader(account, header)
+}
+
+// SignOverlayProof signs the masternode overlay proof between two node ids.
+func (api *PublicSignerAPI) SignOverlayProof(account common.Address, from, to hexutil.Bytes) (hexutil.Bytes, error) {
+	return api.s.SignOverlayProof(account, from, to)
+}
+
+// SignTransaction signs the RLP encoded transaction and returns it encoded.
+func (api *PublicSignerAPI) SignTransaction(account common.Address, data hexutil.Bytes, chainID *hexutil.Big) (hexutil.Bytes, error) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(data, tx); err != nil {
+		return nil, fmt.Errorf("invalid transaction: %v", err)
+	}
+	signed, err := api.s.SignTx(account, tx, (*big.Int)(chainID))
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(signed)
+}
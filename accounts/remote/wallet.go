@@ -0,0 +1,226 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package remote implements an account backend delegating signatures to a
+// signer running outside of the node, and the reference signer serving it.
+//
+// The signer never signs raw hashes: block seals are requested with the full
+// header so the signer can refuse to sign two different headers at the same
+// height, masternode overlay proofs with the node ids they bind, and
+// transactions are limited to the ones a masternode sends.
+package remote
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	ethereum "github.com/tomochain/tomochain"
+	"github.com/tomochain/tomochain/accounts"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/common/hexutil"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/event"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/rlp"
+	"github.com/tomochain/tomochain/rpc"
+)
+
+// Scheme is the protocol scheme prefixing remote signer wallet URLs.
+const Scheme = "remote"
+
+// ErrHashSigning is returned for raw hash signing requests, which the remote
+// signer doesn't serve.
+var ErrHashSigning = errors.New("remote signer only signs headers, overlay proofs and transactions")
+
+// Backend is an accounts.Backend holding the wallet of a single remote signer.
+type Backend struct {
+	wallets []accounts.Wallet
+	feed    event.Feed
+}
+
+// NewBackend connects to the remote signer listening on the given IPC path or
+// HTTP/WebSocket URL.
+func NewBackend(endpoint string) (*Backend, error) {
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{wallets: []accounts.Wallet{newWallet(endpoint, client)}}, nil
+}
+
+// Wallets implements accounts.Backend, returning the remote signer wallet.
+func (b *Backend) Wallets() []accounts.Wallet {
+	return b.wallets
+}
+
+// Subscribe implements accounts.Backend. The wallet of a remote signer never
+// comes or goes, so no events are ever sent.
+func (b *Backend) Subscribe(sink chan<- accounts.WalletEvent) event.Subscription {
+	return b.feed.Subscribe(sink)
+}
+
+// wallet is an accounts.Wallet whose keys live in a remote signer.
+type wallet struct {
+	url    accounts.URL
+	client *rpc.Client
+
+	lock     sync.Mutex
+	accounts []accounts.Account // Accounts served by the signer, nil until fetched
+}
+
+func newWallet(endpoint string, client *rpc.Client) *wallet {
+	return &wallet{
+		url:    accounts.URL{Scheme: Scheme, Path: endpoint},
+		client: client,
+	}
+}
+
+// URL implements accounts.Wallet, returning the endpoint of the signer.
+func (w *wallet) URL() accounts.URL {
+	return w.url
+}
+
+// Status implements accounts.Wallet, reporting whether the signer is reachable.
+func (w *wallet) Status() (string, error) {
+	var addrs []common.Address
+	if err := w.client.Call(&addrs, "signer_accounts"); err != nil {
+		return "Offline", err
+	}
+	return "Online", nil
+}
+
+// Open implements accounts.Wallet. The keys are unlocked in the signer itself,
+// so there is nothing to open.
+func (w *wallet) Open(passphrase string) error {
+	return nil
+}
+
+// Close implements accounts.Wallet, it is a noop as the connection is shared
+// with the backend for the lifetime of the node.
+func (w *wallet) Close() error {
+	return nil
+}
+
+// Accounts implements accounts.Wallet, returning the accounts of the signer.
+// The list is fetched on first use and kept afterwards.
+func (w *wallet) Accounts() []accounts.Account {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.accounts == nil {
+		var addrs []common.Address
+		if err := w.client.Call(&addrs, "signer_accounts"); err != nil {
+			log.Warn("Failed to list remote signer accounts", "url", w.url, "err", err)
+			return nil
+		}
+		w.accounts = make([]accounts.Account, len(addrs))
+		for i, addr := range addrs {
+			w.accounts[i] = accounts.Account{Address: addr, URL: w.url}
+		}
+	}
+	cpy := make([]accounts.Account, len(w.accounts))
+	copy(cpy, w.accounts)
+	return cpy
+}
+
+// Contains implements accounts.Wallet, returning whether the signer holds the
+// key of the account.
+func (w *wallet) Contains(account accounts.Account) bool {
+	for _, a := range w.Accounts() {
+		if a.Address == account.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// Derive implements accounts.Wallet, remote signers are not hierarchical.
+func (w *wallet) Derive(path accounts.DerivationPath, pin bool) (accounts.Account, error) {
+	return accounts.Account{}, accounts.ErrNotSupported
+}
+
+// SelfDerive implements accounts.Wallet, remote signers are not hierarchical.
+func (w *wallet) SelfDerive(base accounts.DerivationPath, chain ethereum.ChainStateReader) {
+}
+
+// SignHash implements accounts.Wallet. Raw hashes are never signed, block
+// seals go through SignHeader and overlay proofs through SignOverlayProof.
+func (w *wallet) SignHash(account accounts.Account, hash []byte) ([]byte, error) {
+	return nil, ErrHashSigning
+}
+
+// SignHeader implements accounts.HeaderSigner, requesting the seal signature
+// of the header from the signer.
+func (w *wallet) SignHeader(account accounts.Account, header *types.Header) ([]byte, error) {
+	if !w.Contains(account) {
+		return nil, accounts.ErrUnknownAccount
+	}
+	data, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		return nil, err
+	}
+	var sig hexutil.Bytes
+	if err := w.client.Call(&sig, "signer_signHeader", account.Address, hexutil.Bytes(data)); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// SignOverlayProof implements accounts.OverlaySigner, requesting the overlay
+// proof of the connection between the two nodes from the signer.
+func (w *wallet) SignOverlayProof(account accounts.Account, from, to []byte) ([]byte, error) {
+	if !w.Contains(account) {
+		return nil, accounts.ErrUnknownAccount
+	}
+	var sig hexutil.Bytes
+	if err := w.client.Call(&sig, "signer_signOverlayProof", account.Address, hexutil.Bytes(from), hexutil.Bytes(to)); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// SignTx implements accounts.Wallet, requesting the signed transaction from
+// the signer.
+func (w *wallet) SignTx(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	if !w.Contains(account) {
+		return nil, accounts.ErrUnknownAccount
+	}
+	data, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, err
+	}
+	var signed hexutil.Bytes
+	if err := w.client.Call(&signed, "signer_signTransaction", account.Address, hexutil.Bytes(data), (*hexutil.Big)(chainID)); err != nil {
+		return nil, err
+	}
+	result := new(types.Transaction)
+	if err := rlp.DecodeBytes(signed, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SignHashWithPassphrase implements accounts.Wallet, passphrases are handled
+// by the signer itself.
+func (w *wallet) SignHashWithPassphrase(account accounts.Account, passphrase string, hash []byte) ([]byte, error) {
+	return nil, accounts.ErrNotSupported
+}
+
+// SignTxWithPassphrase implements accounts.Wallet, passphrases are handled by
+// the signer itself.
+func (w *wallet) SignTxWithPassphrase(account accounts.Account, passphrase string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return nil, accounts.ErrNotSupported
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/tomochain/tomochain/cmd/utils"
+	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/tomox"
+	"github.com/tomochain/tomochain/tomoxlending"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var checkdexCommand = cli.Command{
+	Action:    utils.MigrateFlags(checkdex),
+	Name:      "checkdex",
+	Usage:     "Verify the TomoX and lending invariants of a range of blocks",
+	ArgsUsage: "[<fromBlock> [<toBlock>]]",
+	Flags: []cli.Flag{
+		utils.DataDirFlag,
+		utils.CacheFlag,
+		utils.TomoXDataDirFlag,
+		utils.TomoTestnetFlag,
+	},
+	Category: "BLOCKCHAIN COMMANDS",
+	Description: `
+The checkdex command verifies the state of the exchange after each block of the
+range, the current block by default:
+  - the collateral locked by the lending trades sums up to the balances of the
+    lending lock address
+  - the orders of the trading and lending books are stored with the quantity
+    they are listed with, and a consistent filled amount
+  - every open lending trade is listed exactly once in the liquidation time tree
+    and, unless it's backed by a collateral basket, in the liquidation price tree
+  - the relayer deposits cover their fees
+
+Every violation is printed with the offending hash. The command exits with a
+non-zero status if any invariant is violated or a state is missing, so it can
+run in CI against generated chains.`,
+}
+
+func checkdex(ctx *cli.Context) error {
+	if len(ctx.Args()) > 2 {
+		utils.Fatalf("This command requires at most two arguments.")
+	}
+	stack, cfg := makeConfigNode(ctx)
+	chain, chainDb := utils.MakeChain(ctx, stack)
+	defer chainDb.Close()
+
+	from, to := chain.CurrentBlock().NumberU64(), chain.CurrentBlock().NumberU64()
+	if len(ctx.Args()) > 0 {
+		from = parseBlockNumber(ctx.Args().Get(0))
+		to = from
+	}
+	if len(ctx.Args()) > 1 {
+		to = parseBlockNumber(ctx.Args().Get(1))
+	}
+	if from > to {
+		utils.Fatalf("Invalid block range %d-%d", from, to)
+	}
+
+	tomoX := tomox.New(&cfg.TomoX)
+	lending := tomoxlending.New(tomoX)
+	violations := 0
+	for number := from; number <= to; number++ {
+		block := chain.GetBlockByNumber(number)
+		if block == nil {
+			utils.Fatalf("Block %d not found", number)
+		}
+		found, err := checkBlockInvariants(chain, tomoX, lending, block)
+		if err != nil {
+			utils.Fatalf("Failed to check block %d: %v", number, err)
+		}
+		for _, violation := range found {
+			fmt.Printf("block %d %x: %s\n", number, block.Hash(), violation)
+		}
+		violations += len(found)
+	}
+	if violations > 0 {
+		utils.Fatalf("%d invariant violations found in blocks %d-%d", violations, from, to)
+	}
+	fmt.Printf("No invariant violation found in blocks %d-%d\n", from, to)
+	return nil
+}
+
+// checkBlockInvariants verifies the states of the exchange after a block. The
+// blocks before TomoX have no exchange state, they are skipped.
+func checkBlockInvariants(chain *core.BlockChain, tomoX *tomox.TomoX, lending *tomoxlending.Lending, block *types.Block) ([]tomox.InvariantViolation, error) {
+	config := chain.Config()
+	if !config.IsTIPTomoX(block.Number()) || config.Posv == nil || block.NumberU64() <= config.Posv.Epoch {
+		return nil, nil
+	}
+	author, err := chain.Engine().Author(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	statedb, err := chain.StateAt(block.Root())
+	if err != nil {
+		return nil, err
+	}
+	if !tomoX.HasTradingState(block, author) {
+		return nil, fmt.Errorf("trading state not found")
+	}
+	tradingState, err := tomoX.GetTradingState(block, author)
+	if err != nil {
+		return nil, err
+	}
+	violations, err := tomox.CheckTradingInvariants(statedb, tradingState)
+	if err != nil || !config.IsTIPTomoXLending(block.Number()) {
+		return violations, err
+	}
+	if !lending.HasLendingState(block, author) {
+		return nil, fmt.Errorf("lending state not found")
+	}
+	lendingState, err := lending.GetLendingState(block, author)
+	if err != nil {
+		return nil, err
+	}
+	lendingViolations, err := tomoxlending.CheckLendingInvariants(statedb, tradingState, lendingState)
+	if err != nil {
+		return nil, err
+	}
+	return append(violations, lendingViolations...), nil
+}
+
+func parseBlockNumber(arg string) uint64 {
+	number, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		utils.Fatalf("Invalid block number %q: %v", arg, err)
+	}
+	return number
+}
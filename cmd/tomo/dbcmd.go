@@ -0,0 +1,377 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/tomochain/tomochain/cmd/utils"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/ethdb"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/params"
+	"github.com/tomochain/tomochain/tomox"
+	"github.com/tomochain/tomochain/tomoxlending"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	dbFlags = []cli.Flag{
+		utils.DataDirFlag,
+		utils.AncientFlag,
+		utils.DBEngineFlag,
+		utils.CacheFlag,
+		utils.TomoTestnetFlag,
+	}
+
+	dbCommand = cli.Command{
+		Name:     "db",
+		Usage:    "Low level database operations",
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+
+Inspect, verify and fix the chain database of a stopped node. The node must not
+be running, as the database is opened exclusively.`,
+		Subcommands: []cli.Command{
+			{
+				Name:   "inspect",
+				Usage:  "Show the size of the databases broken down by kind of data",
+				Action: utils.MigrateFlags(dbInspect),
+				Flags:  append(dbFlags, utils.TomoXDataDirFlag),
+				Description: `
+    tomo db inspect
+
+Iterates the whole chain database and reports the number and size of the
+headers, bodies, receipts, transaction lookups, state trie nodes, posv
+snapshots and other entries, along with the ancient tables. The TomoX database
+holding the trading and lending tries and the reward records are reported too.`,
+			},
+			{
+				Name:   "check",
+				Usage:  "Check the consistency of the chain database",
+				Action: utils.MigrateFlags(dbCheck),
+				Flags:  dbFlags,
+				Description: `
+    tomo db check
+
+Verifies that every canonical hash up to the head header points to a stored
+header linked to its canonical parent and that none is left above the head,
+that every canonical block up to the head block has its body and receipts, and
+that every transaction lookup points to a canonical block including the
+transaction. Every issue is printed; the command exits with a non-zero status
+if any is found.`,
+			},
+			{
+				Name:      "get",
+				Usage:     "Show the value of a database key",
+				ArgsUsage: "<hex-key>",
+				Action:    utils.MigrateFlags(dbGet),
+				Flags:     dbFlags,
+			},
+			{
+				Name:      "put",
+				Usage:     "Set the value of a database key (WARNING: may corrupt your database)",
+				ArgsUsage: "<hex-key> <hex-value>",
+				Action:    utils.MigrateFlags(dbPut),
+				Flags:     dbFlags,
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete a database key (WARNING: may corrupt your database)",
+				ArgsUsage: "<hex-key>",
+				Action:    utils.MigrateFlags(dbDelete),
+				Flags:     dbFlags,
+			},
+			{
+				Name:   "repair",
+				Usage:  "Rewind the head block to the last block with full state",
+				Action: utils.MigrateFlags(dbRepair),
+				Flags:  append(dbFlags, utils.TomoXDataDirFlag),
+				Description: `
+    tomo db repair
+
+Walks back from the head block to the most recent block whose state is fully
+available, including the TomoX trading and lending states once they are
+active, and makes it the new head block. Headers and bodies above it are kept
+and get re-processed when the node syncs again.`,
+			},
+		},
+	}
+)
+
+func dbInspect(ctx *cli.Context) error {
+	stack, cfg := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	stats, err := core.InspectDatabase(chainDb)
+	if err != nil {
+		utils.Fatalf("Failed to inspect chain database: %v", err)
+	}
+	printDatabaseStats("Chain database", stats)
+
+	// The TomoX database only exists on nodes that ever processed TomoX blocks
+	if rawdb.PreexistingDatabase(cfg.TomoX.DataDir) != "" {
+		tomoxDb, err := rawdb.Open(rawdb.OpenOptions{Directory: cfg.TomoX.DataDir, Namespace: "tomox/db/", Handles: utils.MakeDatabaseHandles()})
+		if err != nil {
+			utils.Fatalf("Could not open TomoX database: %v", err)
+		}
+		defer tomoxDb.Close()
+
+		stats, err := inspectTomoXDatabase(tomoxDb)
+		if err != nil {
+			utils.Fatalf("Failed to inspect TomoX database: %v", err)
+		}
+		printDatabaseStats("TomoX database", stats)
+	}
+	rewards, err := inspectRewardRecords(filepath.Join(stack.DataDir(), "tomo", "rewards"))
+	if err != nil {
+		utils.Fatalf("Failed to inspect reward records: %v", err)
+	}
+	printDatabaseStats("Reward records", []core.DatabaseStat{rewards})
+	return nil
+}
+
+// inspectTomoXDatabase tallies the entries of the TomoX database. The trading and
+// lending tries share the database, their nodes are keyed by hash.
+func inspectTomoXDatabase(db ethdb.Database) ([]core.DatabaseStat, error) {
+	var (
+		tries = core.DatabaseStat{Name: "TomoX and lending trie nodes"}
+		other = core.DatabaseStat{Name: "Other"}
+	)
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+
+	for it.Next() {
+		size := common.StorageSize(len(it.Key()) + len(it.Value()))
+		if len(it.Key()) == common.HashLength {
+			tries.Count++
+			tries.Size += size
+		} else {
+			other.Count++
+			other.Size += size
+		}
+	}
+	return []core.DatabaseStat{tries, other}, it.Error()
+}
+
+// inspectRewardRecords tallies the per-checkpoint reward files stored by posv.
+func inspectRewardRecords(dir string) (core.DatabaseStat, error) {
+	stat := core.DatabaseStat{Name: "Reward records"}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		stat.Count++
+		stat.Size += common.StorageSize(info.Size())
+		return nil
+	})
+	return stat, err
+}
+
+func printDatabaseStats(title string, stats []core.DatabaseStat) {
+	var (
+		count uint64
+		size  common.StorageSize
+	)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{title, "Items", "Size"})
+	for _, stat := range stats {
+		table.Append([]string{stat.Name, fmt.Sprintf("%d", stat.Count), stat.Size.String()})
+		count += stat.Count
+		size += stat.Size
+	}
+	table.SetFooter([]string{"Total", fmt.Sprintf("%d", count), size.String()})
+	table.Render()
+}
+
+func dbCheck(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	issues, err := core.CheckDatabase(chainDb, func(issue core.DatabaseIssue) {
+		fmt.Printf("block %d %x: %s\n", issue.Number, issue.Hash, issue.Reason)
+	})
+	if err != nil {
+		utils.Fatalf("Failed to check chain database: %v", err)
+	}
+	if issues > 0 {
+		utils.Fatalf("%d issues found in the chain database", issues)
+	}
+	fmt.Println("No issue found in the chain database")
+	return nil
+}
+
+func dbGet(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires a single key argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	key := parseHexBytes(ctx.Args().Get(0))
+	value, err := chainDb.Get(key)
+	if err != nil {
+		utils.Fatalf("Failed to get key %x: %v", key, err)
+	}
+	fmt.Printf("%#x\n", value)
+	return nil
+}
+
+func dbPut(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		utils.Fatalf("This command requires a key and a value argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	key, value := parseHexBytes(ctx.Args().Get(0)), parseHexBytes(ctx.Args().Get(1))
+	if prev, err := chainDb.Get(key); err == nil {
+		fmt.Printf("Previous value: %#x\n", prev)
+	}
+	if err := chainDb.Put(key, value); err != nil {
+		utils.Fatalf("Failed to put key %x: %v", key, err)
+	}
+	return nil
+}
+
+func dbDelete(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires a single key argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	key := parseHexBytes(ctx.Args().Get(0))
+	if prev, err := chainDb.Get(key); err == nil {
+		fmt.Printf("Previous value: %#x\n", prev)
+	}
+	if err := chainDb.Delete(key); err != nil {
+		utils.Fatalf("Failed to delete key %x: %v", key, err)
+	}
+	return nil
+}
+
+func parseHexBytes(arg string) []byte {
+	if strings.HasPrefix(arg, "0x") || strings.HasPrefix(arg, "0X") {
+		arg = arg[2:]
+	}
+	b, err := hex.DecodeString(arg)
+	if err != nil || len(b) == 0 {
+		utils.Fatalf("Invalid hex argument %q", arg)
+	}
+	return b
+}
+
+func dbRepair(ctx *cli.Context) error {
+	stack, cfg := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	config, _, err := core.SetupGenesisBlock(chainDb, utils.MakeGenesis(ctx))
+	if err != nil {
+		utils.Fatalf("%v", err)
+	}
+	head := core.GetHeadBlockHash(chainDb)
+	number := core.GetBlockNumber(chainDb, head)
+	block := core.GetBlock(chainDb, head, number)
+	if block == nil {
+		utils.Fatalf("Head block %x not found", head)
+	}
+	// The exchange states are only checked if the node ever stored them
+	var (
+		engine  *posv.Posv
+		tomoX   *tomox.TomoX
+		lending *tomoxlending.Lending
+	)
+	if config.Posv != nil && rawdb.PreexistingDatabase(cfg.TomoX.DataDir) != "" {
+		engine = posv.New(config.Posv, chainDb)
+		tomoX = tomox.New(&cfg.TomoX)
+		lending = tomoxlending.New(tomoX)
+	}
+	statedb := state.NewDatabase(chainDb)
+	for {
+		ok, err := hasFullState(config, statedb, engine, tomoX, lending, block)
+		if err != nil {
+			utils.Fatalf("Failed to check the state of block %d: %v", block.NumberU64(), err)
+		}
+		if ok {
+			break
+		}
+		if block.NumberU64() == 0 {
+			utils.Fatalf("No block with full state found")
+		}
+		parent := core.GetBlock(chainDb, block.ParentHash(), block.NumberU64()-1)
+		if parent == nil {
+			utils.Fatalf("Block %d %x not found", block.NumberU64()-1, block.ParentHash())
+		}
+		block = parent
+	}
+	if block.Hash() == head {
+		fmt.Printf("Head block %d %x has full state, nothing to repair\n", block.NumberU64(), block.Hash())
+		return nil
+	}
+	if err := core.WriteHeadBlockHash(chainDb, block.Hash()); err != nil {
+		utils.Fatalf("Failed to write head block: %v", err)
+	}
+	if fast := core.GetHeadFastBlockHash(chainDb); core.GetBlockNumber(chainDb, fast) > block.NumberU64() {
+		if err := core.WriteHeadFastBlockHash(chainDb, block.Hash()); err != nil {
+			utils.Fatalf("Failed to write head fast block: %v", err)
+		}
+	}
+	log.Info("Rewound head block", "from", number, "to", block.NumberU64(), "hash", block.Hash())
+	fmt.Printf("Head block rewound from %d to %d %x\n", number, block.NumberU64(), block.Hash())
+	return nil
+}
+
+// hasFullState reports whether the state of the block is available, along with
+// the TomoX trading and lending states if they are active at the block.
+func hasFullState(config *params.ChainConfig, statedb state.Database, engine *posv.Posv, tomoX *tomox.TomoX, lending *tomoxlending.Lending, block *types.Block) (bool, error) {
+	if _, err := state.New(block.Root(), statedb); err != nil {
+		return false, nil
+	}
+	if engine == nil || !config.IsTIPTomoX(block.Number()) || block.NumberU64() <= config.Posv.Epoch {
+		return true, nil
+	}
+	author, err := engine.Author(block.Header())
+	if err != nil {
+		return false, err
+	}
+	if !tomoX.HasTradingState(block, author) {
+		return false, nil
+	}
+	if config.IsTIPTomoXLending(block.Number()) && !lending.HasLendingState(block, author) {
+		return false, nil
+	}
+	return true, nil
+}
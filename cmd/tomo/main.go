@@ -58,7 +58,11 @@ var (
 		utils.BootnodesV4Flag,
 		utils.BootnodesV5Flag,
 		utils.DataDirFlag,
+		utils.AncientFlag,
+		utils.AncientThresholdFlag,
+		utils.DBEngineFlag,
 		utils.KeyStoreDirFlag,
+		utils.ExternalSignerFlag,
 		//utils.NoUSBFlag,
 		//utils.EthashCacheDirFlag,
 		//utils.EthashCachesInMemoryFlag,
@@ -86,6 +90,10 @@ var (
 		utils.LightModeFlag,
 		utils.SyncModeFlag,
 		utils.GCModeFlag,
+		utils.PruneFlag,
+		utils.PruneRetainFlag,
+		utils.SnapshotFlag,
+		utils.SnapshotCacheFlag,
 		//utils.LightServFlag,
 		//utils.LightPeersFlag,
 		//utils.LightKDFFlag,
@@ -119,6 +127,8 @@ var (
 		utils.RPCVirtualHostsFlag,
 		utils.EthStatsURLFlag,
 		utils.MetricsEnabledFlag,
+		utils.MetricsHTTPFlag,
+		utils.MetricsPortFlag,
 		//utils.FakePoWFlag,
 		//utils.NoCompactionFlag,
 		//utils.GpoBlocksFlag,
@@ -164,9 +174,17 @@ func init() {
 		exportCommand,
 		removedbCommand,
 		dumpCommand,
+		// See checkdexcmd.go:
+		checkdexCommand,
+		// See dbcmd.go:
+		dbCommand,
 		// See accountcmd.go:
 		accountCommand,
 		walletCommand,
+		// See stakingcmd.go:
+		stakingCommand,
+		// See relayercmd.go:
+		relayerCommand,
 		// See consolecmd.go:
 		consoleCommand,
 		attachCommand,
@@ -191,6 +209,7 @@ func init() {
 		}
 		// Start system runtime metrics collection
 		go metrics.CollectProcessMetrics(3 * time.Second)
+		utils.SetupMetrics(ctx)
 
 		utils.SetupNetwork(ctx)
 		return nil
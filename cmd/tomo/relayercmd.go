@@ -0,0 +1,309 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/tomochain/tomochain/cmd/utils"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/contracts/tomox"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/internal/ethapi"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	relayerValueFlag = cli.StringFlag{
+		Name:  "value",
+		Usage: "Amount of TOMO to deposit (decimal, e.g. 25000 or 10.5)",
+	}
+	relayerFeeFlag = cli.Uint64Flag{
+		Name:  "fee",
+		Usage: "Trading fee in hundredths of a percent (e.g. 10 for 0.1%)",
+	}
+	relayerPairsFlag = cli.StringFlag{
+		Name:  "pairs",
+		Usage: "Comma separated list of <baseToken>/<quoteToken> trading pairs",
+	}
+	relayerLendingFlag = cli.BoolFlag{
+		Name:  "lending",
+		Usage: "Update the lending fee of the relayer instead of its trading fee",
+	}
+	relayerBlockFlag = cli.StringFlag{
+		Name:  "block",
+		Usage: "Block number to read the relayer at",
+		Value: "latest",
+	}
+
+	relayerCommand = cli.Command{
+		Name:     "relayer",
+		Usage:    "Manage TomoX and lending relayers",
+		Category: "ACCOUNT COMMANDS",
+		Description: `
+Registers and manages a relayer in the relayer registration contract. The
+transactions are signed by the --from account, the owner of the relayer, and
+share the signing flags of 'tomo staking'. Use --tomo-testnet to address the
+contracts of the testnet, and --chainid 89 as well in offline mode.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "info",
+				Usage:     "Print the registration, deposit and income of a relayer",
+				ArgsUsage: "<coinbase>",
+				Action:    utils.MigrateFlags(relayerInfo),
+				Flags: []cli.Flag{
+					stakingEndpointFlag,
+					relayerBlockFlag,
+				},
+				Description: `
+    tomo relayer info <coinbase>
+Prints the registration of the relayer, the deposit left above the locked fund
+with the number of matches it still pays for, and the fees charged and earned
+since the start of the epoch. The income is only tracked by SDK nodes.`,
+			},
+			{
+				Name:      "register",
+				Usage:     "Register a new relayer",
+				ArgsUsage: "<coinbase>",
+				Action:    utils.MigrateFlags(relayerRegister),
+				Flags:     relayerTxFlags(relayerValueFlag, relayerFeeFlag, relayerPairsFlag),
+				Description: `
+    tomo relayer register --from <owner> --value 25000 --fee 10 --pairs <base>/<quote> <coinbase>
+Deposits --value TOMO and registers <coinbase> as a relayer owned by the --from
+account, listing the given trading pairs.`,
+			},
+			{
+				Name:      "update-fee",
+				Usage:     "Change the trading or lending fee of a relayer",
+				ArgsUsage: "<coinbase>",
+				Action:    utils.MigrateFlags(relayerUpdateFee),
+				Flags:     relayerTxFlags(relayerFeeFlag, relayerLendingFlag),
+				Description: `
+    tomo relayer update-fee --from <owner> --fee 10 [--lending] <coinbase>
+Sets the trading fee of the relayer, or its lending fee with --lending.`,
+			},
+			{
+				Name:      "list",
+				Usage:     "List a trading pair on a relayer",
+				ArgsUsage: "<coinbase> <baseToken> <quoteToken>",
+				Action:    utils.MigrateFlags(relayerList),
+				Flags:     relayerTxFlags(),
+			},
+			{
+				Name:      "delist",
+				Usage:     "Remove a trading pair from a relayer",
+				ArgsUsage: "<coinbase> <baseToken> <quoteToken>",
+				Action:    utils.MigrateFlags(relayerDelist),
+				Flags:     relayerTxFlags(),
+			},
+			{
+				Name:      "deposit",
+				Usage:     "Top up the deposit of a relayer",
+				ArgsUsage: "<coinbase>",
+				Action:    utils.MigrateFlags(relayerDeposit),
+				Flags:     relayerTxFlags(relayerValueFlag),
+				Description: `
+    tomo relayer deposit --from <owner> --value 1000 <coinbase>
+Adds --value TOMO to the deposit the matching fees are charged to.`,
+			},
+			{
+				Name:      "resign",
+				Usage:     "Resign a relayer",
+				ArgsUsage: "<coinbase>",
+				Action:    utils.MigrateFlags(relayerResign),
+				Flags:     relayerTxFlags(),
+				Description: `
+    tomo relayer resign --from <owner> <coinbase>
+Stops the relayer. The deposit can be refunded once the resign delay is over.`,
+			},
+		},
+	}
+)
+
+func relayerInfo(ctx *cli.Context) error {
+	coinbase := relayerAddressArgs(ctx, 1)[0]
+	client, err := dialRPC(ctx.String(stakingEndpointFlag.Name))
+	if err != nil {
+		utils.Fatalf("Unable to attach to tomo node: %v", err)
+	}
+	defer client.Close()
+
+	var info ethapi.RelayerInfo
+	if err := client.Call(&info, "tomox_getRelayer", coinbase, ctx.String(relayerBlockFlag.Name)); err != nil {
+		utils.Fatalf("Failed to retrieve relayer: %v", err)
+	}
+	status := "active"
+	if info.Resigned {
+		status = "resigned"
+	}
+	fmt.Printf("Relayer %s (%s), owner %s\n", info.Coinbase.Hex(), status, info.Owner.Hex())
+	fmt.Printf("Deposit: %s TOMO, %s TOMO above the locked fund of %s TOMO\n",
+		formatTomoAmount(info.Deposit.ToInt()), formatTomoAmount(info.RemainingDeposit.ToInt()), formatTomoAmount(info.LockedFund.ToInt()))
+	fmt.Printf("Remaining matches: %v at %s TOMO per matched order\n", info.RemainingMatches.ToInt(), formatTomoAmount(info.MatchingFee.ToInt()))
+	fmt.Printf("Trading fee: %v\n", info.TradeFee.ToInt())
+	for _, pair := range info.Pairs {
+		fmt.Printf("  pair %s/%s\n", pair.BaseToken.Hex(), pair.QuoteToken.Hex())
+	}
+	if info.Lending != nil {
+		fmt.Printf("Lending fee: %v, terms %v\n", info.Lending.Fee.ToInt(), info.Lending.Terms)
+		for _, token := range info.Lending.BaseTokens {
+			fmt.Printf("  lending token %s\n", token.Hex())
+		}
+	}
+	if info.EpochCharged != nil {
+		fmt.Printf("Epoch %d: %s TOMO charged in %d blocks\n", info.Epoch, formatTomoAmount(info.EpochCharged.ToInt()), len(info.Charges))
+	}
+	for token, income := range info.TradingIncome {
+		fmt.Printf("  trading income %v of token %s\n", income.ToInt(), token.Hex())
+	}
+	for token, income := range info.LendingIncome {
+		fmt.Printf("  lending income %v of token %s\n", income.ToInt(), token.Hex())
+	}
+	return nil
+}
+
+func relayerRegister(ctx *cli.Context) error {
+	coinbase := relayerAddressArgs(ctx, 1)[0]
+	fee := relayerFee(ctx)
+	var fromTokens, toTokens []common.Address
+	for _, pair := range strings.Split(ctx.String(relayerPairsFlag.Name), ",") {
+		if pair == "" {
+			continue
+		}
+		tokens := strings.Split(pair, "/")
+		if len(tokens) != 2 || !common.IsHexAddress(tokens[0]) || !common.IsHexAddress(tokens[1]) {
+			utils.Fatalf("Invalid trading pair %q", pair)
+		}
+		fromTokens = append(fromTokens, common.HexToAddress(tokens[0]))
+		toTokens = append(toTokens, common.HexToAddress(tokens[1]))
+	}
+	session, registration := newRelayerSession(ctx, true)
+	return session.send(func() (*types.Transaction, error) {
+		return registration.Register(coinbase, fee, fromTokens, toTokens)
+	})
+}
+
+func relayerUpdateFee(ctx *cli.Context) error {
+	coinbase := relayerAddressArgs(ctx, 1)[0]
+	fee := relayerFee(ctx)
+	session, registration := newRelayerSession(ctx, false)
+	if !ctx.Bool(relayerLendingFlag.Name) {
+		return session.send(func() (*types.Transaction, error) {
+			return registration.UpdateFee(coinbase, fee)
+		})
+	}
+	address := common.HexToAddress(common.LendingRegistrationSMC)
+	if common.IsTestnet {
+		address = common.HexToAddress(common.LendingRegistrationSMCTestnet)
+	}
+	lending, err := tomox.NewLendingRelayerRegistration(session.opts, address, session.backend)
+	if err != nil {
+		utils.Fatalf("Failed to bind lending registration contract: %v", err)
+	}
+	return session.send(func() (*types.Transaction, error) {
+		return lending.UpdateFee(coinbase, fee)
+	})
+}
+
+func relayerList(ctx *cli.Context) error {
+	args := relayerAddressArgs(ctx, 3)
+	session, registration := newRelayerSession(ctx, false)
+	return session.send(func() (*types.Transaction, error) {
+		return registration.ListToken(args[0], args[1], args[2])
+	})
+}
+
+func relayerDelist(ctx *cli.Context) error {
+	args := relayerAddressArgs(ctx, 3)
+	session, registration := newRelayerSession(ctx, false)
+	return session.send(func() (*types.Transaction, error) {
+		return registration.DeListToken(args[0], args[1], args[2])
+	})
+}
+
+func relayerDeposit(ctx *cli.Context) error {
+	coinbase := relayerAddressArgs(ctx, 1)[0]
+	session, registration := newRelayerSession(ctx, true)
+	return session.send(func() (*types.Transaction, error) {
+		return registration.DepositMore(coinbase)
+	})
+}
+
+func relayerResign(ctx *cli.Context) error {
+	coinbase := relayerAddressArgs(ctx, 1)[0]
+	session, registration := newRelayerSession(ctx, false)
+	return session.send(func() (*types.Transaction, error) {
+		return registration.Resign(coinbase)
+	})
+}
+
+// newRelayerSession resolves the sender wallet and the contract backend like
+// the staking commands do, and binds the relayer registration contract.
+func newRelayerSession(ctx *cli.Context, payable bool) (*stakingSession, *tomox.RelayerRegistration) {
+	session := newStakingSession(ctx, false)
+	if payable {
+		value := ctx.String(relayerValueFlag.Name)
+		if value == "" {
+			utils.Fatalf("--%s is required", relayerValueFlag.Name)
+		}
+		amount, err := parseTomoAmount(value)
+		if err != nil {
+			utils.Fatalf("Invalid amount %q: %v", value, err)
+		}
+		session.opts.Value = amount
+	}
+	// the testnet flag has switched the registration address while loading
+	// the node config
+	registration, err := tomox.NewRelayerRegistration(session.opts, common.HexToAddress(common.RelayerRegistrationSMC), session.backend)
+	if err != nil {
+		utils.Fatalf("Failed to bind relayer registration contract: %v", err)
+	}
+	return session, registration
+}
+
+// relayerTxFlags returns the flags of the relayer transactions followed by the
+// given flags of a subcommand.
+func relayerTxFlags(flags ...cli.Flag) []cli.Flag {
+	txFlags := append([]cli.Flag{utils.TomoTestnetFlag}, stakingTxFlags...)
+	return append(txFlags, flags...)
+}
+
+func relayerFee(ctx *cli.Context) uint16 {
+	if !ctx.IsSet(relayerFeeFlag.Name) {
+		utils.Fatalf("--%s is required", relayerFeeFlag.Name)
+	}
+	fee := ctx.Uint64(relayerFeeFlag.Name)
+	if fee > math.MaxUint16 {
+		utils.Fatalf("Invalid fee %d", fee)
+	}
+	return uint16(fee)
+}
+
+func relayerAddressArgs(ctx *cli.Context, n int) []common.Address {
+	if len(ctx.Args()) != n {
+		utils.Fatalf("This command requires exactly %d address arguments", n)
+	}
+	addresses := make([]common.Address, n)
+	for i, arg := range ctx.Args() {
+		if !common.IsHexAddress(arg) {
+			utils.Fatalf("Invalid address %q", arg)
+		}
+		addresses[i] = common.HexToAddress(arg)
+	}
+	return addresses
+}
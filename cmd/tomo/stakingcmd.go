@@ -0,0 +1,713 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/tomochain/tomochain"
+	"github.com/tomochain/tomochain/accounts"
+	"github.com/tomochain/tomochain/accounts/abi"
+	"github.com/tomochain/tomochain/accounts/abi/bind"
+	"github.com/tomochain/tomochain/accounts/keystore"
+	"github.com/tomochain/tomochain/accounts/usbwallet"
+	"github.com/tomochain/tomochain/cmd/utils"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/common/hexutil"
+	"github.com/tomochain/tomochain/contracts/validator/contract"
+	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/ethclient"
+	"github.com/tomochain/tomochain/node"
+	"github.com/tomochain/tomochain/params"
+	"github.com/tomochain/tomochain/rlp"
+	"github.com/tomochain/tomochain/rpc"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	stakingEndpointFlag = cli.StringFlag{
+		Name:  "endpoint",
+		Value: node.DefaultIPCEndpoint(clientIdentifier),
+		Usage: "API endpoint of the node used to read the validator contract and broadcast transactions",
+	}
+	stakingFromFlag = cli.StringFlag{
+		Name:  "from",
+		Usage: "Account (keystore or USB wallet) sending the staking transaction",
+	}
+	stakingValueFlag = cli.StringFlag{
+		Name:  "value",
+		Usage: "Amount of TOMO to stake (decimal, e.g. 50000 or 10.5)",
+	}
+	stakingOfflineFlag = cli.BoolFlag{
+		Name:  "offline",
+		Usage: "Sign the transaction without contacting a node (requires --nonce, --gas, --gasprice, --chainid and --out)",
+	}
+	stakingOutFlag = cli.StringFlag{
+		Name:  "out",
+		Usage: "Write the signed transaction (hex encoded RLP) to this file instead of broadcasting it",
+	}
+	stakingNonceFlag = cli.StringFlag{
+		Name:  "nonce",
+		Usage: "Nonce of the transaction (default: pending nonce reported by the node)",
+	}
+	stakingGasFlag = cli.Uint64Flag{
+		Name:  "gas",
+		Usage: "Gas limit of the transaction (default: estimated by the node)",
+	}
+	stakingGasPriceFlag = cli.StringFlag{
+		Name:  "gasprice",
+		Usage: "Gas price of the transaction in wei (default: suggested by the node)",
+	}
+	stakingChainIdFlag = cli.Uint64Flag{
+		Name:  "chainid",
+		Usage: "Chain identifier used for replay protected signing (default: reported by the node, required with --offline)",
+	}
+	stakingHDPathFlag = cli.StringFlag{
+		Name:  "hdpath",
+		Usage: "Derivation path of the account on a USB hardware wallet",
+		Value: accounts.DefaultBaseDerivationPath.String(),
+	}
+	stakingIndexFlag = cli.StringFlag{
+		Name:  "index",
+		Usage: "Index of the withdrawal in the pending withdrawal list (default: looked up from the contract)",
+	}
+
+	stakingTxFlags = []cli.Flag{
+		utils.DataDirFlag,
+		utils.KeyStoreDirFlag,
+		utils.PasswordFileFlag,
+		utils.NoUSBFlag,
+		stakingEndpointFlag,
+		stakingFromFlag,
+		stakingOfflineFlag,
+		stakingOutFlag,
+		stakingNonceFlag,
+		stakingGasFlag,
+		stakingGasPriceFlag,
+		stakingChainIdFlag,
+		stakingHDPathFlag,
+	}
+
+	stakingCommand = cli.Command{
+		Name:     "staking",
+		Usage:    "Manage masternode candidacies and votes",
+		Category: "ACCOUNT COMMANDS",
+		Description: `
+
+Send staking transactions to the TomoChain validator contract: propose a new
+masternode candidate, vote or unvote for a candidate, resign a candidate and
+withdraw the unlocked stake.
+
+Transactions are signed with an account from the keystore or from a USB
+hardware wallet. With --out the signed transaction is written to a file
+instead of being broadcast; together with --offline no node is contacted at
+all, so the account can live on an air-gapped machine. The resulting file can
+be broadcast later with eth.sendRawTransaction. The chain id used for signing
+is reported by the node, --chainid is only required in offline mode.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "propose",
+				Usage:     "Propose a new masternode candidate",
+				ArgsUsage: "<candidate>",
+				Action:    utils.MigrateFlags(stakingPropose),
+				Flags:     append(stakingTxFlags, stakingValueFlag),
+				Description: `
+    tomo staking propose --from <owner> --value 50000 <candidate>
+
+Deposits --value TOMO and registers <candidate> as a masternode candidate
+owned by the --from account.`,
+			},
+			{
+				Name:      "vote",
+				Usage:     "Vote for a masternode candidate",
+				ArgsUsage: "<candidate>",
+				Action:    utils.MigrateFlags(stakingVote),
+				Flags:     append(stakingTxFlags, stakingValueFlag),
+				Description: `
+    tomo staking vote --from <voter> --value 100 <candidate>
+
+Stakes --value TOMO on <candidate>.`,
+			},
+			{
+				Name:      "unvote",
+				Usage:     "Withdraw a vote from a masternode candidate",
+				ArgsUsage: "<candidate>",
+				Action:    utils.MigrateFlags(stakingUnvote),
+				Flags:     append(stakingTxFlags, stakingValueFlag),
+				Description: `
+    tomo staking unvote --from <voter> --value 100 <candidate>
+
+Removes --value TOMO of the --from account's vote on <candidate>. The amount
+becomes withdrawable after the voter withdraw delay.`,
+			},
+			{
+				Name:      "resign",
+				Usage:     "Resign a masternode candidate",
+				ArgsUsage: "<candidate>",
+				Action:    utils.MigrateFlags(stakingResign),
+				Flags:     stakingTxFlags,
+				Description: `
+    tomo staking resign --from <owner> <candidate>
+
+Removes <candidate> from the candidate list. The owner deposit becomes
+withdrawable after the candidate withdraw delay.`,
+			},
+			{
+				Name:      "withdraw",
+				Usage:     "Withdraw unlocked stake",
+				ArgsUsage: "<blockNumber>",
+				Action:    utils.MigrateFlags(stakingWithdraw),
+				Flags:     append(stakingTxFlags, stakingIndexFlag),
+				Description: `
+    tomo staking withdraw --from <account> <blockNumber>
+
+Withdraws the stake unlocked at <blockNumber>. The index of the withdrawal is
+looked up from the validator contract unless --index is given, which is
+mandatory in --offline mode. Use 'tomo staking withdrawals' to list the
+pending withdrawals of an account.`,
+			},
+			{
+				Name:      "rotate-key",
+				Usage:     "Change the key sealing blocks for a masternode candidate",
+				ArgsUsage: "<candidate> <key>",
+				Action:    utils.MigrateFlags(stakingRotateKey),
+				Flags:     stakingTxFlags,
+				Description: `
+    tomo staking rotate-key --from <owner> <candidate> <key>
+
+Registers <key> as the signing key of <candidate>. From the next checkpoint
+on the masternode seals and validates blocks with <key> instead of the
+candidate address, its stake and voters are kept. The node of the masternode
+has to be restarted with <key> as etherbase at that checkpoint.`,
+			},
+			{
+				Name:   "withdrawals",
+				Usage:  "List pending withdrawals of an account",
+				Action: utils.MigrateFlags(stakingWithdrawals),
+				Flags: []cli.Flag{
+					stakingEndpointFlag,
+					stakingFromFlag,
+				},
+				Description: `
+    tomo staking withdrawals --from <account>
+
+Prints the pending withdrawals of the account with their index, unlock block
+and amount, and whether they can be withdrawn at the current block.`,
+			},
+		},
+	}
+)
+
+// errStakingOffline is returned by the offline contract backend when the
+// binding needs chain data that was not supplied on the command line.
+var errStakingOffline = errors.New("not available in offline mode")
+
+// offlineBackend is a contract backend that never contacts a node. Sent
+// transactions are captured instead of being broadcast.
+type offlineBackend struct {
+	sent *types.Transaction
+}
+
+func (b *offlineBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return nil, errStakingOffline
+}
+
+func (b *offlineBackend) CallContract(ctx context.Context, call tomochain.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return nil, errStakingOffline
+}
+
+func (b *offlineBackend) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	return nil, errStakingOffline
+}
+
+func (b *offlineBackend) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return 0, errStakingOffline
+}
+
+func (b *offlineBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return nil, errStakingOffline
+}
+
+func (b *offlineBackend) EstimateGas(ctx context.Context, call tomochain.CallMsg) (uint64, error) {
+	return 0, errStakingOffline
+}
+
+func (b *offlineBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	b.sent = tx
+	return nil
+}
+
+func (b *offlineBackend) FilterLogs(ctx context.Context, query tomochain.FilterQuery) ([]types.Log, error) {
+	return nil, errStakingOffline
+}
+
+func (b *offlineBackend) SubscribeFilterLogs(ctx context.Context, query tomochain.FilterQuery, ch chan<- types.Log) (tomochain.Subscription, error) {
+	return nil, errStakingOffline
+}
+
+// capturingBackend forwards every call to a live node except SendTransaction,
+// which only records the transaction. It is used with --out.
+type capturingBackend struct {
+	bind.ContractBackend
+	sent *types.Transaction
+}
+
+func (b *capturingBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	b.sent = tx
+	return nil
+}
+
+// stakingSession bundles everything needed to send a single staking transaction.
+type stakingSession struct {
+	backend   bind.ContractBackend
+	validator *contract.TomoValidator
+	rpcClient *rpc.Client
+	client    *ethclient.Client // nil in offline mode
+	opts      *bind.TransactOpts
+	out       string
+	capture   func() *types.Transaction
+}
+
+func stakingPropose(ctx *cli.Context) error {
+	candidate := stakingCandidateArg(ctx)
+	session := newStakingSession(ctx, true)
+	return session.send(func() (*types.Transaction, error) {
+		return session.validator.Propose(session.opts, candidate)
+	})
+}
+
+func stakingVote(ctx *cli.Context) error {
+	candidate := stakingCandidateArg(ctx)
+	session := newStakingSession(ctx, true)
+	return session.send(func() (*types.Transaction, error) {
+		return session.validator.Vote(session.opts, candidate)
+	})
+}
+
+func stakingUnvote(ctx *cli.Context) error {
+	candidate := stakingCandidateArg(ctx)
+	session := newStakingSession(ctx, true)
+	amount := session.opts.Value
+	// Unvote is not payable, the amount is passed as an argument instead.
+	session.opts.Value = nil
+	return session.send(func() (*types.Transaction, error) {
+		return session.validator.Unvote(session.opts, candidate, amount)
+	})
+}
+
+func stakingResign(ctx *cli.Context) error {
+	candidate := stakingCandidateArg(ctx)
+	session := newStakingSession(ctx, false)
+	return session.send(func() (*types.Transaction, error) {
+		return session.validator.Resign(session.opts, candidate)
+	})
+}
+
+// signingKeyRegistryABI describes the signing key registration understood by
+// the signing key registry. The registry has no code, so gas can't be
+// estimated and defaults to the intrinsic gas of the transaction.
+const signingKeyRegistryABI = `[{"constant":false,"inputs":[{"name":"candidate","type":"address"},{"name":"key","type":"address"}],"name":"setSigningKey","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"}]`
+
+func stakingRotateKey(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		utils.Fatalf("This command requires exactly two arguments: the candidate address and the signing key address")
+	}
+	for _, arg := range ctx.Args() {
+		if !common.IsHexAddress(arg) {
+			utils.Fatalf("Invalid address %q", arg)
+		}
+	}
+	candidate, key := common.HexToAddress(ctx.Args()[0]), common.HexToAddress(ctx.Args()[1])
+
+	parsed, err := abi.JSON(strings.NewReader(signingKeyRegistryABI))
+	if err != nil {
+		utils.Fatalf("Failed to parse signing key registry ABI: %v", err)
+	}
+	session := newStakingSession(ctx, false)
+	if session.opts.GasLimit == 0 {
+		input, err := parsed.Pack("setSigningKey", candidate, key)
+		if err != nil {
+			utils.Fatalf("Failed to pack signing key registration: %v", err)
+		}
+		if session.opts.GasLimit, err = core.IntrinsicGas(input, false, true); err != nil {
+			utils.Fatalf("Failed to compute gas: %v", err)
+		}
+	}
+	registry := bind.NewBoundContract(common.HexToAddress(common.SigningKeyRegistry), parsed, session.backend, session.backend, session.backend)
+	return session.send(func() (*types.Transaction, error) {
+		return registry.Transact(session.opts, "setSigningKey", candidate, key)
+	})
+}
+
+func stakingWithdraw(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires exactly one argument: the unlock block number")
+	}
+	blockNumber, ok := new(big.Int).SetString(ctx.Args().First(), 10)
+	if !ok || blockNumber.Sign() <= 0 {
+		utils.Fatalf("Invalid unlock block number %q", ctx.Args().First())
+	}
+	session := newStakingSession(ctx, false)
+
+	var index *big.Int
+	if s := ctx.String(stakingIndexFlag.Name); s != "" {
+		i, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			utils.Fatalf("Invalid withdrawal index %q: %v", s, err)
+		}
+		index = new(big.Int).SetUint64(i)
+	} else if session.client == nil {
+		utils.Fatalf("--%s is required in offline mode", stakingIndexFlag.Name)
+	}
+	if session.client != nil {
+		withdrawals, err := pendingWithdrawals(session.validator, session.opts.From)
+		if err != nil {
+			utils.Fatalf("Failed to retrieve pending withdrawals: %v", err)
+		}
+		found, err := findWithdrawal(withdrawals, blockNumber, index)
+		if err != nil {
+			utils.Fatalf("%v", err)
+		}
+		head, err := session.client.HeaderByNumber(context.Background(), nil)
+		if err != nil {
+			utils.Fatalf("Failed to retrieve current block: %v", err)
+		}
+		if head.Number.Cmp(blockNumber) < 0 {
+			utils.Fatalf("Withdrawal is locked until block %v (current block %v)", blockNumber, head.Number)
+		}
+		index = new(big.Int).SetUint64(found.Index)
+	}
+	return session.send(func() (*types.Transaction, error) {
+		return session.validator.Withdraw(session.opts, blockNumber, index)
+	})
+}
+
+func stakingWithdrawals(ctx *cli.Context) error {
+	from := ctx.String(stakingFromFlag.Name)
+	if !common.IsHexAddress(from) {
+		utils.Fatalf("--%s must be a valid address", stakingFromFlag.Name)
+	}
+	rpcClient, client, validator := dialValidator(ctx)
+	defer rpcClient.Close()
+
+	withdrawals, err := pendingWithdrawals(validator, common.HexToAddress(from))
+	if err != nil {
+		utils.Fatalf("Failed to retrieve pending withdrawals: %v", err)
+	}
+	if len(withdrawals) == 0 {
+		fmt.Println("No pending withdrawals")
+		return nil
+	}
+	head, err := client.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		utils.Fatalf("Failed to retrieve current block: %v", err)
+	}
+	fmt.Printf("Current block: %v\n", head.Number)
+	for _, w := range withdrawals {
+		status := "locked"
+		if head.Number.Cmp(w.BlockNumber) >= 0 {
+			status = "unlocked"
+		}
+		fmt.Printf("Index %d: block %v, %s TOMO (%s)\n", w.Index, w.BlockNumber, formatTomoAmount(w.Cap), status)
+	}
+	return nil
+}
+
+// stakingWithdrawal is a pending withdrawal of an account in the validator contract.
+type stakingWithdrawal struct {
+	Index       uint64
+	BlockNumber *big.Int
+	Cap         *big.Int
+}
+
+// pendingWithdrawals lists the withdrawals of an account which have not been
+// executed yet. Executed withdrawals are zeroed in the contract but keep their
+// slot, so the position in the list is the index expected by withdraw.
+func pendingWithdrawals(validator *contract.TomoValidator, account common.Address) ([]stakingWithdrawal, error) {
+	opts := &bind.CallOpts{From: account}
+	blockNumbers, err := validator.GetWithdrawBlockNumbers(opts)
+	if err != nil {
+		return nil, err
+	}
+	var withdrawals []stakingWithdrawal
+	for i, number := range blockNumbers {
+		if number == nil || number.Sign() == 0 {
+			continue
+		}
+		cap, err := validator.GetWithdrawCap(opts, number)
+		if err != nil {
+			return nil, err
+		}
+		if cap.Sign() == 0 {
+			continue
+		}
+		withdrawals = append(withdrawals, stakingWithdrawal{Index: uint64(i), BlockNumber: number, Cap: cap})
+	}
+	return withdrawals, nil
+}
+
+// findWithdrawal returns the pending withdrawal unlocked at the given block. If
+// index is not nil it must match the position of that withdrawal.
+func findWithdrawal(withdrawals []stakingWithdrawal, blockNumber *big.Int, index *big.Int) (stakingWithdrawal, error) {
+	for _, w := range withdrawals {
+		if w.BlockNumber.Cmp(blockNumber) != 0 {
+			continue
+		}
+		if index != nil && index.Uint64() != w.Index {
+			return stakingWithdrawal{}, fmt.Errorf("withdrawal index mismatch: block %v is at index %d, not %v", blockNumber, w.Index, index)
+		}
+		return w, nil
+	}
+	return stakingWithdrawal{}, fmt.Errorf("no pending withdrawal unlocked at block %v", blockNumber)
+}
+
+func stakingCandidateArg(ctx *cli.Context) common.Address {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires exactly one argument: the candidate address")
+	}
+	if !common.IsHexAddress(ctx.Args().First()) {
+		utils.Fatalf("Invalid candidate address %q", ctx.Args().First())
+	}
+	return common.HexToAddress(ctx.Args().First())
+}
+
+func dialValidator(ctx *cli.Context) (*rpc.Client, *ethclient.Client, *contract.TomoValidator) {
+	rpcClient, err := dialRPC(ctx.String(stakingEndpointFlag.Name))
+	if err != nil {
+		utils.Fatalf("Unable to attach to tomo node: %v", err)
+	}
+	client := ethclient.NewClient(rpcClient)
+	validator, err := contract.NewTomoValidator(common.HexToAddress(common.MasternodeVotingSMC), client)
+	if err != nil {
+		utils.Fatalf("Failed to bind validator contract: %v", err)
+	}
+	return rpcClient, client, validator
+}
+
+// newStakingSession resolves the sender wallet and the contract backend from
+// the command line flags.
+func newStakingSession(ctx *cli.Context, payable bool) *stakingSession {
+	var (
+		session = &stakingSession{out: ctx.String(stakingOutFlag.Name)}
+		backend bind.ContractBackend
+	)
+	if ctx.Bool(stakingOfflineFlag.Name) {
+		if session.out == "" {
+			utils.Fatalf("--%s is required in offline mode", stakingOutFlag.Name)
+		}
+		for _, flag := range []string{stakingNonceFlag.Name, stakingGasFlag.Name, stakingGasPriceFlag.Name, stakingChainIdFlag.Name} {
+			if !ctx.IsSet(flag) {
+				utils.Fatalf("--%s is required in offline mode", flag)
+			}
+		}
+		offline := new(offlineBackend)
+		backend, session.capture = offline, func() *types.Transaction { return offline.sent }
+	} else {
+		rpcClient, client, _ := dialValidator(ctx)
+		session.rpcClient, session.client = rpcClient, client
+		if session.out != "" {
+			capturing := &capturingBackend{ContractBackend: client}
+			backend, session.capture = capturing, func() *types.Transaction { return capturing.sent }
+		} else {
+			backend = client
+		}
+	}
+	validator, err := contract.NewTomoValidator(common.HexToAddress(common.MasternodeVotingSMC), backend)
+	if err != nil {
+		utils.Fatalf("Failed to bind validator contract: %v", err)
+	}
+	session.backend, session.validator = backend, validator
+	session.opts = makeStakingTransactor(ctx, stakingChainID(ctx, session.client))
+
+	if payable {
+		value := ctx.String(stakingValueFlag.Name)
+		if value == "" {
+			utils.Fatalf("--%s is required", stakingValueFlag.Name)
+		}
+		amount, err := parseTomoAmount(value)
+		if err != nil {
+			utils.Fatalf("Invalid amount %q: %v", value, err)
+		}
+		session.opts.Value = amount
+	}
+	if s := ctx.String(stakingNonceFlag.Name); s != "" {
+		nonce, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			utils.Fatalf("Invalid nonce %q: %v", s, err)
+		}
+		session.opts.Nonce = new(big.Int).SetUint64(nonce)
+	}
+	if s := ctx.String(stakingGasPriceFlag.Name); s != "" {
+		price, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			utils.Fatalf("Invalid gas price %q", s)
+		}
+		session.opts.GasPrice = price
+	}
+	session.opts.GasLimit = ctx.Uint64(stakingGasFlag.Name)
+	return session
+}
+
+// send creates and signs the transaction, then either broadcasts it or writes
+// it to the output file.
+func (s *stakingSession) send(transact func() (*types.Transaction, error)) error {
+	if s.rpcClient != nil {
+		defer s.rpcClient.Close()
+	}
+	tx, err := transact()
+	if err != nil {
+		utils.Fatalf("Failed to create transaction: %v", err)
+	}
+	if s.out == "" {
+		fmt.Printf("Transaction sent: %s\n", tx.Hash().Hex())
+		return nil
+	}
+	data, err := rlp.EncodeToBytes(s.capture())
+	if err != nil {
+		utils.Fatalf("Failed to encode transaction: %v", err)
+	}
+	if err := ioutil.WriteFile(s.out, []byte(hexutil.Encode(data)+"\n"), 0600); err != nil {
+		utils.Fatalf("Failed to write transaction: %v", err)
+	}
+	fmt.Printf("Signed transaction %s written to %s\n", tx.Hash().Hex(), s.out)
+	return nil
+}
+
+// stakingChainID returns the chain id reported by the node, or the one given
+// with --chainid in offline mode. A --chainid differing from the one of the
+// node is rejected.
+func stakingChainID(ctx *cli.Context, client *ethclient.Client) *big.Int {
+	if client == nil {
+		return new(big.Int).SetUint64(ctx.Uint64(stakingChainIdFlag.Name))
+	}
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		utils.Fatalf("Failed to retrieve the chain id: %v", err)
+	}
+	if chainID.Sign() == 0 {
+		utils.Fatalf("The node doesn't report a chain id, use --%s", stakingChainIdFlag.Name)
+	}
+	if ctx.IsSet(stakingChainIdFlag.Name) && ctx.Uint64(stakingChainIdFlag.Name) != chainID.Uint64() {
+		utils.Fatalf("--%s %d doesn't match the chain id %v of the node", stakingChainIdFlag.Name, ctx.Uint64(stakingChainIdFlag.Name), chainID)
+	}
+	return chainID
+}
+
+// makeStakingTransactor looks up the --from account in the keystore and on the
+// connected USB wallets and returns transact options signing with it.
+func makeStakingTransactor(ctx *cli.Context, chainID *big.Int) *bind.TransactOpts {
+	from := ctx.String(stakingFromFlag.Name)
+	if from == "" {
+		utils.Fatalf("--%s is required", stakingFromFlag.Name)
+	}
+	stack, _ := makeConfigNode(ctx)
+	ks := stack.AccountManager().Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+
+	account, err := utils.MakeAddress(ks, from)
+	if err != nil {
+		utils.Fatalf("Invalid sender account: %v", err)
+	}
+	if ks.HasAddress(account.Address) {
+		account, password := unlockAccount(ctx, ks, from, 0, utils.MakePasswordList(ctx))
+		return &bind.TransactOpts{
+			From: account.Address,
+			Signer: func(_ types.Signer, address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+				return ks.SignTxWithPassphrase(account, password, tx, chainID)
+			},
+		}
+	}
+	wallet, account := findUSBAccount(ctx, stack.AccountManager(), account.Address)
+	return &bind.TransactOpts{
+		From: account.Address,
+		Signer: func(_ types.Signer, address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			fmt.Println("Please confirm the transaction on your hardware wallet")
+			return wallet.SignTx(account, tx, chainID)
+		},
+	}
+}
+
+// findUSBAccount opens the connected hardware wallets and derives the account
+// at --hdpath on each of them until the requested address is found.
+func findUSBAccount(ctx *cli.Context, am *accounts.Manager, address common.Address) (accounts.Wallet, accounts.Account) {
+	path, err := accounts.ParseDerivationPath(ctx.String(stakingHDPathFlag.Name))
+	if err != nil {
+		utils.Fatalf("Invalid derivation path: %v", err)
+	}
+	for _, scheme := range []string{usbwallet.LedgerScheme, usbwallet.TrezorScheme} {
+		for _, wallet := range am.Wallets() {
+			if wallet.URL().Scheme != scheme {
+				continue
+			}
+			if err := wallet.Open(""); err != nil && err != accounts.ErrWalletAlreadyOpen {
+				fmt.Printf("Failed to open %s: %v\n", wallet.URL(), err)
+				continue
+			}
+			account, err := wallet.Derive(path, true)
+			if err != nil {
+				fmt.Printf("Failed to derive %s on %s: %v\n", path, wallet.URL(), err)
+				continue
+			}
+			if account.Address == address {
+				return wallet, account
+			}
+		}
+	}
+	utils.Fatalf("Account %s not found in the keystore or on any USB wallet (path %s)", address.Hex(), path)
+	return nil, accounts.Account{}
+}
+
+// parseTomoAmount converts a decimal TOMO amount into wei.
+func parseTomoAmount(s string) (*big.Int, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) > 2 || parts[0] == "" && (len(parts) == 1 || parts[1] == "") {
+		return nil, errors.New("malformed amount")
+	}
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	if len(fraction) > 18 {
+		return nil, errors.New("more than 18 decimals")
+	}
+	digits := parts[0] + fraction + strings.Repeat("0", 18-len(fraction))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return nil, errors.New("malformed amount")
+		}
+	}
+	amount, _ := new(big.Int).SetString(digits, 10)
+	if amount.Sign() == 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	return amount, nil
+}
+
+// formatTomoAmount converts a wei amount into a decimal TOMO string.
+func formatTomoAmount(wei *big.Int) string {
+	quo, rem := new(big.Int).QuoRem(wei, big.NewInt(params.Ether), new(big.Int))
+	if rem.Sign() == 0 {
+		return quo.String()
+	}
+	fraction := fmt.Sprintf("%018s", rem.String())
+	return quo.String() + "." + strings.TrimRight(fraction, "0")
+}
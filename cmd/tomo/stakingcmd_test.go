@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common/hexutil"
+	"github.com/tomochain/tomochain/ethclient"
+	"github.com/tomochain/tomochain/rpc"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func TestParseTomoAmount(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		fail  bool
+	}{
+		{input: "50000", want: "50000000000000000000000"},
+		{input: "10.5", want: "10500000000000000000"},
+		{input: ".25", want: "250000000000000000"},
+		{input: "0.000000000000000001", want: "1"},
+		{input: "0.0000000000000000001", fail: true},
+		{input: "0", fail: true},
+		{input: "", fail: true},
+		{input: ".", fail: true},
+		{input: "1.2.3", fail: true},
+		{input: "-1", fail: true},
+		{input: "1e18", fail: true},
+	}
+	for _, tt := range tests {
+		amount, err := parseTomoAmount(tt.input)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", tt.input, amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if amount.String() != tt.want {
+			t.Errorf("%q: amount mismatch: have %v, want %s", tt.input, amount, tt.want)
+		}
+		if back, _ := parseTomoAmount(formatTomoAmount(amount)); back.Cmp(amount) != 0 {
+			t.Errorf("%q: format round trip mismatch: have %v, want %v", tt.input, back, amount)
+		}
+	}
+}
+
+func TestFindWithdrawal(t *testing.T) {
+	withdrawals := []stakingWithdrawal{
+		{Index: 1, BlockNumber: big.NewInt(100), Cap: big.NewInt(1)},
+		{Index: 3, BlockNumber: big.NewInt(200), Cap: big.NewInt(2)},
+	}
+	if w, err := findWithdrawal(withdrawals, big.NewInt(200), nil); err != nil || w.Index != 3 {
+		t.Fatalf("lookup by block failed: %v %v", w, err)
+	}
+	if _, err := findWithdrawal(withdrawals, big.NewInt(200), big.NewInt(3)); err != nil {
+		t.Fatalf("matching index rejected: %v", err)
+	}
+	if _, err := findWithdrawal(withdrawals, big.NewInt(200), big.NewInt(1)); err == nil {
+		t.Fatalf("wrong index accepted")
+	}
+	if _, err := findWithdrawal(withdrawals, big.NewInt(150), nil); err == nil {
+		t.Fatalf("unknown block accepted")
+	}
+}
+
+type StakingTestAPI struct{}
+
+func (StakingTestAPI) ChainId() hexutil.Uint64 { return 89 }
+
+func TestStakingChainID(t *testing.T) {
+	newContext := func(args ...string) *cli.Context {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		stakingChainIdFlag.Apply(set)
+		if err := set.Parse(args); err != nil {
+			t.Fatalf("failed to parse flags: %v", err)
+		}
+		return cli.NewContext(nil, set, nil)
+	}
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("eth", StakingTestAPI{}); err != nil {
+		t.Fatalf("failed to register API: %v", err)
+	}
+	rpcClient := rpc.DialInProc(server)
+	defer rpcClient.Close()
+	client := ethclient.NewClient(rpcClient)
+
+	if chainID := stakingChainID(newContext(), client); chainID.Uint64() != 89 {
+		t.Errorf("online chain id mismatch: have %v, want 89", chainID)
+	}
+	if chainID := stakingChainID(newContext("--chainid", "89"), client); chainID.Uint64() != 89 {
+		t.Errorf("matching chain id mismatch: have %v, want 89", chainID)
+	}
+	if chainID := stakingChainID(newContext("--chainid", "88"), nil); chainID.Uint64() != 88 {
+		t.Errorf("offline chain id mismatch: have %v, want 88", chainID)
+	}
+}
@@ -67,13 +67,21 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			configFileFlag,
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.AncientThresholdFlag,
+			utils.DBEngineFlag,
 			utils.KeyStoreDirFlag,
+			utils.ExternalSignerFlag,
 			//utils.NoUSBFlag,
 			utils.NetworkIdFlag,
 			//utils.TestnetFlag,
 			//utils.RinkebyFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
+			utils.PruneFlag,
+			utils.PruneRetainFlag,
+			utils.SnapshotFlag,
+			utils.SnapshotCacheFlag,
 			utils.EthStatsURLFlag,
 			utils.IdentityFlag,
 			//utils.LightServFlag,
@@ -205,6 +213,8 @@ var AppHelpFlagGroups = []flagGroup{
 		Name: "LOGGING AND DEBUGGING",
 		Flags: append([]cli.Flag{
 			utils.MetricsEnabledFlag,
+			utils.MetricsHTTPFlag,
+			utils.MetricsPortFlag,
 			//utils.FakePoWFlag,
 			//utils.NoCompactionFlag,
 		}, debug.Flags...),
@@ -0,0 +1,147 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// tomosigner is the reference remote signer for masternodes. It keeps the
+// masternode keys away from the node, which connects to it with --signer, and
+// refuses to sign two different block headers at the same height.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/tomochain/tomochain/accounts"
+	"github.com/tomochain/tomochain/accounts/keystore"
+	"github.com/tomochain/tomochain/accounts/remote"
+	"github.com/tomochain/tomochain/cmd/utils"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/ethdb/leveldb"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/rpc"
+)
+
+func main() {
+	var (
+		keydir    = flag.String("keystore", "", "directory of the keystore holding the masternode keys")
+		unlock    = flag.String("unlock", "", "comma separated list of accounts to unlock")
+		password  = flag.String("password", "", "password file, one line per unlocked account")
+		datadir   = flag.String("datadir", "", "directory of the slashing protection database")
+		ipcPath   = flag.String("ipcpath", "", "IPC endpoint to serve (default <datadir>/tomosigner.ipc)")
+		httpAddr  = flag.String("http", "", "HTTP listen address, e.g. 127.0.0.1:8550 (disabled by default)")
+		vhosts    = flag.String("http.vhosts", "localhost", "comma separated list of virtual hostnames accepted over HTTP")
+		chainID   = flag.Uint64("chainid", 88, "chain identifier of the accepted transactions (0 accepts any chain)")
+		verbosity = flag.Int("verbosity", int(log.LvlInfo), "log verbosity (0-9)")
+	)
+	flag.Parse()
+
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(*verbosity))
+	log.Root().SetHandler(glogger)
+
+	if *keydir == "" || *datadir == "" {
+		utils.Fatalf("Use -keystore and -datadir to specify the keys and the slashing protection database")
+	}
+	ks := keystore.NewKeyStore(*keydir, keystore.StandardScryptN, keystore.StandardScryptP)
+	if err := unlockAccounts(ks, *unlock, *password); err != nil {
+		utils.Fatalf("%v", err)
+	}
+	db, err := leveldb.New(filepath.Join(*datadir, "protection"), 16, 16, "")
+	if err != nil {
+		utils.Fatalf("Failed to open slashing protection database: %v", err)
+	}
+	defer db.Close()
+
+	var id *big.Int
+	if *chainID != 0 {
+		id = new(big.Int).SetUint64(*chainID)
+	}
+	server := rpc.NewServer()
+	for _, api := range remote.NewSigner(ks, db, id).APIs() {
+		if err := server.RegisterName(api.Namespace, api.Service); err != nil {
+			utils.Fatalf("Failed to register signer API: %v", err)
+		}
+	}
+	endpoint := *ipcPath
+	if endpoint == "" {
+		endpoint = filepath.Join(*datadir, "tomosigner.ipc")
+	}
+	listener, err := rpc.CreateIPCListener(endpoint)
+	if err != nil {
+		utils.Fatalf("Failed to listen on %s: %v", endpoint, err)
+	}
+	go server.ServeListener(listener)
+	log.Info("IPC endpoint opened", "url", endpoint)
+
+	if *httpAddr != "" {
+		listener, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			utils.Fatalf("Failed to listen on %s: %v", *httpAddr, err)
+		}
+		go rpc.NewHTTPServer(nil, strings.Split(*vhosts, ","), server).Serve(listener)
+		log.Info("HTTP endpoint opened", "url", "http://"+*httpAddr)
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	<-sigc
+	log.Info("Shutting down signer")
+	listener.Close()
+}
+
+// unlockAccounts unlocks the listed accounts for the lifetime of the signer
+// with the passwords of the password file.
+func unlockAccounts(ks *keystore.KeyStore, unlock string, passfile string) error {
+	if unlock == "" {
+		return nil
+	}
+	var passwords []string
+	if passfile != "" {
+		text, err := ioutil.ReadFile(passfile)
+		if err != nil {
+			return err
+		}
+		passwords = strings.Split(strings.TrimRight(string(text), "\r\n"), "\n")
+	}
+	for i, addr := range strings.Split(unlock, ",") {
+		addr = strings.TrimSpace(addr)
+		if !common.IsHexAddress(addr) {
+			return fmt.Errorf("invalid account %q", addr)
+		}
+		password := ""
+		if len(passwords) > 0 {
+			password = strings.TrimRight(passwords[min(i, len(passwords)-1)], "\r")
+		}
+		if err := ks.Unlock(accounts.Account{Address: common.HexToAddress(addr)}, password); err != nil {
+			return err
+		}
+		log.Info("Unlocked account", "address", addr)
+	}
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
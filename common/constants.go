@@ -11,6 +11,12 @@ const (
 	HexSignMethod              = "e341eaa4"
 	HexSetSecret               = "34d38600"
 	HexSetOpening              = "e11f5ba2"
+	HexSetSigningKey           = "f061bf0b"
+	HexSubmitPrice             = "ba0188ee"
+	HexSetOracleFeeder         = "de66acd2"
+	HexSetOracleConfig         = "644fe111"
+	HexSetLiquidationBonus     = "7cadffd9"
+	HexSetRepayPolicy          = "01807ff5"
 	EpocBlockSecret            = 800
 	EpocBlockOpening           = 850
 	EpocBlockRandomize         = 900
@@ -25,6 +31,10 @@ const (
 	IgnoreSignerCheckBlock     = uint64(14458500)
 	OneYear                    = uint64(365 * 86400)
 	LiquidateLendingTradeBlock = uint64(100)
+	OracleMinFeeders           = uint64(3)
+	OraclePriceMaxAge          = uint64(900) // blocks
+	OracleMaxDeviation         = uint64(10)  // %
+	OracleFallbackAges         = uint64(4)
 )
 
 var Rewound = uint64(0)
@@ -37,6 +47,14 @@ var BlackListHFNumber = uint64(9349100)
 var TIPTomoX = big.NewInt(20581700)
 var TIPTomoXLending = big.NewInt(21430200)
 var TIPTomoXCancellationFee = big.NewInt(30915660)
+var TIPSigningKey = big.NewInt(9999999999)
+var TIPTomoXPartialRepay = big.NewInt(9999999999)
+var TIPTomoXPartialLiquidation = big.NewInt(9999999999)
+var TIPTomoXPriceOracle = big.NewInt(9999999999)
+var TIPTomoXRollover = big.NewInt(9999999999)
+var TIPTomoXMultiCollateral = big.NewInt(9999999999)
+var TIPTomoXTradeTransfer = big.NewInt(9999999999)
+var TIPTomoXBorrowerPolicy = big.NewInt(9999999999)
 var TIPTomoXTestnet = big.NewInt(0)
 var IsTestnet bool = false
 var StoreRewardFolder string
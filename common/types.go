@@ -39,6 +39,10 @@ const (
 	TradingStateAddr                  = "0x0000000000000000000000000000000000000092"
 	TomoXLendingAddress               = "0x0000000000000000000000000000000000000093"
 	TomoXLendingFinalizedTradeAddress = "0x0000000000000000000000000000000000000094"
+	SigningKeyRegistry                = "0x0000000000000000000000000000000000000095"
+	LendingPriceOracle                = "0x0000000000000000000000000000000000000096"
+	LendingCollateralBasket           = "0x0000000000000000000000000000000000000097"
+	LendingRepayPolicy                = "0x0000000000000000000000000000000000000098"
 	TomoNativeAddress                 = "0x0000000000000000000000000000000000000001"
 	LendingLockAddress                = "0x0000000000000000000000000000000000000011"
 	VoteMethod                        = "0x6dd7d8ea"
@@ -47,6 +47,7 @@ import (
 	"github.com/tomochain/tomochain/crypto/sha3"
 	"github.com/tomochain/tomochain/ethdb"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
 	"github.com/tomochain/tomochain/params"
 	"github.com/tomochain/tomochain/rlp"
 	"github.com/tomochain/tomochain/rpc"
@@ -93,6 +94,12 @@ type LendingService interface {
 }
 
 // Posv proof-of-stake-voting protocol constants.
+var (
+	// Masternode turns: blocks created in turn, and turns skipped before a block
+	turnsCounter       = metrics.NewRegisteredCounter("posv/turns", nil)
+	missedTurnsCounter = metrics.NewRegisteredCounter("posv/turns/missed", nil)
+)
+
 var (
 	epochLength = uint64(900) // Default number of blocks after which to checkpoint and reset the pending votes
 
@@ -171,6 +178,10 @@ var (
 
 	errFailedDoubleValidation = errors.New("wrong pair of creator-validator in double validation")
 
+	// errInvalidSigningKey is returned if a block is sealed by a key which is not
+	// the one registered for its creator.
+	errInvalidSigningKey = errors.New("block sealed with an unregistered signing key")
+
 	// errWaitTransactions is returned if an empty block is attempted to be sealed
 	// on an instant chain (0 second period). It's important to refuse these as the
 	// block reward is zero, so an empty block just bloats the chain... fast.
@@ -243,6 +254,27 @@ func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, er
 	return signer, nil
 }
 
+// isSigningKeyFork reports whether masternodes seal the given block with their
+// registered signing keys.
+func isSigningKeyFork(number *big.Int) bool {
+	return number != nil && common.TIPSigningKey.Cmp(number) <= 0
+}
+
+// recoverCreator returns the masternode which created the header. From the
+// signing key fork on the header names its creator as coinbase, verifySeal
+// checks the sealing key against the one its checkpoint lists for the creator.
+func recoverCreator(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	signer, err := ecrecover(header, sigcache)
+	if err != nil || !isSigningKeyFork(header.Number) {
+		return signer, err
+	}
+	return header.Coinbase, nil
+}
+
+// HeaderSignerFn is a signer callback function to request the seal signature of
+// a header from a backing account.
+type HeaderSignerFn func(accounts.Account, *types.Header) ([]byte, error)
+
 // Posv is the proof-of-stake-voting consensus engine proposed to support the
 // Ethereum testnet following the Ropsten attacks.
 type Posv struct {
@@ -253,11 +285,18 @@ type Posv struct {
 	signatures          *lru.ARCCache // Signatures of recent blocks to speed up mining
 	validatorSignatures *lru.ARCCache // Signatures of recent blocks to speed up mining
 	verifiedHeaders     *lru.ARCCache
+	epochKeys           *lru.ARCCache           // Signing keys listed by recent checkpoints
 	proposals           map[common.Address]bool // Current list of proposals we are pushing
 
-	signer common.Address  // Ethereum address of the signing key
-	signFn clique.SignerFn // Signer function to authorize hashes with
-	lock   sync.RWMutex    // Protects the signer fields
+	signer       common.Address  // Ethereum address of the signing key
+	signFn       clique.SignerFn // Signer function to authorize hashes with
+	signHeaderFn HeaderSignerFn  // Signer function to authorize headers with, preferred over signFn
+	lock         sync.RWMutex    // Protects the signer fields
+
+	now func() time.Time // Clock used to timestamp headers and reject future blocks
+
+	turnsHead uint64     // Number of the last block whose turn was counted
+	turnsLock sync.Mutex // Protects turnsHead
 
 	BlockSigners               *lru.Cache
 	HookReward                 func(chain consensus.ChainReader, state *state.StateDB, parentState *state.StateDB, header *types.Header) (error, map[string]interface{})
@@ -268,6 +307,7 @@ type Posv struct {
 	GetTomoXService            func() TradingService
 	GetLendingService          func() LendingService
 	HookGetSignersFromContract func(blockHash common.Hash) ([]common.Address, error)
+	HookSigningKeys            func(chain consensus.ChainReader, parent *types.Header, masternodes []common.Address) ([]common.Address, error)
 }
 
 // New creates a PoSV proof-of-stake-voting consensus engine with the initial
@@ -284,6 +324,7 @@ func New(config *params.PosvConfig, db ethdb.Database) *Posv {
 	signatures, _ := lru.NewARC(inmemorySnapshots)
 	validatorSignatures, _ := lru.NewARC(inmemorySnapshots)
 	verifiedHeaders, _ := lru.NewARC(inmemorySnapshots)
+	epochKeys, _ := lru.NewARC(inmemorySnapshots)
 	return &Posv{
 		config:              &conf,
 		db:                  db,
@@ -291,20 +332,50 @@ func New(config *params.PosvConfig, db ethdb.Database) *Posv {
 		recents:             recents,
 		signatures:          signatures,
 		verifiedHeaders:     verifiedHeaders,
+		epochKeys:           epochKeys,
 		validatorSignatures: validatorSignatures,
 		proposals:           make(map[common.Address]bool),
+		now:                 time.Now,
 	}
 }
 
+// SetClock replaces the wall clock of the engine. It is meant for simulations
+// which need deterministic header timestamps.
+func (c *Posv) SetClock(now func() time.Time) {
+	c.now = now
+}
+
 // Author implements consensus.Engine, returning the Ethereum address recovered
 // from the signature in the header's extra-data section.
 func (c *Posv) Author(header *types.Header) (common.Address, error) {
-	return ecrecover(header, c.signatures)
+	return recoverCreator(header, c.signatures)
 }
 
 // Get signer coinbase
 func (c *Posv) Signer() common.Address { return c.signer }
 
+// Candidate returns the masternode the local signer creates blocks for on top
+// of parent, or the zero address if its key can't be resolved.
+func (c *Posv) Candidate(chain consensus.ChainReader, parent *types.Header) common.Address {
+	c.lock.RLock()
+	signer := c.signer
+	c.lock.RUnlock()
+
+	var (
+		candidate common.Address
+		err       error
+	)
+	if number := new(big.Int).Add(parent.Number, common.Big1); isSigningKeyFork(number) && number.Uint64()%c.config.Epoch == 0 {
+		candidate, err = c.nextCheckpointCandidate(chain, parent, signer)
+	} else {
+		candidate, err = c.SigningCandidate(chain, parent, signer)
+	}
+	if err != nil {
+		log.Warn("Failed to resolve the candidate of the signing key", "key", signer, "number", parent.Number, "err", err)
+	}
+	return candidate
+}
+
 // VerifyHeader checks whether a header conforms to the consensus rules.
 func (c *Posv) VerifyHeader(chain consensus.ChainReader, header *types.Header, fullVerify bool) error {
 	return c.verifyHeaderWithCache(chain, header, nil, fullVerify)
@@ -360,13 +431,14 @@ func (c *Posv) verifyHeader(chain consensus.ChainReader, header *types.Header, p
 			return consensus.ErrNoValidatorSignature
 		}
 		// Don't waste time checking blocks from the future
-		if header.Time.Cmp(big.NewInt(time.Now().Unix())) > 0 {
+		if header.Time.Cmp(big.NewInt(c.now().Unix())) > 0 {
 			return consensus.ErrFutureBlock
 		}
 	}
-	// Checkpoint blocks need to enforce zero beneficiary
+	// Checkpoint blocks need to enforce zero beneficiary, until the beneficiary
+	// names the creator of every block
 	checkpoint := (number % c.config.Epoch) == 0
-	if checkpoint && header.Coinbase != (common.Address{}) {
+	if checkpoint && header.Coinbase != (common.Address{}) && !isSigningKeyFork(header.Number) {
 		return errInvalidCheckpointBeneficiary
 	}
 
@@ -392,6 +464,10 @@ func (c *Posv) verifyHeader(chain consensus.ChainReader, header *types.Header, p
 	if checkpoint && signersBytes%common.AddressLength != 0 {
 		return errInvalidCheckpointSigners
 	}
+	// From the signing key fork on, checkpoints list a key for every masternode
+	if checkpoint && number > 0 && isSigningKeyFork(header.Number) && signersBytes%(2*common.AddressLength) != 0 {
+		return errInvalidCheckpointSigners
+	}
 	// Ensure that the mix digest is zero as we don't have fork protection currently
 	if header.MixDigest != (common.Hash{}) {
 		return errInvalidMixDigest
@@ -495,8 +571,7 @@ func (c *Posv) checkSignersOnCheckpoint(chain consensus.ChainReader, header *typ
 			signers = RemovePenaltiesFromBlock(chain, signers, number-uint64(i)*c.config.Epoch)
 		}
 	}
-	extraSuffix := len(header.Extra) - extraSeal
-	masternodesFromCheckpointHeader := common.ExtractAddressFromBytes(header.Extra[extraVanity:extraSuffix])
+	masternodesFromCheckpointHeader, _ := checkpointAddresses(header)
 	validSigners := compareSignersLists(masternodesFromCheckpointHeader, signers)
 
 	if !validSigners {
@@ -571,7 +646,7 @@ func whoIsCreator(snap *Snapshot, header *types.Header) (common.Address, error)
 	if header.Number.Uint64() == 0 {
 		return common.Address{}, errors.New("Don't take block 0")
 	}
-	m, err := ecrecover(header, snap.sigcache)
+	m, err := recoverCreator(header, snap.sigcache)
 	if err != nil {
 		return common.Address{}, err
 	}
@@ -733,7 +808,7 @@ func (c *Posv) verifySeal(chain consensus.ChainReader, header *types.Header, par
 	}
 
 	// Resolve the authorization key and check against signers
-	creator, err := ecrecover(header, c.signatures)
+	creator, err := recoverCreator(header, c.signatures)
 	if err != nil {
 		return err
 	}
@@ -773,6 +848,22 @@ func (c *Posv) verifySeal(chain consensus.ChainReader, header *types.Header, par
 			return errUnauthorized
 		}
 	}
+	// From the signing key fork on, the creator must seal with the key listed for
+	// it by the checkpoint of the epoch
+	var keys *epochKeys
+	if isSigningKeyFork(header.Number) {
+		if keys, err = c.epochSigningKeys(chain, header, parents); err != nil {
+			return err
+		}
+		signer, err := ecrecover(header, c.signatures)
+		if err != nil {
+			return err
+		}
+		if signer != keys.keyOf(creator) {
+			log.Debug("Block sealed with an unregistered key", "number", number, "creator", creator, "signer", signer)
+			return errInvalidSigningKey
+		}
+	}
 	if len(masternodes) > 1 {
 		for seen, recent := range snap.Recents {
 			if recent == creator {
@@ -801,6 +892,10 @@ func (c *Posv) verifySeal(chain consensus.ChainReader, header *types.Header, par
 		if err != nil {
 			return err
 		}
+		// The validator signs with the key listed for it by the checkpoint
+		if keys != nil {
+			assignedValidator = keys.keyOf(assignedValidator)
+		}
 		if validator != assignedValidator {
 			log.Debug("Bad block detected. Header contains wrong pair of creator-validator", "creator", creator, "assigned validator", assignedValidator, "wrong validator", validator)
 			return errFailedDoubleValidation
@@ -847,7 +942,15 @@ func (c *Posv) Prepare(chain consensus.ChainReader, header *types.Header) error
 	if err != nil {
 		return err
 	}
-	if number%c.config.Epoch != 0 {
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	creator := c.Candidate(chain, parent)
+	if isSigningKeyFork(header.Number) {
+		// The beneficiary names the creator instead of casting votes
+		header.Coinbase = creator
+	} else if number%c.config.Epoch != 0 {
 		c.lock.RLock()
 
 		// Gather all the proposals that make sense voting on
@@ -868,12 +971,8 @@ func (c *Posv) Prepare(chain consensus.ChainReader, header *types.Header) error
 		}
 		c.lock.RUnlock()
 	}
-	parent := chain.GetHeader(header.ParentHash, number-1)
-	if parent == nil {
-		return consensus.ErrUnknownAncestor
-	}
 	// Set the correct difficulty
-	header.Difficulty = c.calcDifficulty(chain, parent, c.signer)
+	header.Difficulty = c.calcDifficulty(chain, parent, creator)
 	log.Debug("CalcDifficulty ", "number", header.Number, "difficulty", header.Difficulty)
 	// Ensure the extra data has all it's components
 	if len(header.Extra) < extraVanity {
@@ -911,6 +1010,18 @@ func (c *Posv) Prepare(chain consensus.ChainReader, header *types.Header) error
 		for _, masternode := range masternodes {
 			header.Extra = append(header.Extra, masternode[:]...)
 		}
+		// List the keys sealing the new epoch after the masternodes
+		if isSigningKeyFork(header.Number) {
+			keys := masternodes
+			if c.HookSigningKeys != nil {
+				if keys, err = c.HookSigningKeys(chain, parent, masternodes); err != nil {
+					return err
+				}
+			}
+			for _, key := range keys {
+				header.Extra = append(header.Extra, key[:]...)
+			}
+		}
 		if c.HookValidator != nil {
 			validators, err := c.HookValidator(header, masternodes)
 			if err != nil {
@@ -927,8 +1038,8 @@ func (c *Posv) Prepare(chain consensus.ChainReader, header *types.Header) error
 	// Ensure the timestamp has the correct delay
 
 	header.Time = new(big.Int).Add(parent.Time, new(big.Int).SetUint64(c.config.Period))
-	if header.Time.Int64() < time.Now().Unix() {
-		header.Time = big.NewInt(time.Now().Unix())
+	if now := c.now().Unix(); header.Time.Int64() < now {
+		header.Time = big.NewInt(now)
 	}
 	return nil
 }
@@ -958,6 +1069,11 @@ func (c *Posv) UpdateMasternodes(chain consensus.ChainReader, header *types.Head
 // Finalize implements consensus.Engine, ensuring no uncles are set, nor block
 // rewards given, and returns the final block.
 func (c *Posv) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, parentState *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	if chain.Config().IsTIPSigningKey(header.Number) && header.Number.Uint64()%c.config.Epoch == 0 {
+		if err := verifyCheckpointKeys(header, state); err != nil {
+			return nil, err
+		}
+	}
 	// set block reward
 	number := header.Number.Uint64()
 	rCheckpoint := chain.Config().Posv.RewardCheckpoint
@@ -988,6 +1104,25 @@ func (c *Posv) Finalize(chain consensus.ChainReader, header *types.Header, state
 	return types.NewBlock(header, txs, nil, receipts), nil
 }
 
+// verifyCheckpointKeys checks that the checkpoint lists, for every masternode,
+// the key the registry holds for it at the checkpoint. Registrations made
+// during the epoch only take over at the next checkpoint, so the list holds for
+// the whole epoch.
+func verifyCheckpointKeys(header *types.Header, statedb *state.StateDB) error {
+	number := header.Number.Uint64()
+	masternodes, keys := checkpointAddresses(header)
+	if len(keys) != len(masternodes) {
+		return errInvalidCheckpointSigners
+	}
+	for i, masternode := range masternodes {
+		if state.GetSigningKey(statedb, masternode, number) != keys[i] {
+			log.Debug("Checkpoint lists an unregistered signing key", "number", number, "masternode", masternode, "key", keys[i])
+			return errInvalidSigningKey
+		}
+	}
+	return nil
+}
+
 // Authorize injects a private key into the consensus engine to mint new blocks
 // with.
 func (c *Posv) Authorize(signer common.Address, signFn clique.SignerFn) {
@@ -996,6 +1131,18 @@ func (c *Posv) Authorize(signer common.Address, signFn clique.SignerFn) {
 
 	c.signer = signer
 	c.signFn = signFn
+	c.signHeaderFn = nil
+}
+
+// AuthorizeHeaders injects a signer which is handed the headers to seal instead
+// of their seal hash, e.g. a remote signer with slashing protection.
+func (c *Posv) AuthorizeHeaders(signer common.Address, signFn HeaderSignerFn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.signer = signer
+	c.signFn = nil
+	c.signHeaderFn = signFn
 }
 
 // Seal implements consensus.Engine, attempting to create a sealed block using
@@ -1015,7 +1162,7 @@ func (c *Posv) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan
 	}
 	// Don't hold the signer fields for the entire sealing procedure
 	c.lock.RLock()
-	signer, signFn := c.signer, c.signFn
+	signer, signFn, signHeaderFn := c.signer, c.signFn, c.signHeaderFn
 	c.lock.RUnlock()
 
 	// Bail out if we're unauthorized to sign a block
@@ -1023,11 +1170,15 @@ func (c *Posv) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan
 	if err != nil {
 		return nil, err
 	}
+	creator, err := c.SigningCandidate(chain, header, signer)
+	if err != nil {
+		return nil, err
+	}
 	masternodes := c.GetMasternodes(chain, header)
-	if _, authorized := snap.Signers[signer]; !authorized {
+	if _, authorized := snap.Signers[creator]; !authorized {
 		valid := false
 		for _, m := range masternodes {
-			if m == signer {
+			if m == creator {
 				valid = true
 				break
 			}
@@ -1040,7 +1191,7 @@ func (c *Posv) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan
 	// only check recent signers if there are more than one signer.
 	if len(masternodes) > 1 {
 		for seen, recent := range snap.Recents {
-			if recent == signer {
+			if recent == creator {
 				// Signer is among recents, only wait if the current block doesn't shift it out
 				// There is only case that we don't allow signer to create two continuous blocks.
 				if limit := uint64(2); number < limit || seen > number-limit {
@@ -1060,16 +1211,21 @@ func (c *Posv) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan
 	default:
 	}
 	// Sign all the things!
-	sighash, err := signFn(accounts.Account{Address: signer}, sigHash(header).Bytes())
+	var sighash []byte
+	if signHeaderFn != nil {
+		sighash, err = signHeaderFn(accounts.Account{Address: signer}, header)
+	} else {
+		sighash, err = signFn(accounts.Account{Address: signer}, sigHash(header).Bytes())
+	}
 	if err != nil {
 		return nil, err
 	}
 	copy(header.Extra[len(header.Extra)-extraSeal:], sighash)
-	m2, err := c.GetValidator(signer, chain, header)
+	m2, err := c.GetValidator(creator, chain, header)
 	if err != nil {
 		return nil, fmt.Errorf("can't get block validator: %v", err)
 	}
-	if m2 == signer {
+	if m2 == creator {
 		header.Validator = sighash
 	}
 	return block.WithSeal(header), nil
@@ -1079,7 +1235,7 @@ func (c *Posv) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan
 // that a new block should have based on the previous blocks in the chain and the
 // current signer.
 func (c *Posv) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
-	return c.calcDifficulty(chain, parent, c.signer)
+	return c.calcDifficulty(chain, parent, c.Candidate(chain, parent))
 }
 
 func (c *Posv) calcDifficulty(chain consensus.ChainReader, parent *types.Header, signer common.Address) *big.Int {
@@ -1101,8 +1257,9 @@ func (c *Posv) APIs(chain consensus.ChainReader) []rpc.API {
 	}}
 }
 
+// RecoverSigner returns the masternode which created the header.
 func (c *Posv) RecoverSigner(header *types.Header) (common.Address, error) {
-	return ecrecover(header, c.signatures)
+	return recoverCreator(header, c.signatures)
 }
 
 func (c *Posv) RecoverValidator(header *types.Header) (common.Address, error) {
@@ -1134,11 +1291,7 @@ func (c *Posv) GetMasternodesFromCheckpointHeader(preCheckpointHeader *types.Hea
 		log.Info("Previous checkpoint's header is empty", "block number", n, "epoch", e)
 		return []common.Address{}
 	}
-	masternodes := make([]common.Address, (len(preCheckpointHeader.Extra)-extraVanity-extraSeal)/common.AddressLength)
-	for i := 0; i < len(masternodes); i++ {
-		copy(masternodes[i][:], preCheckpointHeader.Extra[extraVanity+i*common.AddressLength:])
-	}
-
+	masternodes, _ := checkpointAddresses(preCheckpointHeader)
 	return masternodes
 }
 
@@ -1205,10 +1358,7 @@ func RemovePenaltiesFromBlock(chain consensus.ChainReader, masternodes []common.
 
 // Get masternodes address from checkpoint Header.
 func GetMasternodesFromCheckpointHeader(checkpointHeader *types.Header) []common.Address {
-	masternodes := make([]common.Address, (len(checkpointHeader.Extra)-extraVanity-extraSeal)/common.AddressLength)
-	for i := 0; i < len(masternodes); i++ {
-		copy(masternodes[i][:], checkpointHeader.Extra[extraVanity+i*common.AddressLength:])
-	}
+	masternodes, _ := checkpointAddresses(checkpointHeader)
 	return masternodes
 }
 
@@ -1276,6 +1426,60 @@ func Hop(len, pre, cur int) int {
 	}
 }
 
+// CountTurns records the masternode turns of the canonical blocks up to head
+// which were not counted yet. It follows the chain head events, so that every
+// block number is counted once, off the consensus path.
+func (c *Posv) CountTurns(chain consensus.ChainReader, head *types.Header) {
+	if !metrics.Enabled {
+		return
+	}
+	c.turnsLock.Lock()
+	defer c.turnsLock.Unlock()
+
+	number := head.Number.Uint64()
+	from := c.turnsHead + 1
+	if c.turnsHead == 0 {
+		// The blocks before the first head were not seen by this node
+		from = number
+	}
+	if number < from {
+		return
+	}
+	c.turnsHead = number
+	if from < 2 {
+		from = 2
+	}
+	var headers []*types.Header
+	for header := head; header != nil && header.Number.Uint64() >= from; header = chain.GetHeader(header.ParentHash, header.Number.Uint64()-1) {
+		headers = append(headers, header)
+	}
+	for i := len(headers) - 1; i >= 0; i-- {
+		c.countTurn(chain, headers[i])
+	}
+}
+
+// countTurn records whether the creator of header took its turn, and how many
+// masternodes missed theirs before it did.
+func (c *Posv) countTurn(chain consensus.ChainReader, header *types.Header) {
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return
+	}
+	creator, err := recoverCreator(header, c.signatures)
+	if err != nil {
+		return
+	}
+	length, preIndex, curIndex, ok, err := c.YourTurn(chain, parent, creator)
+	if err != nil || preIndex < 0 || curIndex < 0 {
+		return
+	}
+	if ok {
+		turnsCounter.Inc(1)
+		return
+	}
+	missedTurnsCounter.Inc(int64(Hop(length, preIndex, curIndex)))
+}
+
 func (c *Posv) CheckMNTurn(chain consensus.ChainReader, parent *types.Header, signer common.Address) bool {
 	masternodes := c.GetMasternodes(chain, parent)
 
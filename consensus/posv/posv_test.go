@@ -1,12 +1,16 @@
 package posv
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"math/big"
 	"testing"
 
 	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
 	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/metrics"
 	"github.com/tomochain/tomochain/params"
 )
 
@@ -82,3 +86,83 @@ func TestCompareSignersLists(t *testing.T) {
 		t.Error("Failed with list has only one signer")
 	}
 }
+
+// testHeaderChain is a consensus.ChainReader over a single branch of headers.
+type testHeaderChain []*types.Header
+
+func (hc testHeaderChain) Config() *params.ChainConfig  { return params.TestChainConfig }
+func (hc testHeaderChain) CurrentHeader() *types.Header { return hc[len(hc)-1] }
+func (hc testHeaderChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := hc.GetHeaderByNumber(number); header != nil && header.Hash() == hash {
+		return header
+	}
+	return nil
+}
+func (hc testHeaderChain) GetHeaderByNumber(number uint64) *types.Header {
+	if number < uint64(len(hc)) {
+		return hc[number]
+	}
+	return nil
+}
+func (hc testHeaderChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	for _, header := range hc {
+		if header.Hash() == hash {
+			return header
+		}
+	}
+	return nil
+}
+func (hc testHeaderChain) GetBlock(hash common.Hash, number uint64) *types.Block { return nil }
+
+func TestCountTurns(t *testing.T) {
+	enabled := metrics.Enabled
+	turns, missed := turnsCounter, missedTurnsCounter
+	metrics.Enabled = true
+	turnsCounter, missedTurnsCounter = metrics.NewCounter(), metrics.NewCounter()
+	defer func() {
+		metrics.Enabled = enabled
+		turnsCounter, missedTurnsCounter = turns, missed
+	}()
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	extra := make([]byte, extraVanity)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		extra = append(extra, crypto.PubkeyToAddress(keys[i].PublicKey).Bytes()...)
+	}
+	genesis := &types.Header{
+		Number:    common.Big0,
+		Time:      common.Big0,
+		UncleHash: uncleHash,
+		Extra:     append(extra, make([]byte, extraSeal)...),
+	}
+	// Every masternode takes its turn, except the one skipped at block 4
+	chain := testHeaderChain{genesis}
+	for number, creator := range []int{0, 1, 2, 1, 2, 0} {
+		header := &types.Header{
+			ParentHash: chain[len(chain)-1].Hash(),
+			Number:     big.NewInt(int64(number + 1)),
+			Time:       big.NewInt(int64(number + 1)),
+			UncleHash:  uncleHash,
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		sig, err := crypto.Sign(sigHash(header).Bytes(), keys[creator])
+		if err != nil {
+			t.Fatalf("failed to seal block %d: %v", number+1, err)
+		}
+		copy(header.Extra[extraVanity:], sig)
+		chain = append(chain, header)
+	}
+	c := New(&params.PosvConfig{Epoch: 900}, rawdb.NewMemoryDatabase())
+
+	// Heads are reported once per insertion, and again on reorgs
+	for _, number := range []int{1, 3, 3, 2, 6} {
+		c.CountTurns(chain, chain[number])
+	}
+	if have := turnsCounter.Count(); have != 4 {
+		t.Errorf("turns mismatch: have %d, want %d", have, 4)
+	}
+	if have := missedTurnsCounter.Count(); have != 1 {
+		t.Errorf("missed turns mismatch: have %d, want %d", have, 1)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package posv
+
+import (
+	"errors"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus"
+	"github.com/tomochain/tomochain/core/types"
+)
+
+// errUnknownCheckpoint is returned if the checkpoint listing the signing keys of
+// an epoch is not available.
+var errUnknownCheckpoint = errors.New("unknown checkpoint header")
+
+// epochKeys is the set of signing keys listed by a checkpoint header.
+type epochKeys struct {
+	keys       map[common.Address]common.Address // masternode => key sealing its blocks
+	candidates map[common.Address]common.Address // key => masternode it seals for
+}
+
+// newEpochKeys indexes the keys the masternodes seal with, listed in the same
+// order.
+func newEpochKeys(masternodes []common.Address, keys []common.Address) *epochKeys {
+	epoch := &epochKeys{
+		keys:       make(map[common.Address]common.Address, len(keys)),
+		candidates: make(map[common.Address]common.Address, len(keys)),
+	}
+	for i, key := range keys {
+		epoch.keys[masternodes[i]] = key
+		epoch.candidates[key] = masternodes[i]
+	}
+	return epoch
+}
+
+// keyOf returns the key the masternode seals with in the epoch. Masternodes which
+// are not listed seal with their own address.
+func (e *epochKeys) keyOf(masternode common.Address) common.Address {
+	if key, ok := e.keys[masternode]; ok {
+		return key
+	}
+	return masternode
+}
+
+// candidateOf returns the masternode the key seals for in the epoch. Keys of
+// no listed masternode act for themselves, while the address of a masternode
+// which rotated its key acts for nobody.
+func (e *epochKeys) candidateOf(key common.Address) common.Address {
+	if candidate, ok := e.candidates[key]; ok {
+		return candidate
+	}
+	if _, ok := e.keys[key]; ok {
+		return common.Address{}
+	}
+	return key
+}
+
+// checkpointAddresses splits the addresses listed by a checkpoint header into the
+// masternodes of the epoch and, from the signing key fork on, the keys they seal
+// with, in the same order. The genesis block never lists keys.
+func checkpointAddresses(header *types.Header) (masternodes []common.Address, keys []common.Address) {
+	if len(header.Extra) < extraVanity+extraSeal {
+		return []common.Address{}, nil
+	}
+	addresses := common.ExtractAddressFromBytes(header.Extra[extraVanity : len(header.Extra)-extraSeal])
+	if header.Number.Sign() == 0 || !isSigningKeyFork(header.Number) {
+		return addresses, nil
+	}
+	half := len(addresses) / 2
+	return addresses[:half], addresses[half : 2*half]
+}
+
+// checkpointHeader returns the checkpoint header opening the epoch of header,
+// looking it up in the batch of parents being verified before the chain.
+func (c *Posv) checkpointHeader(chain consensus.ChainReader, header *types.Header, parents []*types.Header) *types.Header {
+	number := header.Number.Uint64()
+	if number%c.config.Epoch == 0 {
+		return header
+	}
+	checkpoint := number - number%c.config.Epoch
+	for i := len(parents) - 1; i >= 0; i-- {
+		if parents[i].Number.Uint64() == checkpoint {
+			return parents[i]
+		}
+	}
+	return chain.GetHeaderByNumber(checkpoint)
+}
+
+// epochSigningKeys returns the signing keys of the epoch of header, as listed by
+// its checkpoint.
+func (c *Posv) epochSigningKeys(chain consensus.ChainReader, header *types.Header, parents []*types.Header) (*epochKeys, error) {
+	checkpoint := c.checkpointHeader(chain, header, parents)
+	if checkpoint == nil {
+		return nil, errUnknownCheckpoint
+	}
+	hash := checkpoint.Hash()
+	if keys, ok := c.epochKeys.Get(hash); ok {
+		return keys.(*epochKeys), nil
+	}
+	epoch := newEpochKeys(checkpointAddresses(checkpoint))
+	c.epochKeys.Add(hash, epoch)
+	return epoch, nil
+}
+
+// SigningCandidate returns the masternode a signing key seals for at the given
+// header, as listed by the checkpoint of its epoch. Keys which were never
+// registered act for themselves, and the zero address is returned for the
+// address of a masternode which seals with another key.
+func (c *Posv) SigningCandidate(chain consensus.ChainReader, header *types.Header, key common.Address) (common.Address, error) {
+	if header == nil || !isSigningKeyFork(header.Number) {
+		return key, nil
+	}
+	keys, err := c.epochSigningKeys(chain, header, nil)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return keys.candidateOf(key), nil
+}
+
+// nextCheckpointCandidate returns the masternode the key seals for in the epoch
+// opened by the checkpoint on top of parent. That checkpoint doesn't exist yet,
+// so the keys are read from the registry through HookSigningKeys.
+func (c *Posv) nextCheckpointCandidate(chain consensus.ChainReader, parent *types.Header, key common.Address) (common.Address, error) {
+	snap, err := c.snapshot(chain, parent.Number.Uint64(), parent.Hash(), nil)
+	if err != nil {
+		return common.Address{}, err
+	}
+	masternodes := snap.GetSigners()
+	keys := masternodes
+	if c.HookSigningKeys != nil {
+		if keys, err = c.HookSigningKeys(chain, parent, masternodes); err != nil {
+			return common.Address{}, err
+		}
+	}
+	return newEpochKeys(masternodes, keys).candidateOf(key), nil
+}
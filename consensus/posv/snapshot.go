@@ -201,7 +201,7 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 			delete(snap.Recents, number-limit)
 		}
 		// Resolve the authorization key and check against signers
-		signer, err := ecrecover(header, s.sigcache)
+		signer, err := recoverCreator(header, s.sigcache)
 		if err != nil {
 			return nil, err
 		}
@@ -216,6 +216,11 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 		//}
 		snap.Recents[number] = signer
 
+		// The beneficiary names the creator from the signing key fork on, votes
+		// are no longer cast
+		if isSigningKeyFork(header.Number) {
+			continue
+		}
 		// Header authorized, discard any previous votes from the signer
 		for i, vote := range snap.Votes {
 			if vote.Signer == signer && vote.Address == header.Coinbase {
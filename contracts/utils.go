@@ -52,7 +52,9 @@ const (
 	extraSeal   = 65 // Fixed number of extra-data suffix bytes reserved for signer seal
 )
 
-type rewardLog struct {
+// RewardLog records how many blocks of a reward range a masternode signed
+// and the reward it earned for them.
+type RewardLog struct {
 	Sign   uint64   `json:"sign"`
 	Reward *big.Int `json:"reward"`
 }
@@ -317,18 +319,26 @@ func DecryptRandomizeFromSecretsAndOpening(secrets [][32]byte, opening [32]byte)
 }
 
 // Calculate reward for reward checkpoint.
-func GetRewardForCheckpoint(c *posv.Posv, chain consensus.ChainReader, header *types.Header, rCheckpoint uint64, totalSigner *uint64) (map[common.Address]*rewardLog, error) {
-	// Not reward for singer of genesis block and only calculate reward at checkpoint block.
+func GetRewardForCheckpoint(c *posv.Posv, chain consensus.ChainReader, header *types.Header, rCheckpoint uint64, totalSigner *uint64) (map[common.Address]*RewardLog, error) {
 	number := header.Number.Uint64()
+	return GetRewardForCheckpointFrom(c, chain, chain.GetHeader(header.ParentHash, number-1), number, rCheckpoint, totalSigner)
+}
+
+// GetRewardForCheckpointFrom counts the signatures of the reward range of the
+// given checkpoint number using the signer transactions included up to head.
+// With head right before the checkpoint it matches GetRewardForCheckpoint,
+// an earlier head gives the rewards known so far.
+func GetRewardForCheckpointFrom(c *posv.Posv, chain consensus.ChainReader, head *types.Header, number uint64, rCheckpoint uint64, totalSigner *uint64) (map[common.Address]*RewardLog, error) {
+	// Not reward for singer of genesis block and only calculate reward at checkpoint block.
 	prevCheckpoint := number - (rCheckpoint * 2)
 	startBlockNumber := prevCheckpoint + 1
 	endBlockNumber := startBlockNumber + rCheckpoint - 1
-	signers := make(map[common.Address]*rewardLog)
+	signers := make(map[common.Address]*RewardLog)
 	mapBlkHash := map[uint64]common.Hash{}
 
 	data := make(map[common.Hash][]common.Address)
-	for i := prevCheckpoint + (rCheckpoint * 2) - 1; i >= startBlockNumber; i-- {
-		header = chain.GetHeader(header.ParentHash, i)
+	header := head
+	for i := head.Number.Uint64(); i >= startBlockNumber; i-- {
 		mapBlkHash[i] = header.Hash()
 		signData, ok := c.BlockSigners.Get(header.Hash())
 		if !ok {
@@ -345,11 +355,15 @@ func GetRewardForCheckpoint(c *posv.Posv, chain consensus.ChainReader, header *t
 		txs := signData.([]*types.Transaction)
 		for _, tx := range txs {
 			blkHash := common.BytesToHash(tx.Data()[len(tx.Data())-32:])
-			from := *tx.From()
+			// Resolve the key active in the block including the transaction
+			from, err := c.SigningCandidate(chain, header, *tx.From())
+			if err != nil {
+				return nil, err
+			}
 			data[blkHash] = append(data[blkHash], from)
 		}
+		header = chain.GetHeader(header.ParentHash, i-1)
 	}
-	header = chain.GetHeader(header.ParentHash, prevCheckpoint)
 	masternodes := posv.GetMasternodesFromCheckpointHeader(header)
 
 	for i := startBlockNumber; i <= endBlockNumber; i++ {
@@ -374,7 +388,7 @@ func GetRewardForCheckpoint(c *posv.Posv, chain consensus.ChainReader, header *t
 					if exist {
 						signers[addr].Sign++
 					} else {
-						signers[addr] = &rewardLog{1, new(big.Int)}
+						signers[addr] = &RewardLog{1, new(big.Int)}
 					}
 					*totalSigner++
 				}
@@ -387,8 +401,21 @@ func GetRewardForCheckpoint(c *posv.Posv, chain consensus.ChainReader, header *t
 	return signers, nil
 }
 
+// ExcludeLowSigners removes the signers with less than minSigns signatures
+// from the reward list and returns the number of signatures left, so their
+// share is spread over the remaining signers.
+func ExcludeLowSigners(signers map[common.Address]*RewardLog, totalSigner uint64, minSigns uint64) uint64 {
+	for signer, rLog := range signers {
+		if rLog.Sign < minSigns {
+			totalSigner -= rLog.Sign
+			delete(signers, signer)
+		}
+	}
+	return totalSigner
+}
+
 // Calculate reward for signers.
-func CalculateRewardForSigner(chainReward *big.Int, signers map[common.Address]*rewardLog, totalSigner uint64) (map[common.Address]*big.Int, error) {
+func CalculateRewardForSigner(chainReward *big.Int, signers map[common.Address]*RewardLog, totalSigner uint64) (map[common.Address]*big.Int, error) {
 	resultSigners := make(map[common.Address]*big.Int)
 	// Add reward for signers.
 	if totalSigner > 0 {
@@ -418,26 +445,23 @@ func GetCandidatesOwnerBySigner(state *state.StateDB, signerAddr common.Address)
 	return owner
 }
 
-func CalculateRewardForHolders(foundationWalletAddr common.Address, state *state.StateDB, signer common.Address, calcReward *big.Int, blockNumber uint64) (error, map[common.Address]*big.Int) {
-	rewards, err := GetRewardBalancesRate(foundationWalletAddr, state, signer, calcReward, blockNumber)
+func CalculateRewardForHolders(policy *params.PosvRewardPolicy, foundationWalletAddr common.Address, state *state.StateDB, signer common.Address, calcReward *big.Int, blockNumber uint64) (error, map[common.Address]*big.Int) {
+	rewards, err := GetRewardBalancesRate(policy, foundationWalletAddr, state, signer, calcReward, blockNumber)
 	if err != nil {
 		return err, nil
 	}
 	return nil, rewards
 }
 
-func GetRewardBalancesRate(foundationWalletAddr common.Address, state *state.StateDB, masterAddr common.Address, totalReward *big.Int, blockNumber uint64) (map[common.Address]*big.Int, error) {
+func GetRewardBalancesRate(policy *params.PosvRewardPolicy, foundationWalletAddr common.Address, state *state.StateDB, masterAddr common.Address, totalReward *big.Int, blockNumber uint64) (map[common.Address]*big.Int, error) {
 	owner := GetCandidatesOwnerBySigner(state, masterAddr)
 	balances := make(map[common.Address]*big.Int)
-	rewardMaster := new(big.Int).Mul(totalReward, new(big.Int).SetInt64(common.RewardMasterPercent))
-	rewardMaster = new(big.Int).Div(rewardMaster, new(big.Int).SetInt64(100))
-	balances[owner] = rewardMaster
+	balances[owner] = policy.Share(totalReward, policy.MasterPercent)
 	// Get voters for masternode.
 	voters := stateDatabase.GetVoters(state, masterAddr)
 
 	if len(voters) > 0 {
-		totalVoterReward := new(big.Int).Mul(totalReward, new(big.Int).SetUint64(common.RewardVoterPercent))
-		totalVoterReward = new(big.Int).Div(totalVoterReward, new(big.Int).SetUint64(100))
+		totalVoterReward := policy.Share(totalReward, policy.VoterPercent)
 		totalCap := new(big.Int)
 		// Get voters capacities.
 		voterCaps := make(map[common.Address]*big.Int)
@@ -465,9 +489,7 @@ func GetRewardBalancesRate(foundationWalletAddr common.Address, state *state.Sta
 		}
 	}
 
-	foundationReward := new(big.Int).Mul(totalReward, new(big.Int).SetInt64(common.RewardFoundationPercent))
-	foundationReward = new(big.Int).Div(foundationReward, new(big.Int).SetInt64(100))
-	balances[foundationWalletAddr] = foundationReward
+	balances[foundationWalletAddr] = policy.Share(totalReward, policy.FoundationPercent)
 
 	jsonHolders, err := json.Marshal(balances)
 	if err != nil {
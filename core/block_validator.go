@@ -24,11 +24,16 @@ import (
 	"github.com/tomochain/tomochain/core/state"
 	"github.com/tomochain/tomochain/core/types"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
 	"github.com/tomochain/tomochain/params"
 	"github.com/tomochain/tomochain/tomox/tradingstate"
 	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
 )
 
+// blockTradesHistogram tracks the number of trades matched per block. It is
+// shared with TomoX, which records the trades of locally produced blocks.
+var blockTradesHistogram = metrics.GetOrRegisterHistogram("tomox/block/trades", nil, metrics.NewExpDecaySample(1028, 0.015))
+
 // BlockValidator is responsible for validating block headers, uncles and
 // processed state.
 //
@@ -116,6 +121,7 @@ func (v *BlockValidator) ValidateTradingOrder(statedb *state.StateDB, tomoxState
 	}
 	log.Debug("verify matching transaction found a TxMatches Batch", "numTxMatches", len(txMatchBatch.Data))
 	tradingResult := map[common.Hash]tradingstate.MatchingResult{}
+	trades := 0
 	for _, txMatch := range txMatchBatch.Data {
 		// verify orderItem
 		order, err := txMatch.DecodeOrder()
@@ -134,7 +140,9 @@ func (v *BlockValidator) ValidateTradingOrder(statedb *state.StateDB, tomoxState
 			Trades:  newTrades,
 			Rejects: newRejectedOrders,
 		}
+		trades += len(newTrades)
 	}
+	blockTradesHistogram.Update(int64(trades))
 	if tomoXService.IsSDKNode() {
 		v.bc.AddMatchingResult(txMatchBatch.TxHash, tradingResult)
 	}
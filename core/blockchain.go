@@ -40,6 +40,7 @@ import (
 	"github.com/tomochain/tomochain/consensus/posv"
 	contractValidator "github.com/tomochain/tomochain/contracts/validator/contract"
 	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/state/snapshot"
 	"github.com/tomochain/tomochain/core/types"
 	"github.com/tomochain/tomochain/core/vm"
 	"github.com/tomochain/tomochain/crypto"
@@ -58,6 +59,13 @@ var (
 	blockInsertTimer = metrics.NewRegisteredTimer("chain/inserts", nil)
 	CheckpointCh     = make(chan int)
 	ErrNoGenesis     = errors.New("Genesis not found in chain")
+
+	// SDK node synchronisation metrics: the delay between a block's timestamp
+	// and its matching data being stored, and the items of the block left to store.
+	sdkTradingLatencyTimer = metrics.NewRegisteredTimer("tomox/sdk/latency", nil)
+	sdkTradingQueueGauge   = metrics.NewRegisteredGauge("tomox/sdk/queue", nil)
+	sdkLendingLatencyTimer = metrics.NewRegisteredTimer("tomox/sdk/lending/latency", nil)
+	sdkLendingQueueGauge   = metrics.NewRegisteredGauge("tomox/sdk/lending/queue", nil)
 )
 
 const (
@@ -81,6 +89,9 @@ type CacheConfig struct {
 	Disabled      bool          // Whether to disable trie write caching (archive node)
 	TrieNodeLimit int           // Memory limit (MB) at which to flush the current in-memory trie to disk
 	TrieTimeLimit time.Duration // Time limit after which to flush the current in-memory trie to disk
+
+	PruneRetention uint64 // Number of recent block states kept by the online state pruner (0 = disabled)
+	SnapshotLimit  int    // Memory allowance (MB) to use for caching snapshot entries in memory (0 = disabled)
 }
 type ResultProcessBlock struct {
 	logs         []*types.Log
@@ -133,6 +144,7 @@ type BlockChain struct {
 	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
 
 	stateCache state.Database // State database to reuse between imports (contains state cache)
+	snaps      *snapshot.Tree // Snapshot tree for fast trie leaf access
 
 	bodyCache        *lru.Cache    // Cache for the most recent block bodies
 	bodyRLPCache     *lru.Cache    // Cache for the most recent block bodies in RLP encoded format
@@ -247,8 +259,18 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 			}
 		}
 	}
+	// Load any existing snapshot, regenerating it if loading failed
+	if cacheConfig.SnapshotLimit > 0 {
+		bc.snaps = snapshot.New(bc.db, bc.stateCache.TrieDB(), cacheConfig.SnapshotLimit, bc.CurrentBlock().Root(), true)
+	}
 	// Take ownership of this particular state
 	go bc.update()
+
+	// Prune stale state tries in the background, archive nodes keep everything
+	if cacheConfig.PruneRetention > 0 && !cacheConfig.Disabled {
+		bc.wg.Add(1)
+		go newStatePruner(bc, cacheConfig.PruneRetention).loop()
+	}
 	return bc, nil
 }
 
@@ -390,6 +412,14 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	bc.hc.SetHead(head, delFn)
 	currentHeader := bc.hc.CurrentHeader()
 
+	// Drop any frozen blocks above the new head, the freezer will re-migrate
+	// them once they become immutable again
+	if frozen, err := bc.db.Ancients(); err == nil && frozen > head+1 {
+		if err := bc.db.TruncateAncients(head + 1); err != nil {
+			return err
+		}
+	}
+
 	// Clear out any stale content from the caches
 	bc.bodyCache.Purge()
 	bc.bodyRLPCache.Purge()
@@ -426,6 +456,10 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	if err := WriteHeadFastBlockHash(bc.db, currentFastBlock.Hash()); err != nil {
 		log.Crit("Failed to reset head fast block", "err", err)
 	}
+	// Regenerate the state snapshot if the rewound head is not covered any more
+	if bc.snaps != nil && bc.snaps.Snapshot(currentBlock.Root()) == nil {
+		bc.snaps.Rebuild(currentBlock.Root())
+	}
 	return bc.loadLastState()
 }
 
@@ -501,7 +535,12 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 
 // StateAt returns a new mutable state based on a particular point in time.
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, bc.stateCache)
+	return state.NewWithSnapshot(root, bc.stateCache, bc.snaps)
+}
+
+// Snapshots returns the blockchain snapshot tree, nil if snapshots are disabled.
+func (bc *BlockChain) Snapshots() *snapshot.Tree {
+	return bc.snaps
 }
 
 // OrderStateAt returns a new mutable state based on a particular point in time.
@@ -731,8 +770,7 @@ func (bc *BlockChain) HasBlock(hash common.Hash, number uint64) bool {
 	if bc.blockCache.Contains(hash) {
 		return true
 	}
-	ok, _ := bc.db.Has(blockBodyKey(hash, number))
-	return ok
+	return HasBody(bc.db, hash, number)
 }
 
 // HasFullState checks if state trie is fully present in the database or not.
@@ -867,6 +905,15 @@ func (bc *BlockChain) SaveData() {
 	// Make sure no inconsistent state is leaked during insertion
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
+	// Journal the snapshot diff layers so they survive the restart, remembering
+	// the disk layer root as its trie must be persisted too
+	var snapBase common.Hash
+	if bc.snaps != nil {
+		var err error
+		if snapBase, err = bc.snaps.Journal(bc.CurrentBlock().Root()); err != nil {
+			log.Error("Failed to journal state snapshot", "err", err)
+		}
+	}
 	// Ensure the state of a recent block is also stored to disk before exiting.
 	// We're writing three different states to catch different restart scenarios:
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
@@ -918,6 +965,12 @@ func (bc *BlockChain) SaveData() {
 				}
 			}
 		}
+		if snapBase != (common.Hash{}) {
+			log.Info("Writing snapshot state to disk", "root", snapBase)
+			if err := triedb.Commit(snapBase, true); err != nil {
+				log.Error("Failed to commit recent state trie", "err", err)
+			}
+		}
 		for !bc.triegc.Empty() {
 			triedb.Dereference(bc.triegc.PopItem().(common.Hash))
 		}
@@ -1362,6 +1415,12 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	// Set new head.
 	if status == CanonStatTy {
 		bc.insert(block)
+
+		// Regenerate the state snapshot if it couldn't follow the new head, e.g.
+		// after a reorg deeper than the snapshot diff layers
+		if bc.snaps != nil && bc.snaps.Snapshot(root) == nil {
+			bc.snaps.Rebuild(root)
+		}
 	}
 	// save cache BlockSigners
 	if bc.chainConfig.Posv != nil && bc.chainConfig.IsTIPSigning(block.Number()) {
@@ -1521,7 +1580,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 		} else {
 			parent = chain[i-1]
 		}
-		statedb, err := state.New(parent.Root(), bc.stateCache)
+		statedb, err := state.NewWithSnapshot(parent.Root(), bc.stateCache, bc.snaps)
 		if err != nil {
 			return i, events, coalescedLogs, err
 		}
@@ -1652,6 +1711,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 				// block signer
 				blockSigner, _ := c.RecoverSigner(block.Header())
 				header := block.Header()
+				coinbase = c.Candidate(bc, header)
 				validator, _ := c.RecoverValidator(block.Header())
 				ok := c.CheckMNTurn(bc, header, coinbase)
 				// if created block was your turn
@@ -1813,7 +1873,7 @@ func (bc *BlockChain) getResultBlock(block *types.Block, verifiedM2 bool) (*Resu
 	// Create a new statedb using the parent block and report an
 	// error if it fails.
 	var parent = bc.GetBlock(block.ParentHash(), block.NumberU64()-1)
-	statedb, err := state.New(parent.Root(), bc.stateCache)
+	statedb, err := state.NewWithSnapshot(parent.Root(), bc.stateCache, bc.snaps)
 	if err != nil {
 		return nil, err
 	}
@@ -1996,6 +2056,7 @@ func (bc *BlockChain) insertBlock(block *types.Block) ([]interface{}, []*types.L
 		// ignore synching block
 		if coinbase != common.HexToAddress("0x0000000000000000000000000000000000000000") {
 			header := block.Header()
+			coinbase = c.Candidate(bc, header)
 			// block signer
 			blockSigner, _ := c.RecoverSigner(block.Header())
 			validator, _ := c.RecoverValidator(block.Header())
@@ -2540,6 +2601,16 @@ func (bc *BlockChain) logExchangeData(block *types.Block) {
 		log.Debug("logExchangeData takes", "time", common.PrettyDuration(time.Since(start)), "blockNumber", block.NumberU64())
 	}()
 
+	queued := 0
+	for _, txMatchBatch := range txMatchBatchData {
+		queued += len(txMatchBatch.Data)
+	}
+	sdkTradingQueueGauge.Update(int64(queued))
+	defer func() {
+		sdkTradingQueueGauge.Update(0)
+		sdkTradingLatencyTimer.UpdateSince(time.Unix(block.Time().Int64(), 0))
+	}()
+
 	for _, txMatchBatch := range txMatchBatchData {
 		dirtyOrderCount := uint64(0)
 		for _, txMatch := range txMatchBatch.Data {
@@ -2571,6 +2642,8 @@ func (bc *BlockChain) logExchangeData(block *types.Block) {
 				log.Crit("failed to SyncDataToSDKNode ", "blockNumber", block.Number(), "err", err)
 				return
 			}
+			queued--
+			sdkTradingQueueGauge.Update(int64(queued))
 		}
 	}
 }
@@ -2637,6 +2710,16 @@ func (bc *BlockChain) logLendingData(block *types.Block) {
 		log.Debug("logLendingData takes", "time", common.PrettyDuration(time.Since(start)), "blockNumber", block.NumberU64())
 	}()
 
+	queued := 0
+	for _, batch := range batches {
+		queued += len(batch.Data)
+	}
+	sdkLendingQueueGauge.Update(int64(queued))
+	defer func() {
+		sdkLendingQueueGauge.Update(0)
+		sdkLendingLatencyTimer.UpdateSince(time.Unix(block.Time().Int64(), 0))
+	}()
+
 	for _, batch := range batches {
 
 		dirtyOrderCount := uint64(0)
@@ -2664,6 +2747,8 @@ func (bc *BlockChain) logLendingData(block *types.Block) {
 			if err := lendingService.SyncDataToSDKNode(bc, statedb.Copy(), block, item, batch.TxHash, txMatchTime, trades, rejectedOrders, &dirtyOrderCount); err != nil {
 				log.Crit("lending: failed to SyncDataToSDKNode ", "blockNumber", block.Number(), "err", err)
 			}
+			queued--
+			sdkLendingQueueGauge.Update(int64(queued))
 		}
 	}
 
@@ -0,0 +1,100 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/ethash"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/core/vm"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/params"
+)
+
+// verifySnapshot waits for the background snapshot generation of the chain to
+// finish and checks the head snapshot against the head state trie.
+func verifySnapshot(t *testing.T, chain *BlockChain) {
+	root := chain.CurrentBlock().Root()
+	if chain.Snapshots().Snapshot(root) == nil {
+		t.Fatalf("snapshot missing for head state %x", root)
+	}
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if err = chain.Snapshots().Verify(root); err == nil {
+			return
+		}
+	}
+	t.Fatalf("snapshot mismatch: %v", err)
+}
+
+// Tests that the state snapshot follows the imported chain and that it is
+// restored from its journal after a restart.
+func TestBlockChainSnapshot(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000000000)}},
+		}
+		signer = types.NewEIP155Signer(gspec.Config.ChainId)
+		db     = rawdb.NewMemoryDatabase()
+		config = &CacheConfig{TrieNodeLimit: 256 * 1024 * 1024, TrieTimeLimit: 5 * time.Minute, SnapshotLimit: 16}
+	)
+	genesis := gspec.MustCommit(db)
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 32, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.BigToAddress(big.NewInt(int64(i+1))), big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	chain, err := NewBlockChain(db, config, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	verifySnapshot(t, chain)
+
+	state, err := chain.State()
+	if err != nil {
+		t.Fatalf("failed to retrieve head state: %v", err)
+	}
+	if have, want := state.GetNonce(address), uint64(len(blocks)); have != want {
+		t.Fatalf("nonce mismatch: have %d, want %d", have, want)
+	}
+	chain.Stop()
+
+	// Restart the chain and ensure the journalled snapshot is picked up
+	chain, err = NewBlockChain(db, config, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to recreate blockchain: %v", err)
+	}
+	defer chain.Stop()
+
+	if have, want := chain.CurrentBlock().Hash(), blocks[len(blocks)-1].Hash(); have != want {
+		t.Fatalf("head block mismatch: have %x, want %x", have, want)
+	}
+	verifySnapshot(t, chain)
+}
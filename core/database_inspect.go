@@ -0,0 +1,269 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/ethdb"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/rlp"
+)
+
+// posvSnapshotPrefix is the key prefix of the voting snapshots stored by the
+// posv consensus engine.
+var posvSnapshotPrefix = []byte("posv-")
+
+// DatabaseStat is the number of entries of a kind of data held by a database,
+// along with their total size.
+type DatabaseStat struct {
+	Name  string
+	Count uint64
+	Size  common.StorageSize
+}
+
+// add accounts a single entry of the given size to the stat.
+func (s *DatabaseStat) add(size common.StorageSize) {
+	s.Count++
+	s.Size += size
+}
+
+// InspectDatabase traverses the entire chain database and tallies the entries by
+// the kind of data they hold, told apart by the layout of their keys. The tables
+// of the ancient store, if any, are reported after the key-value store ones.
+func InspectDatabase(db ethdb.Database) ([]DatabaseStat, error) {
+	var (
+		headers      = DatabaseStat{Name: "Headers"}
+		tds          = DatabaseStat{Name: "Total difficulties"}
+		numHashes    = DatabaseStat{Name: "Canonical hashes"}
+		hashNumbers  = DatabaseStat{Name: "Hash to number mappings"}
+		bodies       = DatabaseStat{Name: "Bodies"}
+		receipts     = DatabaseStat{Name: "Receipts"}
+		lookups      = DatabaseStat{Name: "Transaction lookups"}
+		bloomBits    = DatabaseStat{Name: "Bloom bits"}
+		tries        = DatabaseStat{Name: "State trie nodes and code"}
+		preimages    = DatabaseStat{Name: "Trie preimages"}
+		posvSnaps    = DatabaseStat{Name: "Posv snapshots"}
+		accountSnaps = DatabaseStat{Name: "Account snapshots"}
+		storageSnaps = DatabaseStat{Name: "Storage snapshots"}
+		metadata     = DatabaseStat{Name: "Metadata"}
+		unaccounted  = DatabaseStat{Name: "Unaccounted"}
+
+		start  = time.Now()
+		logged = time.Now()
+	)
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+
+	for count := uint64(0); it.Next(); count++ {
+		var (
+			key  = it.Key()
+			size = common.StorageSize(len(key) + len(it.Value()))
+		)
+		switch {
+		case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+common.HashLength:
+			headers.add(size)
+		case bytes.HasPrefix(key, headerPrefix) && bytes.HasSuffix(key, tdSuffix) && len(key) == len(headerPrefix)+8+common.HashLength+len(tdSuffix):
+			tds.add(size)
+		case bytes.HasPrefix(key, headerPrefix) && bytes.HasSuffix(key, numSuffix) && len(key) == len(headerPrefix)+8+len(numSuffix):
+			numHashes.add(size)
+		case bytes.HasPrefix(key, blockHashPrefix) && len(key) == len(blockHashPrefix)+common.HashLength:
+			hashNumbers.add(size)
+		case bytes.HasPrefix(key, bodyPrefix) && len(key) == len(bodyPrefix)+8+common.HashLength:
+			bodies.add(size)
+		case bytes.HasPrefix(key, blockReceiptsPrefix) && len(key) == len(blockReceiptsPrefix)+8+common.HashLength:
+			receipts.add(size)
+		case bytes.HasPrefix(key, lookupPrefix) && len(key) == len(lookupPrefix)+common.HashLength:
+			lookups.add(size)
+		case bytes.HasPrefix(key, bloomBitsPrefix) && len(key) == len(bloomBitsPrefix)+2+8+common.HashLength,
+			bytes.HasPrefix(key, BloomBitsIndexPrefix):
+			bloomBits.add(size)
+		case len(key) == common.HashLength:
+			tries.add(size)
+		case bytes.HasPrefix(key, []byte(preimagePrefix)) && len(key) == len(preimagePrefix)+common.HashLength:
+			preimages.add(size)
+		case bytes.HasPrefix(key, posvSnapshotPrefix) && len(key) == len(posvSnapshotPrefix)+common.HashLength:
+			posvSnaps.add(size)
+		case bytes.HasPrefix(key, rawdb.SnapshotAccountPrefix) && len(key) == len(rawdb.SnapshotAccountPrefix)+common.HashLength:
+			accountSnaps.add(size)
+		case bytes.HasPrefix(key, rawdb.SnapshotStoragePrefix) && len(key) == len(rawdb.SnapshotStoragePrefix)+2*common.HashLength:
+			storageSnaps.add(size)
+		case bytes.HasPrefix(key, configPrefix) && len(key) == len(configPrefix)+common.HashLength:
+			metadata.add(size)
+		default:
+			var accounted bool
+			for _, meta := range [][]byte{headHeaderKey, headBlockKey, headFastKey, trieSyncKey, statePruneMarkerKey, statePruneNumberKey, []byte("BlockchainVersion"), []byte("SnapshotRoot"), []byte("SnapshotJournal")} {
+				if bytes.Equal(key, meta) {
+					metadata.add(size)
+					accounted = true
+					break
+				}
+			}
+			if !accounted {
+				unaccounted.add(size)
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Inspecting database", "count", count, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	stats := []DatabaseStat{headers, tds, numHashes, hashNumbers, bodies, receipts, lookups, bloomBits, tries, preimages, posvSnaps, accountSnaps, storageSnaps, metadata, unaccounted}
+
+	// Append the ancient tables if the database is backed by a freezer
+	if frozen, err := db.Ancients(); err == nil {
+		for _, table := range []string{rawdb.FreezerHeaderTable, rawdb.FreezerBodiesTable, rawdb.FreezerReceiptTable, rawdb.FreezerDifficultyTable, rawdb.FreezerHashTable} {
+			size, err := db.AncientSize(table)
+			if err != nil {
+				return nil, err
+			}
+			stats = append(stats, DatabaseStat{Name: "Ancient " + table, Count: frozen, Size: common.StorageSize(size)})
+		}
+	}
+	return stats, nil
+}
+
+// DatabaseIssue is an inconsistency of the chain database found by CheckDatabase.
+type DatabaseIssue struct {
+	Number uint64      // Number of the block the issue was found at
+	Hash   common.Hash // Hash of the block or transaction the issue is about
+	Reason string      // Description of the inconsistency
+}
+
+// CheckDatabase verifies the consistency of the chain indices of the database:
+//   - every canonical hash up to the head header points to a stored header that
+//     links to the previous canonical one, and none is left above the head
+//   - every canonical block up to the head block has its body stored and, if it
+//     includes transactions, the receipts of all of them
+//   - every transaction lookup entry points to a canonical block including the
+//     transaction at the recorded position
+//
+// Every inconsistency found is passed to report, the number of them is returned.
+func CheckDatabase(db ethdb.Database, report func(DatabaseIssue)) (int, error) {
+	var (
+		issues int
+		start  = time.Now()
+		logged = time.Now()
+	)
+	fail := func(number uint64, hash common.Hash, format string, args ...interface{}) {
+		issues++
+		report(DatabaseIssue{Number: number, Hash: hash, Reason: fmt.Sprintf(format, args...)})
+	}
+	headHeader := GetHeadHeaderHash(db)
+	if headHeader == (common.Hash{}) {
+		return 0, fmt.Errorf("head header hash missing")
+	}
+	headerNumber := GetBlockNumber(db, headHeader)
+	if headerNumber == missingNumber {
+		return 0, fmt.Errorf("head header %x missing", headHeader)
+	}
+	blockNumber := uint64(0)
+	if headBlock := GetHeadBlockHash(db); headBlock != (common.Hash{}) {
+		if number := GetBlockNumber(db, headBlock); number != missingNumber {
+			blockNumber = number
+		}
+	}
+	// Walk the canonical chain, checking the headers, bodies and receipts
+	var parent common.Hash
+	for number := uint64(0); number <= headerNumber; number++ {
+		hash := GetCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			fail(number, hash, "canonical hash missing")
+			parent = common.Hash{}
+			continue
+		}
+		header := GetHeader(db, hash, number)
+		if header == nil {
+			fail(number, hash, "dangling canonical hash, header missing")
+			parent = common.Hash{}
+			continue
+		}
+		if number > 0 && parent != (common.Hash{}) && header.ParentHash != parent {
+			fail(number, hash, "canonical header not linked to canonical parent %x", parent)
+		}
+		parent = hash
+
+		if number <= blockNumber {
+			body := GetBody(db, hash, number)
+			if body == nil {
+				fail(number, hash, "block body missing")
+			} else if len(body.Transactions) > 0 {
+				receipts := GetBlockReceipts(db, hash, number)
+				if receipts == nil {
+					fail(number, hash, "block receipts missing")
+				} else if len(receipts) != len(body.Transactions) {
+					fail(number, hash, "block has %d receipts for %d transactions", len(receipts), len(body.Transactions))
+				}
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Checking canonical chain", "number", number, "head", headerNumber, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	// Ensure there's no canonical hash left above the head header
+	hashes := db.NewIterator(headerPrefix, encodeBlockNumber(headerNumber+1))
+	defer hashes.Release()
+
+	for hashes.Next() {
+		key := hashes.Key()
+		if len(key) != len(headerPrefix)+8+len(numSuffix) || !bytes.HasSuffix(key, numSuffix) {
+			continue
+		}
+		number := binary.BigEndian.Uint64(key[len(headerPrefix) : len(headerPrefix)+8])
+		fail(number, common.BytesToHash(hashes.Value()), "dangling canonical hash above head header #%d", headerNumber)
+	}
+	if err := hashes.Error(); err != nil {
+		return issues, err
+	}
+	// Ensure every transaction lookup points into the canonical chain
+	it := db.NewIterator(lookupPrefix, nil)
+	defer it.Release()
+
+	for it.Next() {
+		if len(it.Key()) != len(lookupPrefix)+common.HashLength {
+			continue
+		}
+		txHash := common.BytesToHash(it.Key()[len(lookupPrefix):])
+
+		var entry TxLookupEntry
+		if err := rlp.DecodeBytes(it.Value(), &entry); err != nil {
+			fail(0, txHash, "invalid transaction lookup: %v", err)
+			continue
+		}
+		if GetCanonicalHash(db, entry.BlockIndex) != entry.BlockHash {
+			fail(entry.BlockIndex, txHash, "orphaned transaction lookup, block %x not canonical", entry.BlockHash)
+			continue
+		}
+		body := GetBody(db, entry.BlockHash, entry.BlockIndex)
+		if body == nil || entry.Index >= uint64(len(body.Transactions)) || body.Transactions[entry.Index].Hash() != txHash {
+			fail(entry.BlockIndex, txHash, "orphaned transaction lookup, not included in block %x at index %d", entry.BlockHash, entry.Index)
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Checking transaction lookups", "hash", txHash, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	return issues, it.Error()
+}
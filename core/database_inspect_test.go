@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/ethash"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/core/vm"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/params"
+)
+
+// Tests that the database inspection accounts the chain data by kind and that
+// the consistency check reports missing bodies, receipts and canonical hashes
+// as well as orphaned transaction lookups.
+func TestInspectAndCheckDatabase(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000000000)}},
+		}
+		signer = types.NewEIP155Signer(gspec.Config.ChainId)
+		db     = rawdb.NewMemoryDatabase()
+	)
+	genesis := gspec.MustCommit(db)
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 10, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x01}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	chain, err := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	chain.Stop()
+
+	stats, err := InspectDatabase(db)
+	if err != nil {
+		t.Fatalf("failed to inspect database: %v", err)
+	}
+	counts := make(map[string]uint64)
+	for _, stat := range stats {
+		counts[stat.Name] = stat.Count
+	}
+	for name, want := range map[string]uint64{"Headers": 11, "Canonical hashes": 11, "Bodies": 11, "Receipts": 11, "Transaction lookups": 10} {
+		if counts[name] != want {
+			t.Errorf("%s count mismatch: have %d, want %d", name, counts[name], want)
+		}
+	}
+	if counts["State trie nodes and code"] == 0 {
+		t.Errorf("no state trie nodes accounted")
+	}
+	// A consistent database should pass the check
+	if issues, err := CheckDatabase(db, func(issue DatabaseIssue) { t.Errorf("unexpected issue: %+v", issue) }); err != nil || issues != 0 {
+		t.Fatalf("consistent database check failed: issues %d, err %v", issues, err)
+	}
+	// Corrupt the database in various ways and ensure every issue gets reported
+	DeleteBody(db, blocks[2].Hash(), blocks[2].NumberU64())
+	DeleteBlockReceipts(db, blocks[4].Hash(), blocks[4].NumberU64())
+	WriteTxLookupEntries(db, types.NewBlock(&types.Header{Number: big.NewInt(3)}, blocks[0].Transactions(), nil, nil))
+	WriteCanonicalHash(db, common.Hash{0xff}, 20)
+
+	// Dropping the body of block #3 also orphans the lookup of its transaction
+	reported := make(map[uint64]int)
+	issues, err := CheckDatabase(db, func(issue DatabaseIssue) { reported[issue.Number]++ })
+	if err != nil {
+		t.Fatalf("failed to check database: %v", err)
+	}
+	if issues != 5 {
+		t.Errorf("issue count mismatch: have %d, want 5", issues)
+	}
+	for _, number := range []uint64{3, 5, 20} {
+		if reported[number] == 0 {
+			t.Errorf("issue at block #%d not reported", number)
+		}
+	}
+}
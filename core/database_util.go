@@ -50,6 +50,10 @@ var (
 	headFastKey   = []byte("LastFast")
 	trieSyncKey   = []byte("TrieSync")
 
+	statePruneMarkerKey  = []byte("StatePruneMarker") // Last key swept by an interrupted online state pruning
+	statePruneNumberKey  = []byte("StatePruneNumber") // Head block number of the last completed state pruning
+	statePruneMarkPrefix = []byte("P")                // statePruneMarkPrefix + hash -> trie node retained by the running state pruning
+
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`).
 	headerPrefix        = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
 	tdSuffix            = []byte("t") // headerPrefix + num (uint64 big endian) + hash + tdSuffix -> td
@@ -95,11 +99,49 @@ func encodeBlockNumber(number uint64) []byte {
 func GetCanonicalHash(db DatabaseReader, number uint64) common.Hash {
 	data, _ := db.Get(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
 	if len(data) == 0 {
-		return common.Hash{}
+		// Blocks beyond the immutability threshold only live in the freezer
+		if adb, ok := db.(ethdb.AncientReader); ok {
+			data, _ = adb.Ancient(rawdb.FreezerHashTable, number)
+		}
+		if len(data) == 0 {
+			return common.Hash{}
+		}
 	}
 	return common.BytesToHash(data)
 }
 
+// readAncient retrieves a blob of the given kind from the chain freezer if the
+// database is backed by one and the frozen canonical block at number matches
+// hash. Frozen data is only ever canonical, side chains are never migrated.
+func readAncient(db DatabaseReader, kind string, hash common.Hash, number uint64) []byte {
+	adb, ok := db.(ethdb.AncientReader)
+	if !ok {
+		return nil
+	}
+	if canon, _ := adb.Ancient(rawdb.FreezerHashTable, number); !bytes.Equal(canon, hash[:]) {
+		return nil
+	}
+	data, _ := adb.Ancient(kind, number)
+	return data
+}
+
+// GetAllHashes retrieves all the hashes assigned to blocks at a certain height,
+// both canonical and reorged forks included.
+func GetAllHashes(db ethdb.Iteratee, number uint64) []common.Hash {
+	prefix := append(headerPrefix, encodeBlockNumber(number)...)
+
+	hashes := make([]common.Hash, 0, 1)
+	it := db.NewIterator(prefix, nil)
+	defer it.Release()
+
+	for it.Next() {
+		if key := it.Key(); len(key) == len(prefix)+common.HashLength {
+			hashes = append(hashes, common.BytesToHash(key[len(prefix):]))
+		}
+	}
+	return hashes
+}
+
 // missingNumber is returned by GetBlockNumber if no header with the
 // given block hash has been stored in the database
 const missingNumber = uint64(0xffffffffffffffff)
@@ -148,6 +190,33 @@ func GetHeadFastBlockHash(db DatabaseReader) common.Hash {
 	return common.BytesToHash(data)
 }
 
+// GetStatePruneMarker retrieves the last key swept by an online state pruning
+// run that was interrupted before completing, or nil if none is in progress.
+func GetStatePruneMarker(db DatabaseReader) []byte {
+	data, _ := db.Get(statePruneMarkerKey)
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+// GetStatePruneNumber retrieves the head block number the last completed online
+// state pruning run retained the state from.
+func GetStatePruneNumber(db DatabaseReader) uint64 {
+	data, _ := db.Get(statePruneNumberKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// HasStatePruneMark reports whether the trie node with the given hash was marked
+// as retained by the running online state pruning.
+func HasStatePruneMark(db ethdb.KeyValueReader, hash common.Hash) bool {
+	ok, _ := db.Has(append(statePruneMarkPrefix, hash.Bytes()...))
+	return ok
+}
+
 // GetTrieSyncProgress retrieves the number of tries nodes fast synced to allow
 // reportinc correct numbers across restarts.
 func GetTrieSyncProgress(db DatabaseReader) uint64 {
@@ -162,9 +231,21 @@ func GetTrieSyncProgress(db DatabaseReader) uint64 {
 // if the header's not found.
 func GetHeaderRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(headerKey(hash, number))
+	if len(data) == 0 {
+		data = readAncient(db, rawdb.FreezerHeaderTable, hash, number)
+	}
 	return data
 }
 
+// HasHeader verifies the existence of a block header corresponding to the hash,
+// either in the key-value store or in the chain freezer.
+func HasHeader(db ethdb.Reader, hash common.Hash, number uint64) bool {
+	if ok, _ := db.Has(headerKey(hash, number)); ok {
+		return true
+	}
+	return hasAncient(db, hash, number)
+}
+
 // GetHeader retrieves the block header corresponding to the hash, nil if none
 // found.
 func GetHeader(db DatabaseReader, hash common.Hash, number uint64) *types.Header {
@@ -183,9 +264,31 @@ func GetHeader(db DatabaseReader, hash common.Hash, number uint64) *types.Header
 // GetBodyRLP retrieves the block body (transactions and uncles) in RLP encoding.
 func GetBodyRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(blockBodyKey(hash, number))
+	if len(data) == 0 {
+		data = readAncient(db, rawdb.FreezerBodiesTable, hash, number)
+	}
 	return data
 }
 
+// HasBody verifies the existence of a block body corresponding to the hash,
+// either in the key-value store or in the chain freezer.
+func HasBody(db ethdb.Reader, hash common.Hash, number uint64) bool {
+	if ok, _ := db.Has(blockBodyKey(hash, number)); ok {
+		return true
+	}
+	return hasAncient(db, hash, number)
+}
+
+// hasAncient reports whether the canonical block at number with the given hash
+// has been moved into the chain freezer.
+func hasAncient(db ethdb.AncientReader, hash common.Hash, number uint64) bool {
+	if ok, _ := db.HasAncient(rawdb.FreezerHashTable, number); !ok {
+		return false
+	}
+	canon, _ := db.Ancient(rawdb.FreezerHashTable, number)
+	return bytes.Equal(canon, hash[:])
+}
+
 func headerKey(hash common.Hash, number uint64) []byte {
 	return append(append(headerPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
@@ -213,6 +316,9 @@ func GetBody(db DatabaseReader, hash common.Hash, number uint64) *types.Body {
 // none found.
 func GetTd(db DatabaseReader, hash common.Hash, number uint64) *big.Int {
 	data, _ := db.Get(append(append(append(headerPrefix, encodeBlockNumber(number)...), hash[:]...), tdSuffix...))
+	if len(data) == 0 {
+		data = readAncient(db, rawdb.FreezerDifficultyTable, hash, number)
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -248,6 +354,9 @@ func GetBlock(db DatabaseReader, hash common.Hash, number uint64) *types.Block {
 // in a block given by its hash.
 func GetBlockReceipts(db DatabaseReader, hash common.Hash, number uint64) types.Receipts {
 	data, _ := db.Get(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash[:]...))
+	if len(data) == 0 {
+		data = readAncient(db, rawdb.FreezerReceiptTable, hash, number)
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -395,6 +504,33 @@ func WriteTrieSyncProgress(db ethdb.KeyValueWriter, count uint64) error {
 	return nil
 }
 
+// WriteStatePruneMarker stores the last key swept by the online state pruner to
+// resume from after a restart.
+func WriteStatePruneMarker(db ethdb.KeyValueWriter, marker []byte) error {
+	if err := db.Put(statePruneMarkerKey, marker); err != nil {
+		log.Crit("Failed to store state pruning marker", "err", err)
+	}
+	return nil
+}
+
+// WriteStatePruneNumber stores the head block number of a completed online state
+// pruning run.
+func WriteStatePruneNumber(db ethdb.KeyValueWriter, number uint64) error {
+	if err := db.Put(statePruneNumberKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store state pruning number", "err", err)
+	}
+	return nil
+}
+
+// WriteStatePruneMark marks the trie node with the given hash as retained by the
+// running online state pruning.
+func WriteStatePruneMark(db ethdb.KeyValueWriter, hash common.Hash) error {
+	if err := db.Put(append(statePruneMarkPrefix, hash.Bytes()...), []byte{0x01}); err != nil {
+		log.Crit("Failed to store state pruning mark", "err", err)
+	}
+	return nil
+}
+
 // WriteHeader serializes a block header into the database.
 func WriteHeader(db ethdb.KeyValueWriter, header *types.Header) error {
 	data, err := rlp.EncodeToBytes(header)
@@ -519,6 +655,36 @@ func DeleteCanonicalHash(db DatabaseDeleter, number uint64) {
 	db.Delete(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
 }
 
+// DeleteStatePruneMarker removes the online state pruning resume marker.
+func DeleteStatePruneMarker(db DatabaseDeleter) {
+	db.Delete(statePruneMarkerKey)
+}
+
+// DeleteStatePruneMarks removes all the trie node marks of an online state
+// pruning run.
+func DeleteStatePruneMarks(db ethdb.KeyValueStore) error {
+	it := db.NewIterator(statePruneMarkPrefix, nil)
+	defer it.Release()
+
+	batch := db.NewBatch()
+	for it.Next() {
+		if len(it.Key()) != len(statePruneMarkPrefix)+common.HashLength {
+			continue
+		}
+		batch.Delete(common.CopyBytes(it.Key()))
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
 // DeleteHeader removes all block header data associated with a hash.
 func DeleteHeader(db DatabaseDeleter, hash common.Hash, number uint64) {
 	db.Delete(append(blockHashPrefix, hash.Bytes()...))
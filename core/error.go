@@ -38,4 +38,34 @@ var (
 	ErrNotFoundM1 = errors.New("list M1 not found ")
 
 	ErrStopPreparingBlock = errors.New("stop calculating a block not verified by M2")
+
+	// ErrSigningKeyOwner is returned if a signing key is registered by someone
+	// else than the owner of the candidate.
+	ErrSigningKeyOwner = errors.New("signing key can only be set by the candidate owner")
+
+	// ErrSigningKeyTaken is returned if the signing key is a candidate itself or
+	// already registered for another candidate.
+	ErrSigningKeyTaken = errors.New("signing key already used by another candidate")
+
+	// ErrOracleModerator is returned if the oracle feeders, bounds or liquidation
+	// bonuses are changed by someone else than the lending moderator.
+	ErrOracleModerator = errors.New("oracle can only be managed by the lending moderator")
+
+	// ErrOracleFeeder is returned if a price is submitted by an address which
+	// isn't a whitelisted feeder.
+	ErrOracleFeeder = errors.New("price submitted by an unknown feeder")
+
+	// ErrOraclePrice is returned for prices which can't be aggregated.
+	ErrOraclePrice = errors.New("invalid oracle price")
+
+	// ErrLiquidationBonus is returned for liquidation bonuses above 100 percent.
+	ErrLiquidationBonus = errors.New("invalid liquidation bonus")
+
+	// ErrRepayPolicyOwner is returned if the repay policy of a relayer is set by
+	// someone else than the relayer owner.
+	ErrRepayPolicyOwner = errors.New("repay policy can only be set by the relayer owner")
+
+	// ErrRepayPolicy is returned for unknown policies or pairs the relayer
+	// doesn't list.
+	ErrRepayPolicy = errors.New("invalid repay policy")
 )
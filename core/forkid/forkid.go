@@ -0,0 +1,213 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package forkid derives identifiers of the fork schedule followed by a
+// TomoChain node, so that peers on incompatible schedules can be told apart.
+package forkid
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/params"
+)
+
+var (
+	// ErrRemoteStale is returned by the filter if a remote fork checksum is a
+	// subset of the local one, but the remote doesn't know about the next
+	// fork the local node has already passed.
+	ErrRemoteStale = errors.New("remote needs update")
+
+	// ErrLocalIncompatibleOrStale is returned by the filter if a remote fork
+	// checksum doesn't match any local checksum, or if the remote announces
+	// a fork the local node has passed without it.
+	ErrLocalIncompatibleOrStale = errors.New("local incompatible or needs update")
+)
+
+// ID is a fork identifier as defined by EIP-2124, computed over the genesis
+// hash and the TomoChain fork schedule.
+type ID struct {
+	Hash [4]byte // CRC32 checksum of the genesis hash and the passed forks
+	Next uint64  // Block number of the next upcoming fork, or 0 if none
+}
+
+// Filter is a fork identifier validator which reports whether a remote
+// node follows a fork schedule compatible with the local chain.
+type Filter func(id ID) error
+
+// NewID calculates the fork identifier of the chain at the given head.
+func NewID(config *params.ChainConfig, genesis common.Hash, head uint64) ID {
+	hash := crc32.ChecksumIEEE(genesis[:])
+	for _, fork := range Gather(config) {
+		if fork <= head {
+			// Fork already passed, checksum the previous hash and the fork number
+			hash = checksumUpdate(hash, fork)
+			continue
+		}
+		return ID{Hash: checksumToBytes(hash), Next: fork}
+	}
+	return ID{Hash: checksumToBytes(hash), Next: 0}
+}
+
+// NewFilter creates a filter validating remote fork identifiers against the
+// local chain, whose current head is retrieved by headfn.
+func NewFilter(config *params.ChainConfig, genesis common.Hash, headfn func() uint64) Filter {
+	// Calculate the all the valid fork hash and fork next combos
+	var (
+		forks = Gather(config)
+		sums  = make([][4]byte, len(forks)+1) // 0th is the genesis
+	)
+	hash := crc32.ChecksumIEEE(genesis[:])
+	sums[0] = checksumToBytes(hash)
+	for i, fork := range forks {
+		hash = checksumUpdate(hash, fork)
+		sums[i+1] = checksumToBytes(hash)
+	}
+	// Add a sentinel fork which will never be passed
+	forks = append(forks, math.MaxUint64)
+
+	return func(id ID) error {
+		// Run the fork checksum validation ruleset:
+		//   1. If local and remote checksums match, compare the local head
+		//      to the remote next fork. If the remote announces a fork the
+		//      local node already passed, the local node is incompatible or
+		//      stale, otherwise the peers are compatible.
+		//   2. If the remote checksum is a subset of the local past forks and
+		//      the remote next fork matches the next local one, the remote is
+		//      merely syncing. Otherwise it is stale and needs an update.
+		//   3. If the remote checksum is a superset of the local past forks,
+		//      the local node is syncing and may be compatible.
+		//   4. Reject in all other cases.
+		head := headfn()
+		for i, fork := range forks {
+			// If our head is beyond this fork, continue to the next
+			if head >= fork {
+				continue
+			}
+			// Found the first unpassed fork block, check if our current state matches
+			// the remote checksum (rule #1).
+			if sums[i] == id.Hash {
+				if id.Next > 0 && head >= id.Next {
+					return ErrLocalIncompatibleOrStale
+				}
+				return nil
+			}
+			// The local and remote nodes are in different forks currently, check if the
+			// remote checksum is a subset of our local forks (rule #2).
+			for j := 0; j < i; j++ {
+				if sums[j] == id.Hash {
+					if forks[j] != id.Next {
+						return ErrRemoteStale
+					}
+					return nil
+				}
+			}
+			// Remote chain is not a subset of our local one, check if it's a superset by
+			// any chance, signalling that we're simply out of sync (rule #3).
+			for j := i + 1; j < len(sums); j++ {
+				if sums[j] == id.Hash {
+					return nil
+				}
+			}
+			// No exact, subset or superset match. We are on differing chains, reject.
+			return ErrLocalIncompatibleOrStale
+		}
+		// The sentinel fork is never passed, so the loop always returns.
+		return nil
+	}
+}
+
+// Gather returns the block numbers at which the rules of the chain change,
+// sorted in ascending order and without duplicates. Forks which are active
+// from genesis don't change the rules of any block and are omitted.
+func Gather(config *params.ChainConfig) []uint64 {
+	tomox := common.TIPTomoX
+	if common.IsTestnet {
+		tomox = common.TIPTomoXTestnet
+	}
+	blocks := []*big.Int{
+		config.HomesteadBlock,
+		config.DAOForkBlock,
+		config.EIP150Block,
+		config.EIP155Block,
+		config.EIP158Block,
+		config.ByzantiumBlock,
+		config.ConstantinopleBlock,
+		common.TIP2019Block,
+		common.TIPSigning,
+		common.TIPRandomize,
+		new(big.Int).SetUint64(common.BlackListHFNumber),
+		common.TIPTRC21Fee,
+		tomox,
+		common.TIPTomoXLending,
+		common.TIPTomoXCancellationFee,
+		common.TIPSigningKey,
+		common.TIPTomoXPartialRepay,
+		common.TIPTomoXPartialLiquidation,
+		common.TIPTomoXPriceOracle,
+		common.TIPTomoXRollover,
+		common.TIPTomoXMultiCollateral,
+		common.TIPTomoXTradeTransfer,
+		common.TIPTomoXBorrowerPolicy,
+	}
+	var forks []uint64
+	for _, block := range blocks {
+		if block != nil && block.Sign() > 0 {
+			forks = append(forks, block.Uint64())
+		}
+	}
+	sort.Slice(forks, func(i, j int) bool { return forks[i] < forks[j] })
+
+	// Drop duplicates, several forks may activate at the same block.
+	for i := 1; i < len(forks); i++ {
+		if forks[i] == forks[i-1] {
+			forks = append(forks[:i], forks[i+1:]...)
+			i--
+		}
+	}
+	return forks
+}
+
+// Checksum returns the CRC32 checksum of the genesis hash and the whole
+// fork schedule of the chain, including forks which are not active yet.
+// Nodes with a different checksum follow another network or will split
+// from the local node at some point.
+func Checksum(config *params.ChainConfig, genesis common.Hash) [4]byte {
+	hash := crc32.ChecksumIEEE(genesis[:])
+	for _, fork := range Gather(config) {
+		hash = checksumUpdate(hash, fork)
+	}
+	return checksumToBytes(hash)
+}
+
+// checksumUpdate calculates the next CRC32 checksum based on the previous
+// one and a fork block number.
+func checksumUpdate(hash uint32, fork uint64) uint32 {
+	var blob [8]byte
+	binary.BigEndian.PutUint64(blob[:], fork)
+	return crc32.Update(hash, crc32.IEEETable, blob[:])
+}
+
+// checksumToBytes converts a uint32 checksum into a [4]byte array.
+func checksumToBytes(hash uint32) [4]byte {
+	var blob [4]byte
+	binary.BigEndian.PutUint32(blob[:], hash)
+	return blob
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package forkid
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/params"
+)
+
+func TestGather(t *testing.T) {
+	forks := Gather(params.TomoMainnetChainConfig)
+	if len(forks) == 0 {
+		t.Fatal("no forks gathered")
+	}
+	for i, fork := range forks {
+		if fork == 0 {
+			t.Errorf("fork %d: genesis fork included", i)
+		}
+		if i > 0 && fork <= forks[i-1] {
+			t.Errorf("fork %d: schedule not strictly ascending: %d after %d", i, fork, forks[i-1])
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	config := params.TomoMainnetChainConfig
+	want := Checksum(config, params.TomoMainnetGenesisHash)
+
+	if sum := Checksum(config, params.TomoMainnetGenesisHash); sum != want {
+		t.Errorf("checksum not deterministic: %x != %x", sum, want)
+	}
+	if sum := Checksum(config, params.TestnetGenesisHash); sum == want {
+		t.Errorf("checksum doesn't depend on genesis")
+	}
+	// Rescheduling a fork must change the checksum.
+	defer func(old *big.Int) { common.TIPTomoXPriceOracle = old }(common.TIPTomoXPriceOracle)
+	common.TIPTomoXPriceOracle = big.NewInt(40000000)
+	if sum := Checksum(config, params.TomoMainnetGenesisHash); sum == want {
+		t.Errorf("checksum doesn't depend on fork schedule")
+	}
+}
+
+func TestNewID(t *testing.T) {
+	config, genesis := params.TomoMainnetChainConfig, params.TomoMainnetGenesisHash
+	forks := Gather(config)
+
+	if id := NewID(config, genesis, 0); id.Next != forks[0] {
+		t.Errorf("genesis: next fork mismatch: got %d, want %d", id.Next, forks[0])
+	}
+	if a, b := NewID(config, genesis, forks[0]-1), NewID(config, genesis, 0); a != b {
+		t.Errorf("id changed before the first fork: %v != %v", a, b)
+	}
+	if a, b := NewID(config, genesis, forks[0]), NewID(config, genesis, 0); a.Hash == b.Hash {
+		t.Errorf("id didn't change at the first fork")
+	}
+	last := NewID(config, genesis, forks[len(forks)-1])
+	if last.Next != 0 {
+		t.Errorf("last fork: next fork mismatch: got %d, want 0", last.Next)
+	}
+	if last.Hash != Checksum(config, genesis) {
+		t.Errorf("last fork: hash %x doesn't match schedule checksum %x", last.Hash, Checksum(config, genesis))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	config, genesis := params.TomoMainnetChainConfig, params.TomoMainnetGenesisHash
+	forks := Gather(config)
+	if len(forks) < 4 {
+		t.Fatalf("too few forks to test: %d", len(forks))
+	}
+	// The local node is between the second and third fork.
+	head := forks[1]
+	filter := NewFilter(config, genesis, func() uint64 { return head })
+
+	local := NewID(config, genesis, head)
+	past := NewID(config, genesis, forks[0])
+	future := NewID(config, genesis, forks[2])
+
+	tests := []struct {
+		id   ID
+		want error
+	}{
+		// Same fork state, same next fork.
+		{local, nil},
+		// Same fork state, remote doesn't know about the next fork yet.
+		{ID{Hash: local.Hash, Next: 0}, nil},
+		// Same fork state, remote announces a fork the local node passed.
+		{ID{Hash: local.Hash, Next: forks[1]}, ErrLocalIncompatibleOrStale},
+		// Remote is syncing and knows about the fork the local node passed.
+		{past, nil},
+		// Remote is on a past fork and doesn't know about the passed fork.
+		{ID{Hash: past.Hash, Next: forks[1] + 1}, ErrRemoteStale},
+		// Local node is syncing, remote is ahead.
+		{future, nil},
+		// Remote is on another chain.
+		{ID{Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}, Next: 0}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		if err := filter(tt.id); err != tt.want {
+			t.Errorf("test %d: validation error mismatch: got %v, want %v", i, err, tt.want)
+		}
+	}
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/tomochain/tomochain/core/types"
 	"github.com/tomochain/tomochain/event"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
 	"github.com/tomochain/tomochain/params"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
@@ -57,6 +58,12 @@ var (
 	LendingTypeMarket = "MO"
 )
 
+var (
+	// Metrics for the lending pool size, refreshed on every status report
+	lendingPoolPendingGauge = metrics.NewRegisteredGauge("lendingpool/pending", nil)
+	lendingPoolQueuedGauge  = metrics.NewRegisteredGauge("lendingpool/queued", nil)
+)
+
 // LendingPoolConfig are the configuration parameters of the order transaction pool.
 type LendingPoolConfig struct {
 	NoLocals  bool          // Whether local transaction handling should be disabled
@@ -235,6 +242,9 @@ func (pool *LendingPool) loop() {
 			pool.mu.RLock()
 			pending, queued := pool.stats()
 			pool.mu.RUnlock()
+
+			lendingPoolPendingGauge.Update(int64(pending))
+			lendingPoolQueuedGauge.Update(int64(queued))
 			if pending != prevPending || queued != prevQueued {
 				log.Debug("Lending pool status report", "executable", pending, "queued", queued)
 				prevPending, prevQueued = pending, queued
@@ -484,11 +494,191 @@ func (pool *LendingPool) validateRepayLending(cloneStateDb *state.StateDB, clone
 	if tx.RelayerAddress().String() != lendingTrade.BorrowingRelayer.String() {
 		return ErrInvalidLendingRelayer
 	}
+	// an early repayment follows the interest policy of the borrowing relayer
+	if header := pool.chain.CurrentHeader(); pool.chain.Config().IsTIPTomoXRollover(header.Number) {
+		policy := lendingstate.GetRepayPolicy(cloneStateDb, lendingTrade.BorrowingRelayer, lendingTrade.LendingToken, lendingTrade.Term)
+		paymentBalance := lendingstate.CalculateRepayValue(policy, header.Time.Uint64(), lendingTrade.LiquidationTime, lendingTrade.Term, lendingTrade.Interest, lendingTrade.Amount)
+		if balance := lendingstate.GetTokenBalance(lendingTrade.Borrower, lendingTrade.LendingToken, cloneStateDb); balance.Cmp(paymentBalance) < 0 {
+			return fmt.Errorf("not enough balance to repay. lendingTradeId: %v. Token: %s. ExpectedBalance: %s. ActualBalance: %s",
+				tx.LendingTradeId(), lendingTrade.LendingToken.Hex(), paymentBalance, balance)
+		}
+		return nil
+	}
 	if err := pool.validateBalance(cloneStateDb, cloneLendingStateDb, tx, tx.CollateralToken()); err != nil {
 		return err
 	}
 	return nil
 }
+func (pool *LendingPool) validatePartialRepayLending(cloneStateDb *state.StateDB, cloneLendingStateDb *lendingstate.LendingStateDB, tx *types.LendingTransaction) error {
+	header := pool.chain.CurrentHeader()
+	if !pool.chain.Config().IsTIPTomoXPartialRepay(header.Number) {
+		return ErrInvalidLendingType
+	}
+	if tx.LendingTradeId() == 0 {
+		return ErrInvalidLendingTradeID
+	}
+	if tx.Quantity() == nil || tx.Quantity().Sign() <= 0 {
+		return ErrInvalidLendingQuantity
+	}
+	lendingBook := lendingstate.GetLendingOrderBookHash(tx.LendingToken(), tx.Term())
+	lendingTrade := cloneLendingStateDb.GetLendingTrade(lendingBook, common.Uint64ToHash(tx.LendingTradeId()))
+	if lendingTrade == lendingstate.EmptyLendingTrade {
+		return ErrInvalidLendingTradeID
+	}
+	if tx.UserAddress().String() != lendingTrade.Borrower.String() {
+		return ErrInvalidLendingUserAddress
+	}
+	if tx.RelayerAddress().String() != lendingTrade.BorrowingRelayer.String() {
+		return ErrInvalidLendingRelayer
+	}
+	// a partial repayment only needs the repaid quantity
+	if balance := lendingstate.GetTokenBalance(lendingTrade.Borrower, lendingTrade.LendingToken, cloneStateDb); balance.Cmp(tx.Quantity()) < 0 {
+		return fmt.Errorf("not enough balance to repay. lendingTradeId: %v. Token: %s. ExpectedBalance: %s. ActualBalance: %s",
+			tx.LendingTradeId(), lendingTrade.LendingToken.Hex(), tx.Quantity(), balance)
+	}
+	return nil
+}
+func (pool *LendingPool) validateRolloverLending(cloneStateDb *state.StateDB, cloneLendingStateDb *lendingstate.LendingStateDB, tx *types.LendingTransaction) error {
+	header := pool.chain.CurrentHeader()
+	if !pool.chain.Config().IsTIPTomoXRollover(header.Number) {
+		return ErrInvalidLendingType
+	}
+	if tx.LendingTradeId() == 0 {
+		return ErrInvalidLendingTradeID
+	}
+	lendingBook := lendingstate.GetLendingOrderBookHash(tx.LendingToken(), tx.Term())
+	lendingTrade := cloneLendingStateDb.GetLendingTrade(lendingBook, common.Uint64ToHash(tx.LendingTradeId()))
+	if lendingTrade == lendingstate.EmptyLendingTrade {
+		return ErrInvalidLendingTradeID
+	}
+	if tx.UserAddress().String() != lendingTrade.Borrower.String() {
+		return ErrInvalidLendingUserAddress
+	}
+	if tx.RelayerAddress().String() != lendingTrade.BorrowingRelayer.String() {
+		return ErrInvalidLendingRelayer
+	}
+	if lendingTrade.LiquidationTime <= header.Time.Uint64() {
+		return fmt.Errorf("lending trade expired. lendingTradeId: %v. LiquidationTime: %v", tx.LendingTradeId(), lendingTrade.LiquidationTime)
+	}
+	// the borrower pays the interest and the borrowing fee, the new investor funds the principal
+	policy := lendingstate.GetRepayPolicy(cloneStateDb, lendingTrade.BorrowingRelayer, lendingTrade.LendingToken, lendingTrade.Term)
+	paymentBalance := lendingstate.CalculateRepayValue(policy, header.Time.Uint64(), lendingTrade.LiquidationTime, lendingTrade.Term, lendingTrade.Interest, lendingTrade.Amount)
+	borrowFee := new(big.Int).Mul(lendingTrade.Amount, lendingstate.GetFee(cloneStateDb, lendingTrade.BorrowingRelayer))
+	borrowFee = new(big.Int).Div(borrowFee, common.TomoXBaseFee)
+	payment := new(big.Int).Add(new(big.Int).Sub(paymentBalance, lendingTrade.Amount), borrowFee)
+	if balance := lendingstate.GetTokenBalance(lendingTrade.Borrower, lendingTrade.LendingToken, cloneStateDb); balance.Cmp(payment) < 0 {
+		return fmt.Errorf("not enough balance to rollover. lendingTradeId: %v. Token: %s. ExpectedBalance: %s. ActualBalance: %s",
+			tx.LendingTradeId(), lendingTrade.LendingToken.Hex(), payment, balance)
+	}
+	return nil
+}
+func (pool *LendingPool) validateBasketTopupLending(cloneStateDb *state.StateDB, cloneLendingStateDb *lendingstate.LendingStateDB, tx *types.LendingTransaction) error {
+	if !pool.chain.Config().IsTIPTomoXMultiCollateral(pool.chain.CurrentHeader().Number) {
+		return ErrInvalidLendingType
+	}
+	if tx.LendingTradeId() == 0 {
+		return ErrInvalidLendingTradeID
+	}
+	if tx.Quantity() == nil || tx.Quantity().Sign() <= 0 {
+		return ErrInvalidLendingQuantity
+	}
+	lendingBook := lendingstate.GetLendingOrderBookHash(tx.LendingToken(), tx.Term())
+	lendingTrade := cloneLendingStateDb.GetLendingTrade(lendingBook, common.Uint64ToHash(tx.LendingTradeId()))
+	if lendingTrade == lendingstate.EmptyLendingTrade {
+		return ErrInvalidLendingTradeID
+	}
+	if tx.UserAddress().String() != lendingTrade.Borrower.String() {
+		return ErrInvalidLendingUserAddress
+	}
+	if tx.RelayerAddress().String() != lendingTrade.BorrowingRelayer.String() {
+		return ErrInvalidLendingRelayer
+	}
+	// any whitelisted collateral of the pair but the lending token itself
+	if tx.CollateralToken().String() == lendingstate.EmptyAddress || tx.CollateralToken() == tx.LendingToken() {
+		return ErrInvalidLendingCollateral
+	}
+	collaterals, _ := lendingstate.GetCollaterals(cloneStateDb, tx.RelayerAddress(), tx.LendingToken(), tx.Term())
+	valid := false
+	for _, collateral := range collaterals {
+		if collateral == tx.CollateralToken() {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrInvalidLendingCollateral
+	}
+	if balance := lendingstate.GetTokenBalance(tx.UserAddress(), tx.CollateralToken(), cloneStateDb); balance.Cmp(tx.Quantity()) < 0 {
+		return fmt.Errorf("not enough balance to top up the basket. Token: %s. ExpectedBalance: %s. ActualBalance: %s",
+			tx.CollateralToken().Hex(), tx.Quantity(), balance)
+	}
+	return nil
+}
+func (pool *LendingPool) validateTransferLending(cloneStateDb *state.StateDB, cloneLendingStateDb *lendingstate.LendingStateDB, tx *types.LendingTransaction) error {
+	header := pool.chain.CurrentHeader()
+	if !pool.chain.Config().IsTIPTomoXTradeTransfer(header.Number) {
+		return ErrInvalidLendingType
+	}
+	if tx.LendingTradeId() == 0 {
+		return ErrInvalidLendingTradeID
+	}
+	if tx.Quantity() == nil || tx.Quantity().Sign() < 0 {
+		return ErrInvalidLendingQuantity
+	}
+	lendingBook := lendingstate.GetLendingOrderBookHash(tx.LendingToken(), tx.Term())
+	lendingTrade := cloneLendingStateDb.GetLendingTrade(lendingBook, common.Uint64ToHash(tx.LendingTradeId()))
+	if lendingTrade == lendingstate.EmptyLendingTrade {
+		return ErrInvalidLendingTradeID
+	}
+	if tx.UserAddress().String() != lendingTrade.Investor.String() {
+		return ErrInvalidLendingUserAddress
+	}
+	if tx.RelayerAddress().String() != lendingTrade.InvestingRelayer.String() {
+		return ErrInvalidLendingRelayer
+	}
+	if lendingTrade.LiquidationTime <= header.Time.Uint64() {
+		return fmt.Errorf("lending trade expired. lendingTradeId: %v. LiquidationTime: %v", tx.LendingTradeId(), lendingTrade.LiquidationTime)
+	}
+	buyer, err := lendingstate.VerifyTransferOffer(&lendingTrade, tx.UserAddress(), tx.ExtraData(), tx.Quantity(), tx.Nonce())
+	if err != nil {
+		return err
+	}
+	if balance := lendingstate.GetTokenBalance(buyer, lendingTrade.LendingToken, cloneStateDb); balance.Cmp(tx.Quantity()) < 0 {
+		return fmt.Errorf("buyer doesn't have enough balance to buy the lending trade. lendingTradeId: %v. Token: %s. ExpectedBalance: %s. ActualBalance: %s",
+			tx.LendingTradeId(), lendingTrade.LendingToken.Hex(), tx.Quantity(), balance)
+	}
+	return nil
+}
+func (pool *LendingPool) validatePolicyLending(cloneStateDb *state.StateDB, cloneLendingStateDb *lendingstate.LendingStateDB, tx *types.LendingTransaction) error {
+	if !pool.chain.Config().IsTIPTomoXBorrowerPolicy(pool.chain.CurrentHeader().Number) {
+		return ErrInvalidLendingType
+	}
+	if _, err := lendingstate.DecodeBorrowerPolicy(tx.ExtraData()); err != nil {
+		return err
+	}
+	if tx.LendingTradeId() == 0 {
+		// an account policy applies to the trades backed by a collateral token
+		collateralList, _ := lendingstate.GetCollaterals(cloneStateDb, tx.RelayerAddress(), tx.LendingToken(), tx.Term())
+		for _, collateral := range collateralList {
+			if tx.CollateralToken().String() == collateral.String() {
+				return nil
+			}
+		}
+		return ErrInvalidLendingCollateral
+	}
+	lendingBook := lendingstate.GetLendingOrderBookHash(tx.LendingToken(), tx.Term())
+	lendingTrade := cloneLendingStateDb.GetLendingTrade(lendingBook, common.Uint64ToHash(tx.LendingTradeId()))
+	if lendingTrade == lendingstate.EmptyLendingTrade {
+		return ErrInvalidLendingTradeID
+	}
+	if tx.UserAddress().String() != lendingTrade.Borrower.String() {
+		return ErrInvalidLendingUserAddress
+	}
+	if tx.RelayerAddress().String() != lendingTrade.BorrowingRelayer.String() {
+		return ErrInvalidLendingRelayer
+	}
+	return nil
+}
 func (pool *LendingPool) validateTopupLending(cloneStateDb *state.StateDB, cloneLendingStateDb *lendingstate.LendingStateDB, tx *types.LendingTransaction) error {
 	if tx.LendingTradeId() == 0 {
 		return ErrInvalidLendingTradeID
@@ -615,6 +805,21 @@ func (pool *LendingPool) validateLending(tx *types.LendingTransaction) error {
 	if tx.IsRepayLending() {
 		return pool.validateRepayLending(cloneStateDb, cloneLendingStateDb, tx)
 	}
+	if tx.IsPartialRepayLending() {
+		return pool.validatePartialRepayLending(cloneStateDb, cloneLendingStateDb, tx)
+	}
+	if tx.IsRolloverLending() {
+		return pool.validateRolloverLending(cloneStateDb, cloneLendingStateDb, tx)
+	}
+	if tx.IsBasketTopupLending() {
+		return pool.validateBasketTopupLending(cloneStateDb, cloneLendingStateDb, tx)
+	}
+	if tx.IsTransferLending() {
+		return pool.validateTransferLending(cloneStateDb, cloneLendingStateDb, tx)
+	}
+	if tx.IsPolicyLending() {
+		return pool.validatePolicyLending(cloneStateDb, cloneLendingStateDb, tx)
+	}
 
 	return ErrInvalidLendingStatus
 }
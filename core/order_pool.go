@@ -33,6 +33,7 @@ import (
 	"github.com/tomochain/tomochain/core/types"
 	"github.com/tomochain/tomochain/event"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
 	"github.com/tomochain/tomochain/params"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
@@ -65,6 +66,12 @@ var (
 	ErrPoolOverflow       = errors.New("Exceed pool size")
 )
 
+var (
+	// Metrics for the order pool size, refreshed on every status report
+	orderPoolPendingGauge = metrics.NewRegisteredGauge("orderpool/pending", nil)
+	orderPoolQueuedGauge  = metrics.NewRegisteredGauge("orderpool/queued", nil)
+)
+
 // OrderPoolConfig are the configuration parameters of the order transaction pool.
 type OrderPoolConfig struct {
 	NoLocals  bool          // Whether local transaction handling should be disabled
@@ -243,6 +250,9 @@ func (pool *OrderPool) loop() {
 			pending, queued := pool.stats()
 			pool.mu.RUnlock()
 
+			orderPoolPendingGauge.Update(int64(pending))
+			orderPoolQueuedGauge.Update(int64(queued))
+
 			log.Debug("Order pool status report", "executable", pending, "queued", queued)
 
 			// Handle inactive account transaction eviction
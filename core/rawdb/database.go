@@ -17,12 +17,31 @@
 package rawdb
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/ethdb"
 	"github.com/tomochain/tomochain/ethdb/leveldb"
 	"github.com/tomochain/tomochain/ethdb/memorydb"
+	"github.com/tomochain/tomochain/log"
+)
+
+const (
+	// DBLeveldb is the identifier of the LevelDB backed key-value store.
+	DBLeveldb = "leveldb"
+
+	// DBPebble is the identifier of the Pebble backed key-value store.
+	DBPebble = "pebble"
 )
 
+// errAncientsRequired is returned if a database whose ancient chain data was
+// moved into a freezer is opened without one.
+var errAncientsRequired = errors.New("ancient chain segments already extracted, please set --datadir.ancient to the correct path")
+
 // freezerdb is a database wrapper that enabled freezer data retrievals.
 type freezerdb struct {
 	ethdb.KeyValueStore
@@ -93,6 +112,40 @@ func NewDatabase(db ethdb.KeyValueStore) ethdb.Database {
 	}
 }
 
+// NewDatabaseWithFreezer creates a high level database on top of a given key-
+// value data store with a freezer moving immutable chain segments into cold
+// storage. Chain data older than threshold blocks (params.ImmutabilityThreshold
+// if zero) is migrated in the background, including any pre-existing data.
+func NewDatabaseWithFreezer(db ethdb.KeyValueStore, freezer string, namespace string, threshold uint64) (ethdb.Database, error) {
+	// Create the idle freezer instance
+	frdb, err := newFreezer(freezer, namespace, threshold)
+	if err != nil {
+		return nil, err
+	}
+	// Since the freezer can be stored separately from the user's key-value database,
+	// there's a fairly high probability that the user requests invalid combinations
+	// of the freezer and database. Ensure that we don't shoot ourselves in the foot
+	// by serving up conflicting data, leading to both datastores getting corrupted.
+	//
+	//   - If the freezer is empty, any key-value database is acceptable, the
+	//     existing chain will be migrated into the freezer over time.
+	//   - If the freezer is non-empty, the key-value genesis must match the
+	//     frozen one, otherwise the two stores belong to different networks.
+	if kvgenesis := readCanonicalHash(db, 0); kvgenesis != (common.Hash{}) {
+		if frgenesis, err := frdb.Ancient(FreezerHashTable, 0); err == nil && !bytes.Equal(kvgenesis[:], frgenesis) {
+			frdb.Close()
+			return nil, fmt.Errorf("genesis mismatch: %#x (leveldb) != %#x (ancients)", kvgenesis, frgenesis)
+		}
+	}
+	// Freezer is consistent with the key-value database, permit combining the two
+	go frdb.freeze(db)
+
+	return &freezerdb{
+		KeyValueStore: db,
+		AncientStore:  frdb,
+	}, nil
+}
+
 // NewMemoryDatabase creates an ephemeral in-memory key-value database without a
 // freezer moving immutable chain segments into cold storage.
 func NewMemoryDatabase() ethdb.Database {
@@ -108,3 +161,128 @@ func NewLevelDBDatabase(file string, cache int, handles int, namespace string) (
 	}
 	return NewDatabase(db), nil
 }
+
+// NewLevelDBDatabaseWithFreezer creates a persistent key-value database with a
+// freezer moving immutable chain segments into cold storage. Without a freezer
+// directory the database is opened as is.
+func NewLevelDBDatabaseWithFreezer(file string, cache int, handles int, freezer string, namespace string, threshold uint64) (ethdb.Database, error) {
+	kvdb, err := leveldb.New(file, cache, handles, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if freezer == "" {
+		// Without the freezer the blocks already moved out of the key-value
+		// store would be missing, refuse to run on such a partial chain
+		if hasFrozenBlocks(kvdb) {
+			kvdb.Close()
+			return nil, errAncientsRequired
+		}
+		return NewDatabase(kvdb), nil
+	}
+	frdb, err := NewDatabaseWithFreezer(kvdb, freezer, namespace, threshold)
+	if err != nil {
+		kvdb.Close()
+		return nil, err
+	}
+	return frdb, nil
+}
+
+// NewPebbleDBDatabase creates a persistent key-value database without a freezer
+// moving immutable chain segments into cold storage.
+func NewPebbleDBDatabase(file string, cache int, handles int, namespace string) (ethdb.Database, error) {
+	db, err := newPebbleKeyValueStore(file, cache, handles, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return NewDatabase(db), nil
+}
+
+// PreexistingDatabase checks the given data directory whether a database is
+// already instantiated at that location, and if so, returns the engine it was
+// created with (or the empty string).
+func PreexistingDatabase(path string) string {
+	if _, err := os.Stat(filepath.Join(path, "CURRENT")); err != nil {
+		return "" // No pre-existing db
+	}
+	// Pebble keeps its options file next to the manifest, LevelDB never does
+	if matches, _ := filepath.Glob(filepath.Join(path, "OPTIONS*")); len(matches) > 0 {
+		return DBPebble
+	}
+	return DBLeveldb
+}
+
+// OpenOptions contains the options to apply when opening a persistent database.
+type OpenOptions struct {
+	Type              string // Key-value engine (leveldb, pebble), empty to keep the existing one
+	Directory         string // Directory of the key-value store
+	AncientsDirectory string // Directory of the ancient store, empty to open without a freezer
+	Namespace         string // Namespace for the database metrics
+	Cache             int    // Megabytes of memory allocated to internal caching
+	Handles           int    // Number of files the database may keep open simultaneously
+	FreezeThreshold   uint64 // Number of recent blocks the freezer keeps in the key-value store
+}
+
+// openKeyValueDatabase opens the key-value store of the requested engine. The
+// engine an existing database was created with always wins, and explicitly
+// requesting a different one is an error, as opening a directory with the wrong
+// engine would either fail or silently mix the two on-disk formats.
+func openKeyValueDatabase(o OpenOptions) (ethdb.KeyValueStore, error) {
+	existing := PreexistingDatabase(o.Directory)
+	if existing != "" && o.Type != "" && o.Type != existing {
+		return nil, fmt.Errorf("db.engine choice was %v but found pre-existing %v database in specified data directory", o.Type, existing)
+	}
+	engine := o.Type
+	if existing != "" {
+		engine = existing
+	}
+	switch engine {
+	case DBPebble:
+		log.Info("Using pebble as the backing database", "path", o.Directory)
+		return newPebbleKeyValueStore(o.Directory, o.Cache, o.Handles, o.Namespace)
+	case DBLeveldb, "":
+		log.Info("Using leveldb as the backing database", "path", o.Directory)
+		db, err := leveldb.New(o.Directory, o.Cache, o.Handles, o.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown db.engine %q", engine)
+	}
+}
+
+// Open opens a persistent key-value database with the engine requested in the
+// options (or the one it was created with), attaching a freezer moving immutable
+// chain segments into cold storage if an ancients directory is given.
+func Open(o OpenOptions) (ethdb.Database, error) {
+	kvdb, err := openKeyValueDatabase(o)
+	if err != nil {
+		return nil, err
+	}
+	if o.AncientsDirectory == "" {
+		// Without the freezer the blocks already moved out of the key-value
+		// store would be missing, refuse to run on such a partial chain
+		if hasFrozenBlocks(kvdb) {
+			kvdb.Close()
+			return nil, errAncientsRequired
+		}
+		return NewDatabase(kvdb), nil
+	}
+	frdb, err := NewDatabaseWithFreezer(kvdb, o.AncientsDirectory, o.Namespace, o.FreezeThreshold)
+	if err != nil {
+		kvdb.Close()
+		return nil, err
+	}
+	return frdb, nil
+}
+
+// hasFrozenBlocks reports whether chain data was moved out of the key-value
+// store into a freezer. The freezer keeps the genesis block but deletes the
+// canonical hashes of all the other blocks it took over.
+func hasFrozenBlocks(db ethdb.KeyValueReader) bool {
+	number, ok := readHeaderNumber(db, readHeadBlockHash(db))
+	if !ok || number == 0 {
+		return false
+	}
+	return readCanonicalHash(db, 0) != (common.Hash{}) && readCanonicalHash(db, 1) == (common.Hash{})
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+)
+
+// Tests that the key-value engine a database was created with is remembered and
+// that opening it with a different one is refused instead of mixing formats.
+func TestOpenEngineSelection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, engine := range []string{DBLeveldb, DBPebble} {
+		path := filepath.Join(dir, engine)
+		if have := PreexistingDatabase(path); have != "" {
+			t.Fatalf("%s: found database in empty directory: %q", engine, have)
+		}
+		db, err := Open(OpenOptions{Type: engine, Directory: path})
+		if err != nil {
+			t.Fatalf("%s: failed to create database: %v", engine, err)
+		}
+		if err := db.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatalf("%s: failed to write: %v", engine, err)
+		}
+		db.Close()
+
+		if have := PreexistingDatabase(path); have != engine {
+			t.Fatalf("%s: engine mismatch: have %q", engine, have)
+		}
+		// Opening with another engine must fail, with none the existing one is used
+		other := DBPebble
+		if engine == DBPebble {
+			other = DBLeveldb
+		}
+		if db, err := Open(OpenOptions{Type: other, Directory: path}); err == nil {
+			db.Close()
+			t.Fatalf("%s: reopened with mismatching engine %s", engine, other)
+		}
+		db, err = Open(OpenOptions{Directory: path})
+		if err != nil {
+			t.Fatalf("%s: failed to reopen database: %v", engine, err)
+		}
+		if val, err := db.Get([]byte("key")); err != nil || string(val) != "value" {
+			t.Fatalf("%s: value mismatch: have %q, %v", engine, val, err)
+		}
+		db.Close()
+	}
+	if _, err := Open(OpenOptions{Type: "rocksdb", Directory: filepath.Join(dir, "unknown")}); err == nil {
+		t.Fatalf("unknown engine accepted")
+	}
+}
+
+// Tests that a database whose chain data was moved into a freezer can't be
+// opened without it.
+func TestOpenFrozenWithoutAncients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frozendb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "chaindata")
+	db, err := Open(OpenOptions{Directory: path})
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	head := common.HexToHash("0x05")
+	db.Put(headerHashKey(0), common.HexToHash("0x01").Bytes())
+	db.Put(headerHashKey(1), common.HexToHash("0x02").Bytes())
+	db.Put(headerNumberKey(head), encodeBlockNumber(5))
+	db.Put(headBlockKey, head.Bytes())
+	db.Close()
+
+	// all the blocks are still in the key-value store
+	if db, err = Open(OpenOptions{Directory: path}); err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	// the freezer took over the blocks after the genesis
+	db.Delete(headerHashKey(1))
+	db.Close()
+
+	if _, err := Open(OpenOptions{Directory: path}); err != errAncientsRequired {
+		t.Fatalf("opened without ancients: have %v, want %v", err, errAncientsRequired)
+	}
+	db, err = Open(OpenOptions{Directory: path, AncientsDirectory: filepath.Join(dir, "ancient")})
+	if err != nil {
+		t.Fatalf("failed to open with ancients: %v", err)
+	}
+	db.Close()
+}
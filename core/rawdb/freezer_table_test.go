@@ -0,0 +1,172 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package rawdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tomochain/tomochain/metrics"
+)
+
+// getChunk returns a chunk of data of length b, filled with the byte b.
+func getChunk(size int, b int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(b)
+	}
+	return data
+}
+
+func newTestTable(t *testing.T, dir string, name string, maxFileSize uint32, noCompression bool) *freezerTable {
+	rm, wm, sc := metrics.NewMeter(), metrics.NewMeter(), metrics.NewCounter()
+	f, err := newCustomTable(dir, name, rm, wm, sc, maxFileSize, noCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// Tests that items can be appended and retrieved across multiple data files,
+// and that the table contents survive a reopen.
+func TestFreezerBasics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Set the max size to 50 bytes, so every item (15 bytes) forces a new file
+	// every third item
+	f := newTestTable(t, dir, "basics", 50, true)
+	for x := 0; x < 255; x++ {
+		if err := f.Append(uint64(x), getChunk(15, x)); err != nil {
+			t.Fatalf("append %d failed: %v", x, err)
+		}
+	}
+	if err := f.Append(254, getChunk(15, 254)); err == nil {
+		t.Fatalf("out of order append succeeded")
+	}
+	f.Close()
+
+	f = newTestTable(t, dir, "basics", 50, true)
+	defer f.Close()
+
+	for y := 0; y < 255; y++ {
+		exp := getChunk(15, y)
+		got, err := f.Retrieve(uint64(y))
+		if err != nil {
+			t.Fatalf("retrieve %d failed: %v", y, err)
+		}
+		if !bytes.Equal(got, exp) {
+			t.Fatalf("item %d mismatch: have %x, want %x", y, got, exp)
+		}
+	}
+	if _, err := f.Retrieve(255); err != errOutOfBounds {
+		t.Fatalf("out of bounds retrieval error mismatch: have %v, want %v", err, errOutOfBounds)
+	}
+}
+
+// Tests that a table with a chopped off head file (e.g. after a crash) repairs
+// its index on open, discarding the dangling items.
+func TestFreezerRepairDanglingHead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newTestTable(t, dir, "dangling", 50, false)
+	for x := 0; x < 255; x++ {
+		if err := f.Append(uint64(x), getChunk(15, x)); err != nil {
+			t.Fatalf("append %d failed: %v", x, err)
+		}
+	}
+	head := f.headId
+	f.Close()
+
+	// Chop a few bytes off the head file, invalidating its last item
+	name := filepath.Join(dir, fmt.Sprintf("dangling.%04d.cdat", head))
+	stat, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(name, stat.Size()-4); err != nil {
+		t.Fatal(err)
+	}
+	f = newTestTable(t, dir, "dangling", 50, false)
+	defer f.Close()
+
+	if f.items != 254 {
+		t.Fatalf("item count mismatch after repair: have %d, want %d", f.items, 254)
+	}
+	if _, err := f.Retrieve(254); err != errOutOfBounds {
+		t.Fatalf("dangling item retrievable: %v", err)
+	}
+	if got, err := f.Retrieve(253); err != nil || !bytes.Equal(got, getChunk(15, 253)) {
+		t.Fatalf("last valid item mismatch: have %x, err %v", got, err)
+	}
+	// The repaired table must accept the dropped item again
+	if err := f.Append(254, getChunk(15, 254)); err != nil {
+		t.Fatalf("append after repair failed: %v", err)
+	}
+}
+
+// Tests that truncating a table across data files drops the superfluous files
+// and allows appending from the new head.
+func TestFreezerTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newTestTable(t, dir, "truncation", 50, true)
+	defer f.Close()
+
+	for x := 0; x < 30; x++ {
+		if err := f.Append(uint64(x), getChunk(15, x)); err != nil {
+			t.Fatalf("append %d failed: %v", x, err)
+		}
+	}
+	if err := f.truncate(10); err != nil {
+		t.Fatalf("truncation failed: %v", err)
+	}
+	if f.items != 10 {
+		t.Fatalf("item count mismatch: have %d, want %d", f.items, 10)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "truncation.0009.rdat")); !os.IsNotExist(err) {
+		t.Fatalf("stale data file not removed: %v", err)
+	}
+	for x := 10; x < 20; x++ {
+		if err := f.Append(uint64(x), getChunk(15, 100+x)); err != nil {
+			t.Fatalf("append %d after truncation failed: %v", x, err)
+		}
+	}
+	for y := 0; y < 20; y++ {
+		exp := getChunk(15, y)
+		if y >= 10 {
+			exp = getChunk(15, 100+y)
+		}
+		if got, err := f.Retrieve(uint64(y)); err != nil || !bytes.Equal(got, exp) {
+			t.Fatalf("item %d mismatch: have %x, want %x, err %v", y, got, exp, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package rawdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/ethdb"
+	"github.com/tomochain/tomochain/ethdb/memorydb"
+)
+
+// writeTestBlock stores a fake block with the given hash at number into the
+// key-value store, using the payload byte to tell the components apart.
+func writeTestBlock(db ethdb.KeyValueWriter, hash common.Hash, number uint64, payload byte) {
+	enc := encodeBlockNumber(number)
+	db.Put(headerNumberKey(hash), enc)
+	db.Put(headerKey(number, hash), []byte{0x01, payload})
+	db.Put(blockBodyKey(number, hash), []byte{0x02, payload})
+	db.Put(blockReceiptsKey(number, hash), []byte{0x03, payload})
+	db.Put(headerTDKey(number, hash), []byte{0x04, payload})
+}
+
+// Tests that the background freezer migrates canonical blocks beyond the
+// threshold into the ancient store, wiping them and any side chains at the
+// same heights from the key-value store.
+func TestFreezerMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kvdb := memorydb.New()
+	var canon []common.Hash
+	for i := uint64(0); i < 100; i++ {
+		hash := common.BytesToHash([]byte{byte(i), 0xca})
+		writeTestBlock(kvdb, hash, i, byte(i))
+		kvdb.Put(headerHashKey(i), hash[:])
+		canon = append(canon, hash)
+	}
+	side := common.BytesToHash([]byte{0x05, 0x5e})
+	writeTestBlock(kvdb, side, 5, 0xff)
+	kvdb.Put(headBlockKey, canon[99][:])
+
+	f, err := newFreezer(dir, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.threshold = 10
+
+	go f.freeze(kvdb)
+
+	// Wait until the frozen segment is deleted from the key-value store
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if ok, _ := kvdb.Has(blockBodyKey(88, canon[88])); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("blocks not frozen in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if frozen, _ := f.Ancients(); frozen != 89 {
+		t.Fatalf("frozen count mismatch: have %d, want %d", frozen, 89)
+	}
+	for i := uint64(0); i < 89; i++ {
+		if hash, _ := f.Ancient(FreezerHashTable, i); !bytes.Equal(hash, canon[i][:]) {
+			t.Fatalf("block %d: frozen hash mismatch: have %x, want %x", i, hash, canon[i])
+		}
+		if body, _ := f.Ancient(FreezerBodiesTable, i); !bytes.Equal(body, []byte{0x02, byte(i)}) {
+			t.Fatalf("block %d: frozen body mismatch: have %x", i, body)
+		}
+		if i == 0 {
+			continue // genesis is kept in the active database
+		}
+		if ok, _ := kvdb.Has(headerKey(i, canon[i])); ok {
+			t.Fatalf("block %d: frozen header not wiped", i)
+		}
+		if ok, _ := kvdb.Has(headerNumberKey(canon[i])); !ok {
+			t.Fatalf("block %d: canonical number mapping wiped", i)
+		}
+	}
+	if ok, _ := kvdb.Has(headerKey(0, canon[0])); !ok {
+		t.Fatalf("genesis header wiped")
+	}
+	if ok, _ := kvdb.Has(headerKey(5, side)); ok {
+		t.Fatalf("side chain header not wiped")
+	}
+	if ok, _ := kvdb.Has(headerNumberKey(side)); ok {
+		t.Fatalf("side chain number mapping not wiped")
+	}
+	for i := uint64(89); i < 100; i++ {
+		if ok, _ := kvdb.Has(headerKey(i, canon[i])); !ok {
+			t.Fatalf("block %d: recent header wiped", i)
+		}
+	}
+	// Rewinding the freezer must drop the ancients above the new length
+	if err := f.TruncateAncients(50); err != nil {
+		t.Fatalf("truncation failed: %v", err)
+	}
+	if ok, _ := f.HasAncient(FreezerHeaderTable, 50); ok {
+		t.Fatalf("truncated ancient still present")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+
+	"github.com/tomochain/tomochain/common"
+)
+
+var (
+	slotSigningKeyMapping = map[string]uint64{
+		"signingKeys":     0, // candidate => key sealing blocks now
+		"nextSigningKeys": 1, // candidate => key taking over at activation
+		"activations":     2, // candidate => block number the next key takes over
+		"keyCandidates":   3, // key => candidate, never cleared
+	}
+)
+
+func signingKeyLoc(key common.Address, name string) common.Hash {
+	return common.BigToHash(GetLocMappingAtKey(key.Hash(), slotSigningKeyMapping[name]))
+}
+
+func getSigningKeyState(statedb *StateDB, key common.Address, name string) common.Hash {
+	return statedb.GetState(common.HexToAddress(common.SigningKeyRegistry), signingKeyLoc(key, name))
+}
+
+func setSigningKeyState(statedb *StateDB, key common.Address, name string, value common.Hash) {
+	statedb.SetState(common.HexToAddress(common.SigningKeyRegistry), signingKeyLoc(key, name), value)
+}
+
+// GetSigningKey returns the key sealing blocks for the candidate at the given
+// block number. Candidates which never registered a key sign with their own
+// address.
+func GetSigningKey(statedb *StateDB, candidate common.Address, number uint64) common.Address {
+	if next, activation := GetNextSigningKey(statedb, candidate); next != (common.Address{}) && activation <= number {
+		return next
+	}
+	if key := common.BytesToAddress(getSigningKeyState(statedb, candidate, "signingKeys").Bytes()); key != (common.Address{}) {
+		return key
+	}
+	return candidate
+}
+
+// GetNextSigningKey returns the key registered for the candidate and the block
+// number from which it seals, or the zero address if there is none.
+func GetNextSigningKey(statedb *StateDB, candidate common.Address) (common.Address, uint64) {
+	next := common.BytesToAddress(getSigningKeyState(statedb, candidate, "nextSigningKeys").Bytes())
+	activation := getSigningKeyState(statedb, candidate, "activations").Big().Uint64()
+	return next, activation
+}
+
+// GetSigningKeyCandidate returns the candidate a key was registered for, or
+// the zero address if the key was never registered. A key stays bound to its
+// candidate after being rotated out, so it can't be claimed by anybody else.
+func GetSigningKeyCandidate(statedb *StateDB, key common.Address) common.Address {
+	return common.BytesToAddress(getSigningKeyState(statedb, key, "keyCandidates").Bytes())
+}
+
+// SetSigningKey registers the key which seals blocks for the candidate from
+// the activation block on. A previous key which is active at the given block
+// number becomes the current one, a pending one is replaced.
+func SetSigningKey(statedb *StateDB, candidate, key common.Address, number, activation uint64) {
+	if next, from := GetNextSigningKey(statedb, candidate); next != (common.Address{}) && from <= number {
+		setSigningKeyState(statedb, candidate, "signingKeys", next.Hash())
+	}
+	setSigningKeyState(statedb, candidate, "nextSigningKeys", key.Hash())
+	setSigningKeyState(statedb, candidate, "activations", common.BigToHash(new(big.Int).SetUint64(activation)))
+	setSigningKeyState(statedb, key, "keyCandidates", candidate.Hash())
+
+	// Keep the registry from being swept as an empty account
+	registry := common.HexToAddress(common.SigningKeyRegistry)
+	if statedb.GetNonce(registry) == 0 {
+		statedb.SetNonce(registry, 1)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+)
+
+func TestSigningKeyRotation(t *testing.T) {
+	statedb, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()))
+	var (
+		candidate = common.HexToAddress("0x01")
+		first     = common.HexToAddress("0x02")
+		second    = common.HexToAddress("0x03")
+	)
+	if have := GetSigningKey(statedb, candidate, 100); have != candidate {
+		t.Fatalf("unregistered key mismatch: have %x, want %x", have, candidate)
+	}
+	SetSigningKey(statedb, candidate, first, 100, 900)
+	if have := GetSigningKey(statedb, candidate, 899); have != candidate {
+		t.Errorf("key before activation mismatch: have %x, want %x", have, candidate)
+	}
+	if have := GetSigningKey(statedb, candidate, 900); have != first {
+		t.Errorf("key after activation mismatch: have %x, want %x", have, first)
+	}
+	// A second rotation keeps the first key until its own activation
+	SetSigningKey(statedb, candidate, second, 1000, 1800)
+	if have := GetSigningKey(statedb, candidate, 1799); have != first {
+		t.Errorf("key before second activation mismatch: have %x, want %x", have, first)
+	}
+	if have := GetSigningKey(statedb, candidate, 1800); have != second {
+		t.Errorf("key after second activation mismatch: have %x, want %x", have, second)
+	}
+	for _, key := range []common.Address{first, second} {
+		if have := GetSigningKeyCandidate(statedb, key); have != candidate {
+			t.Errorf("candidate of key %x mismatch: have %x, want %x", key, have, candidate)
+		}
+	}
+	// The registry survives the removal of empty accounts
+	statedb.Finalise(true)
+	if have := GetSigningKey(statedb, candidate, 1800); have != second {
+		t.Errorf("key after finalise mismatch: have %x, want %x", have, second)
+	}
+}
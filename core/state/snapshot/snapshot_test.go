@@ -0,0 +1,194 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/ethdb"
+	"github.com/tomochain/tomochain/rlp"
+	"github.com/tomochain/tomochain/trie"
+)
+
+// makeTestState creates a small state trie with a handful of accounts, one of
+// them owning a storage trie, and commits it to the given database.
+func makeTestState(t *testing.T, db ethdb.KeyValueStore) (*trie.Database, common.Hash) {
+	triedb := trie.NewDatabase(db)
+
+	stTrie, _ := trie.NewSecure(common.Hash{}, triedb)
+	for i := byte(1); i <= 3; i++ {
+		val, _ := rlp.EncodeToBytes([]byte{i, i})
+		stTrie.Update(common.LeftPadBytes([]byte{i}, 32), val)
+	}
+	stRoot, err := stTrie.Commit(nil)
+	if err != nil {
+		t.Fatalf("failed to commit storage trie: %v", err)
+	}
+	accTrie, _ := trie.NewSecure(common.Hash{}, triedb)
+	for i := byte(1); i <= 5; i++ {
+		root := emptyRoot
+		if i == 1 {
+			root = stRoot
+		}
+		acc := &Account{Nonce: uint64(i), Balance: big.NewInt(int64(i) * 1000), Root: root[:], CodeHash: emptyCode[:]}
+		val, _ := rlp.EncodeToBytes(acc)
+		accTrie.Update(common.BytesToAddress([]byte{i}).Bytes(), val)
+	}
+	root, err := accTrie.Commit(func(leaf []byte, parent common.Hash) error {
+		var acc Account
+		if err := rlp.DecodeBytes(leaf, &acc); err != nil {
+			return err
+		}
+		triedb.Reference(common.BytesToHash(acc.Root), parent)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to commit account trie: %v", err)
+	}
+	if err := triedb.Commit(root, false); err != nil {
+		t.Fatalf("failed to flush tries: %v", err)
+	}
+	return triedb, root
+}
+
+// Tests that a snapshot generated from a state trie serves exactly the same
+// accounts and storage slots as the trie itself.
+func TestGenerateAndVerify(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	triedb, root := makeTestState(t, db)
+
+	snaps := New(db, triedb, 16, root, false)
+	if err := snaps.Verify(root); err != nil {
+		t.Fatalf("generated snapshot mismatch: %v", err)
+	}
+	if have := rawdb.ReadSnapshotRoot(db); have != root {
+		t.Fatalf("snapshot root mismatch: have %x, want %x", have, root)
+	}
+	addr := crypto.Keccak256Hash(common.BytesToAddress([]byte{3}).Bytes())
+	acc, err := snaps.Snapshot(root).Account(addr)
+	if err != nil {
+		t.Fatalf("failed to retrieve account: %v", err)
+	}
+	if acc == nil || acc.Nonce != 3 || acc.Balance.Cmp(big.NewInt(3000)) != 0 {
+		t.Fatalf("account mismatch: %+v", acc)
+	}
+	// Inject an account unknown to the trie and ensure verification notices it
+	rawdb.WriteAccountSnapshot(db, common.HexToHash("0xdead"), SlimAccountRLP(1, big.NewInt(1), emptyRoot, emptyCode[:]))
+	if err := snaps.Verify(root); err == nil {
+		t.Fatalf("corrupted snapshot passed verification")
+	}
+}
+
+// Tests that diff layers shadow their parents, that destructed accounts hide
+// any storage below them and that capping the tree flattens the layers into
+// the persistent disk layer.
+func TestDiffLayersAndCap(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	triedb, root := makeTestState(t, db)
+	snaps := New(db, triedb, 16, root, false)
+
+	var (
+		acc1  = crypto.Keccak256Hash(common.BytesToAddress([]byte{1}).Bytes())
+		acc2  = crypto.Keccak256Hash(common.BytesToAddress([]byte{2}).Bytes())
+		slot1 = crypto.Keccak256Hash(common.LeftPadBytes([]byte{1}, 32))
+
+		root1 = common.HexToHash("0x01")
+		root2 = common.HexToHash("0x02")
+		blob  = SlimAccountRLP(100, big.NewInt(1), emptyRoot, emptyCode[:])
+	)
+	// Layer 1 modifies a plain account, layer 2 destructs the storage owner
+	if err := snaps.Update(root1, root, nil, map[common.Hash][]byte{acc2: blob}, nil); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if err := snaps.Update(root2, root1, map[common.Hash]struct{}{acc1: {}}, nil, nil); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if err := snaps.Update(common.HexToHash("0x03"), common.HexToHash("0xff"), nil, nil, nil); err == nil {
+		t.Fatalf("layer with unknown parent accepted")
+	}
+	if acc, _ := snaps.Snapshot(root).Account(acc2); acc == nil || acc.Nonce != 2 {
+		t.Fatalf("disk layer mutated by diff: %+v", acc)
+	}
+	if acc, _ := snaps.Snapshot(root2).Account(acc2); acc == nil || acc.Nonce != 100 {
+		t.Fatalf("diff layer account mismatch: %+v", acc)
+	}
+	if val, _ := snaps.Snapshot(root1).Storage(acc1, slot1); len(val) == 0 {
+		t.Fatalf("storage missing below destruct")
+	}
+	if val, _ := snaps.Snapshot(root2).Storage(acc1, slot1); len(val) != 0 {
+		t.Fatalf("destructed storage still served: %x", val)
+	}
+	// Flatten everything into the disk layer and check the persisted data
+	if err := snaps.Cap(root2, 0); err != nil {
+		t.Fatalf("failed to cap snapshot tree: %v", err)
+	}
+	if snap := snaps.Snapshot(root); snap != nil {
+		t.Fatalf("stale disk layer still referenced")
+	}
+	if have := rawdb.ReadSnapshotRoot(db); have != root2 {
+		t.Fatalf("persisted root mismatch: have %x, want %x", have, root2)
+	}
+	if acc, _ := snaps.Snapshot(root2).Account(acc2); acc == nil || acc.Nonce != 100 {
+		t.Fatalf("flattened account mismatch: %+v", acc)
+	}
+	if val := rawdb.ReadStorageSnapshot(db, acc1, slot1); len(val) != 0 {
+		t.Fatalf("destructed storage persisted: %x", val)
+	}
+}
+
+// Tests that the journalled diff layers are restored on startup and that a
+// journal not matching the requested head triggers a regeneration.
+func TestJournal(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	triedb, root := makeTestState(t, db)
+	snaps := New(db, triedb, 16, root, false)
+
+	var (
+		acc2  = crypto.Keccak256Hash(common.BytesToAddress([]byte{2}).Bytes())
+		root1 = common.HexToHash("0x01")
+		blob  = SlimAccountRLP(100, big.NewInt(1), emptyRoot, emptyCode[:])
+	)
+	if err := snaps.Update(root1, root, nil, map[common.Hash][]byte{acc2: blob}, nil); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	base, err := snaps.Journal(root1)
+	if err != nil {
+		t.Fatalf("failed to journal snapshot: %v", err)
+	}
+	if base != root {
+		t.Fatalf("journal base mismatch: have %x, want %x", base, root)
+	}
+	// Reload the tree and ensure the diff layer is back
+	snaps = New(db, triedb, 16, root1, false)
+	if snap := snaps.Snapshot(root1); snap == nil {
+		t.Fatalf("journalled diff layer not restored")
+	} else if acc, _ := snap.Account(acc2); acc == nil || acc.Nonce != 100 {
+		t.Fatalf("restored account mismatch: %+v", acc)
+	}
+	// Reloading at the base root must discard the journal and regenerate
+	snaps = New(db, triedb, 16, root, false)
+	if snaps.Snapshot(root1) != nil {
+		t.Fatalf("mismatching journal layer restored")
+	}
+	if err := snaps.Verify(root); err != nil {
+		t.Fatalf("regenerated snapshot mismatch: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/rlp"
+	"github.com/tomochain/tomochain/trie"
+)
+
+// Verify checks the snapshot of the given state root against the state trie
+// it was generated from. Every account and storage slot in the tries must be
+// served by the snapshot with the same content, and no entry persisted on disk
+// or held in the diff layers may be served without being present in the tries.
+//
+// The snapshot must be fully generated and the tries must be available.
+func (t *Tree) Verify(root common.Hash) error {
+	snap, ok := t.Snapshot(root).(snapshot)
+	if !ok || snap == nil {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	accTrie, err := trie.New(root, t.triedb)
+	if err != nil {
+		return err
+	}
+	var (
+		start    = time.Now()
+		logged   = time.Now()
+		accounts uint64
+		slots    uint64
+	)
+	// Ensure every account and storage slot of the trie is served by the snapshot
+	accIt := trie.NewIterator(accTrie.NodeIterator(nil))
+	for accIt.Next() {
+		accountHash := common.BytesToHash(accIt.Key)
+
+		blob, err := snap.AccountRLP(accountHash)
+		if err != nil {
+			return fmt.Errorf("account %#x: %v", accountHash, err)
+		}
+		if len(blob) == 0 {
+			return fmt.Errorf("account %#x missing from snapshot", accountHash)
+		}
+		full, err := FullAccountRLP(blob)
+		if err != nil {
+			return fmt.Errorf("account %#x: %v", accountHash, err)
+		}
+		if !bytes.Equal(full, accIt.Value) {
+			return fmt.Errorf("account %#x mismatch: have %x, want %x", accountHash, full, accIt.Value)
+		}
+		var acc Account
+		if err := rlp.DecodeBytes(accIt.Value, &acc); err != nil {
+			return fmt.Errorf("account %#x: %v", accountHash, err)
+		}
+		storeRoot := common.BytesToHash(acc.Root)
+		if storeRoot != emptyRoot {
+			n, err := t.verifyStorage(snap, accountHash, storeRoot)
+			if err != nil {
+				return err
+			}
+			slots += n
+		}
+		accounts++
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Verifying state snapshot", "root", root, "at", accountHash, "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if accIt.Err != nil {
+		return accIt.Err
+	}
+	// Ensure the snapshot doesn't serve any account missing from the trie
+	check := func(accountHash common.Hash) error {
+		blob, err := snap.AccountRLP(accountHash)
+		if err != nil {
+			return fmt.Errorf("account %#x: %v", accountHash, err)
+		}
+		if len(blob) == 0 {
+			return nil // Deleted by a diff layer
+		}
+		enc, err := accTrie.TryGet(accountHash[:])
+		if err != nil {
+			return err
+		}
+		if len(enc) == 0 {
+			return fmt.Errorf("dangling account %#x in snapshot", accountHash)
+		}
+		return nil
+	}
+	for layer := snap; layer != nil; layer = layer.Parent() {
+		diff, ok := layer.(*diffLayer)
+		if !ok {
+			break
+		}
+		diff.lock.RLock()
+		hashes := make([]common.Hash, 0, len(diff.accountData))
+		for hash := range diff.accountData {
+			hashes = append(hashes, hash)
+		}
+		diff.lock.RUnlock()
+
+		for _, hash := range hashes {
+			if err := check(hash); err != nil {
+				return err
+			}
+		}
+	}
+	it := t.diskdb.NewIterator(rawdb.SnapshotAccountPrefix, nil)
+	defer it.Release()
+
+	for it.Next() {
+		if key := it.Key(); len(key) == 1+common.HashLength {
+			if err := check(common.BytesToHash(key[1:])); err != nil {
+				return err
+			}
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	log.Info("Verified state snapshot", "root", root, "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// verifyStorage checks the storage slots of a single account in the snapshot
+// against its storage trie, returning the number of slots verified.
+func (t *Tree) verifyStorage(snap snapshot, accountHash common.Hash, storeRoot common.Hash) (uint64, error) {
+	storeTrie, err := trie.New(storeRoot, t.triedb)
+	if err != nil {
+		return 0, err
+	}
+	var slots uint64
+
+	storeIt := trie.NewIterator(storeTrie.NodeIterator(nil))
+	for storeIt.Next() {
+		storageHash := common.BytesToHash(storeIt.Key)
+
+		blob, err := snap.Storage(accountHash, storageHash)
+		if err != nil {
+			return 0, fmt.Errorf("slot %#x of account %#x: %v", storageHash, accountHash, err)
+		}
+		if !bytes.Equal(blob, storeIt.Value) {
+			return 0, fmt.Errorf("slot %#x of account %#x mismatch: have %x, want %x", storageHash, accountHash, blob, storeIt.Value)
+		}
+		slots++
+	}
+	if storeIt.Err != nil {
+		return 0, storeIt.Err
+	}
+	// Ensure the snapshot doesn't serve any slot missing from the trie
+	check := func(storageHash common.Hash) error {
+		blob, err := snap.Storage(accountHash, storageHash)
+		if err != nil {
+			return fmt.Errorf("slot %#x of account %#x: %v", storageHash, accountHash, err)
+		}
+		if len(blob) == 0 {
+			return nil // Deleted by a diff layer
+		}
+		enc, err := storeTrie.TryGet(storageHash[:])
+		if err != nil {
+			return err
+		}
+		if len(enc) == 0 {
+			return fmt.Errorf("dangling slot %#x of account %#x in snapshot", storageHash, accountHash)
+		}
+		return nil
+	}
+	for layer := snap; layer != nil; layer = layer.Parent() {
+		diff, ok := layer.(*diffLayer)
+		if !ok {
+			break
+		}
+		diff.lock.RLock()
+		hashes := make([]common.Hash, 0, len(diff.storageData[accountHash]))
+		for hash := range diff.storageData[accountHash] {
+			hashes = append(hashes, hash)
+		}
+		diff.lock.RUnlock()
+
+		for _, hash := range hashes {
+			if err := check(hash); err != nil {
+				return 0, err
+			}
+		}
+	}
+	it := rawdb.IterateStorageSnapshots(t.diskdb, accountHash)
+	defer it.Release()
+
+	for it.Next() {
+		if key := it.Key(); len(key) == 1+2*common.HashLength {
+			if err := check(common.BytesToHash(key[1+common.HashLength:])); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return slots, it.Error()
+}
@@ -80,10 +80,13 @@ type stateObject struct {
 	cachedStorage Storage // Storage entry cache to avoid duplicate reads
 	dirtyStorage  Storage // Storage entries that need to be flushed to disk
 
+	snapRoot common.Hash // Storage root served by the state snapshot, empty if not loaded from it
+
 	// Cache flags.
 	// When an object is marked suicided it will be delete from the trie
 	// during the "update" phase of the state transition.
 	dirtyCode bool // true if the code was updated
+	recreated bool // true if the object replaced an existing account, wiping its storage
 	suicided  bool
 	touched   bool
 	deleted   bool
@@ -169,21 +172,7 @@ func (c *stateObject) getTrie(db Database) Trie {
 }
 
 func (self *stateObject) GetCommittedState(db Database, key common.Hash) common.Hash {
-	value := common.Hash{}
-	// Load from DB in case it is missing.
-	enc, err := self.getTrie(db).TryGet(key[:])
-	if err != nil {
-		self.setError(err)
-		return common.Hash{}
-	}
-	if len(enc) > 0 {
-		_, content, _, err := rlp.Split(enc)
-		if err != nil {
-			self.setError(err)
-		}
-		value.SetBytes(content)
-	}
-	return value
+	return self.readStorage(db, key)
 }
 
 func (self *stateObject) GetState(db Database, key common.Hash) common.Hash {
@@ -192,11 +181,32 @@ func (self *stateObject) GetState(db Database, key common.Hash) common.Hash {
 		return value
 	}
 	// Load from DB in case it is missing.
-	enc, err := self.getTrie(db).TryGet(key[:])
-	if err != nil {
-		self.setError(err)
-		return common.Hash{}
+	value = self.readStorage(db, key)
+	if (value != common.Hash{}) {
+		self.cachedStorage[key] = value
+	}
+	return value
+}
+
+// readStorage loads a committed storage slot, from the state snapshot as long
+// as the storage is unchanged since it was loaded from there, or from the trie.
+func (self *stateObject) readStorage(db Database, key common.Hash) common.Hash {
+	var (
+		enc    []byte
+		err    error
+		served bool
+	)
+	if snap := self.db.snap; snap != nil && self.snapRoot != (common.Hash{}) && self.snapRoot == self.data.Root {
+		enc, err = snap.Storage(self.addrHash, crypto.Keccak256Hash(key[:]))
+		served = err == nil
 	}
+	if !served {
+		if enc, err = self.getTrie(db).TryGet(key[:]); err != nil {
+			self.setError(err)
+			return common.Hash{}
+		}
+	}
+	value := common.Hash{}
 	if len(enc) > 0 {
 		_, content, _, err := rlp.Split(enc)
 		if err != nil {
@@ -204,9 +214,6 @@ func (self *stateObject) GetState(db Database, key common.Hash) common.Hash {
 		}
 		value.SetBytes(content)
 	}
-	if (value != common.Hash{}) {
-		self.cachedStorage[key] = value
-	}
 	return value
 }
 
@@ -230,6 +237,37 @@ func (self *stateObject) setState(key, value common.Hash) {
 	}
 }
 
+// updateSnapshot records the dirty storage of the object for the next snapshot
+// layer. It must run before the storage is flushed into the trie.
+func (self *stateObject) updateSnapshot() {
+	db := self.db
+	if db.snap == nil {
+		return
+	}
+	// A recreated account doesn't inherit any of the previous storage
+	if self.recreated {
+		db.snapDestructs[self.addrHash] = struct{}{}
+		delete(db.snapStorage, self.addrHash)
+		self.recreated = false
+	}
+	if len(self.dirtyStorage) == 0 {
+		return
+	}
+	storage := db.snapStorage[self.addrHash]
+	if storage == nil {
+		storage = make(map[common.Hash][]byte, len(self.dirtyStorage))
+		db.snapStorage[self.addrHash] = storage
+	}
+	for key, value := range self.dirtyStorage {
+		var v []byte
+		if (value != common.Hash{}) {
+			// Encoding []byte cannot fail, ok to ignore the error.
+			v, _ = rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
+		}
+		storage[crypto.Keccak256Hash(key[:])] = v
+	}
+}
+
 // updateTrie writes cached storage modifications into the object's storage trie.
 func (self *stateObject) updateTrie(db Database) Trie {
 	tr := self.getTrie(db)
@@ -319,6 +357,8 @@ func (self *stateObject) deepCopy(db *StateDB, onDirty func(addr common.Address)
 	stateObject.cachedStorage = self.dirtyStorage.Copy()
 	stateObject.suicided = self.suicided
 	stateObject.dirtyCode = self.dirtyCode
+	stateObject.recreated = self.recreated
+	stateObject.snapRoot = self.snapRoot
 	stateObject.deleted = self.deleted
 	return stateObject
 }
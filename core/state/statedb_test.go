@@ -32,6 +32,7 @@ import (
 	check "gopkg.in/check.v1"
 
 	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/state/snapshot"
 	"github.com/tomochain/tomochain/core/types"
 )
 
@@ -427,3 +428,63 @@ func (s *StateSuite) TestTouchDelete(c *check.C) {
 		c.Fatal("expected no dirty state object")
 	}
 }
+
+// Tests that state reads served by the snapshot match the trie, and that state
+// commits keep the snapshot in sync with the modified, destructed and recreated
+// accounts.
+func TestStateSnapshotReads(t *testing.T) {
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		sdb   = NewDatabase(db)
+		addr1 = common.BytesToAddress([]byte{1})
+		addr2 = common.BytesToAddress([]byte{2})
+		addr3 = common.BytesToAddress([]byte{3})
+		key   = common.HexToHash("0x01")
+	)
+	state, _ := New(common.Hash{}, sdb)
+	state.AddBalance(addr1, big.NewInt(1))
+	state.SetState(addr1, key, common.HexToHash("0x11"))
+	state.AddBalance(addr2, big.NewInt(2))
+	state.AddBalance(addr3, big.NewInt(3))
+	state.SetState(addr3, key, common.HexToHash("0x33"))
+	root, _ := state.Commit(false)
+	if err := sdb.TrieDB().Commit(root, false); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	snaps := snapshot.New(db, sdb.TrieDB(), 16, root, false)
+
+	// Modify one account, destruct another and recreate the third
+	state, _ = NewWithSnapshot(root, sdb, snaps)
+	if have := state.GetState(addr1, key); have != common.HexToHash("0x11") {
+		t.Fatalf("storage mismatch: have %x, want %x", have, common.HexToHash("0x11"))
+	}
+	state.SetState(addr1, key, common.HexToHash("0x12"))
+	state.Suicide(addr2)
+	state.CreateAccount(addr3)
+	state.AddBalance(addr3, big.NewInt(4))
+
+	root, _ = state.Commit(false)
+	if snaps.Snapshot(root) == nil {
+		t.Fatalf("snapshot not updated for state root %x", root)
+	}
+	if err := snaps.Verify(root); err != nil {
+		t.Fatalf("snapshot out of sync with state: %v", err)
+	}
+	// Reads through the snapshot must match the ones through the trie
+	plain, _ := New(root, sdb)
+	snapped, _ := NewWithSnapshot(root, sdb, snaps)
+	if snapped.Exist(addr2) || snapped.GetState(addr3, key) != (common.Hash{}) {
+		t.Fatalf("destructed state still served by the snapshot")
+	}
+	for _, addr := range []common.Address{addr1, addr2, addr3} {
+		if have, want := snapped.GetBalance(addr), plain.GetBalance(addr); have.Cmp(want) != 0 {
+			t.Errorf("balance mismatch for %x: have %v, want %v", addr, have, want)
+		}
+		if have, want := snapped.Exist(addr), plain.Exist(addr); have != want {
+			t.Errorf("existence mismatch for %x: have %v, want %v", addr, have, want)
+		}
+		if have, want := snapped.GetState(addr, key), plain.GetState(addr, key); have != want {
+			t.Errorf("storage mismatch for %x: have %x, want %x", addr, have, want)
+		}
+	}
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/tomochain/tomochain/core/vm"
 	"github.com/tomochain/tomochain/crypto"
 	"github.com/tomochain/tomochain/params"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
 )
 
 // StateProcessor is a basic Processor, which takes care of transitioning
@@ -413,6 +414,24 @@ func ApplyTransaction(config *params.ChainConfig, tokensFee map[common.Address]*
 	if err != nil {
 		return nil, 0, err, false
 	}
+	if !failed && tx.IsSigningKeyTransaction() && config.IsTIPSigningKey(header.Number) {
+		if err := ApplySigningKey(config, statedb, header, msg.From(), tx.Data()); err != nil {
+			log.Debug("Rejected signing key registration", "tx", tx.Hash(), "err", err)
+			failed = true
+		}
+	}
+	if !failed && tx.IsPriceOracleTransaction() && config.IsTIPTomoXPriceOracle(header.Number) {
+		if err := ApplyPriceOracle(statedb, header, msg.From(), tx.Data()); err != nil {
+			log.Debug("Rejected price oracle transaction", "tx", tx.Hash(), "err", err)
+			failed = true
+		}
+	}
+	if !failed && tx.IsRepayPolicyTransaction() && config.IsTIPTomoXRollover(header.Number) {
+		if err := ApplyRepayPolicy(statedb, msg.From(), tx.Data()); err != nil {
+			log.Debug("Rejected repay policy transaction", "tx", tx.Hash(), "err", err)
+			failed = true
+		}
+	}
 	// Update the state with pending changes
 	var root []byte
 	if config.IsByzantium(header.Number) {
@@ -475,6 +494,112 @@ func ApplySignTransaction(config *params.ChainConfig, statedb *state.StateDB, he
 	return receipt, 0, nil, false
 }
 
+// ApplySigningKey registers the signing key of a masternode candidate carried
+// by a signing key transaction. The key seals blocks for the candidate from the
+// next checkpoint on, its stake and voters are left untouched.
+func ApplySigningKey(config *params.ChainConfig, statedb *state.StateDB, header *types.Header, from common.Address, data []byte) error {
+	if config.Posv == nil {
+		return ErrNotPoSV
+	}
+	candidate := common.BytesToAddress(data[4:36])
+	key := common.BytesToAddress(data[36:68])
+	if owner := state.GetCandidateOwner(statedb, candidate); owner == (common.Address{}) || owner != from {
+		return ErrSigningKeyOwner
+	}
+	if key == (common.Address{}) {
+		return ErrSigningKeyTaken
+	}
+	if key != candidate && state.GetCandidateOwner(statedb, key) != (common.Address{}) {
+		return ErrSigningKeyTaken
+	}
+	if bound := state.GetSigningKeyCandidate(statedb, key); bound != (common.Address{}) && bound != candidate {
+		return ErrSigningKeyTaken
+	}
+	number := header.Number.Uint64()
+	activation := number - number%config.Posv.Epoch + config.Posv.Epoch
+	state.SetSigningKey(statedb, candidate, key, number, activation)
+	return nil
+}
+
+// ApplyPriceOracle applies a price oracle transaction: feeders submit prices
+// of collateral tokens in lending tokens, the lending moderator whitelists the
+// feeders, sets the bounds their prices are aggregated with and the bonuses
+// paid to the liquidators of the collaterals.
+func ApplyPriceOracle(statedb *state.StateDB, header *types.Header, from common.Address, data []byte) error {
+	switch common.ToHex(data[:4]) {
+	case "0x" + common.HexSubmitPrice:
+		if !lendingstate.IsOracleFeeder(statedb, from) {
+			return ErrOracleFeeder
+		}
+		token := common.BytesToAddress(data[4:36])
+		lendingToken := common.BytesToAddress(data[36:68])
+		price := new(big.Int).SetBytes(data[68:100])
+		if price.Sign() == 0 || token == lendingToken {
+			return ErrOraclePrice
+		}
+		lendingstate.SetFeederPrice(statedb, from, token, lendingToken, price, header.Number.Uint64())
+	case "0x" + common.HexSetOracleFeeder:
+		if from != lendingstate.GetLendingModerator(statedb) {
+			return ErrOracleModerator
+		}
+		feeder := common.BytesToAddress(data[4:36])
+		enabled := new(big.Int).SetBytes(data[36:68]).Sign() != 0
+		lendingstate.SetOracleFeeder(statedb, feeder, enabled)
+	case "0x" + common.HexSetOracleConfig:
+		if from != lendingstate.GetLendingModerator(statedb) {
+			return ErrOracleModerator
+		}
+		var bounds [3]uint64
+		for i := range bounds {
+			value := new(big.Int).SetBytes(data[4+i*32 : 36+i*32])
+			if !value.IsUint64() {
+				return ErrOraclePrice
+			}
+			bounds[i] = value.Uint64()
+		}
+		if bounds[2] > 100 {
+			return ErrOraclePrice
+		}
+		lendingstate.SetOracleConfig(statedb, lendingstate.OracleConfig{
+			MinFeeders:   bounds[0],
+			MaxAge:       bounds[1],
+			MaxDeviation: bounds[2],
+		})
+	case "0x" + common.HexSetLiquidationBonus:
+		if from != lendingstate.GetLendingModerator(statedb) {
+			return ErrOracleModerator
+		}
+		token := common.BytesToAddress(data[4:36])
+		bonus := new(big.Int).SetBytes(data[36:68])
+		if bonus.Cmp(big.NewInt(100)) > 0 {
+			return ErrLiquidationBonus
+		}
+		lendingstate.SetLiquidationBonus(statedb, token, bonus)
+	}
+	return nil
+}
+
+// ApplyRepayPolicy applies a repay policy transaction: the owner of a lending
+// relayer sets the interest policy of early repayments of one of its pairs.
+func ApplyRepayPolicy(statedb *state.StateDB, from common.Address, data []byte) error {
+	relayer := common.BytesToAddress(data[4:36])
+	lendingToken := common.BytesToAddress(data[36:68])
+	term := new(big.Int).SetBytes(data[68:100])
+	policy := new(big.Int).SetBytes(data[100:132])
+
+	if owner := lendingstate.GetRelayerOwner(relayer, statedb); owner == (common.Address{}) || owner != from {
+		return ErrRepayPolicyOwner
+	}
+	if !term.IsUint64() || !policy.IsUint64() || policy.Uint64() > lendingstate.RepayInterestFullTerm {
+		return ErrRepayPolicy
+	}
+	if validPair, _ := lendingstate.IsValidPair(statedb, relayer, lendingToken, term.Uint64()); !validPair {
+		return ErrRepayPolicy
+	}
+	lendingstate.SetRepayPolicy(statedb, relayer, lendingToken, term.Uint64(), policy.Uint64())
+	return nil
+}
+
 func ApplyEmptyTransaction(config *params.ChainConfig, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64) (*types.Receipt, uint64, error, bool) {
 	// Update the state with pending changes
 	var root []byte
@@ -0,0 +1,535 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/ethdb"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
+	"github.com/tomochain/tomochain/params"
+	"github.com/tomochain/tomochain/rlp"
+	"github.com/tomochain/tomochain/trie"
+)
+
+const (
+	// statePruneInterval is the number of blocks between two online pruning runs
+	// of the same database, roughly a day of PoSV blocks.
+	statePruneInterval = 43200
+
+	// statePruneRecheck is the frequency at which the pruner checks whether a
+	// new pruning run is due.
+	statePruneRecheck = time.Minute
+
+	// statePruneBatch is the number of database keys swept before taking the
+	// chain lock to delete the stale trie nodes found among them.
+	statePruneBatch = 100000
+
+	// statePruneMarkCheck is the number of marked nodes after which the mark
+	// phase checks for a shutdown request.
+	statePruneMarkCheck = 10000
+)
+
+var (
+	errPruneInterrupted = errors.New("state pruning interrupted")
+
+	statePruneNodesMeter = metrics.NewRegisteredMeter("chain/prune/nodes", nil)
+)
+
+// MinStatePruneRetention returns the smallest number of recent block states the
+// online pruner accepts to retain. It has to cover the tries still held in
+// memory as well as the last ones flushed to disk, otherwise a restart could
+// find no state to resume block processing from. On PoSV chains it also covers
+// the two previous epochs, whose checkpoint states are read back to compute
+// rewards, penalties and the masternodes of the next epoch.
+func MinStatePruneRetention(config *params.ChainConfig) uint64 {
+	retain := uint64(4 * triesInMemory)
+	if config.Posv != nil {
+		if epochs := 2*config.Posv.Epoch + triesInMemory; epochs > retain {
+			retain = epochs
+		}
+	}
+	return retain
+}
+
+// pruneRoot is a trie root to retain, along with the trie database resolving
+// its nodes from memory and disk.
+type pruneRoot struct {
+	triedb *trie.Database
+	root   common.Hash
+}
+
+// pruneTarget is a persistent database swept by the online pruner, along with
+// the trie roots that need retaining in it for a given block.
+type pruneTarget struct {
+	name   string
+	diskdb ethdb.KeyValueStore
+	roots  func(header *types.Header) ([]pruneRoot, error)
+}
+
+// statePruner deletes trie nodes from the persistent databases of a full node
+// that are no longer reachable from the state of the most recent blocks. It runs
+// in the background while the node keeps importing blocks:
+//
+//   - the mark phase collects every node reachable from the retained roots,
+//   - the sweep phase iterates the database and deletes the unmarked nodes. For
+//     every batch it takes the chain lock and marks any state written since,
+//     so nodes shared with new blocks are never dropped.
+//
+// The sweep position is persisted with every batch so an interrupted run resumes
+// where it left off. Archive nodes never run the pruner.
+type statePruner struct {
+	bc     *BlockChain
+	retain uint64 // Number of recent block states to retain
+}
+
+// newStatePruner creates an online state pruner for the given chain.
+func newStatePruner(bc *BlockChain, retain uint64) *statePruner {
+	if min := MinStatePruneRetention(bc.chainConfig); retain < min {
+		log.Warn("Sanitizing state pruning retention", "provided", retain, "updated", min)
+		retain = min
+	}
+	return &statePruner{
+		bc:     bc,
+		retain: retain,
+	}
+}
+
+// loop periodically checks whether a pruning run is due and executes it, until
+// the blockchain is stopped.
+func (p *statePruner) loop() {
+	defer p.bc.wg.Done()
+
+	timer := time.NewTimer(statePruneRecheck)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-p.bc.quit:
+			return
+		}
+		if err := p.prune(); err != nil {
+			if err == errPruneInterrupted {
+				return
+			}
+			log.Error("Failed to prune state", "err", err)
+		}
+		timer.Reset(statePruneRecheck)
+	}
+}
+
+// prune runs the pruning of every database that is due for one, or that has an
+// interrupted run to finish.
+func (p *statePruner) prune() error {
+	head := p.bc.CurrentBlock()
+	if fast := p.bc.CurrentFastBlock(); fast != nil && fast.NumberU64() > head.NumberU64() {
+		return nil // fast sync in progress, state is still being downloaded
+	}
+	number := head.NumberU64()
+	if number < p.retain {
+		return nil
+	}
+	for _, target := range p.targets() {
+		marker := GetStatePruneMarker(target.diskdb)
+		if marker == nil && number < GetStatePruneNumber(target.diskdb)+statePruneInterval {
+			continue
+		}
+		if err := p.pruneTarget(target, number, marker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targets assembles the databases to prune: the account state stored in the
+// chain database and, if TomoX is running, the trading and lending state sharing
+// the TomoX database.
+func (p *statePruner) targets() []*pruneTarget {
+	targets := []*pruneTarget{{
+		name:   "state",
+		diskdb: p.bc.db,
+		roots: func(header *types.Header) ([]pruneRoot, error) {
+			return []pruneRoot{{p.bc.stateCache.TrieDB(), header.Root}}, nil
+		},
+	}}
+	engine, ok := p.bc.Engine().(*posv.Posv)
+	if !ok {
+		return targets
+	}
+	tradingService, lendingService := engine.GetTomoXService(), engine.GetLendingService()
+	if tradingService == nil || tradingService.GetStateCache() == nil {
+		return targets
+	}
+	tradingTrieDb := tradingService.GetStateCache().TrieDB()
+	diskdb, ok := tradingTrieDb.DiskDB().(ethdb.KeyValueStore)
+	if !ok {
+		return targets
+	}
+	// Lending tries are only retained if they live in the swept database, any
+	// other database is never touched
+	var lendingTrieDb *trie.Database
+	if lendingService != nil && lendingService.GetStateCache() != nil {
+		if db := lendingService.GetStateCache().TrieDB(); db.DiskDB() == tradingTrieDb.DiskDB() {
+			lendingTrieDb = db
+		}
+	}
+	return append(targets, &pruneTarget{
+		name:   "tomox",
+		diskdb: diskdb,
+		roots: func(header *types.Header) ([]pruneRoot, error) {
+			if !p.bc.chainConfig.IsTIPTomoX(header.Number) || header.Number.Uint64() <= p.bc.chainConfig.Posv.Epoch {
+				return nil, nil
+			}
+			block := p.bc.GetBlock(header.Hash(), header.Number.Uint64())
+			if block == nil {
+				return nil, nil // header only, no state was ever written for it
+			}
+			author, err := p.bc.Engine().Author(header)
+			if err != nil {
+				return nil, err
+			}
+			var roots []pruneRoot
+			tradingRoot, err := tradingService.GetTradingStateRoot(block, author)
+			if err != nil {
+				return nil, err
+			}
+			roots = append(roots, pruneRoot{tradingTrieDb, tradingRoot})
+			if lendingTrieDb != nil {
+				lendingRoot, err := lendingService.GetLendingStateRoot(block, author)
+				if err != nil {
+					return nil, err
+				}
+				roots = append(roots, pruneRoot{lendingTrieDb, lendingRoot})
+			}
+			return roots, nil
+		},
+	})
+}
+
+// pruneTarget marks the state retained at the given head and sweeps all other
+// trie nodes out of the target database, starting after marker if set.
+func (p *statePruner) pruneTarget(target *pruneTarget, number uint64, marker []byte) error {
+	start := time.Now()
+	set, err := newPruneSet(target.diskdb, p.bc.quit)
+	if err != nil {
+		return err
+	}
+	if marker != nil {
+		log.Info("Resuming state pruning", "database", target.name, "number", number, "marker", common.ToHex(marker))
+	} else {
+		log.Info("Starting state pruning", "database", target.name, "number", number, "retain", p.retain)
+	}
+	// Always keep the genesis state so the chain can be rewound to it
+	if err := p.markHeader(target, set, p.bc.genesisBlock.Header()); err != nil {
+		return err
+	}
+	if err := p.markRange(target, set, number-p.retain+1, number); err != nil {
+		return err
+	}
+	if err := set.flush(); err != nil {
+		return err
+	}
+	log.Info("Marked retained state", "database", target.name, "nodes", set.marked, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	var (
+		scanned = number // Highest block whose state has been marked
+		swept   uint64   // Number of database keys iterated over
+		deleted uint64   // Number of stale trie nodes deleted
+		logged  = time.Now()
+	)
+	for {
+		// Collect a batch of stale node candidates without holding the chain lock
+		batchStart := time.Now()
+
+		var (
+			stale [][]byte
+			last  []byte
+			keys  int
+		)
+		it := target.diskdb.NewIterator(nil, marker)
+		for keys < statePruneBatch && it.Next() {
+			key := it.Key()
+			if marker != nil && bytes.Equal(key, marker) {
+				continue
+			}
+			keys++
+			last = common.CopyBytes(key)
+
+			// Trie nodes and contract code are the only entries keyed by a bare hash
+			if len(key) != common.HashLength {
+				continue
+			}
+			if !set.contains(common.BytesToHash(key)) {
+				stale = append(stale, last)
+			}
+		}
+		err := it.Error()
+		it.Release()
+		if err != nil {
+			return err
+		}
+		done := keys < statePruneBatch
+		swept += uint64(keys)
+
+		// Take the chain lock, mark any state written in the meantime and drop the
+		// nodes that are still unreachable
+		p.bc.mu.Lock()
+		current := p.bc.CurrentBlock().NumberU64()
+		from := scanned
+		if current < from {
+			from = current
+		}
+		if from > triesInMemory {
+			from -= triesInMemory
+		} else {
+			from = 0
+		}
+		if err := p.markRange(target, set, from, current); err != nil {
+			p.bc.mu.Unlock()
+			return err
+		}
+		if err := set.flush(); err != nil {
+			p.bc.mu.Unlock()
+			return err
+		}
+		scanned = current
+
+		batch := target.diskdb.NewBatch()
+		var count int
+		for _, key := range stale {
+			if !set.contains(common.BytesToHash(key)) {
+				batch.Delete(key)
+				count++
+			}
+		}
+		if !done {
+			WriteStatePruneMarker(batch, last)
+		}
+		err = batch.Write()
+		p.bc.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+		deleted += uint64(count)
+		statePruneNodesMeter.Mark(int64(count))
+
+		if done {
+			break
+		}
+		marker = last
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning state", "database", target.name, "swept", swept, "deleted", deleted, "marker", common.ToHex(marker), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		// Throttle the sweep, leaving the database to block processing at least
+		// half of the time
+		select {
+		case <-time.After(time.Since(batchStart)):
+		case <-p.bc.quit:
+			return errPruneInterrupted
+		}
+	}
+	batch := target.diskdb.NewBatch()
+	DeleteStatePruneMarker(batch)
+	WriteStatePruneNumber(batch, number)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if err := DeleteStatePruneMarks(target.diskdb); err != nil {
+		return err
+	}
+	log.Info("State pruning completed", "database", target.name, "number", number, "swept", swept, "deleted", deleted, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// markRange marks the state of every block, canonical or side chain, between
+// the given heights (inclusive).
+func (p *statePruner) markRange(target *pruneTarget, set *pruneSet, from, to uint64) error {
+	for number := from; number <= to; number++ {
+		for _, hash := range GetAllHashes(p.bc.db, number) {
+			header := p.bc.GetHeader(hash, number)
+			if header == nil {
+				continue
+			}
+			if err := p.markHeader(target, set, header); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// markHeader marks the state roots of a single block in the target database.
+func (p *statePruner) markHeader(target *pruneTarget, set *pruneSet, header *types.Header) error {
+	roots, err := target.roots(header)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if err := set.markTrie(root.triedb, root.root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneSet is the set of trie nodes reachable from the retained roots. The
+// marks are stored in the swept database itself, so the size of the retained
+// state isn't bounded by memory. They are keyed by a prefixed hash the sweep
+// never deletes, and removed once the pruning run completes.
+type pruneSet struct {
+	db      ethdb.KeyValueStore
+	batch   ethdb.Batch
+	pending map[common.Hash]struct{} // Marks buffered in the batch
+	marked  int                      // Number of nodes marked during the run
+	quit    chan struct{}
+}
+
+// newPruneSet creates an empty set of retained trie nodes in the database. Any
+// marks left by an interrupted run are dropped first, as they may cover the
+// root of a subtrie whose nodes weren't all marked yet.
+func newPruneSet(db ethdb.KeyValueStore, quit chan struct{}) (*pruneSet, error) {
+	if err := DeleteStatePruneMarks(db); err != nil {
+		return nil, err
+	}
+	return &pruneSet{
+		db:      db,
+		batch:   db.NewBatch(),
+		pending: make(map[common.Hash]struct{}),
+		quit:    quit,
+	}, nil
+}
+
+// contains reports whether the node with the given hash is retained.
+func (s *pruneSet) contains(hash common.Hash) bool {
+	if _, ok := s.pending[hash]; ok {
+		return true
+	}
+	return HasStatePruneMark(s.db, hash)
+}
+
+// mark adds the node with the given hash to the set, writing out the buffered
+// marks once they reach the ideal batch size.
+func (s *pruneSet) mark(hash common.Hash) error {
+	WriteStatePruneMark(s.batch, hash)
+	s.pending[hash] = struct{}{}
+	s.marked++
+	if s.batch.ValueSize() >= ethdb.IdealBatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered marks to the database.
+func (s *pruneSet) flush() error {
+	if err := s.batch.Write(); err != nil {
+		return err
+	}
+	s.batch.Reset()
+	s.pending = make(map[common.Hash]struct{})
+	return nil
+}
+
+// markTrie marks all nodes of the trie with the given root, skipping subtries
+// already marked. Leaf values are scanned for embedded roots, which covers the
+// storage tries and code of accounts as well as the nested TomoX tries without
+// needing to know their layout. Missing roots (never flushed from memory) are
+// silently ignored.
+func (s *pruneSet) markTrie(triedb *trie.Database, root common.Hash) error {
+	if root == (common.Hash{}) || s.contains(root) {
+		return nil
+	}
+	blob, err := triedb.Node(root)
+	if err != nil {
+		return nil
+	}
+	if err := s.mark(root); err != nil {
+		return err
+	}
+
+	// Contract code shares the key space with trie nodes, retain it as is
+	if _, err := trie.DecodeNode(root[:], blob); err != nil {
+		return nil
+	}
+	t, err := trie.New(root, triedb)
+	if err != nil {
+		return nil
+	}
+	it := t.NodeIterator(nil)
+	for descend := true; it.Next(descend); {
+		descend = true
+		if hash := it.Hash(); hash != (common.Hash{}) {
+			if hash == root {
+				continue
+			}
+			if s.contains(hash) {
+				descend = false
+				continue
+			}
+			if err := s.mark(hash); err != nil {
+				return err
+			}
+			if s.marked%statePruneMarkCheck == 0 {
+				select {
+				case <-s.quit:
+					return errPruneInterrupted
+				default:
+				}
+			}
+			continue
+		}
+		if it.Leaf() {
+			if err := s.markBlob(triedb, it.LeafBlob()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := it.Error(); err != nil {
+		log.Debug("Incomplete trie marked for pruning", "root", root, "err", err)
+	}
+	return nil
+}
+
+// markBlob scans an RLP encoded leaf value for hashes and marks the tries or
+// blobs they reference.
+func (s *pruneSet) markBlob(triedb *trie.Database, blob []byte) error {
+	for len(blob) > 0 {
+		kind, content, rest, err := rlp.Split(blob)
+		if err != nil {
+			return nil
+		}
+		switch {
+		case kind == rlp.List:
+			if err := s.markBlob(triedb, content); err != nil {
+				return err
+			}
+		case len(content) == common.HashLength:
+			if err := s.markTrie(triedb, common.BytesToHash(content)); err != nil {
+				return err
+			}
+		}
+		blob = rest
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/ethash"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/core/vm"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/params"
+)
+
+// Tests that the online state pruner deletes the state of old blocks while
+// keeping the retained window, the genesis state and any storage and code still
+// referenced from them.
+func TestStatePruning(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0xc0de")
+		slot     = common.HexToHash("0x01")
+		gspec    = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				address:  {Balance: big.NewInt(1000000000000000)},
+				contract: {Balance: big.NewInt(0), Code: []byte{0x60, 0x00}, Storage: map[common.Hash]common.Hash{slot: common.HexToHash("0x2a")}},
+			},
+		}
+		signer = types.NewEIP155Signer(gspec.Config.ChainId)
+		db     = rawdb.NewMemoryDatabase()
+		retain = MinStatePruneRetention(gspec.Config)
+	)
+	genesis := gspec.MustCommit(db)
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, int(retain)+100, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.BigToAddress(big.NewInt(int64(i+1))), big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	// Import the chain as an archive node, so every state is on disk to start with
+	chain, err := NewBlockChain(db, &CacheConfig{Disabled: true}, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	pruner := newStatePruner(chain, 0)
+	if pruner.retain != retain {
+		t.Fatalf("retention not sanitized: have %d, want %d", pruner.retain, retain)
+	}
+	head := chain.CurrentBlock().NumberU64()
+	if err := pruner.pruneTarget(pruner.targets()[0], head, nil); err != nil {
+		t.Fatalf("failed to prune state: %v", err)
+	}
+	if marker := GetStatePruneMarker(db); marker != nil {
+		t.Errorf("pruning marker left behind: %x", marker)
+	}
+	if number := GetStatePruneNumber(db); number != head {
+		t.Errorf("pruning number mismatch: have %d, want %d", number, head)
+	}
+	it := db.NewIterator(statePruneMarkPrefix, nil)
+	for it.Next() {
+		if len(it.Key()) == len(statePruneMarkPrefix)+common.HashLength {
+			t.Errorf("pruning mark left behind: %x", it.Key())
+			break
+		}
+	}
+	it.Release()
+	// Read through a fresh state database to avoid hitting any cached nodes
+	statedb := state.NewDatabase(db)
+	for _, number := range []uint64{0, head - retain + 1, head} {
+		root := chain.GetBlockByNumber(number).Root()
+		it := state.NewNodeIterator(mustState(t, statedb, root))
+		for it.Next() {
+		}
+		if it.Error != nil {
+			t.Errorf("block %d: retained state incomplete: %v", number, it.Error)
+		}
+	}
+	headState := mustState(t, statedb, chain.CurrentBlock().Root())
+	if value := headState.GetState(contract, slot); value != common.HexToHash("0x2a") {
+		t.Errorf("contract storage mismatch: have %x, want %x", value, common.HexToHash("0x2a"))
+	}
+	if code := headState.GetCode(contract); len(code) != 2 {
+		t.Errorf("contract code mismatch: have %x", code)
+	}
+	for _, number := range []uint64{1, head - retain} {
+		if _, err := state.New(chain.GetBlockByNumber(number).Root(), statedb); err == nil {
+			t.Errorf("block %d: stale state not pruned", number)
+		}
+	}
+}
+
+func TestMinStatePruneRetention(t *testing.T) {
+	if have, want := MinStatePruneRetention(params.TestChainConfig), uint64(4*triesInMemory); have != want {
+		t.Errorf("non-PoSV retention mismatch: have %d, want %d", have, want)
+	}
+	config := &params.ChainConfig{Posv: &params.PosvConfig{Epoch: 900}}
+	if have, want := MinStatePruneRetention(config), uint64(2*900+triesInMemory); have != want {
+		t.Errorf("PoSV retention mismatch: have %d, want %d", have, want)
+	}
+}
+
+func mustState(t *testing.T, db state.Database, root common.Hash) *state.StateDB {
+	statedb, err := state.New(root, db)
+	if err != nil {
+		t.Fatalf("state %x missing: %v", root, err)
+	}
+	return statedb
+}
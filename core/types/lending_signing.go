@@ -165,6 +165,21 @@ func (lendingsign LendingTxSigner) LendingRepayHash(tx *LendingTransaction) comm
 	return common.BytesToHash(sha.Sum(nil))
 }
 
+// LendingPartialRepayHash hash of partial repay lending transaction, it commits to the repaid quantity
+func (lendingsign LendingTxSigner) LendingPartialRepayHash(tx *LendingTransaction) common.Hash {
+	sha := sha3.NewKeccak256()
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.Nonce()))).Bytes())
+	sha.Write([]byte(tx.Status()))
+	sha.Write(tx.RelayerAddress().Bytes())
+	sha.Write(tx.UserAddress().Bytes())
+	sha.Write(tx.LendingToken().Bytes())
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.Term()))).Bytes())
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.LendingTradeId()))).Bytes())
+	sha.Write(common.BigToHash(tx.Quantity()).Bytes())
+	sha.Write([]byte(tx.Type()))
+	return common.BytesToHash(sha.Sum(nil))
+}
+
 // LendingTopUpHash hash of cancelled lending transaction
 func (lendingsign LendingTxSigner) LendingTopUpHash(tx *LendingTransaction) common.Hash {
 	sha := sha3.NewKeccak256()
@@ -180,6 +195,56 @@ func (lendingsign LendingTxSigner) LendingTopUpHash(tx *LendingTransaction) comm
 	return common.BytesToHash(sha.Sum(nil))
 }
 
+// LendingBasketTopUpHash hash of basket top-up lending transaction, it commits to the pledged collateral token
+func (lendingsign LendingTxSigner) LendingBasketTopUpHash(tx *LendingTransaction) common.Hash {
+	sha := sha3.NewKeccak256()
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.Nonce()))).Bytes())
+	sha.Write([]byte(tx.Status()))
+	sha.Write(tx.RelayerAddress().Bytes())
+	sha.Write(tx.UserAddress().Bytes())
+	sha.Write(tx.LendingToken().Bytes())
+	sha.Write(tx.CollateralToken().Bytes())
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.Term()))).Bytes())
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.LendingTradeId()))).Bytes())
+	sha.Write(common.BigToHash(tx.Quantity()).Bytes())
+	sha.Write([]byte(tx.Type()))
+	return common.BytesToHash(sha.Sum(nil))
+}
+
+// LendingTransferHash hash of transfer lending transaction, it commits to the
+// price in Quantity and to the buyer acceptance carried in ExtraData
+func (lendingsign LendingTxSigner) LendingTransferHash(tx *LendingTransaction) common.Hash {
+	sha := sha3.NewKeccak256()
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.Nonce()))).Bytes())
+	sha.Write([]byte(tx.Status()))
+	sha.Write(tx.RelayerAddress().Bytes())
+	sha.Write(tx.UserAddress().Bytes())
+	sha.Write(tx.LendingToken().Bytes())
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.Term()))).Bytes())
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.LendingTradeId()))).Bytes())
+	sha.Write(common.BigToHash(tx.Quantity()).Bytes())
+	sha.Write([]byte(tx.Type()))
+	sha.Write(crypto.Keccak256([]byte(tx.ExtraData())))
+	return common.BytesToHash(sha.Sum(nil))
+}
+
+// LendingPolicyHash hash of borrower policy lending transaction, it commits to
+// the collateral token of an account policy and to the policy carried in ExtraData
+func (lendingsign LendingTxSigner) LendingPolicyHash(tx *LendingTransaction) common.Hash {
+	sha := sha3.NewKeccak256()
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.Nonce()))).Bytes())
+	sha.Write([]byte(tx.Status()))
+	sha.Write(tx.RelayerAddress().Bytes())
+	sha.Write(tx.UserAddress().Bytes())
+	sha.Write(tx.LendingToken().Bytes())
+	sha.Write(tx.CollateralToken().Bytes())
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.Term()))).Bytes())
+	sha.Write(common.BigToHash(big.NewInt(int64(tx.LendingTradeId()))).Bytes())
+	sha.Write([]byte(tx.Type()))
+	sha.Write(crypto.Keccak256([]byte(tx.ExtraData())))
+	return common.BytesToHash(sha.Sum(nil))
+}
+
 // Hash returns the hash to be signed by the sender.
 // It does not uniquely identify the transaction.
 func (lendingsign LendingTxSigner) Hash(tx *LendingTransaction) common.Hash {
@@ -192,9 +257,21 @@ func (lendingsign LendingTxSigner) Hash(tx *LendingTransaction) common.Hash {
 	if tx.IsTopupLending() {
 		return lendingsign.LendingTopUpHash(tx)
 	}
-	if tx.IsRepayLending() {
+	if tx.IsBasketTopupLending() {
+		return lendingsign.LendingBasketTopUpHash(tx)
+	}
+	if tx.IsTransferLending() {
+		return lendingsign.LendingTransferHash(tx)
+	}
+	if tx.IsPolicyLending() {
+		return lendingsign.LendingPolicyHash(tx)
+	}
+	if tx.IsRepayLending() || tx.IsRolloverLending() {
 		return lendingsign.LendingRepayHash(tx)
 	}
+	if tx.IsPartialRepayLending() {
+		return lendingsign.LendingPartialRepayHash(tx)
+	}
 	return common.Hash{}
 }
 
@@ -46,7 +46,12 @@ const (
 	LendingSideBorrow          = "BORROW"
 	LendingSideInvest          = "INVEST"
 	LendingRePay               = "REPAY"
+	LendingPartialRepay        = "PARTIAL_REPAY"
 	LendingTopup               = "TOPUP"
+	LendingRollover            = "ROLLOVER"
+	LendingBasketTopup         = "BASKET_TOPUP"
+	LendingTransfer            = "TRANSFER"
+	LendingPolicy              = "POLICY"
 )
 
 // LendingTransaction lending transaction
@@ -108,6 +113,14 @@ func (tx *LendingTransaction) IsRepayLending() bool {
 	return false
 }
 
+// IsPartialRepayLending check if tx is partial repay lending transaction
+func (tx *LendingTransaction) IsPartialRepayLending() bool {
+	if tx.Type() == LendingPartialRepay {
+		return true
+	}
+	return false
+}
+
 // IsTopupLending check if tx is repay lending transaction
 func (tx *LendingTransaction) IsTopupLending() bool {
 	if tx.Type() == LendingTopup {
@@ -116,6 +129,38 @@ func (tx *LendingTransaction) IsTopupLending() bool {
 	return false
 }
 
+// IsRolloverLending check if tx is rollover lending transaction
+func (tx *LendingTransaction) IsRolloverLending() bool {
+	if tx.Type() == LendingRollover {
+		return true
+	}
+	return false
+}
+
+// IsBasketTopupLending check if tx is a top-up of a collateral basket
+func (tx *LendingTransaction) IsBasketTopupLending() bool {
+	if tx.Type() == LendingBasketTopup {
+		return true
+	}
+	return false
+}
+
+// IsTransferLending check if tx is a transfer of a lending trade claim
+func (tx *LendingTransaction) IsTransferLending() bool {
+	if tx.Type() == LendingTransfer {
+		return true
+	}
+	return false
+}
+
+// IsPolicyLending check if tx registers a borrower policy
+func (tx *LendingTransaction) IsPolicyLending() bool {
+	if tx.Type() == LendingPolicy {
+		return true
+	}
+	return false
+}
+
 // IsMoTypeLending check if tx type is MO lending
 func (tx *LendingTransaction) IsMoTypeLending() bool {
 	if tx.Type() == LendingTypeMo {
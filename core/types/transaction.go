@@ -341,6 +341,46 @@ func (tx *Transaction) IsLendingFinalizedTradeTransaction() bool {
 	return true
 }
 
+// IsSigningKeyTransaction reports whether the transaction registers a new
+// signing key for a masternode candidate.
+func (tx *Transaction) IsSigningKeyTransaction() bool {
+	if tx.To() == nil || tx.To().String() != common.SigningKeyRegistry {
+		return false
+	}
+	data := tx.Data()
+	return len(data) == 4+2*32 && common.ToHex(data[:4]) == "0x"+common.HexSetSigningKey
+}
+
+// IsPriceOracleTransaction reports whether the transaction submits a price to
+// the lending price oracle or manages its feeders, bounds and the collateral
+// liquidation bonuses.
+func (tx *Transaction) IsPriceOracleTransaction() bool {
+	if tx.To() == nil || tx.To().String() != common.LendingPriceOracle {
+		return false
+	}
+	data := tx.Data()
+	if len(data) < 4 {
+		return false
+	}
+	switch common.ToHex(data[:4]) {
+	case "0x" + common.HexSubmitPrice, "0x" + common.HexSetOracleConfig:
+		return len(data) == 4+3*32
+	case "0x" + common.HexSetOracleFeeder, "0x" + common.HexSetLiquidationBonus:
+		return len(data) == 4+2*32
+	}
+	return false
+}
+
+// IsRepayPolicyTransaction reports whether the transaction sets the interest
+// policy of early repayments of a lending pair of a relayer.
+func (tx *Transaction) IsRepayPolicyTransaction() bool {
+	if tx.To() == nil || tx.To().String() != common.LendingRepayPolicy {
+		return false
+	}
+	data := tx.Data()
+	return len(data) == 4+4*32 && common.ToHex(data[:4]) == "0x"+common.HexSetRepayPolicy
+}
+
 func (tx *Transaction) IsSkipNonceTransaction() bool {
 	if tx.To() == nil {
 		return false
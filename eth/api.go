@@ -19,6 +19,7 @@ package eth
 import (
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -27,9 +28,12 @@ import (
 
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/common/hexutil"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/contracts"
 	"github.com/tomochain/tomochain/core"
 	"github.com/tomochain/tomochain/core/state"
 	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/eth/hooks"
 	"github.com/tomochain/tomochain/log"
 	"github.com/tomochain/tomochain/miner"
 	"github.com/tomochain/tomochain/params"
@@ -502,3 +506,39 @@ func (api *PublicEthereumAPI) GetOwnerByCoinbase(ctx context.Context, coinbase c
 	return statedb.GetOwner(coinbase), nil
 }
 
+// CheckpointRewards is the dry-run result of eth_getNextCheckpointRewards.
+type CheckpointRewards struct {
+	Checkpoint hexutil.Uint64                                 `json:"checkpoint"`
+	Signers    map[common.Address]*contracts.RewardLog        `json:"signers"`
+	Rewards    map[common.Address]map[common.Address]*big.Int `json:"rewards"`
+}
+
+// GetNextCheckpointRewards computes what the next checkpoint would pay to the
+// masternode owners, voters and foundation under the chain reward policy,
+// given the signer transactions and stakes at the current head.
+func (api *PublicEthereumAPI) GetNextCheckpointRewards() (*CheckpointRewards, error) {
+	engine, ok := api.e.engine.(*posv.Posv)
+	if api.e.chainConfig.Posv == nil || !ok {
+		return nil, core.ErrNotPoSV
+	}
+	config := api.e.chainConfig.Posv
+	if config.FoudationWalletAddr == (common.Address{}) {
+		return nil, errors.New("foundation wallet address is empty")
+	}
+	head := api.e.blockchain.CurrentHeader()
+	number := head.Number.Uint64() - head.Number.Uint64()%config.RewardCheckpoint + config.RewardCheckpoint
+	result := &CheckpointRewards{Checkpoint: hexutil.Uint64(number)}
+	if number <= config.RewardCheckpoint {
+		// The first checkpoint pays no reward
+		return result, nil
+	}
+	statedb, err := api.e.blockchain.State()
+	if err != nil {
+		return nil, err
+	}
+	result.Signers, result.Rewards, err = hooks.CalculateCheckpointRewards(engine, api.e.blockchain, head, number, statedb)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -325,7 +325,8 @@ func (b *EthApiBackend) GetVotersRewards(masternodeAddr common.Address) map[comm
 	// Get signers in blockSigner smartcontract.
 	// Get reward inflation.
 	chainReward := new(big.Int).Mul(new(big.Int).SetUint64(chain.Config().Posv.Reward), new(big.Int).SetUint64(params.Ether))
-	chainReward = rewardInflation(chainReward, lastCheckpointNumber, common.BlocksPerYear)
+	policy := chain.Config().Posv.Rewards()
+	chainReward = policy.Inflate(chainReward, lastCheckpointNumber)
 
 	totalSigner := new(uint64)
 	signers, err := contracts.GetRewardForCheckpoint(engine, chain, lastCheckpointBlock.Header(), rCheckpoint, totalSigner)
@@ -335,6 +336,7 @@ func (b *EthApiBackend) GetVotersRewards(masternodeAddr common.Address) map[comm
 		return nil
 	}
 
+	*totalSigner = contracts.ExcludeLowSigners(signers, *totalSigner, policy.MinSigns)
 	rewardSigners, err := contracts.CalculateRewardForSigner(chainReward, signers, *totalSigner)
 	if err != nil {
 		log.Crit("Fail to calculate reward for signers", "error", err)
@@ -349,7 +351,7 @@ func (b *EthApiBackend) GetVotersRewards(masternodeAddr common.Address) map[comm
 	var voterResults map[common.Address]*big.Int
 	for signer, calcReward := range rewardSigners {
 		if signer == masternodeAddr {
-			err, rewards := contracts.CalculateRewardForHolders(foundationWalletAddr, state, masternodeAddr, calcReward, number)
+			err, rewards := contracts.CalculateRewardForHolders(policy, foundationWalletAddr, state, masternodeAddr, calcReward, number)
 			if err != nil {
 				log.Crit("Fail to calculate reward for holders.", "error", err)
 				return nil
@@ -22,28 +22,21 @@ import (
 	"fmt"
 	"math/big"
 	"runtime"
-	"sort"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/tomochain/tomochain/tomoxlending"
 
-	"github.com/tomochain/tomochain/accounts/abi/bind"
 	"github.com/tomochain/tomochain/common/hexutil"
-	"github.com/tomochain/tomochain/core/state"
 	"github.com/tomochain/tomochain/eth/filters"
 	"github.com/tomochain/tomochain/rlp"
 
-	"bytes"
-
 	"github.com/tomochain/tomochain/accounts"
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/consensus"
 	"github.com/tomochain/tomochain/consensus/ethash"
 	"github.com/tomochain/tomochain/consensus/posv"
 	"github.com/tomochain/tomochain/contracts"
-	contractValidator "github.com/tomochain/tomochain/contracts/validator/contract"
 	"github.com/tomochain/tomochain/core"
 	"github.com/tomochain/tomochain/core/bloombits"
 
@@ -52,13 +45,17 @@ import (
 	"github.com/tomochain/tomochain/core/vm"
 	"github.com/tomochain/tomochain/eth/downloader"
 	"github.com/tomochain/tomochain/eth/gasprice"
+	"github.com/tomochain/tomochain/eth/hooks"
 	"github.com/tomochain/tomochain/ethdb"
 	"github.com/tomochain/tomochain/event"
 	"github.com/tomochain/tomochain/internal/ethapi"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
 	"github.com/tomochain/tomochain/miner"
 	"github.com/tomochain/tomochain/node"
 	"github.com/tomochain/tomochain/p2p"
+	"github.com/tomochain/tomochain/p2p/discover"
+	"github.com/tomochain/tomochain/p2p/enr"
 	"github.com/tomochain/tomochain/params"
 	"github.com/tomochain/tomochain/rpc"
 	"github.com/tomochain/tomochain/tomox"
@@ -125,7 +122,7 @@ func New(ctx *node.ServiceContext, config *Config, tomoXServ *tomox.TomoX, lendi
 	if !config.SyncMode.IsValid() {
 		return nil, fmt.Errorf("invalid sync mode %d", config.SyncMode)
 	}
-	chainDb, err := CreateDB(ctx, config, "chaindata")
+	chainDb, err := ctx.OpenDatabaseWithFreezer("chaindata", config.DatabaseCache, config.DatabaseHandles, config.DatabaseFreezer, "eth/db/chaindata/", config.AncientThreshold)
 	if err != nil {
 		return nil, err
 	}
@@ -133,6 +130,11 @@ func New(ctx *node.ServiceContext, config *Config, tomoXServ *tomox.TomoX, lendi
 	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
 		return nil, genesisErr
 	}
+	if chainConfig.Posv != nil {
+		if err := chainConfig.Posv.Rewards().Validate(); err != nil {
+			return nil, fmt.Errorf("invalid posv reward policy: %v", err)
+		}
+	}
 
 	log.Info("Initialised chain configuration", "config", chainConfig)
 
@@ -168,7 +170,7 @@ func New(ctx *node.ServiceContext, config *Config, tomoXServ *tomox.TomoX, lendi
 	}
 	var (
 		vmConfig    = vm.Config{EnablePreimageRecording: config.EnablePreimageRecording}
-		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout}
+		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout, PruneRetention: config.StatePruneRetain, SnapshotLimit: config.SnapshotCache}
 	)
 	if eth.chainConfig.Posv != nil {
 		c := eth.engine.(*posv.Posv)
@@ -266,14 +268,23 @@ func New(ctx *node.ServiceContext, config *Config, tomoXServ *tomox.TomoX, lendi
 			if err != nil {
 				return block, false, fmt.Errorf("can't get block validator: %v", err)
 			}
-			if m2 == eb {
+			candidate, err := c.SigningCandidate(eth.blockchain, block.Header(), eb)
+			if err != nil {
+				return block, false, fmt.Errorf("can't resolve etherbase candidate: %v", err)
+			}
+			if m2 == candidate {
 				wallet, err := eth.accountManager.Find(accounts.Account{Address: eb})
 				if err != nil {
 					log.Error("Can't find coinbase account wallet", "err", err)
 					return block, false, err
 				}
 				header := block.Header()
-				sighash, err := wallet.SignHash(accounts.Account{Address: eb}, posv.SigHash(header).Bytes())
+				var sighash []byte
+				if signer, ok := wallet.(accounts.HeaderSigner); ok {
+					sighash, err = signer.SignHeader(accounts.Account{Address: eb}, header)
+				} else {
+					sighash, err = wallet.SignHash(accounts.Account{Address: eb}, posv.SigHash(header).Bytes())
+				}
 				if err != nil || sighash == nil {
 					log.Error("Can't get signature hash of m2", "sighash", sighash, "err", err)
 					return block, false, err
@@ -287,289 +298,17 @@ func New(ctx *node.ServiceContext, config *Config, tomoXServ *tomox.TomoX, lendi
 		eth.protocolManager.fetcher.SetSignHook(signHook)
 		eth.protocolManager.fetcher.SetAppendM2HeaderHook(appendM2HeaderHook)
 
-		// Hook prepares validators M2 for the current epoch at checkpoint block
-		c.HookValidator = func(header *types.Header, signers []common.Address) ([]byte, error) {
-			start := time.Now()
-			validators, err := GetValidators(eth.blockchain, signers)
-			if err != nil {
-				return []byte{}, err
-			}
-			header.Validators = validators
-			log.Debug("Time Calculated HookValidator ", "block", header.Number.Uint64(), "time", common.PrettyDuration(time.Since(start)))
-			return validators, nil
-		}
-
-		// Hook scans for bad masternodes and decide to penalty them
-		c.HookPenalty = func(chain consensus.ChainReader, blockNumberEpoc uint64) ([]common.Address, error) {
-			canonicalState, err := eth.blockchain.State()
-			if canonicalState == nil || err != nil {
-				log.Crit("Can't get state at head of canonical chain", "head number", eth.blockchain.CurrentHeader().Number.Uint64(), "err", err)
-			}
-			prevEpoc := blockNumberEpoc - chain.Config().Posv.Epoch
-			if prevEpoc >= 0 {
-				start := time.Now()
-				prevHeader := chain.GetHeaderByNumber(prevEpoc)
-				penSigners := c.GetMasternodes(chain, prevHeader)
-				if len(penSigners) > 0 {
-					// Loop for each block to check missing sign.
-					for i := prevEpoc; i < blockNumberEpoc; i++ {
-						if i%common.MergeSignRange == 0 || !chainConfig.IsTIP2019(big.NewInt(int64(i))) {
-							bheader := chain.GetHeaderByNumber(i)
-							bhash := bheader.Hash()
-							block := chain.GetBlock(bhash, i)
-							if len(penSigners) > 0 {
-								signedMasternodes, err := contracts.GetSignersFromContract(canonicalState, block)
-								if err != nil {
-									return nil, err
-								}
-								if len(signedMasternodes) > 0 {
-									// Check signer signed?
-									for _, signed := range signedMasternodes {
-										for j, addr := range penSigners {
-											if signed == addr {
-												// Remove it from dupSigners.
-												penSigners = append(penSigners[:j], penSigners[j+1:]...)
-											}
-										}
-									}
-								}
-							} else {
-								break
-							}
-						}
-					}
-				}
-				log.Debug("Time Calculated HookPenalty ", "block", blockNumberEpoc, "time", common.PrettyDuration(time.Since(start)))
-				return penSigners, nil
-			}
-			return []common.Address{}, nil
-		}
-
-		// Hook scans for bad masternodes and decide to penalty them
-		c.HookPenaltyTIPSigning = func(chain consensus.ChainReader, header *types.Header, candidates []common.Address) ([]common.Address, error) {
-			prevEpoc := header.Number.Uint64() - chain.Config().Posv.Epoch
-			combackEpoch := uint64(0)
-			comebackLength := (common.LimitPenaltyEpoch + 1) * chain.Config().Posv.Epoch
-			if header.Number.Uint64() > comebackLength {
-				combackEpoch = header.Number.Uint64() - comebackLength
-			}
-			if prevEpoc >= 0 {
-				start := time.Now()
-
-				listBlockHash := make([]common.Hash, chain.Config().Posv.Epoch)
-
-				// get list block hash & stats total created block
-				statMiners := make(map[common.Address]int)
-				listBlockHash[0] = header.ParentHash
-				parentnumber := header.Number.Uint64() - 1
-				parentHash := header.ParentHash
-				for i := uint64(1); i < chain.Config().Posv.Epoch; i++ {
-					parentHeader := chain.GetHeader(parentHash, parentnumber)
-					miner, _ := c.RecoverSigner(parentHeader)
-					value, exist := statMiners[miner]
-					if exist {
-						value = value + 1
-					} else {
-						value = 1
-					}
-					statMiners[miner] = value
-					parentHash = parentHeader.ParentHash
-					parentnumber--
-					listBlockHash[i] = parentHash
-				}
-
-				// add list not miner to penalties
-				prevHeader := chain.GetHeaderByNumber(prevEpoc)
-				preMasternodes := c.GetMasternodes(chain, prevHeader)
-				penalties := []common.Address{}
-				for miner, total := range statMiners {
-					if total < common.MinimunMinerBlockPerEpoch {
-						log.Debug("Find a node not enough requirement create block", "addr", miner.Hex(), "total", total)
-						penalties = append(penalties, miner)
-					}
-				}
-				for _, addr := range preMasternodes {
-					if _, exist := statMiners[addr]; !exist {
-						log.Debug("Find a node don't create block", "addr", addr.Hex())
-						penalties = append(penalties, addr)
-					}
-				}
-
-				// get list check penalties signing block & list master nodes wil comeback
-				penComebacks := []common.Address{}
-				if combackEpoch > 0 {
-					combackHeader := chain.GetHeaderByNumber(combackEpoch)
-					penalties := common.ExtractAddressFromBytes(combackHeader.Penalties)
-					for _, penaltie := range penalties {
-						for _, addr := range candidates {
-							if penaltie == addr {
-								penComebacks = append(penComebacks, penaltie)
-							}
-						}
-					}
-				}
-
-				// Loop for each block to check missing sign. with comeback nodes
-				mapBlockHash := map[common.Hash]bool{}
-				for i := common.RangeReturnSigner - 1; i >= 0; i-- {
-					if len(penComebacks) > 0 {
-						blockNumber := header.Number.Uint64() - uint64(i) - 1
-						bhash := listBlockHash[i]
-						if blockNumber%common.MergeSignRange == 0 {
-							mapBlockHash[bhash] = true
-						}
-						signData, ok := c.BlockSigners.Get(bhash)
-						if !ok {
-							block := chain.GetBlock(bhash, blockNumber)
-							txs := block.Transactions()
-							signData = c.CacheSigner(bhash, txs)
-						}
-						txs := signData.([]*types.Transaction)
-						// Check signer signed?
-						for _, tx := range txs {
-							blkHash := common.BytesToHash(tx.Data()[len(tx.Data())-32:])
-							from := *tx.From()
-							if mapBlockHash[blkHash] {
-								for j, addr := range penComebacks {
-									if from == addr {
-										// Remove it from dupSigners.
-										penComebacks = append(penComebacks[:j], penComebacks[j+1:]...)
-										break
-									}
-								}
-							}
-						}
-					} else {
-						break
-					}
-				}
-
-				log.Debug("Time Calculated HookPenaltyTIPSigning ", "block", header.Number, "hash", header.Hash().Hex(), "pen comeback nodes", len(penComebacks), "not enough miner", len(penalties), "time", common.PrettyDuration(time.Since(start)))
-				penalties = append(penalties, penComebacks...)
-				if chain.Config().IsTIPRandomize(header.Number) {
-					return penalties, nil
-				}
-				return penComebacks, nil
-			}
-			return []common.Address{}, nil
-		}
-
-		/*
-		   HookGetSignersFromContract return list masternode for current state (block)
-		   This is a solution for work around issue return wrong list signers from snapshot
-		*/
-		c.HookGetSignersFromContract = func(block common.Hash) ([]common.Address, error) {
-			client, err := eth.blockchain.GetClient()
-			if err != nil {
-				return nil, err
-			}
-			addr := common.HexToAddress(common.MasternodeVotingSMC)
-			validator, err := contractValidator.NewTomoValidator(addr, client)
-			if err != nil {
-				return nil, err
-			}
-			opts := new(bind.CallOpts)
-			var (
-				candidateAddresses []common.Address
-				candidates         []posv.Masternode
-			)
-
-			stateDB, err := eth.blockchain.StateAt(eth.blockchain.GetBlockByHash(block).Root())
-			candidateAddresses = state.GetCandidates(stateDB)
-
+		// Masternodes prove their key to peers with the etherbase account
+		overlaySignProof := func(account common.Address, from, to discover.NodeID) ([]byte, error) {
+			wallet, err := eth.accountManager.Find(accounts.Account{Address: account})
 			if err != nil {
 				return nil, err
 			}
-			for _, address := range candidateAddresses {
-				v, err := validator.GetCandidateCap(opts, address)
-				if err != nil {
-					return nil, err
-				}
-				if address.String() != "0x0000000000000000000000000000000000000000" {
-					candidates = append(candidates, posv.Masternode{Address: address, Stake: v})
-				}
-			}
-			// sort candidates by stake descending
-			sort.Slice(candidates, func(i, j int) bool {
-				return candidates[i].Stake.Cmp(candidates[j].Stake) >= 0
-			})
-			if len(candidates) > 150 {
-				candidates = candidates[:150]
-			}
-			result := []common.Address{}
-			for _, candidate := range candidates {
-				result = append(result, candidate.Address)
-			}
-			return result, nil
+			return signOverlayProof(wallet, accounts.Account{Address: account}, from, to)
 		}
+		eth.protocolManager.overlay = newMasternodeOverlay(c, eth.blockchain, eth.Etherbase, overlaySignProof)
 
-		// Hook calculates reward for masternodes
-		c.HookReward = func(chain consensus.ChainReader, stateBlock *state.StateDB, parentState *state.StateDB, header *types.Header) (error, map[string]interface{}) {
-			number := header.Number.Uint64()
-			rCheckpoint := chain.Config().Posv.RewardCheckpoint
-			foundationWalletAddr := chain.Config().Posv.FoudationWalletAddr
-			if foundationWalletAddr == (common.Address{}) {
-				log.Error("Foundation Wallet Address is empty", "error", foundationWalletAddr)
-				return errors.New("Foundation Wallet Address is empty"), nil
-			}
-			rewards := make(map[string]interface{})
-			if number > 0 && number-rCheckpoint > 0 && foundationWalletAddr != (common.Address{}) {
-				start := time.Now()
-				// Get signers in blockSigner smartcontract.
-				// Get reward inflation.
-				chainReward := new(big.Int).Mul(new(big.Int).SetUint64(chain.Config().Posv.Reward), new(big.Int).SetUint64(params.Ether))
-				chainReward = rewardInflation(chainReward, number, common.BlocksPerYear)
-
-				totalSigner := new(uint64)
-				signers, err := contracts.GetRewardForCheckpoint(c, chain, header, rCheckpoint, totalSigner)
-
-				log.Debug("Time Get Signers", "block", header.Number.Uint64(), "time", common.PrettyDuration(time.Since(start)))
-				if err != nil {
-					log.Crit("Fail to get signers for reward checkpoint", "error", err)
-				}
-				rewards["signers"] = signers
-				rewardSigners, err := contracts.CalculateRewardForSigner(chainReward, signers, *totalSigner)
-				if err != nil {
-					log.Crit("Fail to calculate reward for signers", "error", err)
-				}
-				// Add reward for coin holders.
-				voterResults := make(map[common.Address]interface{})
-				if len(signers) > 0 {
-					for signer, calcReward := range rewardSigners {
-						err, rewards := contracts.CalculateRewardForHolders(foundationWalletAddr, parentState, signer, calcReward, number)
-						if err != nil {
-							log.Crit("Fail to calculate reward for holders.", "error", err)
-						}
-						if len(rewards) > 0 {
-							for holder, reward := range rewards {
-								stateBlock.AddBalance(holder, reward)
-							}
-						}
-						voterResults[signer] = rewards
-					}
-				}
-				rewards["rewards"] = voterResults
-				log.Debug("Time Calculated HookReward ", "block", header.Number.Uint64(), "time", common.PrettyDuration(time.Since(start)))
-			}
-			return nil, rewards
-		}
-
-		// Hook verifies masternodes set
-		c.HookVerifyMNs = func(header *types.Header, signers []common.Address) error {
-			number := header.Number.Int64()
-			if number > 0 && number%common.EpocBlockRandomize == 0 {
-				start := time.Now()
-				validators, err := GetValidators(eth.blockchain, signers)
-				log.Debug("Time Calculated HookVerifyMNs ", "block", header.Number.Uint64(), "time", common.PrettyDuration(time.Since(start)))
-				if err != nil {
-					return err
-				}
-				if !bytes.Equal(header.Validators, validators) {
-					return posv.ErrInvalidCheckpointValidators
-				}
-			}
-			return nil
-		}
+		hooks.AttachPosvHooks(c, eth.blockchain, chainConfig)
 
 		eth.txPool.IsSigner = func(address common.Address) bool {
 			currentHeader := eth.blockchain.CurrentHeader()
@@ -586,10 +325,13 @@ func New(ctx *node.ServiceContext, config *Config, tomoXServ *tomox.TomoX, lendi
 				log.Error("Can't get snapshot with at ", "number", header.Number, "hash", header.Hash().Hex(), "err", err)
 				return false
 			}
-			if _, ok := snap.Signers[address]; ok {
-				return true
+			candidate, err := c.SigningCandidate(eth.blockchain, currentHeader, address)
+			if err != nil {
+				log.Error("Can't resolve the candidate of a signing key", "key", address, "number", currentHeader.Number, "err", err)
+				return false
 			}
-			return false
+			_, ok := snap.Signers[candidate]
+			return ok
 		}
 
 	}
@@ -804,7 +546,11 @@ func (s *Ethereum) StartStaking(local bool) error {
 			log.Error("Etherbase account unavailable locally", "err", err)
 			return fmt.Errorf("signer missing: %v", err)
 		}
-		posv.Authorize(eb, wallet.SignHash)
+		if signer, ok := wallet.(accounts.HeaderSigner); ok {
+			posv.AuthorizeHeaders(eb, signer.SignHeader)
+		} else {
+			posv.Authorize(eb, wallet.SignHash)
+		}
 	}
 	if local {
 		// If local (CPU) mining is started, we can disable the transaction rejection
@@ -837,10 +583,19 @@ func (s *Ethereum) Downloader() *downloader.Downloader { return s.protocolManage
 // Protocols implements node.Service, returning all the currently configured
 // network protocols to start.
 func (s *Ethereum) Protocols() []p2p.Protocol {
-	if s.lesServer == nil {
-		return s.protocolManager.SubProtocols
+	protos := append([]p2p.Protocol{}, s.protocolManager.SubProtocols...)
+	entry := s.nodeEntry()
+	for i := range protos {
+		protos[i].Attributes = []enr.Entry{entry}
+		protos[i].DialFilter = newNodeFilter(entry.NetworkID, s.protocolManager.forkFilter)
+	}
+	if s.protocolManager.overlay != nil {
+		protos = append(protos, s.protocolManager.overlay.protocol())
 	}
-	return append(s.protocolManager.SubProtocols, s.lesServer.Protocols()...)
+	if s.lesServer != nil {
+		protos = append(protos, s.lesServer.Protocols()...)
+	}
+	return protos
 }
 
 // Start implements node.Service, starting all internal goroutines needed by the
@@ -861,12 +616,38 @@ func (s *Ethereum) Start(srvr *p2p.Server) error {
 		maxPeers -= s.config.LightPeers
 	}
 	// Start the networking layer and the light server if requested
+	s.protocolManager.nodeRecord = srvr.NodeRecord
 	s.protocolManager.Start(maxPeers)
+	if s.protocolManager.overlay != nil {
+		s.protocolManager.overlay.start(srvr)
+	}
 	if s.lesServer != nil {
 		s.lesServer.Start(srvr)
 	}
+	if c, ok := s.engine.(*posv.Posv); ok && metrics.Enabled {
+		go s.countTurnsLoop(c)
+	}
 	return nil
 }
+
+// countTurnsLoop counts the masternode turns of the new canonical blocks.
+func (s *Ethereum) countTurnsLoop(c *posv.Posv) {
+	headCh := make(chan core.ChainHeadEvent, 16)
+	headSub := s.blockchain.SubscribeChainHeadEvent(headCh)
+	defer headSub.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-headCh:
+			c.CountTurns(s.blockchain, ev.Block.Header())
+		case <-headSub.Err():
+			return
+		case <-s.shutdownChan:
+			return
+		}
+	}
+}
+
 func (s *Ethereum) SaveData() {
 	s.blockchain.SaveData()
 }
@@ -876,6 +657,9 @@ func (s *Ethereum) SaveData() {
 func (s *Ethereum) Stop() error {
 	s.bloomIndexer.Close()
 	s.blockchain.Stop()
+	if s.protocolManager.overlay != nil {
+		s.protocolManager.overlay.stop()
+	}
 	s.protocolManager.Stop()
 	if s.lesServer != nil {
 		s.lesServer.Stop()
@@ -890,51 +674,6 @@ func (s *Ethereum) Stop() error {
 	return nil
 }
 
-func GetValidators(bc *core.BlockChain, masternodes []common.Address) ([]byte, error) {
-	if bc.Config().Posv == nil {
-		return nil, core.ErrNotPoSV
-	}
-	client, err := bc.GetClient()
-	if err != nil {
-		return nil, err
-	}
-	// Check m2 exists on chaindb.
-	// Get secrets and opening at epoc block checkpoint.
-
-	var candidates []int64
-	if err != nil {
-		return nil, err
-	}
-	lenSigners := int64(len(masternodes))
-	if lenSigners > 0 {
-		for _, addr := range masternodes {
-			random, err := contracts.GetRandomizeFromContract(client, addr)
-			if err != nil {
-				return nil, err
-			}
-			candidates = append(candidates, random)
-		}
-		// Get randomize m2 list.
-		m2, err := contracts.GenM2FromRandomize(candidates, lenSigners)
-		if err != nil {
-			return nil, err
-		}
-		return contracts.BuildValidatorFromM2(m2), nil
-	}
-	return nil, core.ErrNotFoundM1
-}
-
-func rewardInflation(chainReward *big.Int, number uint64, blockPerYear uint64) *big.Int {
-	if blockPerYear*2 <= number && number < blockPerYear*5 {
-		chainReward.Div(chainReward, new(big.Int).SetUint64(2))
-	}
-	if blockPerYear*5 <= number {
-		chainReward.Div(chainReward, new(big.Int).SetUint64(4))
-	}
-
-	return chainReward
-}
-
 func (s *Ethereum) GetPeer() int {
 	return len(s.protocolManager.peers.peers)
 }
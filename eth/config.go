@@ -83,6 +83,10 @@ type Config struct {
 	SyncMode  downloader.SyncMode
 	NoPruning bool
 
+	// Number of recent block states kept by the online state pruner of full
+	// nodes (0 = pruning disabled)
+	StatePruneRetain uint64 `toml:",omitempty"`
+
 	// Light client options
 	LightServ  int `toml:",omitempty"` // Maximum percentage of time allowed for serving LES requests
 	LightPeers int `toml:",omitempty"` // Maximum number of LES client peers
@@ -91,8 +95,11 @@ type Config struct {
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
 	DatabaseCache      int
+	DatabaseFreezer    string // Ancient chain data directory, immutable chain data is only moved out of the database if set
+	AncientThreshold   uint64 `toml:",omitempty"` // Blocks kept out of the freezer, defaults to params.ImmutabilityThreshold
 	TrieCache          int
 	TrieTimeout        time.Duration
+	SnapshotCache      int `toml:",omitempty"` // Megabytes cached for the state snapshot (0 = snapshot disabled)
 
 	// Mining-related options
 	Etherbase    common.Address `toml:",omitempty"`
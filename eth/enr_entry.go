@@ -0,0 +1,93 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"errors"
+
+	"github.com/tomochain/tomochain/core/forkid"
+	"github.com/tomochain/tomochain/p2p/enr"
+	"github.com/tomochain/tomochain/rlp"
+)
+
+var errRecordNetwork = errors.New("node record of another network")
+
+// Services advertised in the node record.
+const (
+	serviceTomoX   = 1 << iota // node runs TomoX and serves the trading state
+	serviceLending             // node runs TomoX lending and serves the lending state
+	serviceSDK                 // node keeps the order history for the SDK
+	serviceLES                 // node serves light clients
+)
+
+// tomoEntry is the "tomo" entry of the node record. It advertises the
+// network and fork identifier of the node as well as the services it
+// provides, so that discovered nodes can be checked before dialing.
+type tomoEntry struct {
+	NetworkID uint64
+	ForkID    forkid.ID // EIP-2124 fork identifier at the head when the record was made
+	Services  uint
+
+	// Ignore additional fields (for forward compatibility).
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+// ENRKey implements enr.Entry.
+func (e tomoEntry) ENRKey() string {
+	return "tomo"
+}
+
+// nodeEntry creates the node record entry of the local node.
+func (s *Ethereum) nodeEntry() tomoEntry {
+	entry := tomoEntry{
+		NetworkID: s.networkId,
+		ForkID:    forkid.NewID(s.chainConfig, s.blockchain.Genesis().Hash(), s.blockchain.CurrentHeader().Number.Uint64()),
+	}
+	if s.TomoX != nil {
+		entry.Services |= serviceTomoX
+		if s.TomoX.IsSDKNode() {
+			entry.Services |= serviceSDK
+		}
+	}
+	if s.Lending != nil {
+		entry.Services |= serviceLending
+	}
+	if s.lesServer != nil {
+		entry.Services |= serviceLES
+	}
+	return entry
+}
+
+// newNodeFilter returns a dial filter rejecting nodes which advertise another
+// network, or a fork identifier the fork filter rejects. The identifier of a
+// node which started before the local head passed a fork still validates, as
+// long as the fork schedules agree. Records without a "tomo" entry are
+// accepted, the handshake checks those nodes.
+func newNodeFilter(networkID uint64, forkFilter forkid.Filter) func(*enr.Record) error {
+	return func(r *enr.Record) error {
+		var entry tomoEntry
+		if err := r.Load(&entry); err != nil {
+			if enr.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.NetworkID != networkID {
+			return errRecordNetwork
+		}
+		return forkFilter(entry.ForkID)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"testing"
+
+	"github.com/tomochain/tomochain/core/forkid"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/p2p/enr"
+	"github.com/tomochain/tomochain/params"
+	"github.com/tomochain/tomochain/rlp"
+)
+
+func TestNodeFilter(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	config, genesis := params.TomoMainnetChainConfig, params.TomoMainnetGenesisHash
+	forks := forkid.Gather(config)
+	head := forks[1]
+	filter := newNodeFilter(88, forkid.NewFilter(config, genesis, func() uint64 { return head }))
+
+	local := forkid.NewID(config, genesis, head)
+	tests := []struct {
+		entry *tomoEntry
+		want  error
+	}{
+		{entry: nil, want: nil},
+		{entry: &tomoEntry{NetworkID: 88, ForkID: local}, want: nil},
+		{entry: &tomoEntry{NetworkID: 88, ForkID: local, Services: serviceSDK | serviceLES}, want: nil},
+		// a record made before the node passed the last fork is still valid
+		{entry: &tomoEntry{NetworkID: 88, ForkID: forkid.NewID(config, genesis, forks[0])}, want: nil},
+		{entry: &tomoEntry{NetworkID: 89, ForkID: local}, want: errRecordNetwork},
+		{entry: &tomoEntry{NetworkID: 88, ForkID: forkid.ID{Hash: [4]byte{4, 3, 2, 1}}}, want: forkid.ErrLocalIncompatibleOrStale},
+		{entry: &tomoEntry{NetworkID: 88, ForkID: forkid.ID{Hash: local.Hash, Next: forks[1]}}, want: forkid.ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		var r enr.Record
+		if tt.entry != nil {
+			r.Set(*tt.entry)
+		}
+		if err := r.Sign(key); err != nil {
+			t.Fatalf("test %d: can't sign record: %v", i, err)
+		}
+		// Round-trip the record as it would be received from the network.
+		blob, err := rlp.EncodeToBytes(&r)
+		if err != nil {
+			t.Fatalf("test %d: can't encode record: %v", i, err)
+		}
+		var dec enr.Record
+		if err := rlp.DecodeBytes(blob, &dec); err != nil {
+			t.Fatalf("test %d: can't decode record: %v", i, err)
+		}
+		if err := filter(&dec); err != tt.want {
+			t.Errorf("test %d: filter error mismatch: got %v, want %v", i, err, tt.want)
+		}
+	}
+}
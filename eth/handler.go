@@ -31,6 +31,7 @@ import (
 	"github.com/tomochain/tomochain/consensus"
 	"github.com/tomochain/tomochain/consensus/misc"
 	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/core/forkid"
 	"github.com/tomochain/tomochain/core/types"
 	"github.com/tomochain/tomochain/eth/downloader"
 	"github.com/tomochain/tomochain/eth/fetcher"
@@ -39,6 +40,7 @@ import (
 	"github.com/tomochain/tomochain/log"
 	"github.com/tomochain/tomochain/p2p"
 	"github.com/tomochain/tomochain/p2p/discover"
+	"github.com/tomochain/tomochain/p2p/enr"
 	"github.com/tomochain/tomochain/params"
 	"github.com/tomochain/tomochain/rlp"
 )
@@ -75,11 +77,15 @@ type ProtocolManager struct {
 	lendingpool lendingPool
 	blockchain  *core.BlockChain
 	chainconfig *params.ChainConfig
+	forkFilter  forkid.Filter // Fork ID filter, constant across the lifetime of the node
 	maxPeers    int
 
+	nodeRecord func(id discover.NodeID) *enr.Record // Records known to discovery, checked for legacy peers
+
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
 	peers      *peerSet
+	overlay    *masternodeOverlay // Masternode peers served first, nil without PoSV
 
 	SubProtocols []p2p.Protocol
 
@@ -143,6 +149,9 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		orderTxSub:     nil,
 		lendingTxSub:   nil,
 	}
+	manager.forkFilter = forkid.NewFilter(config, blockchain.Genesis().Hash(), func() uint64 {
+		return blockchain.CurrentHeader().Number.Uint64()
+	})
 	// Figure out whether to allow fast sync or not
 	if mode == downloader.FastSync && blockchain.CurrentBlock().NumberU64() > 0 {
 		log.Warn("Blockchain not empty, fast sync disabled")
@@ -309,11 +318,37 @@ func (pm *ProtocolManager) newPeer(pv int, p *p2p.Peer, rw p2p.MsgReadWriter) *p
 	return newPeer(pv, p, newMeteredMsgWriter(rw))
 }
 
+// checkRecord validates the node record of a peer running a protocol version
+// older than eth/64, whose handshake doesn't exchange fork identifiers. Peers
+// with no record known to discovery are accepted.
+func (pm *ProtocolManager) checkRecord(p *peer) error {
+	if p.version >= eth64 || pm.nodeRecord == nil {
+		return nil
+	}
+	r := pm.nodeRecord(p.ID())
+	if r == nil {
+		return nil
+	}
+	switch err := newNodeFilter(pm.networkId, pm.forkFilter)(r); err {
+	case nil:
+		return nil
+	case errRecordNetwork:
+		return errResp(ErrNetworkIdMismatch, "%v", err)
+	case forkid.ErrRemoteStale:
+		forkStalePeerMeter.Mark(1)
+		return errResp(ErrForkIDRejected, "%v", err)
+	default:
+		forkIncompatiblePeerMeter.Mark(1)
+		return errResp(ErrForkIDRejected, "%v", err)
+	}
+}
+
 // handle is the callback invoked to manage the life cycle of an eth peer. When
 // this function terminates, the peer is disconnected.
 func (pm *ProtocolManager) handle(p *peer) error {
-	// Ignore maxPeers if this is a trusted peer
-	if pm.peers.Len() >= pm.maxPeers && !p.Peer.Info().Network.Trusted {
+	// Ignore maxPeers if this is a trusted peer or a static one proving to be a
+	// masternode, the overlay keeps those connected
+	if info := p.Peer.Info(); pm.peers.Len() >= pm.maxPeers && !info.Network.Trusted && !(info.Network.Static && pm.overlay.waitMasternode(p.id, handshakeTimeout)) {
 		return p2p.DiscTooManyPeers
 	}
 	p.Log().Debug("Ethereum peer connected", "name", p.Name())
@@ -326,7 +361,12 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		number  = head.Number.Uint64()
 		td      = pm.blockchain.GetTd(hash, number)
 	)
-	if err := p.Handshake(pm.networkId, td, hash, genesis.Hash()); err != nil {
+	if err := pm.checkRecord(p); err != nil {
+		p.Log().Debug("Ethereum node record rejected", "err", err)
+		return err
+	}
+	forkID := forkid.NewID(pm.chainconfig, genesis.Hash(), number)
+	if err := p.Handshake(pm.networkId, td, hash, genesis.Hash(), forkID, pm.forkFilter); err != nil {
 		p.Log().Debug("Ethereum handshake failed", "err", err)
 		return err
 	}
@@ -819,7 +859,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 // will only announce it's availability (depending what's requested).
 func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 	hash := block.Hash()
-	peers := pm.peers.PeersWithoutBlock(hash)
+	peers := pm.overlay.prioritize(pm.peers.PeersWithoutBlock(hash))
 
 	// If propagation is requested, send to a subset of the peer
 	if propagate {
@@ -852,6 +892,9 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 func (pm *ProtocolManager) BroadcastTx(hash common.Hash, tx *types.Transaction) {
 	// Broadcast transaction to a batch of peers not knowing about it
 	peers := pm.peers.PeersWithoutTx(hash)
+	if priorityTx(tx) {
+		peers = pm.overlay.prioritize(peers)
+	}
 	//FIXME include this again: peers = peers[:int(math.Sqrt(float64(len(peers))))]
 	for _, peer := range peers {
 		peer.SendTransactions(types.Transactions{tx})
@@ -863,7 +906,7 @@ func (pm *ProtocolManager) BroadcastTx(hash common.Hash, tx *types.Transaction)
 // already have the given transaction.
 func (pm *ProtocolManager) OrderBroadcastTx(hash common.Hash, tx *types.OrderTransaction) {
 	// Broadcast transaction to a batch of peers not knowing about it
-	peers := pm.peers.OrderPeersWithoutTx(hash)
+	peers := pm.overlay.prioritize(pm.peers.OrderPeersWithoutTx(hash))
 	//FIXME include this again: peers = peers[:int(math.Sqrt(float64(len(peers))))]
 	for _, peer := range peers {
 		peer.SendOrderTransactions(types.OrderTransactions{tx})
@@ -875,7 +918,7 @@ func (pm *ProtocolManager) OrderBroadcastTx(hash common.Hash, tx *types.OrderTra
 // already have the given transaction.
 func (pm *ProtocolManager) LendingBroadcastTx(hash common.Hash, tx *types.LendingTransaction) {
 	// Broadcast transaction to a batch of peers not knowing about it
-	peers := pm.peers.LendingPeersWithoutTx(hash)
+	peers := pm.overlay.prioritize(pm.peers.LendingPeersWithoutTx(hash))
 	//FIXME include this again: peers = peers[:int(math.Sqrt(float64(len(peers))))]
 	for _, peer := range peers {
 		peer.SendLendingTransactions(types.LendingTransactions{tx})
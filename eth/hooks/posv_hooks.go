@@ -0,0 +1,414 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package hooks wires the chain dependent callbacks of the PoSV consensus
+// engine: M2 validator selection, penalties, rewards and masternode checks.
+package hooks
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/tomochain/tomochain/accounts/abi/bind"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/contracts"
+	contractValidator "github.com/tomochain/tomochain/contracts/validator/contract"
+	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
+	"github.com/tomochain/tomochain/params"
+)
+
+// rewardTimer measures the checkpoint reward calculation of HookReward.
+var rewardTimer = metrics.NewRegisteredTimer("posv/reward", nil)
+
+// AttachPosvHooks installs the PoSV engine hooks backed by the given chain.
+func AttachPosvHooks(c *posv.Posv, bc *core.BlockChain, chainConfig *params.ChainConfig) {
+	// Hook prepares validators M2 for the current epoch at checkpoint block
+	c.HookValidator = func(header *types.Header, signers []common.Address) ([]byte, error) {
+		start := time.Now()
+		validators, err := GetValidators(bc, signers)
+		if err != nil {
+			return []byte{}, err
+		}
+		header.Validators = validators
+		log.Debug("Time Calculated HookValidator ", "block", header.Number.Uint64(), "time", common.PrettyDuration(time.Since(start)))
+		return validators, nil
+	}
+
+	// Hook scans for bad masternodes and decide to penalty them
+	c.HookPenalty = func(chain consensus.ChainReader, blockNumberEpoc uint64) ([]common.Address, error) {
+		canonicalState, err := bc.State()
+		if canonicalState == nil || err != nil {
+			log.Crit("Can't get state at head of canonical chain", "head number", bc.CurrentHeader().Number.Uint64(), "err", err)
+		}
+		prevEpoc := blockNumberEpoc - chain.Config().Posv.Epoch
+		if prevEpoc >= 0 {
+			start := time.Now()
+			prevHeader := chain.GetHeaderByNumber(prevEpoc)
+			penSigners := c.GetMasternodes(chain, prevHeader)
+			if len(penSigners) > 0 {
+				// Loop for each block to check missing sign.
+				for i := prevEpoc; i < blockNumberEpoc; i++ {
+					if i%common.MergeSignRange == 0 || !chainConfig.IsTIP2019(big.NewInt(int64(i))) {
+						bheader := chain.GetHeaderByNumber(i)
+						bhash := bheader.Hash()
+						block := chain.GetBlock(bhash, i)
+						if len(penSigners) > 0 {
+							signedMasternodes, err := contracts.GetSignersFromContract(canonicalState, block)
+							if err != nil {
+								return nil, err
+							}
+							if len(signedMasternodes) > 0 {
+								// Check signer signed?
+								for _, signed := range signedMasternodes {
+									for j, addr := range penSigners {
+										if signed == addr {
+											// Remove it from dupSigners.
+											penSigners = append(penSigners[:j], penSigners[j+1:]...)
+										}
+									}
+								}
+							}
+						} else {
+							break
+						}
+					}
+				}
+			}
+			log.Debug("Time Calculated HookPenalty ", "block", blockNumberEpoc, "time", common.PrettyDuration(time.Since(start)))
+			return penSigners, nil
+		}
+		return []common.Address{}, nil
+	}
+
+	// Hook scans for bad masternodes and decide to penalty them
+	c.HookPenaltyTIPSigning = func(chain consensus.ChainReader, header *types.Header, candidates []common.Address) ([]common.Address, error) {
+		prevEpoc := header.Number.Uint64() - chain.Config().Posv.Epoch
+		combackEpoch := uint64(0)
+		comebackLength := (common.LimitPenaltyEpoch + 1) * chain.Config().Posv.Epoch
+		if header.Number.Uint64() > comebackLength {
+			combackEpoch = header.Number.Uint64() - comebackLength
+		}
+		if prevEpoc >= 0 {
+			start := time.Now()
+
+			listBlockHash := make([]common.Hash, chain.Config().Posv.Epoch)
+
+			// get list block hash & stats total created block
+			statMiners := make(map[common.Address]int)
+			listBlockHash[0] = header.ParentHash
+			parentnumber := header.Number.Uint64() - 1
+			parentHash := header.ParentHash
+			for i := uint64(1); i < chain.Config().Posv.Epoch; i++ {
+				parentHeader := chain.GetHeader(parentHash, parentnumber)
+				miner, _ := c.RecoverSigner(parentHeader)
+				value, exist := statMiners[miner]
+				if exist {
+					value = value + 1
+				} else {
+					value = 1
+				}
+				statMiners[miner] = value
+				parentHash = parentHeader.ParentHash
+				parentnumber--
+				listBlockHash[i] = parentHash
+			}
+
+			// add list not miner to penalties
+			prevHeader := chain.GetHeaderByNumber(prevEpoc)
+			preMasternodes := c.GetMasternodes(chain, prevHeader)
+			penalties := []common.Address{}
+			for miner, total := range statMiners {
+				if total < common.MinimunMinerBlockPerEpoch {
+					log.Debug("Find a node not enough requirement create block", "addr", miner.Hex(), "total", total)
+					penalties = append(penalties, miner)
+				}
+			}
+			for _, addr := range preMasternodes {
+				if _, exist := statMiners[addr]; !exist {
+					log.Debug("Find a node don't create block", "addr", addr.Hex())
+					penalties = append(penalties, addr)
+				}
+			}
+
+			// get list check penalties signing block & list master nodes wil comeback
+			penComebacks := []common.Address{}
+			if combackEpoch > 0 {
+				combackHeader := chain.GetHeaderByNumber(combackEpoch)
+				penalties := common.ExtractAddressFromBytes(combackHeader.Penalties)
+				for _, penaltie := range penalties {
+					for _, addr := range candidates {
+						if penaltie == addr {
+							penComebacks = append(penComebacks, penaltie)
+						}
+					}
+				}
+			}
+
+			// Loop for each block to check missing sign. with comeback nodes
+			mapBlockHash := map[common.Hash]bool{}
+			for i := common.RangeReturnSigner - 1; i >= 0; i-- {
+				if len(penComebacks) > 0 {
+					blockNumber := header.Number.Uint64() - uint64(i) - 1
+					bhash := listBlockHash[i]
+					if blockNumber%common.MergeSignRange == 0 {
+						mapBlockHash[bhash] = true
+					}
+					signData, ok := c.BlockSigners.Get(bhash)
+					if !ok {
+						block := chain.GetBlock(bhash, blockNumber)
+						txs := block.Transactions()
+						signData = c.CacheSigner(bhash, txs)
+					}
+					txs := signData.([]*types.Transaction)
+					// Resolve the keys active in the block including the transactions
+					blockHeader := chain.GetHeader(bhash, blockNumber)
+					// Check signer signed?
+					for _, tx := range txs {
+						blkHash := common.BytesToHash(tx.Data()[len(tx.Data())-32:])
+						from, err := c.SigningCandidate(chain, blockHeader, *tx.From())
+						if err != nil {
+							return nil, err
+						}
+						if mapBlockHash[blkHash] {
+							for j, addr := range penComebacks {
+								if from == addr {
+									// Remove it from dupSigners.
+									penComebacks = append(penComebacks[:j], penComebacks[j+1:]...)
+									break
+								}
+							}
+						}
+					}
+				} else {
+					break
+				}
+			}
+
+			log.Debug("Time Calculated HookPenaltyTIPSigning ", "block", header.Number, "hash", header.Hash().Hex(), "pen comeback nodes", len(penComebacks), "not enough miner", len(penalties), "time", common.PrettyDuration(time.Since(start)))
+			penalties = append(penalties, penComebacks...)
+			if chain.Config().IsTIPRandomize(header.Number) {
+				return penalties, nil
+			}
+			return penComebacks, nil
+		}
+		return []common.Address{}, nil
+	}
+
+	// Hook reads the keys the masternodes seal the epoch opened on top of parent
+	// with from the registry
+	c.HookSigningKeys = func(chain consensus.ChainReader, parent *types.Header, masternodes []common.Address) ([]common.Address, error) {
+		statedb, err := bc.StateAt(parent.Root)
+		if err != nil {
+			return nil, err
+		}
+		number := parent.Number.Uint64() + 1
+		keys := make([]common.Address, len(masternodes))
+		for i, masternode := range masternodes {
+			keys[i] = state.GetSigningKey(statedb, masternode, number)
+		}
+		return keys, nil
+	}
+
+	/*
+	   HookGetSignersFromContract return list masternode for current state (block)
+	   This is a solution for work around issue return wrong list signers from snapshot
+	*/
+	c.HookGetSignersFromContract = func(block common.Hash) ([]common.Address, error) {
+		client, err := bc.GetClient()
+		if err != nil {
+			return nil, err
+		}
+		addr := common.HexToAddress(common.MasternodeVotingSMC)
+		validator, err := contractValidator.NewTomoValidator(addr, client)
+		if err != nil {
+			return nil, err
+		}
+		opts := new(bind.CallOpts)
+		var (
+			candidateAddresses []common.Address
+			candidates         []posv.Masternode
+		)
+
+		stateDB, err := bc.StateAt(bc.GetBlockByHash(block).Root())
+		candidateAddresses = state.GetCandidates(stateDB)
+
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range candidateAddresses {
+			v, err := validator.GetCandidateCap(opts, address)
+			if err != nil {
+				return nil, err
+			}
+			if address.String() != "0x0000000000000000000000000000000000000000" {
+				candidates = append(candidates, posv.Masternode{Address: address, Stake: v})
+			}
+		}
+		// sort candidates by stake descending
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].Stake.Cmp(candidates[j].Stake) >= 0
+		})
+		if len(candidates) > 150 {
+			candidates = candidates[:150]
+		}
+		result := []common.Address{}
+		for _, candidate := range candidates {
+			result = append(result, candidate.Address)
+		}
+		return result, nil
+	}
+
+	// Hook calculates reward for masternodes
+	c.HookReward = func(chain consensus.ChainReader, stateBlock *state.StateDB, parentState *state.StateDB, header *types.Header) (error, map[string]interface{}) {
+		number := header.Number.Uint64()
+		rCheckpoint := chain.Config().Posv.RewardCheckpoint
+		foundationWalletAddr := chain.Config().Posv.FoudationWalletAddr
+		if foundationWalletAddr == (common.Address{}) {
+			log.Error("Foundation Wallet Address is empty", "error", foundationWalletAddr)
+			return errors.New("Foundation Wallet Address is empty"), nil
+		}
+		rewards := make(map[string]interface{})
+		if number > 0 && number-rCheckpoint > 0 && foundationWalletAddr != (common.Address{}) {
+			start := time.Now()
+			parent := chain.GetHeader(header.ParentHash, number-1)
+			signers, voterResults, err := CalculateCheckpointRewards(c, chain, parent, number, parentState)
+			if err != nil {
+				log.Crit("Fail to calculate checkpoint rewards", "error", err)
+			}
+			rewards["signers"] = signers
+			results := make(map[common.Address]interface{})
+			for signer, holders := range voterResults {
+				for holder, reward := range holders {
+					stateBlock.AddBalance(holder, reward)
+				}
+				results[signer] = holders
+			}
+			rewards["rewards"] = results
+			rewardTimer.UpdateSince(start)
+			log.Debug("Time Calculated HookReward ", "block", header.Number.Uint64(), "time", common.PrettyDuration(time.Since(start)))
+		}
+		return nil, rewards
+	}
+
+	// Hook verifies masternodes set
+	c.HookVerifyMNs = func(header *types.Header, signers []common.Address) error {
+		number := header.Number.Int64()
+		if number > 0 && number%common.EpocBlockRandomize == 0 {
+			start := time.Now()
+			validators, err := GetValidators(bc, signers)
+			log.Debug("Time Calculated HookVerifyMNs ", "block", header.Number.Uint64(), "time", common.PrettyDuration(time.Since(start)))
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(header.Validators, validators) {
+				return posv.ErrInvalidCheckpointValidators
+			}
+		}
+		return nil
+	}
+
+}
+
+// GetValidators builds the M2 validator list of the masternodes from the
+// secrets and openings stored in the randomize contract by their signing keys.
+func GetValidators(bc *core.BlockChain, masternodes []common.Address) ([]byte, error) {
+	if bc.Config().Posv == nil {
+		return nil, core.ErrNotPoSV
+	}
+	client, err := bc.GetClient()
+	if err != nil {
+		return nil, err
+	}
+	// Check m2 exists on chaindb.
+	// Get secrets and opening at epoc block checkpoint.
+
+	var candidates []int64
+	statedb, err := bc.State()
+	if err != nil {
+		return nil, err
+	}
+	// Masternodes send their randomize transactions from the key sealing their
+	// blocks, so the contract keeps the values under that key
+	head := bc.CurrentHeader().Number.Uint64()
+	lenSigners := int64(len(masternodes))
+	if lenSigners > 0 {
+		for _, addr := range masternodes {
+			random, err := contracts.GetRandomizeFromContract(client, state.GetSigningKey(statedb, addr, head))
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, random)
+		}
+		// Get randomize m2 list.
+		m2, err := contracts.GenM2FromRandomize(candidates, lenSigners)
+		if err != nil {
+			return nil, err
+		}
+		return contracts.BuildValidatorFromM2(m2), nil
+	}
+	return nil, core.ErrNotFoundM1
+}
+
+// CalculateCheckpointRewards computes what the checkpoint with the given
+// number pays under the chain reward policy, from the signer transactions
+// included up to head and the stakes in statedb. It returns the rewarded
+// signers and, for each of them, the amounts credited to its owner, voters
+// and the foundation wallet. The state is left untouched.
+func CalculateCheckpointRewards(c *posv.Posv, chain consensus.ChainReader, head *types.Header, number uint64, statedb *state.StateDB) (map[common.Address]*contracts.RewardLog, map[common.Address]map[common.Address]*big.Int, error) {
+	config := chain.Config().Posv
+	policy := config.Rewards()
+
+	// Get signers in blockSigner smartcontract.
+	// Get reward inflation.
+	chainReward := new(big.Int).Mul(new(big.Int).SetUint64(config.Reward), new(big.Int).SetUint64(params.Ether))
+	chainReward = policy.Inflate(chainReward, number)
+
+	totalSigner := new(uint64)
+	signers, err := contracts.GetRewardForCheckpointFrom(c, chain, head, number, config.RewardCheckpoint, totalSigner)
+	if err != nil {
+		return nil, nil, err
+	}
+	*totalSigner = contracts.ExcludeLowSigners(signers, *totalSigner, policy.MinSigns)
+	rewardSigners, err := contracts.CalculateRewardForSigner(chainReward, signers, *totalSigner)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Add reward for coin holders.
+	holders := make(map[common.Address]map[common.Address]*big.Int)
+	for signer, calcReward := range rewardSigners {
+		err, rewards := contracts.CalculateRewardForHolders(policy, config.FoudationWalletAddr, statedb, signer, calcReward, number)
+		if err != nil {
+			return nil, nil, err
+		}
+		holders[signer] = rewards
+	}
+	return signers, holders, nil
+}
+
+// RewardInflation applies the mainnet halving schedule to the checkpoint
+// reward: the reward is halved from the 2nd year and quartered from the 5th year.
+func RewardInflation(chainReward *big.Int, number uint64, blockPerYear uint64) *big.Int {
+	policy := &params.PosvRewardPolicy{Inflation: params.PosvHalvingSchedule(blockPerYear)}
+	return chainReward.Set(policy.Inflate(chainReward, number))
+}
@@ -1,4 +1,4 @@
-package eth
+package hooks
 
 import (
 	"github.com/tomochain/tomochain/params"
@@ -10,7 +10,7 @@ func TestRewardInflation(t *testing.T) {
 	for i := 0; i < 100; i++ {
 		// the first 2 years
 		chainReward := new(big.Int).Mul(new(big.Int).SetUint64(250), new(big.Int).SetUint64(params.Ether))
-		chainReward = rewardInflation(chainReward, uint64(i), 10)
+		chainReward = RewardInflation(chainReward, uint64(i), 10)
 
 		// 3rd year, 4th year, 5th year
 		halfReward := new(big.Int).Mul(new(big.Int).SetUint64(125), new(big.Int).SetUint64(params.Ether))
@@ -0,0 +1,86 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package hooks_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/eth/hooks"
+	"github.com/tomochain/tomochain/eth/posvsim"
+	"github.com/tomochain/tomochain/params"
+)
+
+// Tests that the dry-run of a checkpoint pays exactly what the checkpoint
+// block credits under a custom reward policy.
+func TestCheckpointRewardsPolicy(t *testing.T) {
+	policy := &params.PosvRewardPolicy{MasterPercent: 20, FoundationPercent: 80, MinSigns: 1}
+	network, err := posvsim.NewNetwork(posvsim.Config{RewardPolicy: policy})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+	epoch := network.Config.Epoch
+	if err := network.Run(2*epoch - 1); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	node := network.Nodes[0]
+	statedb, err := node.Chain.State()
+	if err != nil {
+		t.Fatalf("failed to get state: %v", err)
+	}
+	signers, rewards, err := hooks.CalculateCheckpointRewards(node.Engine, node.Chain, node.Chain.CurrentHeader(), 2*epoch, statedb)
+	if err != nil {
+		t.Fatalf("failed to calculate rewards: %v", err)
+	}
+	if len(signers) != len(network.Nodes) {
+		t.Fatalf("signer count mismatch: have %d, want %d", len(signers), len(network.Nodes))
+	}
+	var (
+		owner      = crypto.PubkeyToAddress(network.Owner.PublicKey)
+		foundation = network.ChainConfig.Posv.FoudationWalletAddr
+		wantOwner  = new(big.Int)
+		wantFound  = new(big.Int)
+	)
+	for signer, holders := range rewards {
+		if holders[foundation].Cmp(new(big.Int).Mul(holders[owner], big.NewInt(4))) < 0 {
+			t.Errorf("signer %x: foundation share %v below 4x owner share %v", signer, holders[foundation], holders[owner])
+		}
+		wantOwner.Add(wantOwner, holders[owner])
+		wantFound.Add(wantFound, holders[foundation])
+	}
+	total := new(big.Int).Add(wantOwner, wantFound)
+	if max := new(big.Int).Mul(big.NewInt(250), big.NewInt(params.Ether)); total.Sign() <= 0 || total.Cmp(max) > 0 {
+		t.Fatalf("total reward %v out of range (0, %v]", total, max)
+	}
+
+	ownerBefore, _ := node.Balance(owner)
+	foundBefore, _ := node.Balance(foundation)
+	if err := network.Run(2 * epoch); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	ownerAfter, _ := node.Balance(owner)
+	foundAfter, _ := node.Balance(foundation)
+	if have := new(big.Int).Sub(ownerAfter, ownerBefore); have.Cmp(wantOwner) != 0 {
+		t.Errorf("owner reward mismatch: have %v, want %v", have, wantOwner)
+	}
+	if have := new(big.Int).Sub(foundAfter, foundBefore); have.Cmp(wantFound) != 0 {
+		t.Errorf("foundation reward mismatch: have %v, want %v", have, wantFound)
+	}
+	if penalties := node.Penalties(2 * epoch); len(penalties) != 0 {
+		t.Errorf("unexpected penalties: %v", penalties)
+	}
+}
@@ -54,6 +54,9 @@ var (
 	miscInTrafficMeter        = metrics.NewRegisteredMeter("eth/misc/in/traffic", nil)
 	miscOutPacketsMeter       = metrics.NewRegisteredMeter("eth/misc/out/packets", nil)
 	miscOutTrafficMeter       = metrics.NewRegisteredMeter("eth/misc/out/traffic", nil)
+
+	forkStalePeerMeter        = metrics.NewRegisteredMeter("eth/handshake/fork/stale", nil)
+	forkIncompatiblePeerMeter = metrics.NewRegisteredMeter("eth/handshake/fork/incompatible", nil)
 )
 
 // meteredMsgReadWriter is a wrapper around a p2p.MsgReadWriter, capable of
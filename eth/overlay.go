@@ -0,0 +1,572 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tomochain/tomochain/accounts"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/event"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/p2p"
+	"github.com/tomochain/tomochain/p2p/discover"
+)
+
+// The masternode overlay is a side protocol run next to eth. Peers prove
+// control of a masternode key during its handshake, and blocks, order, lending
+// and block-signer transactions are pushed to proven masternodes before any
+// other peer. Masternodes additionally keep static connections to each other,
+// learning the endpoints of the other members from the ones they know.
+const (
+	overlayProtocolName    = "posv"
+	overlayProtocolVersion = 1
+	overlayProtocolLength  = 2
+
+	overlayStatusMsg = 0x00 // Handshake carrying the masternode proof
+	overlayNodesMsg  = 0x01 // Endpoints of other proven masternodes
+
+	overlayMaxMsgSize = 64 * 1024 // Maximum cap on the size of an overlay message
+	overlayMaxNodes   = 256       // Maximum number of endpoints accepted in one message
+
+	overlayNodesPeriod = time.Minute // Period in which a peer may announce up to overlayMaxNodes endpoints
+)
+
+var (
+	errOverlayProof   = errors.New("invalid masternode proof")
+	errOverlayStopped = errors.New("masternode overlay stopped")
+)
+
+// overlayStatus is the handshake message of the overlay protocol.
+type overlayStatus struct {
+	Signature []byte // Signature of overlayProofHash, empty if not a masternode
+	Enode     string // Endpoint the sender accepts connections on
+}
+
+// overlayServer is the part of the p2p server used by the overlay.
+type overlayServer interface {
+	Self() *discover.Node
+	AddPeer(node *discover.Node)
+	RemovePeer(node *discover.Node)
+}
+
+// overlayPeer is a peer which ran the overlay handshake.
+type overlayPeer struct {
+	id         string         // Peer id, shared with the eth peer set
+	node       *discover.Node // Endpoint of the peer, nil if it announced none
+	rw         p2p.MsgReadWriter
+	masternode common.Address // Masternode the peer proved control of
+	proven     bool           // Whether the peer sent a valid proof
+	conns      int            // Number of connections, pair peers share an id
+
+	announced     int       // Endpoints accepted from the peer in the current period
+	announceReset time.Time // End of the current announcement period
+}
+
+// allowAnnounce reports whether n more endpoints announced by the peer fit in
+// its allowance, accounting for them if so.
+func (op *overlayPeer) allowAnnounce(n int, now time.Time) bool {
+	if now.After(op.announceReset) {
+		op.announced, op.announceReset = 0, now.Add(overlayNodesPeriod)
+	}
+	if op.announced+n > overlayMaxNodes {
+		return false
+	}
+	op.announced += n
+	return true
+}
+
+// masternodeOverlay tracks the masternodes among the connected peers and the
+// static connections kept to them.
+type masternodeOverlay struct {
+	masternodes func(header *types.Header) []common.Address // Masternode set at a header
+	resolve     func(key common.Address) common.Address     // Masternode a signing key acts for
+	etherbase   func() (common.Address, error)              // Local masternode key
+	signProof   func(account common.Address, from, to discover.NodeID) ([]byte, error)
+	chain       *core.BlockChain
+
+	server  overlayServer
+	started chan struct{}
+	quit    chan struct{}
+
+	lock    sync.RWMutex
+	epoch   uint64                             // Epoch of the cached masternode set
+	members map[common.Address]struct{}        // Masternodes of the current epoch
+	self    common.Address                     // Local masternode, zero if not a member
+	peers   map[string]*overlayPeer            // Handshaken peers by id
+	dialed  map[discover.NodeID]*discover.Node // Static connections kept by the overlay
+}
+
+// newMasternodeOverlay creates the overlay on top of the PoSV engine. The
+// etherbase and signProof callbacks supply the local masternode key.
+func newMasternodeOverlay(engine *posv.Posv, chain *core.BlockChain, etherbase func() (common.Address, error), signProof func(common.Address, discover.NodeID, discover.NodeID) ([]byte, error)) *masternodeOverlay {
+	o := newOverlay(etherbase, signProof)
+	o.chain = chain
+	o.masternodes = func(header *types.Header) []common.Address {
+		return engine.GetMasternodes(chain, header)
+	}
+	o.resolve = func(key common.Address) common.Address {
+		candidate, err := engine.SigningCandidate(chain, chain.CurrentHeader(), key)
+		if err != nil {
+			log.Debug("Failed to resolve overlay member key", "key", key, "err", err)
+		}
+		return candidate
+	}
+	return o
+}
+
+func newOverlay(etherbase func() (common.Address, error), signProof func(common.Address, discover.NodeID, discover.NodeID) ([]byte, error)) *masternodeOverlay {
+	return &masternodeOverlay{
+		etherbase: etherbase,
+		signProof: signProof,
+		resolve:   func(key common.Address) common.Address { return key },
+		started:   make(chan struct{}),
+		quit:      make(chan struct{}),
+		members:   make(map[common.Address]struct{}),
+		peers:     make(map[string]*overlayPeer),
+		dialed:    make(map[discover.NodeID]*discover.Node),
+	}
+}
+
+// overlayProofHash is the hash a masternode signs to prove its key to the
+// peer it is talking to.
+func overlayProofHash(from, to discover.NodeID) []byte {
+	return accounts.OverlayProofHash(from[:], to[:])
+}
+
+// signOverlayProof signs the proof of the connection from node from to node to
+// with an account of the wallet. Wallets refusing raw hashes sign it through
+// accounts.OverlaySigner.
+func signOverlayProof(wallet accounts.Wallet, account accounts.Account, from, to discover.NodeID) ([]byte, error) {
+	if signer, ok := wallet.(accounts.OverlaySigner); ok {
+		return signer.SignOverlayProof(account, from[:], to[:])
+	}
+	return wallet.SignHash(account, overlayProofHash(from, to))
+}
+
+// recoverOverlaySigner returns the key which signed the proof sent by node
+// from to node to.
+func recoverOverlaySigner(from, to discover.NodeID, sig []byte) (common.Address, error) {
+	if len(sig) != 65 {
+		return common.Address{}, errOverlayProof
+	}
+	pub, err := crypto.SigToPub(overlayProofHash(from, to), sig)
+	if err != nil {
+		return common.Address{}, errOverlayProof
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// protocol returns the p2p protocol of the overlay.
+func (o *masternodeOverlay) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    overlayProtocolName,
+		Version: overlayProtocolVersion,
+		Length:  overlayProtocolLength,
+		Run:     o.handle,
+	}
+}
+
+// start begins tracking the masternode set of the chain.
+func (o *masternodeOverlay) start(server overlayServer) {
+	o.server = server
+	close(o.started)
+
+	if o.chain == nil {
+		return
+	}
+	o.refresh(o.chain.CurrentHeader())
+
+	headCh := make(chan core.ChainHeadEvent, 16)
+	headSub := o.chain.SubscribeChainHeadEvent(headCh)
+	go o.loop(headCh, headSub)
+}
+
+// stop terminates the overlay, the running handshakes are aborted.
+func (o *masternodeOverlay) stop() {
+	close(o.quit)
+}
+
+func (o *masternodeOverlay) loop(headCh chan core.ChainHeadEvent, headSub event.Subscription) {
+	defer headSub.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-headCh:
+			o.refresh(ev.Block.Header())
+		case <-headSub.Err():
+			return
+		case <-o.quit:
+			return
+		}
+	}
+}
+
+// refresh reloads the masternode set once the chain enters a new epoch, and
+// drops the static connections to the nodes which left it.
+func (o *masternodeOverlay) refresh(head *types.Header) {
+	epoch := o.chain.Config().Posv.Epoch
+	number := head.Number.Uint64()
+
+	o.lock.RLock()
+	fresh := len(o.members) > 0 && number/epoch == o.epoch
+	o.lock.RUnlock()
+	if fresh {
+		return
+	}
+	members := make(map[common.Address]struct{})
+	for _, m := range o.masternodes(head) {
+		members[m] = struct{}{}
+	}
+	o.setMembers(number/epoch, members)
+}
+
+// setMembers replaces the masternode set.
+func (o *masternodeOverlay) setMembers(epoch uint64, members map[common.Address]struct{}) {
+	self := common.Address{}
+	if eb, err := o.etherbase(); err == nil {
+		if m := o.resolve(eb); isMember(members, m) {
+			self = m
+		}
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.epoch, o.members, o.self = epoch, members, self
+	for id, node := range o.dialed {
+		if self != (common.Address{}) && o.provenMember(node.ID) {
+			continue
+		}
+		o.server.RemovePeer(node)
+		delete(o.dialed, id)
+	}
+	log.Debug("Masternode overlay updated", "epoch", epoch, "masternodes", len(members), "member", self != (common.Address{}))
+}
+
+func isMember(members map[common.Address]struct{}, m common.Address) bool {
+	_, ok := members[m]
+	return ok
+}
+
+// provenMember reports whether the given node proved a key of the current
+// masternode set. The lock must be held.
+func (o *masternodeOverlay) provenMember(id discover.NodeID) bool {
+	op := o.peers[peerID(id)]
+	return op != nil && op.proven && isMember(o.members, op.masternode)
+}
+
+// peerID returns the id the eth peer set uses for a node.
+func peerID(id discover.NodeID) string {
+	return fmt.Sprintf("%x", id[:8])
+}
+
+// isMasternode reports whether the peer with the given id is a proven
+// masternode of the current epoch.
+func (o *masternodeOverlay) isMasternode(id string) bool {
+	if o == nil {
+		return false
+	}
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	op := o.peers[id]
+	return op != nil && op.proven && isMember(o.members, op.masternode)
+}
+
+// waitMasternode waits up to timeout for the peer with the given id to finish
+// the overlay handshake, and reports whether it proved a key of the current
+// masternode set.
+func (o *masternodeOverlay) waitMasternode(id string, timeout time.Duration) bool {
+	if o == nil {
+		return false
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		o.lock.RLock()
+		op := o.peers[id]
+		o.lock.RUnlock()
+		if op != nil {
+			return o.isMasternode(id)
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return false
+		case <-o.quit:
+			return false
+		}
+	}
+}
+
+// prioritize reorders peers to put the masternodes first, keeping the
+// relative order of the others.
+func (o *masternodeOverlay) prioritize(peers []*peer) []*peer {
+	if o == nil || len(peers) < 2 {
+		return peers
+	}
+	first := make([]*peer, 0, len(peers))
+	rest := make([]*peer, 0, len(peers))
+	for _, p := range peers {
+		if o.isMasternode(p.id) {
+			first = append(first, p)
+		} else {
+			rest = append(rest, p)
+		}
+	}
+	return append(first, rest...)
+}
+
+// handle runs the overlay protocol with a peer. The overlay is only a hint
+// for propagation, so peers failing to prove a key are kept connected.
+func (o *masternodeOverlay) handle(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	select {
+	case <-o.started:
+	case <-o.quit:
+		return p2p.DiscQuitting
+	}
+	self := o.server.Self()
+
+	status, err := o.handshake(self, p, rw)
+	if err != nil {
+		p.Log().Debug("Masternode overlay handshake failed", "err", err)
+		return err
+	}
+	op := &overlayPeer{id: peerID(p.ID()), rw: rw, node: announcedNode(p, status.Enode)}
+	if len(status.Signature) > 0 {
+		key, err := recoverOverlaySigner(p.ID(), self.ID, status.Signature)
+		if err != nil {
+			return err
+		}
+		op.masternode, op.proven = o.resolve(key), true
+	}
+	o.register(op)
+	defer o.unregister(op)
+
+	for {
+		if err := o.handleMsg(p, rw); err != nil {
+			return err
+		}
+	}
+}
+
+// handshake exchanges the overlay status with the peer.
+func (o *masternodeOverlay) handshake(self *discover.Node, p *p2p.Peer, rw p2p.MsgReadWriter) (*overlayStatus, error) {
+	ours := &overlayStatus{Enode: self.String()}
+	if eb, err := o.etherbase(); err == nil && o.signProof != nil {
+		if sig, err := o.signProof(eb, self.ID, p.ID()); err == nil {
+			ours.Signature = sig
+		} else {
+			log.Warn("Failed to sign masternode overlay proof", "account", eb, "err", err)
+		}
+	}
+	var theirs overlayStatus
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- p2p.Send(rw, overlayStatusMsg, ours)
+	}()
+	go func() {
+		errc <- readOverlayStatus(rw, &theirs)
+	}()
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return nil, err
+			}
+		case <-timeout.C:
+			return nil, p2p.DiscReadTimeout
+		case <-o.quit:
+			return nil, errOverlayStopped
+		}
+	}
+	return &theirs, nil
+}
+
+func readOverlayStatus(rw p2p.MsgReadWriter, status *overlayStatus) error {
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if msg.Code != overlayStatusMsg {
+		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, overlayStatusMsg)
+	}
+	if msg.Size > overlayMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, overlayMaxMsgSize)
+	}
+	if err := msg.Decode(status); err != nil {
+		return errResp(ErrDecode, "msg %v: %v", msg, err)
+	}
+	return nil
+}
+
+// announcedNode returns the endpoint a peer announced, filling in the address
+// the connection came from if the peer doesn't know its own.
+func announcedNode(p *p2p.Peer, enode string) *discover.Node {
+	node, err := discover.ParseNode(enode)
+	if err != nil || node.ID != p.ID() || node.TCP == 0 {
+		return nil
+	}
+	if node.IP == nil || node.IP.IsUnspecified() {
+		addr, ok := p.RemoteAddr().(*net.TCPAddr)
+		if !ok {
+			return nil
+		}
+		node = discover.NewNode(node.ID, addr.IP, node.UDP, node.TCP)
+	}
+	return node
+}
+
+// register adds a handshaken peer. Masternodes keep a static connection to a
+// proven member and tell it about the other members they know; the other
+// members learn about the new one.
+func (o *masternodeOverlay) register(op *overlayPeer) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if existing, ok := o.peers[op.id]; ok {
+		existing.conns++
+		return
+	}
+	op.conns = 1
+	o.peers[op.id] = op
+
+	if !op.proven || !isMember(o.members, op.masternode) {
+		return
+	}
+	log.Debug("Masternode peer connected", "id", op.id, "masternode", op.masternode)
+	if o.self == (common.Address{}) {
+		return
+	}
+	var known []string
+	for _, other := range o.peers {
+		if other == op || other.node == nil || !other.proven || !isMember(o.members, other.masternode) {
+			continue
+		}
+		known = append(known, other.node.String())
+		if op.node != nil {
+			go p2p.Send(other.rw, overlayNodesMsg, []string{op.node.String()})
+		}
+	}
+	if len(known) > 0 {
+		go p2p.Send(op.rw, overlayNodesMsg, known)
+	}
+	if op.node != nil {
+		o.dial(op.node)
+	}
+}
+
+// unregister removes a peer once its last connection is gone.
+func (o *masternodeOverlay) unregister(op *overlayPeer) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	existing, ok := o.peers[op.id]
+	if !ok {
+		return
+	}
+	if existing.conns--; existing.conns == 0 {
+		delete(o.peers, op.id)
+	}
+}
+
+// dial keeps a static connection to a masternode, never more than the size of
+// the masternode set. The lock must be held.
+func (o *masternodeOverlay) dial(node *discover.Node) {
+	if _, ok := o.dialed[node.ID]; ok {
+		return
+	}
+	if len(o.dialed) >= len(o.members) {
+		log.Debug("Masternode overlay dial limit reached", "id", node.ID, "dialed", len(o.dialed))
+		return
+	}
+	o.dialed[node.ID] = node
+	o.server.AddPeer(node)
+}
+
+func (o *masternodeOverlay) handleMsg(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > overlayMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, overlayMaxMsgSize)
+	}
+	defer msg.Discard()
+
+	switch msg.Code {
+	case overlayStatusMsg:
+		return errResp(ErrExtraStatusMsg, "uncontrolled status message")
+
+	case overlayNodesMsg:
+		var enodes []string
+		if err := msg.Decode(&enodes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(enodes) > overlayMaxNodes {
+			return errResp(ErrMsgTooLarge, "%d endpoints > %d", len(enodes), overlayMaxNodes)
+		}
+		o.lock.Lock()
+		defer o.lock.Unlock()
+
+		// Only members dial the endpoints, the dialed nodes have to prove
+		// their key once connected or get dropped on the next epoch.
+		if o.self == (common.Address{}) || !o.provenMember(p.ID()) {
+			return nil
+		}
+		if op := o.peers[peerID(p.ID())]; !op.allowAnnounce(len(enodes), time.Now()) {
+			p.Log().Debug("Dropping masternode announcement over the rate limit", "endpoints", len(enodes))
+			return nil
+		}
+		self := o.server.Self().ID
+		for _, enode := range enodes {
+			node, err := discover.ParseNode(enode)
+			if err != nil || node.ID == self || node.TCP == 0 {
+				continue
+			}
+			if _, ok := o.peers[peerID(node.ID)]; ok {
+				continue
+			}
+			o.dial(node)
+		}
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+	return nil
+}
+
+// priorityTx reports whether a transaction is pushed to the masternodes
+// first: the block-signer transactions drive finality. IsSigningTransaction
+// expects a method selector, shorter payloads are never signer transactions.
+func priorityTx(tx *types.Transaction) bool {
+	return len(tx.Data()) >= 4 && tx.IsSigningTransaction()
+}
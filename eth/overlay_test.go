@@ -0,0 +1,334 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tomochain/tomochain/accounts/keystore"
+	"github.com/tomochain/tomochain/accounts/remote"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/p2p"
+	"github.com/tomochain/tomochain/p2p/discover"
+	"github.com/tomochain/tomochain/rpc"
+)
+
+// testOverlayServer records the static connections requested by an overlay.
+type testOverlayServer struct {
+	self *discover.Node
+
+	lock  sync.Mutex
+	added map[discover.NodeID]bool
+}
+
+func (s *testOverlayServer) Self() *discover.Node { return s.self }
+
+func (s *testOverlayServer) AddPeer(node *discover.Node) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.added[node.ID] = true
+}
+
+func (s *testOverlayServer) RemovePeer(node *discover.Node) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.added, node.ID)
+}
+
+func (s *testOverlayServer) dialed(id discover.NodeID) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.added[id]
+}
+
+// testOverlayNode is an overlay with its own node identity and masternode key.
+type testOverlayNode struct {
+	overlay *masternodeOverlay
+	server  *testOverlayServer
+	address common.Address
+}
+
+func newTestOverlayNode(port int) *testOverlayNode {
+	nodeKey, _ := crypto.GenerateKey()
+	key, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+
+	server := &testOverlayServer{
+		self:  discover.NewNode(discover.PubkeyID(&nodeKey.PublicKey), net.IP{127, 0, 0, 1}, uint16(port), uint16(port)),
+		added: make(map[discover.NodeID]bool),
+	}
+	overlay := newOverlay(
+		func() (common.Address, error) { return address, nil },
+		func(account common.Address, from, to discover.NodeID) ([]byte, error) {
+			if account != address {
+				return nil, fmt.Errorf("unknown account %x", account)
+			}
+			return crypto.Sign(overlayProofHash(from, to), key)
+		},
+	)
+	overlay.start(server)
+	return &testOverlayNode{overlay: overlay, server: server, address: address}
+}
+
+func (n *testOverlayNode) setMembers(members ...common.Address) {
+	set := make(map[common.Address]struct{})
+	for _, m := range members {
+		set[m] = struct{}{}
+	}
+	n.overlay.setMembers(1, set)
+}
+
+// connectOverlays runs the overlay protocol between two nodes, returning a
+// function tearing the connection down.
+func connectOverlays(a, b *testOverlayNode) func() {
+	rwa, rwb := p2p.MsgPipe()
+	errc := make(chan error, 2)
+	go func() { errc <- a.overlay.handle(p2p.NewPeer(b.server.self.ID, "b", nil), rwa) }()
+	go func() { errc <- b.overlay.handle(p2p.NewPeer(a.server.self.ID, "a", nil), rwb) }()
+	return func() {
+		rwa.Close()
+		rwb.Close()
+		<-errc
+		<-errc
+	}
+}
+
+func waitOverlay(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func TestOverlayProof(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var from, to discover.NodeID
+	from[0], to[0] = 1, 2
+
+	sig, err := crypto.Sign(overlayProofHash(from, to), key)
+	if err != nil {
+		t.Fatalf("failed to sign proof: %v", err)
+	}
+	signer, err := recoverOverlaySigner(from, to, sig)
+	if err != nil {
+		t.Fatalf("failed to recover signer: %v", err)
+	}
+	if want := crypto.PubkeyToAddress(key.PublicKey); signer != want {
+		t.Fatalf("signer mismatch: have %x, want %x", signer, want)
+	}
+	// A proof made for another connection must not verify as this key
+	if replayed, err := recoverOverlaySigner(to, from, sig); err == nil && replayed == signer {
+		t.Fatalf("proof replayed on the reverse connection")
+	}
+	if _, err := recoverOverlaySigner(from, to, sig[:64]); err != errOverlayProof {
+		t.Fatalf("short signature error mismatch: have %v, want %v", err, errOverlayProof)
+	}
+}
+
+func TestOverlayProofRemoteWallet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay-remote-signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Unlock(account, ""); err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	defer server.Stop()
+	for _, api := range remote.NewSigner(ks, rawdb.NewMemoryDatabase(), nil).APIs() {
+		if err := server.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	endpoint := filepath.Join(dir, "signer.ipc")
+	listener, err := rpc.CreateIPCListener(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.ServeListener(listener)
+
+	backend, err := remote.NewBackend(endpoint)
+	if err != nil {
+		t.Fatalf("failed to connect to the remote signer: %v", err)
+	}
+	wallet := backend.Wallets()[0]
+
+	var from, to discover.NodeID
+	from[0], to[0] = 1, 2
+	if _, err := wallet.SignHash(account, overlayProofHash(from, to)); err != remote.ErrHashSigning {
+		t.Fatalf("remote wallet signed a raw hash: %v", err)
+	}
+	sig, err := signOverlayProof(wallet, account, from, to)
+	if err != nil {
+		t.Fatalf("failed to sign overlay proof: %v", err)
+	}
+	signer, err := recoverOverlaySigner(from, to, sig)
+	if err != nil {
+		t.Fatalf("failed to recover signer: %v", err)
+	}
+	if signer != account.Address {
+		t.Fatalf("signer mismatch: have %x, want %x", signer, account.Address)
+	}
+}
+
+func TestOverlayHandshake(t *testing.T) {
+	a := newTestOverlayNode(30301)
+	b := newTestOverlayNode(30302)
+	c := newTestOverlayNode(30303)
+
+	// a and b are masternodes, c is a plain node with a key of its own
+	for _, n := range []*testOverlayNode{a, b, c} {
+		n.setMembers(a.address, b.address)
+	}
+	disconnect := connectOverlays(a, b)
+	defer disconnect()
+	disconnectC := connectOverlays(a, c)
+	defer disconnectC()
+
+	bID, cID := peerID(b.server.self.ID), peerID(c.server.self.ID)
+	waitOverlay(t, "masternode peer", func() bool { return a.overlay.isMasternode(bID) })
+	waitOverlay(t, "plain peer", func() bool {
+		a.overlay.lock.RLock()
+		defer a.overlay.lock.RUnlock()
+		return a.overlay.peers[cID] != nil
+	})
+	if a.overlay.isMasternode(cID) {
+		t.Fatalf("plain node accepted as masternode")
+	}
+	// Only proven masternodes are exempted from the peer limit
+	if !a.overlay.waitMasternode(bID, time.Second) {
+		t.Fatalf("masternode peer not exempted from the peer limit")
+	}
+	if a.overlay.waitMasternode(cID, time.Second) {
+		t.Fatalf("plain peer exempted from the peer limit")
+	}
+	if a.overlay.waitMasternode("unknown", 10*time.Millisecond) {
+		t.Fatalf("peer without overlay handshake exempted from the peer limit")
+	}
+	if !b.overlay.isMasternode(peerID(a.server.self.ID)) {
+		t.Fatalf("masternode not accepted by its peer")
+	}
+	// Members keep static connections to each other only
+	if !a.server.dialed(b.server.self.ID) || !b.server.dialed(a.server.self.ID) {
+		t.Fatalf("masternodes not kept connected")
+	}
+	if a.server.dialed(c.server.self.ID) || c.server.dialed(a.server.self.ID) {
+		t.Fatalf("plain node kept connected")
+	}
+	// Leaving the masternode set drops the static connections
+	a.setMembers(a.address)
+	if a.server.dialed(b.server.self.ID) {
+		t.Fatalf("former masternode still kept connected")
+	}
+	if a.overlay.isMasternode(bID) {
+		t.Fatalf("former masternode still prioritized")
+	}
+}
+
+func TestOverlayGossip(t *testing.T) {
+	a := newTestOverlayNode(30311)
+	b := newTestOverlayNode(30312)
+	c := newTestOverlayNode(30313)
+	for _, n := range []*testOverlayNode{a, b, c} {
+		n.setMembers(a.address, b.address, c.address)
+	}
+	disconnectB := connectOverlays(a, b)
+	defer disconnectB()
+	waitOverlay(t, "first masternode", func() bool { return a.overlay.isMasternode(peerID(b.server.self.ID)) })
+
+	// c connecting to a is announced to b, and c learns about b
+	disconnectC := connectOverlays(a, c)
+	defer disconnectC()
+	waitOverlay(t, "announced masternode", func() bool { return b.server.dialed(c.server.self.ID) })
+	waitOverlay(t, "known masternode", func() bool { return c.server.dialed(b.server.self.ID) })
+}
+
+func TestOverlayDialLimit(t *testing.T) {
+	a := newTestOverlayNode(30331)
+	a.setMembers(a.address, common.Address{1})
+
+	a.overlay.lock.Lock()
+	for i := 0; i < 4; i++ {
+		key, _ := crypto.GenerateKey()
+		a.overlay.dial(discover.NewNode(discover.PubkeyID(&key.PublicKey), net.IP{127, 0, 0, 1}, uint16(30340+i), uint16(30340+i)))
+	}
+	dialed := len(a.overlay.dialed)
+	a.overlay.lock.Unlock()
+
+	if dialed != 2 {
+		t.Fatalf("dialed nodes mismatch: have %d, want %d", dialed, 2)
+	}
+}
+
+func TestOverlayAnnounceLimit(t *testing.T) {
+	var (
+		op  = new(overlayPeer)
+		now = time.Now()
+	)
+	if !op.allowAnnounce(overlayMaxNodes-10, now) {
+		t.Fatalf("announcement within the allowance rejected")
+	}
+	if op.allowAnnounce(11, now.Add(time.Second)) {
+		t.Fatalf("announcement over the allowance accepted")
+	}
+	if !op.allowAnnounce(10, now.Add(time.Second)) {
+		t.Fatalf("announcement filling the allowance rejected")
+	}
+	if !op.allowAnnounce(overlayMaxNodes, now.Add(overlayNodesPeriod+time.Second)) {
+		t.Fatalf("allowance not renewed after the period")
+	}
+}
+
+func TestOverlayPrioritize(t *testing.T) {
+	a := newTestOverlayNode(30321)
+	b := newTestOverlayNode(30322)
+	a.setMembers(a.address, b.address)
+	b.setMembers(a.address, b.address)
+
+	disconnect := connectOverlays(a, b)
+	defer disconnect()
+	waitOverlay(t, "masternode peer", func() bool { return a.overlay.isMasternode(peerID(b.server.self.ID)) })
+
+	peers := []*peer{{id: "plain1"}, {id: peerID(b.server.self.ID)}, {id: "plain2"}}
+	ordered := a.overlay.prioritize(peers)
+	if ordered[0] != peers[1] || ordered[1] != peers[0] || ordered[2] != peers[2] {
+		t.Fatalf("unexpected order: %s, %s, %s", ordered[0].id, ordered[1].id, ordered[2].id)
+	}
+	// Nodes without PoSV have no overlay and keep the order
+	var none *masternodeOverlay
+	if ordered := none.prioritize(peers); ordered[0] != peers[0] {
+		t.Fatalf("nil overlay reordered peers")
+	}
+}
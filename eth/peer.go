@@ -25,6 +25,7 @@ import (
 
 	mapset "github.com/deckarep/golang-set"
 	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/forkid"
 	"github.com/tomochain/tomochain/core/types"
 	"github.com/tomochain/tomochain/p2p"
 	"github.com/tomochain/tomochain/rlp"
@@ -322,13 +323,25 @@ func (p *peer) RequestReceipts(hashes []common.Hash) error {
 }
 
 // Handshake executes the eth protocol handshake, negotiating version number,
-// network IDs, difficulties, head and genesis blocks.
-func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash) error {
+// network IDs, difficulties, head and genesis blocks. Since eth/64 the fork
+// identifiers are exchanged as well and validated with forkFilter.
+func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash, forkID forkid.ID, forkFilter forkid.Filter) error {
 	// Send out own handshake in a new thread
 	errc := make(chan error, 2)
 	var status statusData // safe to read after two values have been received from errc
 
 	go func() {
+		if p.version >= eth64 {
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData64{
+				ProtocolVersion: uint32(p.version),
+				NetworkId:       network,
+				TD:              td,
+				CurrentBlock:    head,
+				GenesisBlock:    genesis,
+				ForkID:          forkID,
+			})
+			return
+		}
 		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
 			ProtocolVersion: uint32(p.version),
 			NetworkId:       network,
@@ -338,6 +351,10 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 		})
 	}()
 	go func() {
+		if p.version >= eth64 {
+			errc <- p.readStatus64(network, &status, genesis, forkFilter)
+			return
+		}
 		errc <- p.readStatus(network, &status, genesis)
 	}()
 	timeout := time.NewTimer(handshakeTimeout)
@@ -383,6 +400,51 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 	return nil
 }
 
+// readStatus64 reads and validates an eth/64 status message, which carries
+// the fork identifier of the remote node in addition to the legacy fields.
+func (p *peer) readStatus64(network uint64, status *statusData, genesis common.Hash, forkFilter forkid.Filter) (err error) {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Code != StatusMsg {
+		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	// Decode the handshake and make sure everything matches
+	var status64 statusData64
+	if err := msg.Decode(&status64); err != nil {
+		return errResp(ErrDecode, "msg %v: %v", msg, err)
+	}
+	if status64.GenesisBlock != genesis {
+		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", status64.GenesisBlock[:8], genesis[:8])
+	}
+	if status64.NetworkId != network {
+		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", status64.NetworkId, network)
+	}
+	if int(status64.ProtocolVersion) != p.version {
+		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status64.ProtocolVersion, p.version)
+	}
+	if err := forkFilter(status64.ForkID); err != nil {
+		if err == forkid.ErrRemoteStale {
+			forkStalePeerMeter.Mark(1)
+		} else {
+			forkIncompatiblePeerMeter.Mark(1)
+		}
+		return errResp(ErrForkIDRejected, "%v", err)
+	}
+	*status = statusData{
+		ProtocolVersion: status64.ProtocolVersion,
+		NetworkId:       status64.NetworkId,
+		TD:              status64.TD,
+		CurrentBlock:    status64.CurrentBlock,
+		GenesisBlock:    status64.GenesisBlock,
+	}
+	return nil
+}
+
 // String implements fmt.Stringer.
 func (p *peer) String() string {
 	return fmt.Sprintf("Peer %s [%s]", p.id,
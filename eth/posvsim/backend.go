@@ -0,0 +1,104 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package posvsim
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/common/hexutil"
+	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/core/vm"
+	"github.com/tomochain/tomochain/ethclient"
+	"github.com/tomochain/tomochain/rpc"
+)
+
+// ChainAPI is the subset of the eth RPC namespace used by the contract bindings
+// inside the consensus hooks (randomize, validator and block signer contracts).
+// The simulated nodes have no IPC endpoint, so each chain gets an in-process
+// client backed by this service.
+type ChainAPI struct {
+	chain *core.BlockChain
+}
+
+// CallArgs represents the arguments of eth_call as sent by ethclient.
+type CallArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      hexutil.Uint64  `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Data     hexutil.Bytes   `json:"data"`
+}
+
+// newChainClient returns an ethclient served in-process from the given chain.
+func newChainClient(chain *core.BlockChain) (*ethclient.Client, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &ChainAPI{chain: chain}); err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(rpc.DialInProc(server)), nil
+}
+
+func (api *ChainAPI) stateAt(number rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
+	block := api.chain.CurrentBlock()
+	if number >= 0 {
+		block = api.chain.GetBlockByNumber(uint64(number))
+	}
+	if block == nil {
+		return nil, nil, errors.New("unknown block")
+	}
+	statedb, err := api.chain.StateAt(block.Root())
+	if err != nil {
+		return nil, nil, err
+	}
+	return statedb, block.Header(), nil
+}
+
+// Call executes a read only message call against the state of the given block.
+func (api *ChainAPI) Call(ctx context.Context, args CallArgs, number rpc.BlockNumber) (hexutil.Bytes, error) {
+	statedb, header, err := api.stateAt(number)
+	if err != nil {
+		return nil, err
+	}
+	gas := uint64(args.Gas)
+	if gas == 0 {
+		gas = 50000000
+	}
+	value := new(big.Int)
+	if args.Value != nil {
+		value = args.Value.ToInt()
+	}
+	msg := types.NewMessage(args.From, args.To, 0, value, gas, new(big.Int), args.Data, false, nil)
+	evm := vm.NewEVM(core.NewEVMContext(msg, header, api.chain, nil), statedb, nil, api.chain.Config(), vm.Config{})
+	gp := new(core.GasPool).AddGas(math.MaxUint64)
+	res, _, _, err := core.ApplyMessage(evm, msg, gp, common.Address{})
+	return res, err
+}
+
+// GetCode returns the code of a contract at the given block.
+func (api *ChainAPI) GetCode(ctx context.Context, address common.Address, number rpc.BlockNumber) (hexutil.Bytes, error) {
+	statedb, _, err := api.stateAt(number)
+	if err != nil {
+		return nil, err
+	}
+	return statedb.GetCode(address), nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package posvsim
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+
+	"github.com/tomochain/tomochain/accounts/abi/bind"
+	"github.com/tomochain/tomochain/accounts/abi/bind/backends"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/contracts/blocksigner"
+	"github.com/tomochain/tomochain/contracts/randomize"
+	"github.com/tomochain/tomochain/contracts/validator"
+	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/params"
+	"github.com/tomochain/tomochain/rlp"
+)
+
+// deployerKey only pays for the contract deployments on the throw-away
+// simulated backend, its account does not exist on the simulated network.
+var deployerKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+
+// makeGenesis builds a PoSV genesis with the validator, block signer and
+// randomize contracts pre-deployed the same way puppeth does for real networks.
+func makeGenesis(config *params.ChainConfig, masternodes []common.Address, owner *ecdsa.PrivateKey, stake *big.Int, timestamp uint64) (*core.Genesis, error) {
+	ownerAddr := crypto.PubkeyToAddress(owner.PublicKey)
+	genesis := &core.Genesis{
+		Config:     config,
+		Timestamp:  timestamp,
+		GasLimit:   4700000,
+		Difficulty: big.NewInt(1),
+		Alloc: core.GenesisAlloc{
+			ownerAddr:                       {Balance: new(big.Int).Mul(big.NewInt(1000000), big.NewInt(params.Ether))},
+			config.Posv.FoudationWalletAddr: {Balance: new(big.Int)},
+		},
+		ExtraData: make([]byte, 32+len(masternodes)*common.AddressLength+65),
+	}
+	caps := make([]*big.Int, len(masternodes))
+	for i, masternode := range masternodes {
+		caps[i] = new(big.Int).Set(stake)
+		copy(genesis.ExtraData[32+i*common.AddressLength:], masternode[:])
+		genesis.Alloc[masternode] = core.GenesisAccount{Balance: new(big.Int)}
+	}
+
+	deployer := crypto.PubkeyToAddress(deployerKey.PublicKey)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{deployer: {Balance: big.NewInt(1000000000)}})
+	opts := bind.NewKeyedTransactor(deployerKey)
+
+	validatorAddr, _, err := validator.DeployValidator(opts, backend, masternodes, caps, ownerAddr)
+	if err != nil {
+		return nil, err
+	}
+	backend.Commit()
+	blockSignerAddr, _, err := blocksigner.DeployBlockSigner(opts, backend, new(big.Int).SetUint64(config.Posv.Epoch))
+	if err != nil {
+		return nil, err
+	}
+	backend.Commit()
+	randomizeAddr, _, err := randomize.DeployRandomize(opts, backend)
+	if err != nil {
+		return nil, err
+	}
+	backend.Commit()
+
+	totalStake := new(big.Int).Mul(stake, big.NewInt(int64(len(masternodes))))
+	for addr, deployed := range map[string]common.Address{
+		common.MasternodeVotingSMC: validatorAddr,
+		common.BlockSigners:        blockSignerAddr,
+		common.RandomizeSMC:        randomizeAddr,
+	} {
+		account, err := dumpContract(backend, deployed)
+		if err != nil {
+			return nil, err
+		}
+		if addr == common.MasternodeVotingSMC {
+			account.Balance = totalStake
+		}
+		genesis.Alloc[common.HexToAddress(addr)] = account
+	}
+	return genesis, nil
+}
+
+// dumpContract copies the code and storage of a contract deployed on the
+// simulated backend into a genesis account.
+func dumpContract(backend *backends.SimulatedBackend, addr common.Address) (core.GenesisAccount, error) {
+	ctx := context.Background()
+	code, err := backend.CodeAt(ctx, addr, nil)
+	if err != nil {
+		return core.GenesisAccount{}, err
+	}
+	storage := make(map[common.Hash]common.Hash)
+	err = backend.ForEachStorageAt(ctx, addr, nil, func(key, val common.Hash) bool {
+		decode := []byte{}
+		rlp.DecodeBytes(bytes.TrimLeft(val.Bytes(), "\x00"), &decode)
+		storage[key] = common.BytesToHash(decode)
+		return true
+	})
+	if err != nil {
+		return core.GenesisAccount{}, err
+	}
+	return core.GenesisAccount{Balance: new(big.Int), Code: code, Storage: storage}, nil
+}
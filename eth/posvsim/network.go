@@ -0,0 +1,411 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package posvsim runs a deterministic, in-memory network of PoSV masternodes.
+//
+// Every node owns a full blockchain backed by a memory database and runs the
+// same consensus hooks as a real node (rewards, penalties, M1 refresh and
+// double validation). Block production is driven by a simulated clock so the
+// turn taking, out-of-turn waits and checkpoint logic behave the same way on
+// every run, which makes the network suitable for tests of consensus changes
+// and for reproducing penalty or fork scenarios.
+//
+// Masternodes send their randomize secrets and openings like real nodes, with
+// values derived from their index and the epoch, so the M2 validator
+// assignment rotates between epochs the same way on every run. Which
+// masternodes are online and can reach each other is tracked by a
+// p2p/simulations network.
+package posvsim
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/p2p/simulations"
+	"github.com/tomochain/tomochain/params"
+)
+
+const (
+	// waitPeriod and waitPeriodCheckpoint mirror the out-of-turn waits of the
+	// miner: a masternode h hops away from the in-turn one may only create a
+	// block after waitPeriod*h seconds (or waitPeriodCheckpoint*h when the hop
+	// range crosses a checkpoint).
+	waitPeriod           = 10
+	waitPeriodCheckpoint = 20
+
+	// genesisTime is the fixed timestamp of the simulated genesis block.
+	genesisTime = 1546300800
+)
+
+var (
+	errNoProgress    = errors.New("no block produced")
+	errUnknownNode   = errors.New("unknown node")
+	errUnknownBlock  = errors.New("unknown block")
+	drainCheckpoints sync.Once
+)
+
+// Config contains the parameters of a simulated network. Zero fields are
+// replaced by the defaults listed next to them.
+type Config struct {
+	Masternodes int      // Number of masternodes in the genesis set (default 3)
+	Epoch       uint64   // Epoch length in blocks, a multiple of common.EpocBlockRandomize (default 900)
+	Gap         uint64   // Blocks before the checkpoint at which M1 is refreshed (default 5)
+	Period      uint64   // Seconds between blocks (default 2)
+	Reward      uint64   // Checkpoint reward in TOMO (default 250)
+	Stake       *big.Int // Genesis stake of every masternode in wei (default 50000 TOMO)
+
+	RewardPolicy *params.PosvRewardPolicy // Checkpoint reward policy (default mainnet policy)
+}
+
+func (c Config) withDefaults() Config {
+	if c.Masternodes == 0 {
+		c.Masternodes = 3
+	}
+	if c.Epoch == 0 {
+		c.Epoch = common.EpocBlockRandomize
+	}
+	if c.Gap == 0 {
+		c.Gap = 5
+	}
+	if c.Period == 0 {
+		c.Period = 2
+	}
+	if c.Reward == 0 {
+		c.Reward = 250
+	}
+	if c.Stake == nil {
+		c.Stake = new(big.Int).Mul(big.NewInt(50000), big.NewInt(params.Ether))
+	}
+	return c
+}
+
+// Network is a set of simulated masternodes sharing a genesis block and a
+// simulated clock. It is not safe for concurrent use.
+type Network struct {
+	Config      Config
+	ChainConfig *params.ChainConfig
+	Genesis     *core.Genesis
+	Owner       *ecdsa.PrivateKey // Owner of the genesis candidates
+	Nodes       []*Node
+
+	sim   *simulations.Network // Links between the masternodes
+	clock uint64               // Simulated unix time, accessed atomically
+}
+
+// NewNetwork creates a network with the given number of masternodes, all of
+// them online and in the same partition. Masternode keys are derived from
+// their index, so the same configuration always yields the same addresses.
+//
+// The signing, randomize and 2019 forks are activated from genesis the same
+// way the testnet configuration does, together with the signing key fork.
+// This changes the fork globals of the common package for the whole process.
+func NewNetwork(config Config) (*Network, error) {
+	config = config.withDefaults()
+	if config.Gap >= config.Epoch {
+		return nil, fmt.Errorf("gap %d must be below the epoch length %d", config.Gap, config.Epoch)
+	}
+	if config.Epoch%common.EpocBlockRandomize != 0 {
+		// M2 validators are only read from checkpoints on the randomize range
+		return nil, fmt.Errorf("epoch %d must be a multiple of %d blocks", config.Epoch, common.EpocBlockRandomize)
+	}
+	common.TIP2019Block = big.NewInt(0)
+	common.TIPSigning = big.NewInt(0)
+	common.TIPRandomize = big.NewInt(0)
+	common.TIPSigningKey = big.NewInt(0)
+
+	// Checkpoint imports block on this channel until somebody reads it
+	drainCheckpoints.Do(func() {
+		go func() {
+			for range core.CheckpointCh {
+			}
+		}()
+	})
+
+	keys := make([]*ecdsa.PrivateKey, config.Masternodes)
+	addrs := make([]common.Address, config.Masternodes)
+	for i := range keys {
+		keys[i] = deriveKey(fmt.Sprintf("masternode-%d", i))
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	owner := deriveKey("owner")
+
+	chainConfig := &params.ChainConfig{
+		ChainId:        big.NewInt(99),
+		HomesteadBlock: big.NewInt(0),
+		EIP150Block:    big.NewInt(0),
+		EIP155Block:    big.NewInt(0),
+		EIP158Block:    big.NewInt(0),
+		ByzantiumBlock: big.NewInt(0),
+		Posv: &params.PosvConfig{
+			Period:              config.Period,
+			Epoch:               config.Epoch,
+			Reward:              config.Reward,
+			RewardCheckpoint:    config.Epoch,
+			Gap:                 config.Gap,
+			FoudationWalletAddr: common.HexToAddress(common.FoudationAddr),
+			RewardPolicy:        config.RewardPolicy,
+		},
+	}
+	genesis, err := makeGenesis(chainConfig, addrs, owner, config.Stake, genesisTime)
+	if err != nil {
+		return nil, err
+	}
+	n := &Network{
+		Config:      config,
+		ChainConfig: chainConfig,
+		Genesis:     genesis,
+		Owner:       owner,
+		sim:         newTopology(),
+		clock:       genesisTime,
+	}
+	for i, key := range keys {
+		node, err := newNode(n, i, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := n.addSimNode(node); err != nil {
+			return nil, err
+		}
+		n.Nodes = append(n.Nodes, node)
+	}
+	return n, nil
+}
+
+// deriveKey returns a deterministic private key for the given label.
+func deriveKey(label string) *ecdsa.PrivateKey {
+	key, err := crypto.ToECDSA(crypto.Keccak256([]byte("posvsim " + label)))
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Now returns the current simulated time.
+func (n *Network) Now() time.Time {
+	return time.Unix(int64(atomic.LoadUint64(&n.clock)), 0)
+}
+
+// Node returns the node with the given address, or nil if there is none.
+func (n *Network) Node(addr common.Address) *Node {
+	for _, node := range n.Nodes {
+		if node.Address == addr {
+			return node
+		}
+	}
+	return nil
+}
+
+// RotateKey sends the transaction of the candidate owner registering a new
+// signing key for the node. The node switches to the key once it becomes
+// active on its chain.
+func (n *Network) RotateKey(index int, key *ecdsa.PrivateKey) error {
+	if index < 0 || index >= len(n.Nodes) {
+		return errUnknownNode
+	}
+	node := n.Nodes[index]
+	statedb, err := node.Chain.State()
+	if err != nil {
+		return err
+	}
+	owner := crypto.PubkeyToAddress(n.Owner.PublicKey)
+	nonce := statedb.GetNonce(owner)
+	for _, tx := range node.pool {
+		if from, _ := types.Sender(node.signer, tx); from == owner && tx.Nonce() >= nonce {
+			nonce = tx.Nonce() + 1
+		}
+	}
+	data := append(common.Hex2Bytes(common.HexSetSigningKey), node.Address.Hash().Bytes()...)
+	data = append(data, crypto.PubkeyToAddress(key.PublicKey).Hash().Bytes()...)
+	gas, err := core.IntrinsicGas(data, false, true)
+	if err != nil {
+		return err
+	}
+	tx, err := types.SignTx(types.NewTransaction(nonce, common.HexToAddress(common.SigningKeyRegistry), new(big.Int), gas, common.MinGasPrice, data), node.signer, n.Owner)
+	if err != nil {
+		return err
+	}
+	node.nextKey = key
+	node.broadcast(tx, n.partitionOf(node))
+	return nil
+}
+
+// Step advances the simulated clock by one block period and lets every
+// partition produce at most one block. It reports whether any block was made.
+func (n *Network) Step() (bool, error) {
+	atomic.AddUint64(&n.clock, n.Config.Period)
+
+	produced := false
+	for _, group := range n.partitions() {
+		ok, err := n.produce(group)
+		if err != nil {
+			return produced, err
+		}
+		produced = produced || ok
+	}
+	return produced, nil
+}
+
+// Run steps the network until the best alive node reaches the given block
+// number. It fails if the network stops producing blocks for longer than the
+// largest out-of-turn wait of a full masternode rotation.
+func (n *Network) Run(number uint64) error {
+	stalled := uint64(0)
+	limit := uint64(waitPeriodCheckpoint*(len(n.Nodes)+1))/n.Config.Period + 1
+	for n.Head() < number {
+		ok, err := n.Step()
+		if err != nil {
+			return err
+		}
+		if ok {
+			stalled = 0
+			continue
+		}
+		if stalled++; stalled > limit {
+			return fmt.Errorf("%v at block %d after %d steps", errNoProgress, n.Head(), stalled)
+		}
+	}
+	return nil
+}
+
+// Head returns the highest block number among the alive nodes.
+func (n *Network) Head() uint64 {
+	head := uint64(0)
+	for _, node := range n.Nodes {
+		if node.Alive() {
+			if number := node.Chain.CurrentBlock().NumberU64(); number > head {
+				head = number
+			}
+		}
+	}
+	return head
+}
+
+// sync brings every alive node of a partition to the best chain known in it
+// and shares the pending signer transactions between them.
+func (n *Network) sync() error {
+	for _, group := range n.partitions() {
+		best := group[0]
+		for _, node := range group[1:] {
+			if node.td().Cmp(best.td()) > 0 {
+				best = node
+			}
+		}
+		for _, node := range group {
+			if node == best {
+				continue
+			}
+			if err := node.syncWith(best); err != nil {
+				return err
+			}
+		}
+		for _, node := range group {
+			for _, tx := range node.pool {
+				for _, peer := range group {
+					peer.pool[tx.Hash()] = tx
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// produce selects the creator of the next block in a partition, seals the
+// block with the M2 validator signature and imports it on every member.
+func (n *Network) produce(group []*Node) (bool, error) {
+	parent := group[0].Chain.CurrentBlock()
+	for _, node := range group {
+		if err := node.updateSigningKey(); err != nil {
+			return false, err
+		}
+	}
+	for _, creator := range n.creators(group, parent) {
+		block, err := creator.mine(parent, group)
+		if err != nil {
+			return false, err
+		}
+		if block == nil {
+			// The assigned validator is unreachable, nobody would accept it
+			continue
+		}
+		for _, node := range group {
+			if err := node.importBlock(block); err != nil {
+				return false, fmt.Errorf("node %d: import block %d: %v", node.Index, block.NumberU64(), err)
+			}
+		}
+		for _, node := range group {
+			node.signBlock(block, group)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// creators returns the nodes of a partition allowed to create a block on top
+// of parent at the current simulated time, the in-turn node first and the
+// others by increasing hop distance.
+func (n *Network) creators(group []*Node, parent *types.Block) []*Node {
+	type candidate struct {
+		node *Node
+		hop  int
+	}
+	var (
+		candidates []candidate
+		now        = atomic.LoadUint64(&n.clock)
+		waited     = int64(now) - parent.Time().Int64()
+		nearest    = n.Config.Epoch - parent.NumberU64()%n.Config.Epoch
+	)
+	for _, node := range group {
+		total, preIndex, curIndex, ok, err := node.Engine.YourTurn(node.Chain, parent.Header(), node.Address)
+		if err != nil || curIndex == -1 {
+			continue
+		}
+		if ok {
+			candidates = append(candidates, candidate{node, 0})
+			continue
+		}
+		if preIndex == -1 || (preIndex == curIndex && total > 1) {
+			// First block belongs to the first masternode and nobody may
+			// create two blocks in a row
+			continue
+		}
+		hop := posv.Hop(total, preIndex, curIndex)
+		gap := int64(waitPeriod * hop)
+		if uint64(hop) >= nearest {
+			gap = int64(waitPeriodCheckpoint * hop)
+		}
+		if gap <= waited {
+			candidates = append(candidates, candidate{node, hop})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].hop < candidates[j].hop
+	})
+	nodes := make([]*Node, len(candidates))
+	for i, c := range candidates {
+		nodes[i] = c.node
+	}
+	return nodes
+}
@@ -0,0 +1,404 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package posvsim
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/contracts"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+func newTestNetwork(t *testing.T, masternodes int) *Network {
+	network, err := NewNetwork(Config{Masternodes: masternodes})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+	return network
+}
+
+func checkConverged(t *testing.T, network *Network) {
+	head := network.Nodes[0].Head()
+	for _, node := range network.Nodes[1:] {
+		if !node.Alive() {
+			continue
+		}
+		if have := node.Head(); have.Hash() != head.Hash() {
+			t.Fatalf("node %d head mismatch: have %d %x, want %d %x", node.Index, have.NumberU64(), have.Hash(), head.NumberU64(), head.Hash())
+		}
+	}
+}
+
+func contains(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSteadyNetworkRewards(t *testing.T) {
+	network := newTestNetwork(t, 3)
+	epoch := network.Config.Epoch
+	if err := network.Run(2 * epoch); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	checkConverged(t, network)
+
+	node := network.Nodes[0]
+	for _, number := range []uint64{epoch, 2 * epoch} {
+		if penalties := node.Penalties(number); len(penalties) != 0 {
+			t.Errorf("checkpoint %d: unexpected penalties %v", number, penalties)
+		}
+	}
+	if have := len(node.Masternodes()); have != 3 {
+		t.Errorf("masternode count mismatch: have %d, want 3", have)
+	}
+	// Every masternode signed the first epoch, so the foundation got its share
+	balance, err := node.Balance(network.ChainConfig.Posv.FoudationWalletAddr)
+	if err != nil {
+		t.Fatalf("failed to read balance: %v", err)
+	}
+	if balance.Sign() <= 0 {
+		t.Errorf("foundation not rewarded at checkpoint %d", 2*epoch)
+	}
+	// Blocks are produced at the configured period when everybody is online
+	want := network.Genesis.Timestamp + 2*epoch*network.Config.Period
+	if have := node.Head().Time().Uint64(); have != want {
+		t.Errorf("head time mismatch: have %d, want %d", have, want)
+	}
+}
+
+func TestOfflineMasternodePenalized(t *testing.T) {
+	network := newTestNetwork(t, 4)
+	epoch := network.Config.Epoch
+	offline := network.Nodes[2]
+
+	if err := network.Run(1); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	if err := network.Kill(offline.Index); err != nil {
+		t.Fatalf("failed to kill node: %v", err)
+	}
+	if err := network.Run(epoch + 1); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	node := network.Nodes[0]
+	if penalties := node.Penalties(epoch); !contains(penalties, offline.Address) || len(penalties) != 1 {
+		t.Fatalf("penalties mismatch: have %v, want [%x]", penalties, offline.Address)
+	}
+	if contains(node.Masternodes(), offline.Address) {
+		t.Errorf("penalized masternode still in the set")
+	}
+	// The revived node catches up with the network
+	if err := network.Revive(offline.Index); err != nil {
+		t.Fatalf("failed to revive node: %v", err)
+	}
+	checkConverged(t, network)
+}
+
+func TestPartitionHeal(t *testing.T) {
+	network := newTestNetwork(t, 3)
+
+	if err := network.Run(20); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	if err := network.Partition([]int{0, 1}, []int{2}); err != nil {
+		t.Fatalf("failed to partition network: %v", err)
+	}
+	for i := 0; i < 60; i++ {
+		if _, err := network.Step(); err != nil {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+	majority, minority := network.Nodes[0].Head(), network.Nodes[2].Head()
+	if majority.Hash() == minority.Hash() {
+		t.Fatalf("partitions did not fork")
+	}
+	if err := network.Heal(); err != nil {
+		t.Fatalf("failed to heal network: %v", err)
+	}
+	checkConverged(t, network)
+	if have := network.Nodes[2].Head().Hash(); have != majority.Hash() {
+		t.Errorf("minority fork won: have %x, want %x", have, majority.Hash())
+	}
+	if err := network.Run(majority.NumberU64() + 10); err != nil {
+		t.Fatalf("failed to run healed network: %v", err)
+	}
+	checkConverged(t, network)
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	network := newTestNetwork(t, 3)
+	epoch := network.Config.Epoch
+	rotated := network.Nodes[1]
+	oldKey := rotated.SigningKey
+	key := deriveKey("rotated-key")
+	keyAddr := crypto.PubkeyToAddress(key.PublicKey)
+
+	if err := network.Run(10); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	if err := network.RotateKey(rotated.Index, key); err != nil {
+		t.Fatalf("failed to rotate key: %v", err)
+	}
+	if err := network.Run(2*epoch + 1); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	checkConverged(t, network)
+
+	// The old key seals up to the checkpoint, the new one afterwards
+	node := network.Nodes[0]
+	var before, after int
+	for number := uint64(1); number <= node.Head().NumberU64(); number++ {
+		header := node.Chain.GetHeaderByNumber(number)
+		if header.Coinbase != rotated.Address {
+			continue
+		}
+		pubkey, err := crypto.SigToPub(posv.SigHash(header).Bytes(), header.Extra[len(header.Extra)-65:])
+		if err != nil {
+			t.Fatalf("block %d: failed to recover sealer: %v", number, err)
+		}
+		sealer := crypto.PubkeyToAddress(*pubkey)
+		switch {
+		case number < epoch && sealer == rotated.Address:
+			before++
+		case number > epoch && sealer == keyAddr:
+			after++
+		default:
+			t.Fatalf("block %d sealed by %x", number, sealer)
+		}
+	}
+	if before == 0 || after == 0 {
+		t.Fatalf("missing blocks of the rotated masternode: %d before, %d after the checkpoint", before, after)
+	}
+	// The checkpoint lists the new key, header verification rejects any other
+	checkpoint := node.Chain.GetHeaderByNumber(epoch)
+	listed := 32 + common.AddressLength*len(posv.GetMasternodesFromCheckpointHeader(checkpoint))
+	if keys := checkpoint.Extra[listed : len(checkpoint.Extra)-65]; !bytes.Contains(keys, keyAddr.Bytes()) {
+		t.Fatalf("checkpoint doesn't list the rotated key: %x", keys)
+	}
+	var sealed *types.Header
+	for number := node.Head().NumberU64(); number > epoch; number-- {
+		if header := node.Chain.GetHeaderByNumber(number); header.Coinbase == rotated.Address && number%epoch != 0 {
+			sealed = header
+			break
+		}
+	}
+	for name, sealer := range map[string]*ecdsa.PrivateKey{"rotated out key": oldKey, "outsider key": deriveKey("outsider")} {
+		forged := types.CopyHeader(sealed)
+		seal, err := crypto.Sign(posv.SigHash(forged).Bytes(), sealer)
+		if err != nil {
+			t.Fatalf("failed to seal header: %v", err)
+		}
+		copy(forged.Extra[len(forged.Extra)-65:], seal)
+		if err := node.Engine.VerifyHeader(node.Chain, forged, false); err == nil {
+			t.Errorf("header sealed with the %s for the rotated masternode accepted", name)
+		}
+	}
+	// Stake and signatures stay with the candidate
+	if penalties := node.Penalties(2 * epoch); len(penalties) != 0 {
+		t.Errorf("unexpected penalties %v", penalties)
+	}
+	if !contains(node.Masternodes(), rotated.Address) {
+		t.Errorf("rotated masternode dropped from the set")
+	}
+}
+
+func TestMissingSignerTransactions(t *testing.T) {
+	network := newTestNetwork(t, 3)
+	epoch := network.Config.Epoch
+	silent := network.Nodes[1]
+	silent.SkipSigning = true
+
+	if err := network.Run(2 * epoch); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	checkConverged(t, network)
+
+	node := network.Nodes[0]
+	signs, err := node.Signs(2 * epoch)
+	if err != nil {
+		t.Fatalf("failed to count signs: %v", err)
+	}
+	if have, ok := signs[silent.Address]; ok {
+		t.Errorf("silent masternode rewarded for %d signs", have)
+	}
+	for _, signer := range []*Node{network.Nodes[0], network.Nodes[2]} {
+		if have, want := signs[signer.Address], epoch/common.MergeSignRange; have != want {
+			t.Errorf("node %d sign count mismatch: have %d, want %d", signer.Index, have, want)
+		}
+	}
+	// Creating blocks is enough to stay in the masternode set
+	if penalties := node.Penalties(2 * epoch); len(penalties) != 0 {
+		t.Errorf("unexpected penalties %v", penalties)
+	}
+}
+
+func TestLateSignerTransactions(t *testing.T) {
+	tests := []struct {
+		delay uint64
+		lost  uint64 // Signs included after the rewarding checkpoint
+	}{
+		{delay: 30},
+		// The sign of the last block of the epoch is released on top of the
+		// rewarding checkpoint, which carries no transactions
+		{delay: common.EpocBlockRandomize - 1, lost: 1},
+	}
+	for _, tt := range tests {
+		network := newTestNetwork(t, 3)
+		epoch := network.Config.Epoch
+		late := network.Nodes[1]
+		late.SignDelay = tt.delay
+
+		if err := network.Run(2*epoch + 1); err != nil {
+			t.Fatalf("delay %d: failed to run network: %v", tt.delay, err)
+		}
+		checkConverged(t, network)
+
+		// The signer transactions land at least SignDelay blocks late
+		node := network.Nodes[0]
+		signer := types.NewEIP155Signer(network.ChainConfig.ChainId)
+		included := 0
+		for number := uint64(1); number <= node.Head().NumberU64(); number++ {
+			for _, tx := range node.Chain.GetBlockByNumber(number).Transactions() {
+				if from, _ := types.Sender(signer, tx); from != late.Address || !tx.IsSigningTransaction() {
+					continue
+				}
+				signed := new(big.Int).SetBytes(tx.Data()[4:36]).Uint64()
+				if number < signed+tt.delay+1 {
+					t.Fatalf("delay %d: sign of block %d included in block %d", tt.delay, signed, number)
+				}
+				included++
+			}
+		}
+		if included == 0 {
+			t.Fatalf("delay %d: no signer transactions included", tt.delay)
+		}
+		signs, err := node.Signs(2 * epoch)
+		if err != nil {
+			t.Fatalf("delay %d: failed to count signs: %v", tt.delay, err)
+		}
+		full := epoch / common.MergeSignRange
+		if have, want := signs[late.Address], full-tt.lost; have != want {
+			t.Errorf("delay %d: late masternode sign count mismatch: have %d, want %d", tt.delay, have, want)
+		}
+		if have := signs[network.Nodes[0].Address]; have != full {
+			t.Errorf("delay %d: sign count mismatch: have %d, want %d", tt.delay, have, full)
+		}
+	}
+}
+
+// checkValidators checks that every masternode listed by the checkpoint at the
+// head opened the secret it committed to in the epoch before, from its signing
+// key, and that the checkpoint assigns the M2 validators these secrets select.
+func checkValidators(t *testing.T, network *Network, checkpoint uint64) []byte {
+	node := network.Nodes[0]
+	client, err := node.Chain.GetClient()
+	if err != nil {
+		t.Fatalf("failed to get chain client: %v", err)
+	}
+	statedb, err := node.Chain.State()
+	if err != nil {
+		t.Fatalf("failed to get state: %v", err)
+	}
+	epoch := network.Config.Epoch
+	header := node.Chain.GetHeaderByNumber(checkpoint)
+	masternodes := posv.GetMasternodesFromCheckpointHeader(header)
+	secrets := make([]int64, len(masternodes))
+	for i, addr := range masternodes {
+		m := network.Node(addr)
+		key := state.GetSigningKey(statedb, addr, checkpoint-1)
+		have, err := contracts.GetRandomizeFromContract(client, key)
+		if err != nil {
+			t.Fatalf("failed to read randomize of node %d: %v", m.Index, err)
+		}
+		if secrets[i] = randomizeSecret(m.Index, checkpoint/epoch-1, epoch); have != secrets[i] {
+			t.Fatalf("checkpoint %d: node %d randomize mismatch: have %d, want %d", checkpoint, m.Index, have, secrets[i])
+		}
+	}
+	m2, err := contracts.GenM2FromRandomize(secrets, int64(len(masternodes)))
+	if err != nil {
+		t.Fatalf("failed to generate M2: %v", err)
+	}
+	if want := contracts.BuildValidatorFromM2(m2); !bytes.Equal(header.Validators, want) {
+		t.Fatalf("checkpoint %d: validators mismatch: have %x, want %x", checkpoint, header.Validators, want)
+	}
+	return header.Validators
+}
+
+func TestRandomizeRotatesValidators(t *testing.T) {
+	network := newTestNetwork(t, 5)
+	epoch := network.Config.Epoch
+
+	var assignments [][]byte
+	for checkpoint := epoch; checkpoint <= 2*epoch; checkpoint += epoch {
+		if err := network.Run(checkpoint); err != nil {
+			t.Fatalf("failed to run network: %v", err)
+		}
+		checkConverged(t, network)
+		assignments = append(assignments, checkValidators(t, network, checkpoint))
+	}
+	if bytes.Equal(assignments[0], assignments[1]) {
+		t.Errorf("M2 assignment did not rotate: %x", assignments[0])
+	}
+	// Blocks of the second epoch are validated by the rotated assignment
+	if err := network.Run(2*epoch + 10); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	checkConverged(t, network)
+}
+
+func TestRotatedKeyRandomize(t *testing.T) {
+	network := newTestNetwork(t, 5)
+	epoch := network.Config.Epoch
+	rotated := network.Nodes[3]
+	key := deriveKey("rotated-key")
+
+	if err := network.Run(10); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	if err := network.RotateKey(rotated.Index, key); err != nil {
+		t.Fatalf("failed to rotate key: %v", err)
+	}
+	for checkpoint := epoch; checkpoint <= 2*epoch; checkpoint += epoch {
+		if err := network.Run(checkpoint); err != nil {
+			t.Fatalf("failed to run network: %v", err)
+		}
+		checkConverged(t, network)
+		checkValidators(t, network, checkpoint)
+	}
+	// The rotated key sent the randomize transactions of the second epoch
+	statedb, err := network.Nodes[0].Chain.State()
+	if err != nil {
+		t.Fatalf("failed to get state: %v", err)
+	}
+	if have, want := state.GetSigningKey(statedb, rotated.Address, 2*epoch-1), crypto.PubkeyToAddress(key.PublicKey); have != want {
+		t.Fatalf("signing key mismatch: have %x, want %x", have, want)
+	}
+	if err := network.Run(2*epoch + 10); err != nil {
+		t.Fatalf("failed to run network: %v", err)
+	}
+	checkConverged(t, network)
+}
@@ -0,0 +1,430 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package posvsim
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"sort"
+
+	"github.com/tomochain/tomochain/accounts"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus/posv"
+	"github.com/tomochain/tomochain/contracts"
+	"github.com/tomochain/tomochain/core"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/core/vm"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/eth/hooks"
+	"github.com/tomochain/tomochain/p2p/discover"
+	"github.com/tomochain/tomochain/params"
+)
+
+// Node is a single simulated masternode.
+type Node struct {
+	Index   int
+	Key     *ecdsa.PrivateKey
+	Address common.Address
+	ID      discover.NodeID // Node of the p2p simulation network
+	Chain   *core.BlockChain
+	Engine  *posv.Posv
+
+	// SigningKey seals, validates and signs blocks for the node. It is the
+	// candidate key until another one is registered with Network.RotateKey.
+	SigningKey *ecdsa.PrivateKey
+
+	// SignDelay postpones the block signer and randomize transactions of the
+	// node by the given number of blocks.
+	SignDelay uint64
+	// SkipSigning stops the node from sending block signer transactions.
+	SkipSigning bool
+
+	network   *Network
+	signer    types.Signer
+	partition int // Partition the node rejoins when revived
+
+	pool    map[common.Hash]*types.Transaction // Signer transactions not yet seen in the chain
+	delayed []delayedTx                        // Own transactions held back by SignDelay
+	nextKey *ecdsa.PrivateKey                  // Registered signing key waiting for its activation
+
+	randomizeKey []byte // Key of the committed randomize secret, until it is opened
+}
+
+type delayedTx struct {
+	release uint64
+	tx      *types.Transaction
+}
+
+func newNode(network *Network, index int, key *ecdsa.PrivateKey) (*Node, error) {
+	var (
+		config = network.ChainConfig
+		db     = rawdb.NewMemoryDatabase()
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+	)
+	network.Genesis.MustCommit(db)
+
+	engine := posv.New(config.Posv, db)
+	engine.SetClock(network.Now)
+	authorize(engine, key)
+	engine.GetTomoXService = func() posv.TradingService {
+		return nil
+	}
+	engine.GetLendingService = func() posv.LendingService {
+		return nil
+	}
+	chain, err := core.NewBlockChain(db, nil, config, engine, vm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if chain.Client, err = newChainClient(chain); err != nil {
+		return nil, err
+	}
+	hooks.AttachPosvHooks(engine, chain, config)
+
+	return &Node{
+		Index:      index,
+		Key:        key,
+		Address:    addr,
+		Chain:      chain,
+		Engine:     engine,
+		SigningKey: key,
+		network:    network,
+		signer:     types.NewEIP155Signer(config.ChainId),
+		pool:       make(map[common.Hash]*types.Transaction),
+	}, nil
+}
+
+func authorize(engine *posv.Posv, key *ecdsa.PrivateKey) {
+	engine.Authorize(crypto.PubkeyToAddress(key.PublicKey), func(account accounts.Account, hash []byte) ([]byte, error) {
+		return crypto.Sign(hash, key)
+	})
+}
+
+// updateSigningKey switches to the registered signing key once it seals the
+// next block of the node's chain.
+func (n *Node) updateSigningKey() error {
+	if n.nextKey == nil {
+		return nil
+	}
+	statedb, err := n.Chain.State()
+	if err != nil {
+		return err
+	}
+	next := n.Chain.CurrentBlock().NumberU64() + 1
+	if state.GetSigningKey(statedb, n.Address, next) == crypto.PubkeyToAddress(n.nextKey.PublicKey) {
+		n.SigningKey, n.nextKey = n.nextKey, nil
+		authorize(n.Engine, n.SigningKey)
+	}
+	return nil
+}
+
+// Alive reports whether the node is online.
+func (n *Node) Alive() bool {
+	return n.network.sim.GetNode(n.ID).Up
+}
+
+// Head returns the current block of the node.
+func (n *Node) Head() *types.Block {
+	return n.Chain.CurrentBlock()
+}
+
+// Masternodes returns the masternode set the node uses for its next block.
+func (n *Node) Masternodes() []common.Address {
+	return n.Engine.GetMasternodes(n.Chain, n.Chain.CurrentHeader())
+}
+
+// Penalties returns the masternodes penalized in the given checkpoint block
+// of the node's chain.
+func (n *Node) Penalties(number uint64) []common.Address {
+	header := n.Chain.GetHeaderByNumber(number)
+	if header == nil {
+		return nil
+	}
+	return common.ExtractAddressFromBytes(header.Penalties)
+}
+
+// Signs returns, for every masternode, the number of block signatures the
+// given checkpoint of the node's chain rewards.
+func (n *Node) Signs(checkpoint uint64) (map[common.Address]uint64, error) {
+	header := n.Chain.GetHeaderByNumber(checkpoint)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	signers, err := contracts.GetRewardForCheckpoint(n.Engine, n.Chain, header, n.network.ChainConfig.Posv.RewardCheckpoint, new(uint64))
+	if err != nil {
+		return nil, err
+	}
+	signs := make(map[common.Address]uint64, len(signers))
+	for addr, signer := range signers {
+		signs[addr] = signer.Sign
+	}
+	return signs, nil
+}
+
+// Balance returns the balance of an account at the head of the node's chain.
+func (n *Node) Balance(addr common.Address) (*big.Int, error) {
+	statedb, err := n.Chain.State()
+	if err != nil {
+		return nil, err
+	}
+	return statedb.GetBalance(addr), nil
+}
+
+func (n *Node) td() *big.Int {
+	head := n.Chain.CurrentBlock()
+	return n.Chain.GetTd(head.Hash(), head.NumberU64())
+}
+
+// mine creates the next block on top of parent, sealed by the node and
+// validated by its M2 if that validator is reachable in the group. It returns
+// nil if the block could not be validated.
+func (n *Node) mine(parent *types.Block, group []*Node) (*types.Block, error) {
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   params.TargetGasLimit,
+	}
+	if err := n.Engine.Prepare(n.Chain, header); err != nil {
+		return nil, err
+	}
+	statedb, err := n.Chain.StateAt(parent.Root())
+	if err != nil {
+		return nil, err
+	}
+	parentState := statedb.Copy()
+	txs, receipts := n.commitTransactions(header, statedb)
+
+	block, err := n.Engine.Finalize(n.Chain, header, statedb, parentState, txs, nil, receipts)
+	if err != nil {
+		return nil, err
+	}
+	header = block.Header()
+	sighash := posv.SigHash(header).Bytes()
+	seal, err := crypto.Sign(sighash, n.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	copy(header.Extra[len(header.Extra)-65:], seal)
+
+	if header.Number.Uint64() >= n.network.Config.Epoch {
+		m2, err := n.Engine.GetValidator(n.Address, n.Chain, header)
+		if err != nil {
+			return nil, err
+		}
+		var validator *Node
+		for _, node := range group {
+			if node.Address == m2 {
+				validator = node
+			}
+		}
+		if validator == nil {
+			return nil, nil
+		}
+		if header.Validator, err = crypto.Sign(sighash, validator.SigningKey); err != nil {
+			return nil, err
+		}
+	}
+	return block.WithSeal(header), nil
+}
+
+// commitTransactions applies the pending signer transactions in sender and
+// nonce order, crediting the fees to the node as the miner does. Checkpoint
+// blocks carry no transactions.
+func (n *Node) commitTransactions(header *types.Header, statedb *state.StateDB) ([]*types.Transaction, []*types.Receipt) {
+	if header.Number.Uint64()%n.network.Config.Epoch == 0 {
+		return nil, nil
+	}
+	var (
+		txs      []*types.Transaction
+		receipts []*types.Receipt
+		gp       = new(core.GasPool).AddGas(header.GasLimit)
+		fees     = state.GetTRC21FeeCapacityFromState(statedb)
+	)
+	for _, list := range n.pending() {
+		for _, tx := range list {
+			statedb.Prepare(tx.Hash(), common.Hash{}, len(txs))
+			receipt, _, err, _ := core.ApplyTransaction(n.network.ChainConfig, fees, n.Chain, &n.Address, gp, statedb, nil, header, tx, &header.GasUsed, vm.Config{})
+			if err != nil {
+				// Later nonces of the same sender can't be applied either
+				break
+			}
+			txs = append(txs, tx)
+			receipts = append(receipts, receipt)
+		}
+	}
+	return txs, receipts
+}
+
+// pending returns the pool grouped by sender, ordered by sender address and
+// nonce, so every node builds the same block from the same pool.
+func (n *Node) pending() [][]*types.Transaction {
+	bySender := make(map[common.Address][]*types.Transaction)
+	for _, tx := range n.pool {
+		from, err := types.Sender(n.signer, tx)
+		if err != nil {
+			continue
+		}
+		bySender[from] = append(bySender[from], tx)
+	}
+	senders := make([]common.Address, 0, len(bySender))
+	for from := range bySender {
+		senders = append(senders, from)
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		return bytes.Compare(senders[i][:], senders[j][:]) < 0
+	})
+	lists := make([][]*types.Transaction, len(senders))
+	for i, from := range senders {
+		list := bySender[from]
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Nonce() < list[j].Nonce()
+		})
+		lists[i] = list
+	}
+	return lists
+}
+
+// importBlock inserts a block created in the node's partition.
+func (n *Node) importBlock(block *types.Block) error {
+	if n.Chain.HasBlock(block.Hash(), block.NumberU64()) {
+		return nil
+	}
+	if _, err := n.Chain.InsertChain(types.Blocks{block}); err != nil {
+		return err
+	}
+	n.prunePool()
+	return nil
+}
+
+// syncWith imports the blocks of the peer's chain that the node is missing.
+func (n *Node) syncWith(peer *Node) error {
+	var blocks types.Blocks
+	for block := peer.Chain.CurrentBlock(); block != nil && !n.Chain.HasBlock(block.Hash(), block.NumberU64()); {
+		blocks = append(blocks, block)
+		block = peer.Chain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	}
+	// Import one block at a time like the fetcher does, the checkpoint
+	// penalty hook reads the parents of a checkpoint from the chain itself
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if _, err := n.Chain.InsertChain(blocks[i : i+1]); err != nil {
+			return err
+		}
+	}
+	n.prunePool()
+	return nil
+}
+
+// prunePool drops the transactions whose nonce is already used on the chain.
+func (n *Node) prunePool() {
+	statedb, err := n.Chain.State()
+	if err != nil {
+		return
+	}
+	for hash, tx := range n.pool {
+		from, err := types.Sender(n.signer, tx)
+		if err != nil || tx.Nonce() < statedb.GetNonce(from) {
+			delete(n.pool, hash)
+		}
+	}
+}
+
+// signBlock creates the block signer transaction of the node for blocks on
+// the signing range, together with its randomize secret or opening, and
+// releases the delayed ones, as a masternode does when a new block arrives.
+func (n *Node) signBlock(block *types.Block, group []*Node) {
+	number := block.NumberU64()
+	kept := n.delayed[:0]
+	for _, d := range n.delayed {
+		if d.release <= number {
+			n.broadcast(d.tx, group)
+		} else {
+			kept = append(kept, d)
+		}
+	}
+	n.delayed = kept
+
+	if !n.isMasternode(block.Header()) {
+		return
+	}
+	statedb, err := n.Chain.State()
+	if err != nil {
+		return
+	}
+	sender := crypto.PubkeyToAddress(n.SigningKey.PublicKey)
+	if !n.SkipSigning && number%common.MergeSignRange == 0 {
+		tx, err := types.SignTx(contracts.CreateTxSign(block.Number(), block.Hash(), n.nextNonce(statedb, sender), common.HexToAddress(common.BlockSigners)), n.signer, n.SigningKey)
+		if err != nil {
+			return
+		}
+		n.send(tx, number, group)
+	}
+	tx, err := n.randomizeTx(number, n.nextNonce(statedb, sender))
+	if tx == nil || err != nil {
+		return
+	}
+	if tx, err = types.SignTx(tx, n.signer, n.SigningKey); err != nil {
+		return
+	}
+	n.send(tx, number, group)
+}
+
+// nextNonce returns the nonce of the next transaction of sender, counting the
+// ones waiting in the pool or held back by SignDelay.
+func (n *Node) nextNonce(statedb *state.StateDB, sender common.Address) uint64 {
+	nonce := statedb.GetNonce(sender)
+	pending := make([]*types.Transaction, 0, len(n.pool)+len(n.delayed))
+	for _, tx := range n.pool {
+		pending = append(pending, tx)
+	}
+	for _, d := range n.delayed {
+		pending = append(pending, d.tx)
+	}
+	for _, tx := range pending {
+		if from, _ := types.Sender(n.signer, tx); from == sender && tx.Nonce() >= nonce {
+			nonce = tx.Nonce() + 1
+		}
+	}
+	return nonce
+}
+
+// send broadcasts a transaction created after the given block, or holds it
+// back for SignDelay blocks.
+func (n *Node) send(tx *types.Transaction, number uint64, group []*Node) {
+	if n.SignDelay > 0 {
+		n.delayed = append(n.delayed, delayedTx{release: number + n.SignDelay, tx: tx})
+		return
+	}
+	n.broadcast(tx, group)
+}
+
+func (n *Node) isMasternode(header *types.Header) bool {
+	for _, addr := range n.Engine.GetMasternodes(n.Chain, header) {
+		if addr == n.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcast hands a transaction to every node of the group.
+func (n *Node) broadcast(tx *types.Transaction, group []*Node) {
+	for _, node := range group {
+		node.pool[tx.Hash()] = tx
+	}
+}
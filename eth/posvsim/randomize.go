@@ -0,0 +1,95 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package posvsim
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/contracts"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+// randomizeKey returns the key a masternode encrypts its secret of the given
+// epoch with, and later reveals as its opening.
+func randomizeKey(index int, epoch uint64) []byte {
+	return crypto.Keccak256([]byte(fmt.Sprintf("posvsim randomize key %d %d", index, epoch)))
+}
+
+// randomizeSecret returns the secret a masternode commits to in the given
+// epoch, a number below the epoch length like contracts.BuildTxSecretRandomize
+// picks.
+func randomizeSecret(index int, epoch uint64, length uint64) int64 {
+	seed := crypto.Keccak256([]byte(fmt.Sprintf("posvsim randomize secret %d %d", index, epoch)))
+	return new(big.Int).Mod(new(big.Int).SetBytes(seed), new(big.Int).SetUint64(length)).Int64()
+}
+
+// encryptSecret encrypts the secret the way contracts.Encrypt does, with an IV
+// derived from the key instead of a random one.
+func encryptSecret(key []byte, secret int64) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	plaintext := []byte(new(big.Int).SetInt64(secret).String())
+	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
+	copy(ciphertext, crypto.Keccak256(key)[:aes.BlockSize])
+	cipher.NewCFBEncrypter(block, ciphertext[:aes.BlockSize]).XORKeyStream(ciphertext[aes.BlockSize:], plaintext)
+	return base64.URLEncoding.EncodeToString(ciphertext), nil
+}
+
+// randomizeTx returns the randomize transaction the masternode sends after the
+// given block, as contracts.CreateTransactionSign does: its encrypted secret
+// once the secret range of the epoch starts and the opening once the opening
+// range starts. It returns nil if there is nothing to send.
+func (n *Node) randomizeTx(number uint64, nonce uint64) (*types.Transaction, error) {
+	var (
+		epochLength = n.network.Config.Epoch
+		epoch       = number / epochLength
+		check       = number % epochLength
+		randomize   = common.HexToAddress(common.RandomizeSMC)
+	)
+	switch {
+	case n.randomizeKey == nil && check > 0 && common.EpocBlockSecret <= check && check < common.EpocBlockOpening:
+		key := randomizeKey(n.Index, epoch)
+		secret, err := encryptSecret(key, randomizeSecret(n.Index, epoch, epochLength))
+		if err != nil {
+			return nil, err
+		}
+		// Dynamic bytes32[] holding a single secret
+		data := common.Hex2Bytes(common.HexSetSecret)
+		data = append(data, common.LeftPadBytes(big.NewInt(32).Bytes(), 32)...)
+		data = append(data, common.LeftPadBytes(big.NewInt(1).Bytes(), 32)...)
+		data = append(data, common.LeftPadBytes([]byte(secret), 32)...)
+
+		n.randomizeKey = key
+		return types.NewTransaction(nonce, randomize, new(big.Int), 200000, new(big.Int), data), nil
+
+	case n.randomizeKey != nil && check > 0 && common.EpocBlockOpening <= check && check <= common.EpocBlockRandomize:
+		tx, err := contracts.BuildTxOpeningRandomize(nonce, randomize, n.randomizeKey)
+		if err != nil {
+			return nil, err
+		}
+		n.randomizeKey = nil
+		return tx, nil
+	}
+	return nil, nil
+}
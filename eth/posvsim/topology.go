@@ -0,0 +1,221 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package posvsim
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tomochain/tomochain/node"
+	"github.com/tomochain/tomochain/p2p/discover"
+	"github.com/tomochain/tomochain/p2p/simulations"
+	"github.com/tomochain/tomochain/p2p/simulations/adapters"
+)
+
+// serviceName is the p2p simulation service of the masternodes.
+const serviceName = "posvsim"
+
+var errNotStarted = errors.New("simulated masternodes exchange blocks in-process")
+
+// newTopology returns the p2p simulation network modelling the links between
+// the masternodes. Blocks and transactions are handed over in-process to keep
+// the network deterministic, so the simulation nodes are never started: only
+// their connections are tracked, and reported on the event feed.
+func newTopology() *simulations.Network {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		serviceName: func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return nil, errNotStarted
+		},
+	})
+	return simulations.NewNetwork(adapter, &simulations.NetworkConfig{
+		ID:             serviceName,
+		DefaultService: serviceName,
+	})
+}
+
+// addSimNode registers the masternode in the topology, online and linked to
+// every other online masternode.
+func (n *Network) addSimNode(m *Node) error {
+	sim, err := n.sim.NewNodeWithConfig(&adapters.NodeConfig{
+		ID:         discover.PubkeyID(&m.Key.PublicKey),
+		PrivateKey: m.Key,
+		Name:       fmt.Sprintf("masternode-%d", m.Index),
+	})
+	if err != nil {
+		return err
+	}
+	sim.Up = true
+	m.ID = sim.ID()
+	for _, peer := range n.Nodes {
+		if peer.Alive() {
+			if err := n.link(m, peer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Simulation returns the p2p simulation network tracking which masternodes
+// are online and which of them can reach each other.
+func (n *Network) Simulation() *simulations.Network {
+	return n.sim
+}
+
+// link connects two masternodes if they aren't already.
+func (n *Network) link(one, other *Node) error {
+	if one == other || n.linked(one, other) {
+		return nil
+	}
+	return n.sim.DidConnect(one.ID, other.ID)
+}
+
+// unlink disconnects two masternodes if they are connected.
+func (n *Network) unlink(one, other *Node) error {
+	if !n.linked(one, other) {
+		return nil
+	}
+	return n.sim.DidDisconnect(one.ID, other.ID)
+}
+
+func (n *Network) linked(one, other *Node) bool {
+	conn := n.sim.GetConn(one.ID, other.ID)
+	return conn != nil && conn.Up
+}
+
+// Kill takes the node offline: it stops creating, validating and signing
+// blocks until it is revived.
+func (n *Network) Kill(index int) error {
+	if index < 0 || index >= len(n.Nodes) {
+		return errUnknownNode
+	}
+	killed := n.Nodes[index]
+	for _, peer := range n.Nodes {
+		if err := n.unlink(killed, peer); err != nil {
+			return err
+		}
+	}
+	n.sim.GetNode(killed.ID).Up = false
+	return nil
+}
+
+// Revive brings a killed node back online and syncs it with its partition.
+func (n *Network) Revive(index int) error {
+	if index < 0 || index >= len(n.Nodes) {
+		return errUnknownNode
+	}
+	revived := n.Nodes[index]
+	n.sim.GetNode(revived.ID).Up = true
+	for _, peer := range n.Nodes {
+		if peer.Alive() && peer.partition == revived.partition {
+			if err := n.link(revived, peer); err != nil {
+				return err
+			}
+		}
+	}
+	return n.sync()
+}
+
+// Partition splits the network: nodes listed in the same group can only reach
+// each other. Nodes that are not listed form one more partition together.
+func (n *Network) Partition(groups ...[]int) error {
+	for _, m := range n.Nodes {
+		m.partition = 0
+	}
+	for i, group := range groups {
+		for _, index := range group {
+			if index < 0 || index >= len(n.Nodes) {
+				return errUnknownNode
+			}
+			n.Nodes[index].partition = i + 1
+		}
+	}
+	return n.relink()
+}
+
+// Heal reconnects all partitions and lets every alive node adopt the chain
+// with the highest total difficulty.
+func (n *Network) Heal() error {
+	for _, m := range n.Nodes {
+		m.partition = 0
+	}
+	if err := n.relink(); err != nil {
+		return err
+	}
+	return n.sync()
+}
+
+// relink connects the alive nodes of the same partition and disconnects the
+// others.
+func (n *Network) relink() error {
+	for i, one := range n.Nodes {
+		for _, other := range n.Nodes[i+1:] {
+			var err error
+			if one.Alive() && other.Alive() && one.partition == other.partition {
+				err = n.link(one, other)
+			} else {
+				err = n.unlink(one, other)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// partitions returns the alive nodes grouped by the connections of the
+// topology. Groups are ordered by their first node, nodes by index.
+func (n *Network) partitions() [][]*Node {
+	var (
+		groups [][]*Node
+		seen   = make(map[int]bool)
+	)
+	for _, first := range n.Nodes {
+		if seen[first.Index] || !first.Alive() {
+			continue
+		}
+		seen[first.Index] = true
+		group := []*Node{first}
+		for i := 0; i < len(group); i++ {
+			for _, peer := range n.Nodes {
+				if !seen[peer.Index] && n.linked(group[i], peer) {
+					seen[peer.Index] = true
+					group = append(group, peer)
+				}
+			}
+		}
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].Index < group[j].Index
+		})
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// partitionOf returns the alive nodes the given node can reach, itself
+// included.
+func (n *Network) partitionOf(m *Node) []*Node {
+	for _, group := range n.partitions() {
+		for _, member := range group {
+			if member == m {
+				return group
+			}
+		}
+	}
+	return nil
+}
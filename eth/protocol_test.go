@@ -23,10 +23,13 @@ import (
 	"time"
 
 	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/forkid"
 	"github.com/tomochain/tomochain/core/types"
 	"github.com/tomochain/tomochain/crypto"
 	"github.com/tomochain/tomochain/eth/downloader"
 	"github.com/tomochain/tomochain/p2p"
+	"github.com/tomochain/tomochain/p2p/discover"
+	"github.com/tomochain/tomochain/p2p/enr"
 	"github.com/tomochain/tomochain/rlp"
 )
 
@@ -92,7 +95,120 @@ func testStatusMsgErrors(t *testing.T, protocol int) {
 	}
 }
 
+func TestStatusMsgErrors64(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	var (
+		genesis = pm.blockchain.Genesis()
+		head    = pm.blockchain.CurrentHeader()
+		td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
+		forkID  = forkid.NewID(pm.blockchain.Config(), genesis.Hash(), head.Number.Uint64())
+	)
+	defer pm.Stop()
+
+	tests := []struct {
+		code      uint64
+		data      interface{}
+		wantError error
+	}{
+		{
+			code: TxMsg, data: []interface{}{},
+			wantError: errResp(ErrNoStatusMsg, "first msg has code 2 (!= 0)"),
+		},
+		{
+			code: StatusMsg, data: statusData64{10, DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash(), forkID},
+			wantError: errResp(ErrProtocolVersionMismatch, "10 (!= %d)", eth64),
+		},
+		{
+			code: StatusMsg, data: statusData64{uint32(eth64), 999, td, head.Hash(), genesis.Hash(), forkID},
+			wantError: errResp(ErrNetworkIdMismatch, "999 (!= 88)"),
+		},
+		{
+			code: StatusMsg, data: statusData64{uint32(eth64), DefaultConfig.NetworkId, td, head.Hash(), common.Hash{3}, forkID},
+			wantError: errResp(ErrGenesisBlockMismatch, "0300000000000000 (!= %x)", genesis.Hash().Bytes()[:8]),
+		},
+		{
+			code: StatusMsg, data: statusData64{uint32(eth64), DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash(), forkid.ID{Hash: [4]byte{0x00, 0x01, 0x02, 0x03}}},
+			wantError: errResp(ErrForkIDRejected, forkid.ErrLocalIncompatibleOrStale.Error()),
+		},
+	}
+
+	for i, test := range tests {
+		p, errc := newTestPeer("peer", eth64, pm, false)
+		// The send call might hang until reset because
+		// the protocol might not read the payload.
+		go p2p.Send(p.app, test.code, test.data)
+
+		select {
+		case err := <-errc:
+			if err == nil {
+				t.Errorf("test %d: protocol returned nil error, want %q", i, test.wantError)
+			} else if err.Error() != test.wantError.Error() {
+				t.Errorf("test %d: wrong error: got %q, want %q", i, err, test.wantError)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("protocol did not shut down within 2 seconds")
+		}
+		p.close()
+	}
+}
+
 // This test checks that received transactions are added to the local pool.
+// Tests that peers on a legacy protocol version, which don't exchange fork
+// identifiers, are checked against the node record known to discovery.
+func TestLegacyPeerRecord62(t *testing.T) { testLegacyPeerRecord(t, 62) }
+func TestLegacyPeerRecord63(t *testing.T) { testLegacyPeerRecord(t, 63) }
+
+func testLegacyPeerRecord(t *testing.T, protocol int) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	var (
+		genesis = pm.blockchain.Genesis()
+		head    = pm.blockchain.CurrentHeader()
+		forkID  = forkid.NewID(pm.blockchain.Config(), genesis.Hash(), head.Number.Uint64())
+	)
+	defer pm.Stop()
+
+	tests := []struct {
+		entry     *tomoEntry
+		wantError error
+	}{
+		{
+			entry:     &tomoEntry{NetworkID: DefaultConfig.NetworkId, ForkID: forkid.ID{Hash: [4]byte{0x00, 0x01, 0x02, 0x03}}},
+			wantError: errResp(ErrForkIDRejected, forkid.ErrLocalIncompatibleOrStale.Error()),
+		},
+		{
+			entry:     &tomoEntry{NetworkID: 999, ForkID: forkID},
+			wantError: errResp(ErrNetworkIdMismatch, errRecordNetwork.Error()),
+		},
+		{entry: &tomoEntry{NetworkID: DefaultConfig.NetworkId, ForkID: forkID}},
+		{entry: nil},
+	}
+	for i, test := range tests {
+		pm.nodeRecord = func(discover.NodeID) *enr.Record {
+			if test.entry == nil {
+				return nil
+			}
+			var r enr.Record
+			r.Set(*test.entry)
+			return &r
+		}
+		p, errc := newTestPeer("peer", protocol, pm, test.wantError == nil)
+
+		select {
+		case err := <-errc:
+			if test.wantError == nil {
+				t.Errorf("test %d: peer rejected: %v", i, err)
+			} else if err == nil || err.Error() != test.wantError.Error() {
+				t.Errorf("test %d: wrong error: got %v, want %q", i, err, test.wantError)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if test.wantError != nil {
+				t.Errorf("test %d: peer accepted, want %q", i, test.wantError)
+			}
+		}
+		p.close()
+	}
+}
+
 func TestRecvTransactions62(t *testing.T) { testRecvTransactions(t, 62) }
 func TestRecvTransactions63(t *testing.T) { testRecvTransactions(t, 63) }
 
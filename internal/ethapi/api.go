@@ -21,6 +21,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/tomochain/tomochain/tomoxlending"
 	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
 	"math/big"
 	"sort"
@@ -37,6 +38,7 @@ import (
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/common/hexutil"
 	"github.com/tomochain/tomochain/common/math"
+	"github.com/tomochain/tomochain/consensus"
 	"github.com/tomochain/tomochain/consensus/ethash"
 	"github.com/tomochain/tomochain/consensus/posv"
 	contractValidator "github.com/tomochain/tomochain/contracts/validator/contract"
@@ -2682,6 +2684,289 @@ func (s *PublicTomoXTransactionPoolAPI) GetLendingTradeById(ctx context.Context,
 	return lendingItem, nil
 }
 
+// LendingPositionArgs selects the lending trades of a lending book, either the
+// trade of the given id or all the trades of the borrower.
+type LendingPositionArgs struct {
+	TradeId  *uint64         `json:"tradeId"`
+	Borrower *common.Address `json:"borrower"`
+}
+
+// GetLendingPositionHealth returns how close the selected lending trades are to
+// being liquidated at the current block.
+func (s *PublicTomoXTransactionPoolAPI) GetLendingPositionHealth(ctx context.Context, lendingToken common.Address, term uint64, args LendingPositionArgs) ([]*tomoxlending.PositionHealth, error) {
+	if (args.TradeId == nil) == (args.Borrower == nil) {
+		return nil, errors.New("either tradeId or borrower must be given")
+	}
+	header, statedb, tradingState, lendingState, err := s.lendingStates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lendingBook := lendingstate.GetLendingOrderBookHash(lendingToken, term)
+	var trades []lendingstate.LendingTrade
+	if args.TradeId != nil {
+		trade := lendingState.GetLendingTrade(lendingBook, common.BigToHash(new(big.Int).SetUint64(*args.TradeId)))
+		if trade.TradeId != *args.TradeId {
+			return nil, errors.New("Lending Item not found")
+		}
+		trades = append(trades, trade)
+	} else {
+		all, err := lendingState.DumpLendingTradeTrie(lendingBook)
+		if err != nil {
+			return nil, err
+		}
+		for _, trade := range all {
+			if trade.Borrower == *args.Borrower {
+				trades = append(trades, trade)
+			}
+		}
+		sort.Slice(trades, func(i, j int) bool { return trades[i].TradeId < trades[j].TradeId })
+	}
+	chain := &backendChain{b: s.b, ctx: ctx}
+	result := make([]*tomoxlending.PositionHealth, 0, len(trades))
+	for i := range trades {
+		health, err := s.b.LendingService().GetPositionHealth(header, chain, statedb, tradingState, lendingState, &trades[i])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, health)
+	}
+	return result, nil
+}
+
+// SimulateLiquidations dry-runs the liquidation process on the current state and
+// returns the trades of the lending book it would liquidate, repay, top up or
+// recall. priceOverrides maps collateral tokens to their price in lendingToken
+// to use instead of the current ones.
+func (s *PublicTomoXTransactionPoolAPI) SimulateLiquidations(ctx context.Context, lendingToken common.Address, term uint64, priceOverrides map[common.Address]*hexutil.Big) (*tomoxlending.LiquidationSimulation, error) {
+	header, statedb, tradingState, lendingState, err := s.lendingStates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prices := make(map[common.Address]*big.Int, len(priceOverrides))
+	for token, price := range priceOverrides {
+		prices[token] = (*big.Int)(price)
+	}
+	return s.b.LendingService().SimulateLiquidations(header, &backendChain{b: s.b, ctx: ctx}, statedb, tradingState, lendingState, lendingToken, term, prices)
+}
+
+// GetLendingMarketStats returns the outstanding principal of the lending market
+// of lendingToken and term and its activity over the last day. Market stats are
+// only kept by SDK nodes.
+func (s *PublicTomoXTransactionPoolAPI) GetLendingMarketStats(ctx context.Context, lendingToken common.Address, term uint64) (*tomoxlending.MarketStats, error) {
+	block := s.b.CurrentBlock()
+	if block == nil {
+		return nil, errors.New("Current block not found")
+	}
+	lendingService := s.b.LendingService()
+	if lendingService == nil {
+		return nil, errors.New("TomoX Lending service not found")
+	}
+	return lendingService.GetMarketStats(lendingToken, term, block.Time().Uint64())
+}
+
+// GetLendingMarketStatsHistory returns the stats of the lending market of
+// lendingToken and term between the from and to timestamps, in candles of
+// resolution seconds.
+func (s *PublicTomoXTransactionPoolAPI) GetLendingMarketStatsHistory(ctx context.Context, lendingToken common.Address, term uint64, from, to, resolution uint64) ([]*lendingstate.LendingMarketStats, error) {
+	lendingService := s.b.LendingService()
+	if lendingService == nil {
+		return nil, errors.New("TomoX Lending service not found")
+	}
+	return lendingService.GetMarketStatsHistory(lendingToken, term, from, to, resolution)
+}
+
+// RelayerPair is a trading pair listed by a relayer.
+type RelayerPair struct {
+	BaseToken  common.Address `json:"baseToken"`
+	QuoteToken common.Address `json:"quoteToken"`
+}
+
+// RelayerLending is the lending configuration of a relayer.
+type RelayerLending struct {
+	Fee        *hexutil.Big     `json:"fee"`
+	BaseTokens []common.Address `json:"baseTokens"`
+	Terms      []uint64         `json:"terms"`
+}
+
+// RelayerCharge is the decrease of a relayer deposit in a block, made of the
+// matching fees charged for the orders and lending items of the block.
+type RelayerCharge struct {
+	Number  hexutil.Uint64 `json:"number"`
+	Fee     *hexutil.Big   `json:"fee"`
+	Deposit *hexutil.Big   `json:"deposit"`
+}
+
+// RelayerInfo is the registration of a relayer at a block, with the fees
+// charged to its deposit and its fee income since the start of the epoch.
+type RelayerInfo struct {
+	Coinbase         common.Address  `json:"coinbase"`
+	Owner            common.Address  `json:"owner"`
+	Resigned         bool            `json:"resigned"`
+	Deposit          *hexutil.Big    `json:"deposit"`
+	LockedFund       *hexutil.Big    `json:"lockedFund"`
+	RemainingDeposit *hexutil.Big    `json:"remainingDeposit"`
+	MatchingFee      *hexutil.Big    `json:"matchingFee"`
+	RemainingMatches *hexutil.Big    `json:"remainingMatches"`
+	TradeFee         *hexutil.Big    `json:"tradeFee"`
+	Pairs            []RelayerPair   `json:"pairs"`
+	Lending          *RelayerLending `json:"lending"`
+
+	Epoch         uint64                          `json:"epoch"`
+	EpochCharged  *hexutil.Big                    `json:"epochCharged"`
+	Charges       []RelayerCharge                 `json:"charges"`
+	TradingIncome map[common.Address]*hexutil.Big `json:"tradingIncome,omitempty"`
+	LendingIncome map[common.Address]*hexutil.Big `json:"lendingIncome,omitempty"`
+}
+
+// GetRelayer returns the registration of the relayer at the given block. The
+// deposit left before orders get rejected is the deposit above the locked fund.
+// The charges are collected from the states of the blocks of the epoch still
+// available on the node, the fee income is only tracked by SDK nodes.
+func (s *PublicTomoXTransactionPoolAPI) GetRelayer(ctx context.Context, coinbase common.Address, blockNr rpc.BlockNumber) (*RelayerInfo, error) {
+	statedb, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	if tradingstate.GetRelayerOwner(coinbase, statedb) == (common.Address{}) {
+		return nil, fmt.Errorf("relayer %s is not registered", coinbase.Hex())
+	}
+	deposit := tradingstate.GetRelayerDeposit(coinbase, statedb)
+	lockedFund := new(big.Int).Mul(common.BasePrice, common.RelayerLockedFund)
+	remaining := new(big.Int).Sub(deposit, lockedFund)
+	if remaining.Sign() < 0 {
+		remaining = new(big.Int)
+	}
+	info := &RelayerInfo{
+		Coinbase:         coinbase,
+		Owner:            tradingstate.GetRelayerOwner(coinbase, statedb),
+		Resigned:         tradingstate.IsResignedRelayer(coinbase, statedb),
+		Deposit:          (*hexutil.Big)(deposit),
+		LockedFund:       (*hexutil.Big)(lockedFund),
+		RemainingDeposit: (*hexutil.Big)(remaining),
+		MatchingFee:      (*hexutil.Big)(common.RelayerFee),
+		RemainingMatches: (*hexutil.Big)(new(big.Int).Div(remaining, common.RelayerFee)),
+		TradeFee:         (*hexutil.Big)(tradingstate.GetExRelayerFee(coinbase, statedb)),
+		Pairs:            []RelayerPair{},
+	}
+	for i := uint64(0); i < tradingstate.GetBaseTokenLength(coinbase, statedb); i++ {
+		info.Pairs = append(info.Pairs, RelayerPair{
+			BaseToken:  tradingstate.GetBaseTokenAtIndex(coinbase, statedb, i),
+			QuoteToken: tradingstate.GetQuoteTokenAtIndex(coinbase, statedb, i),
+		})
+	}
+	if lendingstate.IsValidRelayer(statedb, coinbase) {
+		info.Lending = &RelayerLending{
+			Fee:        (*hexutil.Big)(lendingstate.GetFee(statedb, coinbase)),
+			BaseTokens: lendingstate.GetBaseList(statedb, coinbase),
+			Terms:      lendingstate.GetTerms(statedb, coinbase),
+		}
+	}
+
+	config := s.b.ChainConfig()
+	if config.Posv == nil || config.Posv.Epoch == 0 {
+		return info, nil
+	}
+	number := header.Number.Uint64()
+	info.Epoch = number / config.Posv.Epoch
+	start := number - number%config.Posv.Epoch
+	charged := new(big.Int)
+	prev, _, _ := s.b.StateAndHeaderByNumber(ctx, rpc.BlockNumber(start))
+	for n := start + 1; n <= number; n++ {
+		current := statedb
+		if n < number {
+			if current, _, err = s.b.StateAndHeaderByNumber(ctx, rpc.BlockNumber(n)); err != nil {
+				current = nil
+			}
+		}
+		if current == nil {
+			// the state of the block was pruned
+			prev = nil
+			continue
+		}
+		balance := tradingstate.GetRelayerDeposit(coinbase, current)
+		if prev != nil {
+			if fee := new(big.Int).Sub(tradingstate.GetRelayerDeposit(coinbase, prev), balance); fee.Sign() > 0 {
+				charged.Add(charged, fee)
+				info.Charges = append(info.Charges, RelayerCharge{hexutil.Uint64(n), (*hexutil.Big)(fee), (*hexutil.Big)(balance)})
+			}
+		}
+		prev = current
+	}
+	info.EpochCharged = (*hexutil.Big)(charged)
+
+	startHeader, err := s.b.HeaderByNumber(ctx, rpc.BlockNumber(start))
+	if startHeader == nil || err != nil {
+		return info, nil
+	}
+	from, to := time.Unix(startHeader.Time.Int64(), 0), time.Unix(header.Time.Int64()+1, 0)
+	if tomoxService := s.b.TomoxService(); tomoxService != nil {
+		if income, err := tomoxService.GetRelayerIncome(coinbase, from, to); err == nil {
+			info.TradingIncome = toHexBigs(income)
+		}
+	}
+	if lendingService := s.b.LendingService(); lendingService != nil {
+		if income, err := lendingService.GetRelayerIncome(coinbase, from, to); err == nil {
+			info.LendingIncome = toHexBigs(income)
+		}
+	}
+	return info, nil
+}
+
+func toHexBigs(m map[common.Address]*big.Int) map[common.Address]*hexutil.Big {
+	result := make(map[common.Address]*hexutil.Big, len(m))
+	for token, amount := range m {
+		result[token] = (*hexutil.Big)(amount)
+	}
+	return result
+}
+
+// lendingStates returns the header and the states of the current block.
+func (s *PublicTomoXTransactionPoolAPI) lendingStates(ctx context.Context) (*types.Header, *state.StateDB, *tradingstate.TradingStateDB, *lendingstate.LendingStateDB, error) {
+	block := s.b.CurrentBlock()
+	if block == nil {
+		return nil, nil, nil, nil, errors.New("Current block not found")
+	}
+	tomoxService, lendingService := s.b.TomoxService(), s.b.LendingService()
+	if tomoxService == nil || lendingService == nil {
+		return nil, nil, nil, nil, errors.New("TomoX Lending service not found")
+	}
+	author, err := s.b.GetEngine().Author(block.Header())
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	statedb, header, err := s.b.StateAndHeaderByNumber(ctx, rpc.BlockNumber(block.NumberU64()))
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	tradingState, err := tomoxService.GetTradingState(block, author)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	lendingState, err := lendingService.GetLendingState(block, author)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return header, statedb, tradingState, lendingState, nil
+}
+
+// backendChain adapts a Backend to consensus.ChainContext for the lending price
+// lookups, which read token decimals through the EVM.
+type backendChain struct {
+	b   Backend
+	ctx context.Context
+}
+
+func (c *backendChain) Engine() consensus.Engine     { return c.b.GetEngine() }
+func (c *backendChain) Config() *params.ChainConfig  { return c.b.ChainConfig() }
+func (c *backendChain) CurrentHeader() *types.Header { return c.b.CurrentBlock().Header() }
+func (c *backendChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	header, err := c.b.HeaderByNumber(c.ctx, rpc.BlockNumber(number))
+	if err != nil || header == nil || header.Hash() != hash {
+		return nil
+	}
+	return header
+}
+
 // Sign calculates an ECDSA signature for:
 // keccack256("\x19Ethereum Signed Message:\n" + len(message) + message).
 //
@@ -486,6 +486,11 @@ web3._extend({
 			params: 2,
 			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputBlockNumberFormatter]
 		}),
+		new web3._extend.Method({
+			name: 'getNextCheckpointRewards',
+			call: 'eth_getNextCheckpointRewards',
+			params: 0
+		}),
 	],
 	properties: [
 		new web3._extend.Property({
@@ -833,6 +838,32 @@ web3._extend({
             call: 'tomox_getLendingTradeById',
             params: 3
 		}),
+		new web3._extend.Method({
+            name: 'getLendingPositionHealth',
+            call: 'tomox_getLendingPositionHealth',
+            params: 3
+		}),
+		new web3._extend.Method({
+            name: 'simulateLiquidations',
+            call: 'tomox_simulateLiquidations',
+            params: 3
+		}),
+		new web3._extend.Method({
+            name: 'getLendingMarketStats',
+            call: 'tomox_getLendingMarketStats',
+            params: 2
+		}),
+		new web3._extend.Method({
+            name: 'getLendingMarketStatsHistory',
+            call: 'tomox_getLendingMarketStatsHistory',
+            params: 5
+		}),
+		new web3._extend.Method({
+            name: 'getRelayer',
+            call: 'tomox_getRelayer',
+            params: 2,
+            inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputDefaultBlockNumberFormatter]
+		}),
 	]
 });
 `
@@ -0,0 +1,68 @@
+package prometheus
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomochain/tomochain/metrics"
+)
+
+func TestMain(m *testing.M) {
+	metrics.Enabled = true
+	os.Exit(m.Run())
+}
+
+func TestCollector(t *testing.T) {
+	c := newCollector()
+
+	counter := metrics.NewCounter()
+	counter.Inc(12345)
+	c.addCounter("test/counter", counter)
+
+	gauge := metrics.NewGauge()
+	gauge.Update(23456)
+	c.addGauge("test/gauge", gauge)
+
+	histogram := metrics.NewHistogram(metrics.NewUniformSample(100))
+	histogram.Update(1)
+	c.addHistogram("test/histogram", histogram)
+
+	timer := metrics.NewTimer()
+	timer.Update(120 * time.Millisecond)
+	c.addTimer("test/timer", timer)
+
+	emptyResettingTimer := metrics.NewResettingTimer().Snapshot()
+	c.addResettingTimer("test/empty_resetting_timer", emptyResettingTimer)
+
+	resettingTimer := metrics.NewResettingTimer()
+	resettingTimer.Update(10 * time.Millisecond)
+	resettingTimer.Update(120 * time.Millisecond)
+	c.addResettingTimer("test/resetting_timer", resettingTimer.Snapshot())
+
+	exp := c.buff.String()
+	for _, want := range []string{
+		"# TYPE test_counter counter\ntest_counter 12345\n",
+		"# TYPE test_gauge gauge\ntest_gauge 23456\n",
+		"# TYPE test_histogram summary\ntest_histogram_count 1\ntest_histogram_sum 1\n",
+		"test_histogram{quantile=\"0.5\"} 1\n",
+		"test_timer_count 1\n",
+		"test_timer{quantile=\"0.99\"} 1.2e+08\n",
+		"test_resetting_timer_count 2\n",
+		"test_resetting_timer{quantile=\"0.95\"} 120000000\n",
+	} {
+		if !strings.Contains(exp, want) {
+			t.Errorf("missing %q in output:\n%s", want, exp)
+		}
+	}
+	if strings.Contains(exp, "test_empty_resetting_timer") {
+		t.Errorf("empty resetting timer should be omitted:\n%s", exp)
+	}
+}
+
+func TestMutateKey(t *testing.T) {
+	if have, want := mutateKey("chain/inserts.total-1"), "chain_inserts_total_1"; have != want {
+		t.Errorf("mutated key mismatch: have %s, want %s", have, want)
+	}
+}
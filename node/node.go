@@ -649,7 +649,38 @@ func (n *Node) OpenDatabase(name string, cache, handles int, namespace string) (
 	if n.config.DataDir == "" {
 		return rawdb.NewMemoryDatabase(), nil
 	}
-	return rawdb.NewLevelDBDatabase(n.config.resolvePath(name), cache, handles, namespace)
+	return rawdb.Open(rawdb.OpenOptions{
+		Type:      n.config.DBEngine,
+		Directory: n.config.resolvePath(name),
+		Namespace: namespace,
+		Cache:     cache,
+		Handles:   handles,
+	})
+}
+
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's data directory,
+// also attaching a chain freezer to it that moves ancient chain data from the
+// database to immutable append-only files in the freezer directory. Without a
+// freezer directory the database is opened as is. If the node is an ephemeral
+// one, a memory database is returned.
+func (n *Node) OpenDatabaseWithFreezer(name string, cache, handles int, freezer, namespace string, threshold uint64) (ethdb.Database, error) {
+	if n.config.DataDir == "" {
+		return rawdb.NewMemoryDatabase(), nil
+	}
+	root := n.config.resolvePath(name)
+	if freezer != "" && !filepath.IsAbs(freezer) {
+		freezer = n.config.resolvePath(freezer)
+	}
+	return rawdb.Open(rawdb.OpenOptions{
+		Type:              n.config.DBEngine,
+		Directory:         root,
+		AncientsDirectory: freezer,
+		Namespace:         namespace,
+		Cache:             cache,
+		Handles:           handles,
+		FreezeThreshold:   threshold,
+	})
 }
 
 // ResolvePath returns the absolute path of a resource in the instance directory.
@@ -18,6 +18,7 @@ package node
 
 import (
 	"github.com/tomochain/tomochain/core/rawdb"
+	"path/filepath"
 	"reflect"
 
 	"github.com/tomochain/tomochain/accounts"
@@ -44,13 +45,43 @@ func (ctx *ServiceContext) OpenDatabase(name string, cache int, handles int) (et
 	if ctx.config.DataDir == "" {
 		return rawdb.NewMemoryDatabase(), nil
 	}
-	db, err := rawdb.NewLevelDBDatabase(ctx.config.resolvePath(name), cache, handles, "")
+	db, err := rawdb.Open(rawdb.OpenOptions{
+		Type:      ctx.config.DBEngine,
+		Directory: ctx.config.resolvePath(name),
+		Cache:     cache,
+		Handles:   handles,
+	})
 	if err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's data directory,
+// also attaching a chain freezer to it that moves ancient chain data from the
+// database to immutable append-only files in the freezer directory. Without a
+// freezer directory the database is opened as is. If the node is an ephemeral
+// one, a memory database is returned.
+func (ctx *ServiceContext) OpenDatabaseWithFreezer(name string, cache int, handles int, freezer string, namespace string, threshold uint64) (ethdb.Database, error) {
+	if ctx.config.DataDir == "" {
+		return rawdb.NewMemoryDatabase(), nil
+	}
+	root := ctx.config.resolvePath(name)
+	if freezer != "" && !filepath.IsAbs(freezer) {
+		freezer = ctx.config.resolvePath(freezer)
+	}
+	return rawdb.Open(rawdb.OpenOptions{
+		Type:              ctx.config.DBEngine,
+		Directory:         root,
+		AncientsDirectory: freezer,
+		Namespace:         namespace,
+		Cache:             cache,
+		Handles:           handles,
+		FreezeThreshold:   threshold,
+	})
+}
+
 // ResolvePath resolves a user path into the data directory if that was relative
 // and if the user actually uses persistent storage. It will return an empty string
 // for emphemeral storage and the user's own input for absolute paths.
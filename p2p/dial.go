@@ -26,6 +26,7 @@ import (
 
 	"github.com/tomochain/tomochain/log"
 	"github.com/tomochain/tomochain/p2p/discover"
+	"github.com/tomochain/tomochain/p2p/enr"
 	"github.com/tomochain/tomochain/p2p/netutil"
 )
 
@@ -72,6 +73,7 @@ type dialstate struct {
 	maxDynDials int
 	ntab        discoverTable
 	netrestrict *netutil.Netlist
+	filter      func(*enr.Record) error // checks records of discovered nodes
 
 	lookupRunning bool
 	dialing       map[discover.NodeID]connFlag
@@ -88,6 +90,7 @@ type discoverTable interface {
 	Self() *discover.Node
 	Close()
 	Resolve(target discover.NodeID) *discover.Node
+	Node(id discover.NodeID) *discover.Node
 	Lookup(target discover.NodeID) []*discover.Node
 	ReadRandomNodes([]*discover.Node) int
 }
@@ -258,6 +261,7 @@ var (
 	errAlreadyConnected = errors.New("already connected")
 	errRecentlyDialed   = errors.New("recently dialed")
 	errNotWhitelisted   = errors.New("not contained in netrestrict whitelist")
+	errFiltered         = errors.New("node record rejected by protocol")
 )
 
 func (s *dialstate) checkDial(n *discover.Node, peers map[discover.NodeID]*Peer) error {
@@ -274,6 +278,8 @@ func (s *dialstate) checkDial(n *discover.Node, peers map[discover.NodeID]*Peer)
 		return errSelf
 	case s.netrestrict != nil && !s.netrestrict.Contains(n.IP):
 		return errNotWhitelisted
+	case s.filter != nil && n.Record() != nil && s.filter(n.Record()) != nil:
+		return errFiltered
 	case s.hist.contains(n.ID):
 		return errRecentlyDialed
 	}
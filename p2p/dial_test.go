@@ -82,6 +82,7 @@ func (t fakeTable) Self() *discover.Node                     { return new(discov
 func (t fakeTable) Close()                                   {}
 func (t fakeTable) Lookup(discover.NodeID) []*discover.Node  { return nil }
 func (t fakeTable) Resolve(discover.NodeID) *discover.Node   { return nil }
+func (t fakeTable) Node(discover.NodeID) *discover.Node      { return nil }
 func (t fakeTable) ReadRandomNodes(buf []*discover.Node) int { return copy(buf, t) }
 
 // This test checks that dynamic dials are launched from discovery results.
@@ -705,4 +706,5 @@ func (t *resolveMock) Self() *discover.Node                     { return new(dis
 func (t *resolveMock) Close()                                   {}
 func (t *resolveMock) Bootstrap([]*discover.Node)               {}
 func (t *resolveMock) Lookup(discover.NodeID) []*discover.Node  { return nil }
+func (t *resolveMock) Node(discover.NodeID) *discover.Node      { return nil }
 func (t *resolveMock) ReadRandomNodes(buf []*discover.Node) int { return 0 }
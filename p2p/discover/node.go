@@ -34,6 +34,7 @@ import (
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/crypto"
 	"github.com/tomochain/tomochain/crypto/secp256k1"
+	"github.com/tomochain/tomochain/p2p/enr"
 )
 
 const NodeIDBits = 512
@@ -54,6 +55,9 @@ type Node struct {
 
 	// Time when the node was added to the table.
 	addedAt time.Time
+
+	// The signed node record, if the node advertises one.
+	record *enr.Record
 }
 
 // NewNode creates a new node. It is mostly meant to be used for
@@ -71,6 +75,13 @@ func NewNode(id NodeID, ip net.IP, udpPort, tcpPort uint16) *Node {
 	}
 }
 
+// Record returns the node record advertised by the node. It returns nil
+// if the record is unknown, e.g. because the node doesn't support ENR
+// requests. The returned record should not be modified by the caller.
+func (n *Node) Record() *enr.Record {
+	return n.record
+}
+
 func (n *Node) addr() *net.UDPAddr {
 	return &net.UDPAddr{IP: n.IP, Port: int(n.UDP)}
 }
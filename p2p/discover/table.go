@@ -36,6 +36,7 @@ import (
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/crypto"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/p2p/enr"
 	"github.com/tomochain/tomochain/p2p/netutil"
 )
 
@@ -98,9 +99,10 @@ type bondproc struct {
 // it is an interface so we can test without opening lots of UDP
 // sockets and without generating a private key.
 type transport interface {
-	ping(NodeID, *net.UDPAddr) error
+	ping(NodeID, *net.UDPAddr) (uint64, error)
 	waitping(NodeID) error
 	findnode(toid NodeID, addr *net.UDPAddr, target NodeID) ([]*Node, error)
+	requestENR(NodeID, *net.UDPAddr) (*enr.Record, error)
 	close()
 }
 
@@ -248,17 +250,26 @@ func (tab *Table) isInitDone() bool {
 	}
 }
 
+// Node returns the node with the given ID if it is present in the local
+// table. Unlike Resolve, it never performs a network lookup.
+func (tab *Table) Node(id NodeID) *Node {
+	hash := crypto.Keccak256Hash(id[:])
+	tab.mutex.Lock()
+	cl := tab.closest(hash, 1)
+	tab.mutex.Unlock()
+	if len(cl.entries) > 0 && cl.entries[0].ID == id {
+		return cl.entries[0]
+	}
+	return nil
+}
+
 // Resolve searches for a specific node with the given ID.
 // It returns nil if the node could not be found.
 func (tab *Table) Resolve(targetID NodeID) *Node {
 	// If the node is present in the local table, no
 	// network interaction is required.
-	hash := crypto.Keccak256Hash(targetID[:])
-	tab.mutex.Lock()
-	cl := tab.closest(hash, 1)
-	tab.mutex.Unlock()
-	if len(cl.entries) > 0 && cl.entries[0].ID == targetID {
-		return cl.entries[0]
+	if n := tab.Node(targetID); n != nil {
+		return n
 	}
 	// Otherwise, do a network lookup.
 	result := tab.Lookup(targetID)
@@ -473,7 +484,20 @@ func (tab *Table) doRevalidate(done chan<- struct{}) {
 	}
 
 	// Ping the selected node and wait for a pong.
-	err := tab.ping(last.ID, last.addr())
+	seq, err := tab.ping(last.ID, last.addr())
+
+	// Fetch the node record if the node advertises a newer one. Nodes
+	// may be in use by callers of the table, so the record is set on a
+	// copy which replaces the entry.
+	if err == nil && seq > 0 && (last.record == nil || seq > last.record.Seq()) {
+		if record, rerr := tab.net.requestENR(last.ID, last.addr()); rerr == nil {
+			n := *last
+			n.record = record
+			last = &n
+		} else {
+			log.Trace("ENR request failed", "id", last.ID, "err", rerr)
+		}
+	}
 
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
@@ -641,7 +665,8 @@ func (tab *Table) pingpong(w *bondproc, pinged bool, id NodeID, addr *net.UDPAdd
 	defer func() { tab.bondslots <- struct{}{} }()
 
 	// Ping the remote side and wait for a pong.
-	if w.err = tab.ping(id, addr); w.err != nil {
+	seq, err := tab.ping(id, addr)
+	if w.err = err; w.err != nil {
 		close(w.done)
 		return
 	}
@@ -653,18 +678,26 @@ func (tab *Table) pingpong(w *bondproc, pinged bool, id NodeID, addr *net.UDPAdd
 	}
 	// Bonding succeeded, update the node database.
 	w.n = NewNode(id, addr.IP, uint16(addr.Port), tcpPort)
+	if seq > 0 {
+		// The node supports ENR requests, fetch its record so callers
+		// can decide whether the node is worth connecting to.
+		if w.n.record, err = tab.net.requestENR(id, addr); err != nil {
+			log.Trace("ENR request failed", "id", id, "err", err)
+		}
+	}
 	close(w.done)
 }
 
 // ping a remote endpoint and wait for a reply, also updating the node
 // database accordingly.
-func (tab *Table) ping(id NodeID, addr *net.UDPAddr) error {
+func (tab *Table) ping(id NodeID, addr *net.UDPAddr) (uint64, error) {
 	tab.db.updateLastPing(id, time.Now())
-	if err := tab.net.ping(id, addr); err != nil {
-		return err
+	seq, err := tab.net.ping(id, addr)
+	if err != nil {
+		return 0, err
 	}
 	tab.db.updateBondTime(id, time.Now())
-	return nil
+	return seq, nil
 }
 
 // bucket returns the bucket for the given node ID hash.
@@ -27,6 +27,7 @@ import (
 
 	"github.com/tomochain/tomochain/crypto"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/p2p/enr"
 	"github.com/tomochain/tomochain/p2p/nat"
 	"github.com/tomochain/tomochain/p2p/netutil"
 	"github.com/tomochain/tomochain/rlp"
@@ -44,6 +45,7 @@ var (
 	errTimeout          = errors.New("RPC timeout")
 	errClockWarp        = errors.New("reply deadline too far in the future")
 	errClosed           = errors.New("socket closed")
+	errRecordMismatch   = errors.New("record doesn't match node ID")
 )
 
 // Timeouts
@@ -64,6 +66,8 @@ const (
 	findnodePacket
 	neighborsPacket
 	pingTomo
+	enrRequestPacket
+	enrResponsePacket
 )
 
 // RPC request structures
@@ -105,6 +109,21 @@ type (
 		Rest []rlp.RawValue `rlp:"tail"`
 	}
 
+	// enrRequest is a query for the node record of the recipient.
+	enrRequest struct {
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// enrResponse is the reply to enrRequest.
+	enrResponse struct {
+		ReplyTok []byte // Hash of the enrRequest packet.
+		Record   enr.Record
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
 	rpcNode struct {
 		IP  net.IP // len 4 for IPv4 or 16 for IPv6
 		UDP uint16 // for discovery protocol
@@ -146,6 +165,26 @@ func nodeToRPC(n *Node) rpcNode {
 	return rpcNode{ID: n.ID, IP: n.IP, UDP: n.UDP, TCP: n.TCP}
 }
 
+// Nodes supporting ENR requests append the sequence number of their record
+// to ping and pong packets. Older implementations ignore the extra field.
+func seqTail(seq uint64) []rlp.RawValue {
+	if seq == 0 {
+		return nil
+	}
+	enc, _ := rlp.EncodeToBytes(seq)
+	return []rlp.RawValue{enc}
+}
+
+// seqFromTail returns the record sequence number carried by a ping or pong
+// packet, or zero if the sender didn't include one.
+func seqFromTail(rest []rlp.RawValue) uint64 {
+	var seq uint64
+	if len(rest) == 0 || rlp.DecodeBytes(rest[0], &seq) != nil {
+		return 0
+	}
+	return seq
+}
+
 type packet interface {
 	handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error
 	name() string
@@ -228,6 +267,8 @@ type Config struct {
 	NetRestrict  *netutil.Netlist  // network whitelist
 	Bootnodes    []*Node           // list of bootstrap nodes
 	Unhandled    chan<- ReadPacket // unhandled packets are sent on this channel
+	Entries      []enr.Entry       // additional entries of the local node record
+	TCPPort      int               // TCP port of the local RLPx listener, zero if not listening
 }
 
 // ListenUDP returns a new table that listens for UDP packets on laddr.
@@ -253,13 +294,16 @@ func newUDP(c conn, cfg Config) (*Table, *udp, error) {
 	if cfg.AnnounceAddr != nil {
 		realaddr = cfg.AnnounceAddr
 	}
-	// TODO: separate TCP port
-	udp.ourEndpoint = makeEndpoint(realaddr, uint16(realaddr.Port))
+	udp.ourEndpoint = makeEndpoint(realaddr, uint16(cfg.TCPPort))
 	tab, err := newTable(udp, PubkeyID(&cfg.PrivateKey.PublicKey), realaddr, cfg.NodeDBPath, cfg.Bootnodes)
 	if err != nil {
 		return nil, nil, err
 	}
 	udp.Table = tab
+	if tab.self.record, err = makeRecord(cfg.PrivateKey, realaddr, cfg.TCPPort, cfg.Entries); err != nil {
+		tab.Close()
+		return nil, nil, err
+	}
 
 	go udp.loop()
 	go udp.readLoop(cfg.Unhandled)
@@ -272,23 +316,93 @@ func (t *udp) close() {
 	// TODO: wait for the loops to end.
 }
 
+// makeRecord creates the signed node record of the local node. The
+// sequence number is derived from the current time so that a record
+// created after a restart supersedes the one known by remote nodes.
+// The TCP port is left out of the record if it is zero.
+func makeRecord(priv *ecdsa.PrivateKey, addr *net.UDPAddr, tcpPort int, entries []enr.Entry) (*enr.Record, error) {
+	var r enr.Record
+	if ip := addr.IP.To4(); ip != nil && !ip.IsUnspecified() {
+		r.Set(enr.IP4(ip))
+	} else if ip := addr.IP.To16(); ip != nil && !ip.IsUnspecified() {
+		r.Set(enr.IP6(ip))
+	}
+	r.Set(enr.UDP(addr.Port))
+	if tcpPort != 0 {
+		r.Set(enr.TCP(tcpPort))
+	}
+	for _, e := range entries {
+		r.Set(e)
+	}
+	r.SetSeq(uint64(time.Now().Unix()))
+	if err := r.Sign(priv); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
 // ping sends a ping message to the given node and waits for a reply.
-func (t *udp) ping(toid NodeID, toaddr *net.UDPAddr) error {
+// It returns the sequence number of the remote node record, which is
+// zero if the node doesn't support ENR requests.
+func (t *udp) ping(toid NodeID, toaddr *net.UDPAddr) (uint64, error) {
 	req := &ping{
 		Version:    Version,
 		From:       t.ourEndpoint,
 		To:         makeEndpoint(toaddr, 0), // TODO: maybe use known TCP port from DB
 		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Rest:       seqTail(t.self.record.Seq()),
 	}
 	packet, hash, err := encodePacket(t.priv, pingTomo, req)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	var seq uint64
 	errc := t.pending(toid, pongPacket, func(p interface{}) bool {
-		return bytes.Equal(p.(*pong).ReplyTok, hash)
+		reply := p.(*pong)
+		if !bytes.Equal(reply.ReplyTok, hash) {
+			return false
+		}
+		seq = seqFromTail(reply.Rest)
+		return true
+	})
+	t.write(toaddr, req.name(), packet)
+	if err := <-errc; err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// requestENR sends an ENR request to the given node and waits for the
+// record in the reply. The record must be signed by the node's key.
+func (t *udp) requestENR(toid NodeID, toaddr *net.UDPAddr) (*enr.Record, error) {
+	req := &enrRequest{
+		Expiration: uint64(time.Now().Add(expiration).Unix()),
+	}
+	packet, hash, err := encodePacket(t.priv, enrRequestPacket, req)
+	if err != nil {
+		return nil, err
+	}
+	var record *enr.Record
+	errc := t.pending(toid, enrResponsePacket, func(r interface{}) bool {
+		reply := r.(*enrResponse)
+		if !bytes.Equal(reply.ReplyTok, hash) {
+			return false
+		}
+		record = &reply.Record
+		return true
 	})
 	t.write(toaddr, req.name(), packet)
-	return <-errc
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	var pubkey enr.Secp256k1
+	if err := record.Load(&pubkey); err != nil {
+		return nil, err
+	}
+	if PubkeyID((*ecdsa.PublicKey)(&pubkey)) != toid {
+		return nil, errRecordMismatch
+	}
+	return record, nil
 }
 
 func (t *udp) waitping(from NodeID) error {
@@ -572,6 +686,10 @@ func decodePacket(buf []byte) (packet, NodeID, []byte, error) {
 		req = new(findnode)
 	case neighborsPacket:
 		req = new(neighbors)
+	case enrRequestPacket:
+		req = new(enrRequest)
+	case enrResponsePacket:
+		req = new(enrResponse)
 	default:
 		return nil, fromID, hash, fmt.Errorf("unknown type: %d", ptype)
 	}
@@ -588,6 +706,7 @@ func (req *ping) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) er
 		To:         makeEndpoint(from, req.From.TCP),
 		ReplyTok:   mac,
 		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Rest:       seqTail(t.self.record.Seq()),
 	})
 	if !t.handleReply(fromID, pingTomo, req) {
 		// Note: we're ignoring the provided IP address right now
@@ -663,6 +782,33 @@ func (req *neighbors) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byt
 
 func (req *neighbors) name() string { return "NEIGHBORS/v4" }
 
+func (req *enrRequest) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if !t.db.hasBond(fromID) {
+		// Records are only served to bonded nodes for the same reason
+		// findnode requests are.
+		return errUnknownNode
+	}
+	t.send(from, enrResponsePacket, &enrResponse{
+		ReplyTok: mac,
+		Record:   *t.self.record,
+	})
+	return nil
+}
+
+func (req *enrRequest) name() string { return "ENRREQUEST/v4" }
+
+func (req *enrResponse) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
+	if !t.handleReply(fromID, enrResponsePacket, req) {
+		return errUnsolicitedReply
+	}
+	return nil
+}
+
+func (req *enrResponse) name() string { return "ENRRESPONSE/v4" }
+
 func expired(ts uint64) bool {
 	return time.Unix(int64(ts), 0).Before(time.Now())
 }
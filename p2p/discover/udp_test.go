@@ -36,6 +36,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/p2p/enr"
 	"github.com/tomochain/tomochain/rlp"
 )
 
@@ -70,7 +71,7 @@ func newUDPTest(t *testing.T) *udpTest {
 		remotekey:  newkey(),
 		remoteaddr: &net.UDPAddr{IP: net.IP{10, 0, 1, 99}, Port: 30303},
 	}
-	test.table, test.udp, _ = newUDP(test.pipe, Config{PrivateKey: test.localkey})
+	test.table, test.udp, _ = newUDP(test.pipe, Config{PrivateKey: test.localkey, TCPPort: int(testLocal.TCP)})
 	// Wait for initial refresh so the table doesn't send unexpected findnode.
 	<-test.table.initDone
 	return test
@@ -137,7 +138,7 @@ func TestUDP_pingTimeout(t *testing.T) {
 
 	toaddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 2222}
 	toid := NodeID{1, 2, 3, 4}
-	if err := test.udp.ping(toid, toaddr); err != errTimeout {
+	if _, err := test.udp.ping(toid, toaddr); err != errTimeout {
 		t.Error("expected timeout error, got", err)
 	}
 }
@@ -387,6 +388,78 @@ func TestUDP_successfulPing(t *testing.T) {
 	}
 }
 
+func TestUDP_ENRRequest(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	// Records are only served to bonded nodes.
+	test.packetIn(errUnknownNode, enrRequestPacket, &enrRequest{Expiration: futureExp})
+
+	test.table.db.updateBondTime(PubkeyID(&test.remotekey.PublicKey), time.Now())
+	test.packetIn(nil, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	test.waitPacketOut(func(p *enrResponse) {
+		reqhash := test.sent[1][:macSize]
+		if !bytes.Equal(p.ReplyTok, reqhash) {
+			t.Errorf("got enrResponse.ReplyTok %x, want %x", p.ReplyTok, reqhash)
+		}
+		var pubkey enr.Secp256k1
+		if err := p.Record.Load(&pubkey); err != nil {
+			t.Fatalf("can't load public key: %v", err)
+		}
+		if id := PubkeyID((*ecdsa.PublicKey)(&pubkey)); id != test.table.self.ID {
+			t.Errorf("record has wrong node ID: got %v, want %v", id, test.table.self.ID)
+		}
+		var port enr.UDP
+		if err := p.Record.Load(&port); err != nil || uint16(port) != testLocal.UDP {
+			t.Errorf("record has wrong UDP port: got %d (err %v), want %d", port, err, testLocal.UDP)
+		}
+		var tcp enr.TCP
+		if err := p.Record.Load(&tcp); err != nil || uint16(tcp) != testLocal.TCP {
+			t.Errorf("record has wrong TCP port: got %d (err %v), want %d", tcp, err, testLocal.TCP)
+		}
+	})
+}
+
+func TestUDP_successfulPingENR(t *testing.T) {
+	test := newUDPTest(t)
+	added := make(chan *Node, 1)
+	test.table.nodeAddedHook = func(n *Node) { added <- n }
+	defer test.table.Close()
+
+	var remote enr.Record
+	remote.Set(enr.WithEntry("test", uint(7)))
+	if err := remote.Sign(test.remotekey); err != nil {
+		t.Fatal(err)
+	}
+	go test.packetIn(nil, pingTomo, &ping{From: testRemote, To: testLocalAnnounced, Version: Version, Expiration: futureExp})
+
+	// The pong carries the sequence number of the local record.
+	test.waitPacketOut(func(p *pong) {
+		if seq := seqFromTail(p.Rest); seq != test.table.self.Record().Seq() {
+			t.Errorf("got pong record seq %d, want %d", seq, test.table.self.Record().Seq())
+		}
+	})
+	hash, _ := test.waitPacketOut(func(p *ping) {})
+	test.packetIn(nil, pongPacket, &pong{ReplyTok: hash, Expiration: futureExp, Rest: seqTail(remote.Seq())})
+
+	// The remote node advertised a record, so the table requests it.
+	hash, _ = test.waitPacketOut(func(p *enrRequest) {})
+	test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: hash, Record: remote})
+
+	select {
+	case n := <-added:
+		if n.Record() == nil {
+			t.Fatal("node has no record")
+		}
+		var v uint
+		if err := n.Record().Load(enr.WithEntry("test", &v)); err != nil || v != 7 {
+			t.Errorf("node record has wrong entry: got %d (err %v), want 7", v, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("node was not added within 2 seconds")
+	}
+}
+
 var testPackets = []struct {
 	input      string
 	wantPacket interface{}
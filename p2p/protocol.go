@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	"github.com/tomochain/tomochain/p2p/discover"
+	"github.com/tomochain/tomochain/p2p/enr"
 )
 
 // Protocol represents a P2P subprotocol implementation.
@@ -52,6 +53,15 @@ type Protocol struct {
 	// about a certain peer in the network. If an info retrieval function is set,
 	// but returns nil, it is assumed that the protocol handshake is still running.
 	PeerInfo func(id discover.NodeID) interface{}
+
+	// Attributes contains protocol specific entries which are added to
+	// the node record advertised through discovery.
+	Attributes []enr.Entry
+
+	// DialFilter is an optional function checking the node record of a
+	// discovered node before it is dialed. Nodes whose record is rejected
+	// are skipped. Nodes which don't advertise a record are always dialed.
+	DialFilter func(*enr.Record) error
 }
 
 func (p Protocol) cap() Cap {
@@ -31,6 +31,7 @@ import (
 	"github.com/tomochain/tomochain/log"
 	"github.com/tomochain/tomochain/p2p/discover"
 	"github.com/tomochain/tomochain/p2p/discv5"
+	"github.com/tomochain/tomochain/p2p/enr"
 	"github.com/tomochain/tomochain/p2p/nat"
 	"github.com/tomochain/tomochain/p2p/netutil"
 )
@@ -337,6 +338,43 @@ func (srv *Server) makeSelf(listener net.Listener, ntab discoverTable) *discover
 	return ntab.Self()
 }
 
+// NodeRecord returns the node record of the node with the given ID, if the
+// node and its record are present in the discovery table.
+func (srv *Server) NodeRecord(id discover.NodeID) *enr.Record {
+	srv.lock.Lock()
+	ntab := srv.ntab
+	srv.lock.Unlock()
+	if ntab == nil {
+		return nil
+	}
+	if n := ntab.Node(id); n != nil {
+		return n.Record()
+	}
+	return nil
+}
+
+// dialFilter returns a function checking the records of discovered nodes
+// against the dial filters of all protocols, or nil if no protocol has one.
+func (srv *Server) dialFilter() func(*enr.Record) error {
+	var filters []func(*enr.Record) error
+	for _, p := range srv.Protocols {
+		if p.DialFilter != nil {
+			filters = append(filters, p.DialFilter)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return func(r *enr.Record) error {
+		for _, filter := range filters {
+			if err := filter(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 // Stop terminates the server and all active peer connections.
 // It blocks until all active connections have been closed.
 func (srv *Server) Stop() {
@@ -421,6 +459,22 @@ func (srv *Server) Start() (err error) {
 		unhandled chan discover.ReadPacket
 	)
 
+	// handshake
+	srv.ourHandshake = &protoHandshake{Version: baseProtocolVersion, Name: srv.Name, ID: discover.PubkeyID(&srv.PrivateKey.PublicKey)}
+	for _, p := range srv.Protocols {
+		srv.ourHandshake.Caps = append(srv.ourHandshake.Caps, p.cap())
+	}
+	// listen, before discovery so the node record carries the TCP port
+	if srv.ListenAddr != "" {
+		if err := srv.startListening(); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				srv.listener.Close()
+			}
+		}()
+	}
 	if !srv.NoDiscovery || srv.DiscoveryV5 {
 		addr, err := net.ResolveUDPAddr("udp", srv.ListenAddr)
 		if err != nil {
@@ -457,6 +511,12 @@ func (srv *Server) Start() (err error) {
 			Bootnodes:    srv.BootstrapNodes,
 			Unhandled:    unhandled,
 		}
+		if srv.listener != nil {
+			cfg.TCPPort = srv.listener.Addr().(*net.TCPAddr).Port
+		}
+		for _, p := range srv.Protocols {
+			cfg.Entries = append(cfg.Entries, p.Attributes...)
+		}
 		ntab, err := discover.ListenUDP(conn, cfg)
 		if err != nil {
 			return err
@@ -485,18 +545,8 @@ func (srv *Server) Start() (err error) {
 
 	dynPeers := srv.maxDialedConns()
 	dialer := newDialState(srv.StaticNodes, srv.BootstrapNodes, srv.ntab, dynPeers, srv.NetRestrict)
+	dialer.filter = srv.dialFilter()
 
-	// handshake
-	srv.ourHandshake = &protoHandshake{Version: baseProtocolVersion, Name: srv.Name, ID: discover.PubkeyID(&srv.PrivateKey.PublicKey)}
-	for _, p := range srv.Protocols {
-		srv.ourHandshake.Caps = append(srv.ourHandshake.Caps, p.cap())
-	}
-	// listen/dial
-	if srv.ListenAddr != "" {
-		if err := srv.startListening(); err != nil {
-			return err
-		}
-	}
 	if srv.NoDial && srv.ListenAddr == "" {
 		srv.log.Warn("P2P server will be useless, neither dialing nor listening")
 	}
@@ -171,6 +171,8 @@ type PosvConfig struct {
 	RewardCheckpoint    uint64         `json:"rewardCheckpoint"`    // Checkpoint block for calculate rewards.
 	Gap                 uint64         `json:"gap"`                 // Gap time preparing for the next epoch
 	FoudationWalletAddr common.Address `json:"foudationWalletAddr"` // Foundation Address Wallet
+
+	RewardPolicy *PosvRewardPolicy `json:"rewardPolicy,omitempty"` // Reward split and inflation, mainnet policy if nil
 }
 
 // String implements the stringer interface, returning the consensus engine details.
@@ -273,6 +275,54 @@ func (c *ChainConfig) IsTIPTomoXCancellationFee(num *big.Int) bool {
 	return isForked(common.TIPTomoXCancellationFee, num)
 }
 
+// IsTIPSigningKey returns whether masternodes may seal blocks with a signing
+// key registered for their candidate address.
+func (c *ChainConfig) IsTIPSigningKey(num *big.Int) bool {
+	return isForked(common.TIPSigningKey, num)
+}
+
+// IsTIPTomoXPartialRepay returns whether borrowers may repay a part of a
+// lending trade and auto top-up may lock less than the full required deposit.
+func (c *ChainConfig) IsTIPTomoXPartialRepay(num *big.Int) bool {
+	return isForked(common.TIPTomoXPartialRepay, num)
+}
+
+// IsTIPTomoXPartialLiquidation returns whether lending trades crossing their
+// liquidation price only lose the collateral needed to restore the deposit rate.
+func (c *ChainConfig) IsTIPTomoXPartialLiquidation(num *big.Int) bool {
+	return isForked(common.TIPTomoXPartialLiquidation, num)
+}
+
+// IsTIPTomoXPriceOracle returns whether collateral prices are taken from the
+// median of the whitelisted oracle feeders.
+func (c *ChainConfig) IsTIPTomoXPriceOracle(num *big.Int) bool {
+	return isForked(common.TIPTomoXPriceOracle, num)
+}
+
+// IsTIPTomoXRollover returns whether early repayments follow the interest
+// policy of the borrowing relayer and lending trades may be rolled over.
+func (c *ChainConfig) IsTIPTomoXRollover(num *big.Int) bool {
+	return isForked(common.TIPTomoXRollover, num)
+}
+
+// IsTIPTomoXMultiCollateral returns whether borrowers may pledge a basket of
+// collateral tokens for a lending trade.
+func (c *ChainConfig) IsTIPTomoXMultiCollateral(num *big.Int) bool {
+	return isForked(common.TIPTomoXMultiCollateral, num)
+}
+
+// IsTIPTomoXTradeTransfer returns whether investors may transfer their claim
+// on a lending trade to another address.
+func (c *ChainConfig) IsTIPTomoXTradeTransfer(num *big.Int) bool {
+	return isForked(common.TIPTomoXTradeTransfer, num)
+}
+
+// IsTIPTomoXBorrowerPolicy returns whether borrowers may register the policy
+// applied to their trades by the liquidation processing.
+func (c *ChainConfig) IsTIPTomoXBorrowerPolicy(num *big.Int) bool {
+	return isForked(common.TIPTomoXBorrowerPolicy, num)
+}
+
 // GasTable returns the gas table corresponding to the current phase (homestead or homestead reprice).
 //
 // The returned GasTable's fields shouldn't, under any circumstances, be changed.
@@ -24,3 +24,17 @@ const (
 	// contains.
 	BloomBitsBlocks uint64 = 4096
 )
+
+const (
+	// ImmutabilityThreshold is the number of blocks after which a chain segment is
+	// considered immutable (i.e. soft finality). It is used by the chain freezer
+	// to decide which blocks can be moved out of the key-value store into the
+	// append-only ancient tables. PoSV finalises blocks within a couple of epochs,
+	// so 100 epochs of 900 blocks leaves ample headroom for any reorg.
+	ImmutabilityThreshold uint64 = 90000
+
+	// MinImmutabilityThreshold is the smallest threshold the freezer accepts. It
+	// covers two full PoSV epochs, which is the deepest a checkpoint can still be
+	// rewritten by the masternode voting.
+	MinImmutabilityThreshold uint64 = 1800
+)
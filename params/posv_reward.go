@@ -0,0 +1,104 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package params
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/tomochain/tomochain/common"
+)
+
+// DefaultPosvRewardPolicy is the reward policy of TomoChain mainnet: every
+// signer reward goes 40% to the masternode owner, 50% to its voters and 10%
+// to the foundation, and the checkpoint reward is halved from the 2nd year
+// and quartered from the 5th year.
+var DefaultPosvRewardPolicy = &PosvRewardPolicy{
+	MasterPercent:     common.RewardMasterPercent,
+	VoterPercent:      common.RewardVoterPercent,
+	FoundationPercent: common.RewardFoundationPercent,
+	Inflation:         PosvHalvingSchedule(common.BlocksPerYear),
+}
+
+// PosvRewardPolicy configures how the checkpoint reward of a PoSV chain is
+// reduced over time and shared between the masternode owners, their voters
+// and the foundation wallet.
+type PosvRewardPolicy struct {
+	MasterPercent     uint64              `json:"masterPercent"`       // Share of a signer reward paid to the masternode owner
+	VoterPercent      uint64              `json:"voterPercent"`        // Share of a signer reward split between its voters by stake
+	FoundationPercent uint64              `json:"foundationPercent"`   // Share of a signer reward paid to the foundation wallet
+	MinSigns          uint64              `json:"minSigns"`            // Signed blocks needed in the reward range to get any reward
+	Inflation         []PosvInflationStep `json:"inflation,omitempty"` // Reward reductions, ordered by block number
+}
+
+// PosvInflationStep divides the checkpoint reward by Divisor from Block on.
+type PosvInflationStep struct {
+	Block   uint64 `json:"block"`
+	Divisor uint64 `json:"divisor"`
+}
+
+// PosvHalvingSchedule returns the mainnet inflation curve for the given
+// number of blocks per year.
+func PosvHalvingSchedule(blocksPerYear uint64) []PosvInflationStep {
+	return []PosvInflationStep{
+		{Block: blocksPerYear * 2, Divisor: 2},
+		{Block: blocksPerYear * 5, Divisor: 4},
+	}
+}
+
+// Rewards returns the reward policy of the chain, which is the mainnet one
+// unless the genesis configures another.
+func (c *PosvConfig) Rewards() *PosvRewardPolicy {
+	if c.RewardPolicy != nil {
+		return c.RewardPolicy
+	}
+	return DefaultPosvRewardPolicy
+}
+
+// Validate checks that the shares add up to the whole signer reward and that
+// the inflation steps are usable.
+func (p *PosvRewardPolicy) Validate() error {
+	if total := p.MasterPercent + p.VoterPercent + p.FoundationPercent; total != 100 {
+		return fmt.Errorf("reward shares add up to %d%%, want 100%%", total)
+	}
+	for i, step := range p.Inflation {
+		if step.Divisor == 0 {
+			return fmt.Errorf("inflation step %d: zero divisor", i)
+		}
+		if i > 0 && step.Block <= p.Inflation[i-1].Block {
+			return fmt.Errorf("inflation step %d: block %d not after block %d", i, step.Block, p.Inflation[i-1].Block)
+		}
+	}
+	return nil
+}
+
+// Inflate returns the checkpoint reward paid at the given block number.
+func (p *PosvRewardPolicy) Inflate(reward *big.Int, number uint64) *big.Int {
+	divisor := uint64(1)
+	for _, step := range p.Inflation {
+		if number < step.Block {
+			break
+		}
+		divisor = step.Divisor
+	}
+	return new(big.Int).Div(reward, new(big.Int).SetUint64(divisor))
+}
+
+// Share returns the given percent of a signer reward.
+func (p *PosvRewardPolicy) Share(reward *big.Int, percent uint64) *big.Int {
+	share := new(big.Int).Mul(reward, new(big.Int).SetUint64(percent))
+	return share.Div(share, big.NewInt(100))
+}
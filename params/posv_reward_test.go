@@ -0,0 +1,56 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package params
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestPosvRewardPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy PosvRewardPolicy
+		valid  bool
+	}{
+		{*DefaultPosvRewardPolicy, true},
+		{PosvRewardPolicy{MasterPercent: 100}, true},
+		{PosvRewardPolicy{MasterPercent: 40, VoterPercent: 50}, false},
+		{PosvRewardPolicy{FoundationPercent: 100, Inflation: []PosvInflationStep{{Block: 10, Divisor: 0}}}, false},
+		{PosvRewardPolicy{FoundationPercent: 100, Inflation: []PosvInflationStep{{Block: 10, Divisor: 2}, {Block: 10, Divisor: 4}}}, false},
+	}
+	for i, tt := range tests {
+		if err := tt.policy.Validate(); (err == nil) != tt.valid {
+			t.Errorf("test %d: validity mismatch: err %v, want valid %v", i, err, tt.valid)
+		}
+	}
+}
+
+func TestPosvRewardPolicyInflate(t *testing.T) {
+	policy := &PosvRewardPolicy{Inflation: PosvHalvingSchedule(10)}
+	reward := big.NewInt(1000)
+	for number, want := range map[uint64]int64{0: 1000, 19: 1000, 20: 500, 49: 500, 50: 250, 1000: 250} {
+		if have := policy.Inflate(reward, number); have.Int64() != want {
+			t.Errorf("block %d: reward mismatch: have %v, want %d", number, have, want)
+		}
+	}
+	if reward.Int64() != 1000 {
+		t.Errorf("input reward modified: %v", reward)
+	}
+	// Without a policy the mainnet one applies
+	if (&PosvConfig{}).Rewards() != DefaultPosvRewardPolicy {
+		t.Errorf("default policy not used")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tomox
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+)
+
+// InvariantViolation is an inconsistency found in the state of the exchange.
+type InvariantViolation struct {
+	Check   string      // Name of the violated invariant
+	Hash    common.Hash // Orderbook, lending book, order or account at fault
+	Message string
+}
+
+func (v InvariantViolation) String() string {
+	return fmt.Sprintf("%s %s: %s", v.Check, v.Hash.Hex(), v.Message)
+}
+
+// CheckTradingInvariants verifies the orderbooks of all the trading pairs and
+// the relayer deposits, returning the violations found.
+func CheckTradingInvariants(statedb *state.StateDB, tradingState *tradingstate.TradingStateDB) ([]InvariantViolation, error) {
+	pairs, err := tradingstate.GetAllTradingPairs(statedb)
+	if err != nil {
+		return nil, err
+	}
+	orderBooks := make([]common.Hash, 0, len(pairs))
+	for orderBook := range pairs {
+		orderBooks = append(orderBooks, orderBook)
+	}
+	sortHashes(orderBooks)
+
+	var violations []InvariantViolation
+	for _, orderBook := range orderBooks {
+		if !tradingState.Exist(orderBook) {
+			continue
+		}
+		asks, err := tradingState.DumpAskTrie(orderBook)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, checkOrderTree(tradingState, orderBook, tradingstate.Ask, asks)...)
+		bids, err := tradingState.DumpBidTrie(orderBook)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, checkOrderTree(tradingState, orderBook, tradingstate.Bid, bids)...)
+	}
+	return append(violations, checkRelayerDeposits(statedb)...), nil
+}
+
+// checkOrderTree verifies that the volume of each price level is the sum of
+// its orders, and that every order of the level is stored with its remaining
+// quantity.
+func checkOrderTree(tradingState *tradingstate.TradingStateDB, orderBook common.Hash, side string, tree map[*big.Int]tradingstate.DumpOrderList) []InvariantViolation {
+	var violations []InvariantViolation
+	for _, price := range sortedPrices(tree) {
+		list := tree[price]
+		volume := new(big.Int)
+		for _, orderId := range sortedKeys(list.Orders) {
+			amount := list.Orders[orderId]
+			volume.Add(volume, amount)
+
+			orderIdHash := common.BigToHash(orderId)
+			order := tradingState.GetOrder(orderBook, orderIdHash)
+			switch {
+			case order.Quantity == nil:
+				violations = append(violations, InvariantViolation{"order", orderIdHash, fmt.Sprintf("order %v of orderbook %s at price %v is missing", orderId, orderBook.Hex(), price)})
+			case order.Side != side || order.Price == nil || order.Price.Cmp(price) != 0:
+				violations = append(violations, InvariantViolation{"order", order.Hash, fmt.Sprintf("order %v of orderbook %s is a %s order at price %v, listed as %s at %v", orderId, orderBook.Hex(), order.Side, order.Price, side, price)})
+			case amount.Sign() <= 0 || order.Quantity.Cmp(amount) != 0:
+				violations = append(violations, InvariantViolation{"order", order.Hash, fmt.Sprintf("order %v of orderbook %s has a remaining quantity of %v, listed with %v", orderId, orderBook.Hex(), order.Quantity, amount)})
+			case order.FilledAmount != nil && order.FilledAmount.Sign() < 0:
+				violations = append(violations, InvariantViolation{"order", order.Hash, fmt.Sprintf("order %v of orderbook %s has a negative filled amount %v", orderId, orderBook.Hex(), order.FilledAmount)})
+			}
+		}
+		if list.Volume == nil || list.Volume.Cmp(volume) != 0 {
+			violations = append(violations, InvariantViolation{"volume", orderBook, fmt.Sprintf("%s volume at price %v is %v, orders sum up to %v", side, price, list.Volume, volume)})
+		}
+	}
+	return violations
+}
+
+// checkRelayerDeposits verifies that the relayer registration contract holds
+// the deposits of all the relayers, and that the deposit of each active
+// relayer still covers the locked fund its fees are charged above.
+func checkRelayerDeposits(statedb *state.StateDB) []InvariantViolation {
+	var violations []InvariantViolation
+	contract := common.HexToAddress(common.RelayerRegistrationSMC)
+	deposits := new(big.Int)
+	for _, relayer := range tradingstate.GetAllCoinbases(statedb) {
+		deposits.Add(deposits, tradingstate.GetRelayerDeposit(relayer, statedb))
+		if tradingstate.IsResignedRelayer(relayer, statedb) {
+			continue
+		}
+		if err := tradingstate.CheckRelayerFee(relayer, common.Big0, statedb); err != nil {
+			violations = append(violations, InvariantViolation{"relayer", relayer.Hash(), err.Error()})
+		}
+	}
+	if balance := statedb.GetBalance(contract); balance.Cmp(deposits) < 0 {
+		violations = append(violations, InvariantViolation{"relayer", contract.Hash(), fmt.Sprintf("contract balance %v doesn't cover the relayer deposits %v", balance, deposits)})
+	}
+	return violations
+}
+
+func sortHashes(hashes []common.Hash) {
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i].Bytes(), hashes[j].Bytes()) < 0
+	})
+}
+
+func sortedPrices(tree map[*big.Int]tradingstate.DumpOrderList) []*big.Int {
+	prices := make([]*big.Int, 0, len(tree))
+	for price := range tree {
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].Cmp(prices[j]) < 0
+	})
+	return prices
+}
+
+func sortedKeys(m map[*big.Int]*big.Int) []*big.Int {
+	keys := make([]*big.Int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Cmp(keys[j]) < 0
+	})
+	return keys
+}
@@ -14,9 +14,21 @@ import (
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/core/state"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
 	"github.com/tomochain/tomochain/tomox/tradingstate"
 )
 
+var (
+	// Rejected orders, broken down by the reason of the rejection
+	rejectInvalidCounter  = metrics.NewRegisteredCounter("tomox/orders/rejected/invalid", nil)
+	rejectCancelCounter   = metrics.NewRegisteredCounter("tomox/orders/rejected/cancel", nil)
+	rejectPriceCounter    = metrics.NewRegisteredCounter("tomox/orders/rejected/price", nil)
+	rejectQuantityCounter = metrics.NewRegisteredCounter("tomox/orders/rejected/quantity", nil)
+	rejectMatchCounter    = metrics.NewRegisteredCounter("tomox/orders/rejected/match", nil)
+	rejectTooSmallCounter = metrics.NewRegisteredCounter("tomox/orders/rejected/toosmall", nil)
+	rejectFundsCounter    = metrics.NewRegisteredCounter("tomox/orders/rejected/funds", nil)
+)
+
 func (tomox *TomoX) CommitOrder(header *types.Header, coinbase common.Address, chain consensus.ChainContext, statedb *state.StateDB, tradingStateDB *tradingstate.TradingStateDB, orderBook common.Hash, order *tradingstate.OrderItem) ([]map[string]string, []*tradingstate.OrderItem, error) {
 	tomoxSnap := tradingStateDB.Snapshot()
 	dbSnap := statedb.Snapshot()
@@ -57,6 +69,7 @@ func (tomox *TomoX) ApplyOrder(header *types.Header, coinbase common.Address, ch
 	}()
 
 	if err := order.VerifyOrder(statedb); err != nil {
+		rejectInvalidCounter.Inc(1)
 		rejects = append(rejects, order)
 		return trades, rejects, nil
 	}
@@ -64,6 +77,7 @@ func (tomox *TomoX) ApplyOrder(header *types.Header, coinbase common.Address, ch
 		err, reject := tomox.ProcessCancelOrder(header, tradingStateDB, statedb, chain, coinbase, orderBook, order)
 		if err != nil || reject {
 			log.Debug("Reject cancelled order", "err", err)
+			rejectCancelCounter.Inc(1)
 			rejects = append(rejects, order)
 		}
 		return trades, rejects, nil
@@ -71,12 +85,14 @@ func (tomox *TomoX) ApplyOrder(header *types.Header, coinbase common.Address, ch
 	if order.Type != tradingstate.Market {
 		if order.Price.Sign() == 0 || common.BigToHash(order.Price).Big().Cmp(order.Price) != 0 {
 			log.Debug("Reject order price invalid", "price", order.Price)
+			rejectPriceCounter.Inc(1)
 			rejects = append(rejects, order)
 			return trades, rejects, nil
 		}
 	}
 	if order.Quantity.Sign() == 0 || common.BigToHash(order.Quantity).Big().Cmp(order.Quantity) != 0 {
 		log.Debug("Reject order quantity invalid", "quantity", order.Quantity)
+		rejectQuantityCounter.Inc(1)
 		rejects = append(rejects, order)
 		return trades, rejects, nil
 	}
@@ -87,6 +103,7 @@ func (tomox *TomoX) ApplyOrder(header *types.Header, coinbase common.Address, ch
 		trades, rejects, err = tomox.processMarketOrder(coinbase, chain, statedb, tradingStateDB, orderBook, order)
 		if err != nil {
 			log.Debug("Reject market order", "err", err, "order", tradingstate.ToJSON(order))
+			rejectMatchCounter.Inc(1)
 			trades = []map[string]string{}
 			rejects = append(rejects, order)
 		}
@@ -95,6 +112,7 @@ func (tomox *TomoX) ApplyOrder(header *types.Header, coinbase common.Address, ch
 		trades, rejects, err = tomox.processLimitOrder(coinbase, chain, statedb, tradingStateDB, orderBook, order)
 		if err != nil {
 			log.Debug("Reject limit order", "err", err, "order", tradingstate.ToJSON(order))
+			rejectMatchCounter.Inc(1)
 			trades = []map[string]string{}
 			rejects = append(rejects, order)
 		}
@@ -256,6 +274,7 @@ func (tomox *TomoX) processOrderList(coinbase common.Address, chain consensus.Ch
 		}
 		tradedQuantity, rejectMaker, settleBalanceResult, err := tomox.getTradeQuantity(quotePrice, coinbase, chain, statedb, order, &oldestOrder, maxTradedQuantity)
 		if err != nil && err == tradingstate.ErrQuantityTradeTooSmall {
+			rejectTooSmallCounter.Inc(1)
 			if tradedQuantity.Cmp(maxTradedQuantity) == 0 {
 				if quantityToTrade.Cmp(amount) == 0 { // reject Taker & maker
 					rejects = append(rejects, order)
@@ -297,6 +316,7 @@ func (tomox *TomoX) processOrderList(coinbase common.Address, chain consensus.Ch
 		}
 		if tradedQuantity.Sign() == 0 && !rejectMaker {
 			log.Debug("Reject order Taker ", "tradedQuantity", tradedQuantity, "rejectMaker", rejectMaker)
+			rejectFundsCounter.Inc(1)
 			rejects = append(rejects, order)
 			quantityToTrade = tradingstate.Zero
 			break
@@ -0,0 +1,74 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tomox
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+)
+
+// ErrNoRelayerIncome is returned when relayer income is requested from a node
+// that does not keep the matched trades.
+var ErrNoRelayerIncome = errors.New("relayer income is only tracked by SDK nodes")
+
+// RelayerFee is the fee one side of a trade paid to its relayer.
+type RelayerFee struct {
+	Relayer common.Address
+	Token   common.Address
+	Amount  *big.Int
+}
+
+// GetRelayerIncome returns the trading fees earned by the relayer on the trades
+// matched in [from, to), per quote token. Trades are only kept by SDK nodes.
+func (tomox *TomoX) GetRelayerIncome(relayer common.Address, from, to time.Time) (map[common.Address]*big.Int, error) {
+	return tomox.SumRelayerIncome(relayer, from, to, &tradingstate.Trade{}, func(items interface{}) []RelayerFee {
+		var fees []RelayerFee
+		for _, trade := range items.([]*tradingstate.Trade) {
+			fees = append(fees,
+				RelayerFee{Relayer: trade.MakerExchange, Token: trade.QuoteToken, Amount: trade.MakeFee},
+				RelayerFee{Relayer: trade.TakerExchange, Token: trade.QuoteToken, Amount: trade.TakeFee},
+			)
+		}
+		return fees
+	})
+}
+
+// SumRelayerIncome loads the items of val's type stored for the relayer in
+// [from, to) and sums, per token, the fees that fees reports as paid to it.
+func (tomox *TomoX) SumRelayerIncome(relayer common.Address, from, to time.Time, val interface{}, fees func(items interface{}) []RelayerFee) (map[common.Address]*big.Int, error) {
+	if !tomox.IsSDKNode() {
+		return nil, ErrNoRelayerIncome
+	}
+	income := map[common.Address]*big.Int{}
+	items := tomox.GetMongoDB().GetListItemByRelayer(relayer, from, to, val)
+	if items == nil {
+		return income, nil
+	}
+	for _, fee := range fees(items) {
+		if fee.Relayer != relayer || fee.Amount == nil {
+			continue
+		}
+		if income[fee.Token] == nil {
+			income[fee.Token] = new(big.Int)
+		}
+		income[fee.Token].Add(income[fee.Token], fee.Amount)
+	}
+	return income, nil
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/core/state"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
 	"github.com/tomochain/tomochain/rpc"
 	"golang.org/x/sync/syncmap"
 )
@@ -36,9 +37,17 @@ var (
 	ErrNonceTooLow  = errors.New("nonce too low")
 )
 
+var (
+	processOrdersTimer = metrics.NewRegisteredTimer("tomox/process/orders", nil)
+	// blockTradesHistogram is shared with the block validator, which records
+	// the trades of imported blocks.
+	blockTradesHistogram = metrics.GetOrRegisterHistogram("tomox/block/trades", nil, metrics.NewExpDecaySample(1028, 0.015))
+)
+
 type Config struct {
 	DataDir        string `toml:",omitempty"`
 	DBEngine       string `toml:",omitempty"`
+	StorageEngine  string `toml:",omitempty"` // Key-value store backing the leveldb engine (leveldb, pebble)
 	DBName         string `toml:",omitempty"`
 	ConnectionUrl  string `toml:",omitempty"`
 	ReplicaSetName string `toml:",omitempty"`
@@ -80,7 +89,7 @@ func (tomox *TomoX) Stop() error {
 
 func NewLDBEngine(cfg *Config) *tomoxDAO.BatchDatabase {
 	datadir := cfg.DataDir
-	batchDB := tomoxDAO.NewBatchDatabaseWithEncode(datadir, 0)
+	batchDB := tomoxDAO.NewBatchDatabaseWithEngine(cfg.StorageEngine, datadir, 0)
 	return batchDB
 }
 
@@ -158,6 +167,12 @@ func (tomox *TomoX) ProcessOrderPending(header *types.Header, coinbase common.Ad
 	txMatches := []tradingstate.TxDataMatch{}
 	matchingResults := map[common.Hash]tradingstate.MatchingResult{}
 
+	start, trades := time.Now(), 0
+	defer func() {
+		processOrdersTimer.UpdateSince(start)
+		blockTradesHistogram.Update(int64(trades))
+	}()
+
 	txs := types.NewOrderTransactionByNonce(types.OrderTxSigner{}, pending)
 	numberTx := 0
 	for {
@@ -255,6 +270,7 @@ func (tomox *TomoX) ProcessOrderPending(header *types.Header, coinbase common.Ad
 			Order: originalOrderValue,
 		}
 		txMatches = append(txMatches, txMatch)
+		trades += len(newTrades)
 		matchingResults[tradingstate.GetMatchingResultCacheKey(order)] = tradingstate.MatchingResult{
 			Trades:  newTrades,
 			Rejects: newRejectedOrders,
@@ -326,6 +326,9 @@ func (self *tradingExchanges) deepCopy(db *TradingStateDB, onDirty func(hash com
 	if self.ordersTrie != nil {
 		stateExchanges.ordersTrie = db.db.CopyTrie(self.ordersTrie)
 	}
+	if self.liquidationPriceTrie != nil {
+		stateExchanges.liquidationPriceTrie = db.db.CopyTrie(self.liquidationPriceTrie)
+	}
 	for price, bidObject := range self.stateBidObjects {
 		stateExchanges.stateBidObjects[price] = bidObject.deepCopy(db, self.MarkStateBidObjectDirty)
 	}
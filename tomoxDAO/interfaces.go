@@ -5,6 +5,8 @@
 package tomoxDAO
 
 import (
+	"time"
+
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/ethdb"
 )
@@ -24,6 +26,7 @@ type TomoXDAO interface {
 	GetListItemByTxHash(txhash common.Hash, val interface{}) interface{}
 	GetListItemByHashes(hashes []string, val interface{}) interface{}
 	DeleteItemByTxHash(txhash common.Hash, val interface{})
+	GetListItemByRelayer(relayer common.Address, from, to time.Time, val interface{}) interface{}
 
 	// basic tomox
 	InitBulk()
@@ -7,6 +7,7 @@ import (
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/core/rawdb"
 	"sync"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/tomochain/tomochain/ethdb"
@@ -33,7 +34,19 @@ func NewBatchDatabase(datadir string, cacheLimit int) *BatchDatabase {
 
 // batchdatabase is a fast cache db to retrieve in-mem object
 func NewBatchDatabaseWithEncode(datadir string, cacheLimit int) *BatchDatabase {
-	db, err := rawdb.NewLevelDBDatabase(datadir, 128, 1024, "")
+	return NewBatchDatabaseWithEngine("", datadir, cacheLimit)
+}
+
+// NewBatchDatabaseWithEngine creates the batch database on top of the given
+// key-value store engine (leveldb or pebble). An empty engine keeps the one an
+// existing database was created with, defaulting to leveldb for new ones.
+func NewBatchDatabaseWithEngine(engine string, datadir string, cacheLimit int) *BatchDatabase {
+	db, err := rawdb.Open(rawdb.OpenOptions{
+		Type:      engine,
+		Directory: datadir,
+		Cache:     128,
+		Handles:   1024,
+	})
 	if err != nil {
 		log.Error("Can't create new DB", "error", err)
 		return nil
@@ -119,6 +132,10 @@ func (db *BatchDatabase) GetListItemByHashes(hashes []string, val interface{}) i
 	return []interface{}{}
 }
 
+func (db *BatchDatabase) GetListItemByRelayer(relayer common.Address, from, to time.Time, val interface{}) interface{} {
+	return []interface{}{}
+}
+
 func (db *BatchDatabase) InitBulk() {
 }
 
@@ -25,6 +25,7 @@ const (
 	lendingRepayCollection  = "lending_repays"
 	lendingRecallCollection = "lending_recalls"
 	epochPriceCollection    = "epoch_prices"
+	marketStatsCollection   = "lending_market_stats"
 )
 
 type MongoDatabase struct {
@@ -40,6 +41,7 @@ type MongoDatabase struct {
 	recallBulk       *mgo.Bulk
 	repayBulk        *mgo.Bulk
 	lendingTradeBulk *mgo.Bulk
+	marketStatsBulk  *mgo.Bulk
 }
 
 // InitSession initializes a new session with mongodb
@@ -127,7 +129,7 @@ func (db *MongoDatabase) HasObject(hash common.Hash, val interface{}) (bool, err
 		// Find key in lendingItemsCollection collection
 		item := val.(*lendingstate.LendingItem)
 		switch item.Type {
-		case lendingstate.Repay:
+		case lendingstate.Repay, lendingstate.PartialRepay:
 			count, err = sc.DB(db.dbName).C(lendingRepayCollection).Find(query).Limit(1).Count()
 		case lendingstate.TopUp:
 			count, err = sc.DB(db.dbName).C(lendingTopUpCollection).Find(query).Limit(1).Count()
@@ -152,6 +154,16 @@ func (db *MongoDatabase) HasObject(hash common.Hash, val interface{}) (bool, err
 			return false, err
 		}
 
+		if count == 1 {
+			return true, nil
+		}
+	case *lendingstate.LendingMarketStats:
+		count, err = sc.DB(db.dbName).C(marketStatsCollection).Find(query).Limit(1).Count()
+
+		if err != nil {
+			return false, err
+		}
+
 		if count == 1 {
 			return true, nil
 		}
@@ -197,7 +209,7 @@ func (db *MongoDatabase) GetObject(hash common.Hash, val interface{}) (interface
 			var err error
 			item := val.(*lendingstate.LendingItem)
 			switch item.Type {
-			case lendingstate.Repay:
+			case lendingstate.Repay, lendingstate.PartialRepay:
 				err = sc.DB(db.dbName).C(lendingRepayCollection).Find(query).One(&li)
 			case lendingstate.TopUp:
 				err = sc.DB(db.dbName).C(lendingTopUpCollection).Find(query).One(&li)
@@ -219,6 +231,14 @@ func (db *MongoDatabase) GetObject(hash common.Hash, val interface{}) (interface
 			}
 			db.cacheItems.Add(cacheKey, t)
 			return t, nil
+		case *lendingstate.LendingMarketStats:
+			var stats *lendingstate.LendingMarketStats
+			err := sc.DB(db.dbName).C(marketStatsCollection).Find(query).One(&stats)
+			if err != nil {
+				return nil, err
+			}
+			db.cacheItems.Add(cacheKey, stats)
+			return stats, nil
 		default:
 			return nil, nil
 		}
@@ -257,11 +277,16 @@ func (db *MongoDatabase) PutObject(hash common.Hash, val interface{}) error {
 		} else {
 			db.lendingTradeBulk.Insert(lt)
 		}
+	case *lendingstate.LendingMarketStats:
+		stats := val.(*lendingstate.LendingMarketStats)
+		query := bson.M{"hash": stats.Hash.Hex()}
+		db.marketStatsBulk.Upsert(query, stats)
+		return nil
 	case *lendingstate.LendingItem:
 		// PutObject order into ordersCollection collection
 		li := val.(*lendingstate.LendingItem)
 		switch li.Type {
-		case lendingstate.Repay:
+		case lendingstate.Repay, lendingstate.PartialRepay:
 			if li.Status != lendingstate.LendingStatusReject {
 				li.Status = lendingstate.Repay
 			}
@@ -326,7 +351,7 @@ func (db *MongoDatabase) DeleteObject(hash common.Hash, val interface{}) error {
 		case *lendingstate.LendingItem:
 			item := val.(*lendingstate.LendingItem)
 			switch item.Type {
-			case lendingstate.Repay:
+			case lendingstate.Repay, lendingstate.PartialRepay:
 				err = sc.DB(db.dbName).C(lendingRepayCollection).Remove(query)
 			case lendingstate.TopUp:
 				err = sc.DB(db.dbName).C(lendingTopUpCollection).Remove(query)
@@ -343,6 +368,11 @@ func (db *MongoDatabase) DeleteObject(hash common.Hash, val interface{}) error {
 			if err != nil && err != mgo.ErrNotFound {
 				return fmt.Errorf("failed to delete lendingTrade. Err: %v", err)
 			}
+		case *lendingstate.LendingMarketStats:
+			err = sc.DB(db.dbName).C(marketStatsCollection).Remove(query)
+			if err != nil && err != mgo.ErrNotFound {
+				return fmt.Errorf("failed to delete lendingMarketStats. Err: %v", err)
+			}
 
 		}
 	}
@@ -364,6 +394,7 @@ func (db *MongoDatabase) InitLendingBulk() {
 	db.topUpBulk = sc.DB(db.dbName).C(lendingTopUpCollection).Bulk()
 	db.repayBulk = sc.DB(db.dbName).C(lendingRepayCollection).Bulk()
 	db.recallBulk = sc.DB(db.dbName).C(lendingRecallCollection).Bulk()
+	db.marketStatsBulk = sc.DB(db.dbName).C(marketStatsCollection).Bulk()
 }
 
 func (db *MongoDatabase) CommitBulk() error {
@@ -395,6 +426,9 @@ func (db *MongoDatabase) CommitLendingBulk() error {
 	if _, err := db.recallBulk.Run(); err != nil && !mgo.IsDup(err) {
 		return err
 	}
+	if _, err := db.marketStatsBulk.Run(); err != nil && !mgo.IsDup(err) {
+		return err
+	}
 	return nil
 }
 
@@ -435,7 +469,7 @@ func (db *MongoDatabase) DeleteItemByTxHash(txhash common.Hash, val interface{})
 	case *lendingstate.LendingItem:
 		item := val.(*lendingstate.LendingItem)
 		switch item.Type {
-		case lendingstate.Repay:
+		case lendingstate.Repay, lendingstate.PartialRepay:
 			if err := sc.DB(db.dbName).C(lendingRepayCollection).Remove(query); err != nil && err != mgo.ErrNotFound {
 				log.Error("DeleteItemByTxHash: failed to delete repayItem", "txhash", txhash, "err", err)
 			}
@@ -489,7 +523,7 @@ func (db *MongoDatabase) GetListItemByTxHash(txhash common.Hash, val interface{}
 		item := val.(*lendingstate.LendingItem)
 		result := []*lendingstate.LendingItem{}
 		switch item.Type {
-		case lendingstate.Repay:
+		case lendingstate.Repay, lendingstate.PartialRepay:
 			if err := sc.DB(db.dbName).C(lendingRepayCollection).Find(query).All(&result); err != nil && err != mgo.ErrNotFound {
 				log.Error("failed to GetListItemByTxHash (repayItems)", "err", err, "txhash", txhash)
 			}
@@ -545,7 +579,7 @@ func (db *MongoDatabase) GetListItemByHashes(hashes []string, val interface{}) i
 		item := val.(*lendingstate.LendingItem)
 		result := []*lendingstate.LendingItem{}
 		switch item.Type {
-		case lendingstate.Repay:
+		case lendingstate.Repay, lendingstate.PartialRepay:
 			if err := sc.DB(db.dbName).C(lendingRepayCollection).Find(query).All(&result); err != nil && err != mgo.ErrNotFound {
 				log.Error("failed to GetListItemByHashes (repayItems)", "err", err, "hashes", hashes)
 			}
@@ -572,12 +606,52 @@ func (db *MongoDatabase) GetListItemByHashes(hashes []string, val interface{}) i
 			log.Error("failed to GetListItemByHashes (lendingTrades)", "err", err, "hashes", hashes)
 		}
 		return result
+	case *lendingstate.LendingMarketStats:
+		result := []*lendingstate.LendingMarketStats{}
+		if err := sc.DB(db.dbName).C(marketStatsCollection).Find(query).All(&result); err != nil && err != mgo.ErrNotFound {
+			log.Error("failed to GetListItemByHashes (lendingMarketStats)", "err", err, "hashes", hashes)
+		}
+		return result
 	default:
 		log.Error("GetListItemByHashes: Unknown object type", "hashes", hashes, "object", val)
 	}
 	return nil
 }
 
+// GetListItemByRelayer returns the trades or lending trades matched by the
+// relayer, as maker or taker exchange, which were created in [from, to).
+func (db *MongoDatabase) GetListItemByRelayer(relayer common.Address, from, to time.Time, val interface{}) interface{} {
+	sc := db.Session.Copy()
+	defer sc.Close()
+
+	createdAt := bson.M{"$gte": from, "$lt": to}
+	switch val.(type) {
+	case *tradingstate.Trade:
+		query := bson.M{
+			"createdAt": createdAt,
+			"$or":       []bson.M{{"makerExchange": relayer.Hex()}, {"takerExchange": relayer.Hex()}},
+		}
+		result := []*tradingstate.Trade{}
+		if err := sc.DB(db.dbName).C(tradesCollection).Find(query).All(&result); err != nil && err != mgo.ErrNotFound {
+			log.Error("failed to GetListItemByRelayer (trades)", "err", err, "relayer", relayer)
+		}
+		return result
+	case *lendingstate.LendingTrade:
+		query := bson.M{
+			"createdAt": createdAt,
+			"$or":       []bson.M{{"borrowingRelayer": relayer.Hex()}, {"investingRelayer": relayer.Hex()}},
+		}
+		result := []*lendingstate.LendingTrade{}
+		if err := sc.DB(db.dbName).C(lendingTradesCollection).Find(query).All(&result); err != nil && err != mgo.ErrNotFound {
+			log.Error("failed to GetListItemByRelayer (lendingTrades)", "err", err, "relayer", relayer)
+		}
+		return result
+	default:
+		log.Error("GetListItemByRelayer: Unknown object type", "relayer", relayer, "object", val)
+	}
+	return nil
+}
+
 func (db *MongoDatabase) EnsureIndexes() error {
 	orderHashIndex := mgo.Index{
 		Key:        []string{"hash"},
@@ -609,6 +683,11 @@ func (db *MongoDatabase) EnsureIndexes() error {
 		Sparse:     true,
 		Name:       "index_trade_tx_hash",
 	}
+	tradeCreatedAtIndex := mgo.Index{
+		Key:        []string{"createdAt"},
+		Background: true,
+		Name:       "index_trade_created_at",
+	}
 	lendingItemHashIndex := mgo.Index{
 		Key:        []string{"hash"},
 		Unique:     true,
@@ -639,6 +718,11 @@ func (db *MongoDatabase) EnsureIndexes() error {
 		Sparse:     true,
 		Name:       "index_lending_trade_tx_hash",
 	}
+	lendingTradeCreatedAtIndex := mgo.Index{
+		Key:        []string{"createdAt"},
+		Background: true,
+		Name:       "index_lending_trade_created_at",
+	}
 	repayHashIndex := mgo.Index{
 		Key:        []string{"hash"},
 		DropDups:   true,
@@ -720,6 +804,15 @@ func (db *MongoDatabase) EnsureIndexes() error {
 		Name:       "index_epoch_price",
 	}
 
+	marketStatsIndex := mgo.Index{
+		Key:        []string{"hash"},
+		Unique:     true,
+		DropDups:   true,
+		Background: true,
+		Sparse:     true,
+		Name:       "index_lending_market_stats",
+	}
+
 	sc := db.Session.Copy()
 	defer sc.Close()
 
@@ -746,6 +839,11 @@ func (db *MongoDatabase) EnsureIndexes() error {
 			return fmt.Errorf("failed to create index %s . Err: %v", tradeTxHashIndex.Name, err)
 		}
 	}
+	if !existingIndex(tradeCreatedAtIndex.Name, indexes) {
+		if err := sc.DB(db.dbName).C(tradesCollection).EnsureIndex(tradeCreatedAtIndex); err != nil {
+			return fmt.Errorf("failed to create index %s . Err: %v", tradeCreatedAtIndex.Name, err)
+		}
+	}
 
 	indexes, _ = sc.DB(db.dbName).C(lendingItemsCollection).Indexes()
 	if !existingIndex(lendingItemHashIndex.Name, indexes) {
@@ -770,6 +868,11 @@ func (db *MongoDatabase) EnsureIndexes() error {
 			return fmt.Errorf("failed to create index %s . Err: %v", lendingTradeTxHashIndex.Name, err)
 		}
 	}
+	if !existingIndex(lendingTradeCreatedAtIndex.Name, indexes) {
+		if err := sc.DB(db.dbName).C(lendingTradesCollection).EnsureIndex(lendingTradeCreatedAtIndex); err != nil {
+			return fmt.Errorf("failed to create index %s . Err: %v", lendingTradeCreatedAtIndex.Name, err)
+		}
+	}
 
 	indexes, _ = sc.DB(db.dbName).C(lendingRepayCollection).Indexes()
 	if !existingIndex(repayHashIndex.Name, indexes) {
@@ -830,6 +933,13 @@ func (db *MongoDatabase) EnsureIndexes() error {
 			return fmt.Errorf("failed to create index %s . Err: %v", epochPriceIndex.Name, err)
 		}
 	}
+
+	indexes, _ = sc.DB(db.dbName).C(marketStatsCollection).Indexes()
+	if !existingIndex(marketStatsIndex.Name, indexes) {
+		if err := sc.DB(db.dbName).C(marketStatsCollection).EnsureIndex(marketStatsIndex); err != nil {
+			return fmt.Errorf("failed to create index %s . Err: %v", marketStatsIndex.Name, err)
+		}
+	}
 	return nil
 }
 
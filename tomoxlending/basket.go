@@ -0,0 +1,275 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tomoxlending
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+// BasketValue is the value of the collaterals of a lending trade in its lending token.
+type BasketValue struct {
+	Collaterals []lendingstate.BasketCollateral
+	Prices      []*big.Int // Price of each collateral in the lending token, nil if unknown
+	Value       *big.Int   // Sum of the collateral values
+	Threshold   *big.Int   // Sum of the collateral values over their liquidation rates
+	Incomplete  bool       // Whether a collateral has no known price or liquidation rate
+}
+
+// ProcessBasketTopUp pledges more collateral to a lending trade, in any of the
+// collateral tokens of its pair. The trade becomes a basket position: its
+// health is computed from all of its collaterals and it's not liquidated by
+// the liquidation price of its collateral token anymore.
+func (l *Lending) ProcessBasketTopUp(header *types.Header, chain consensus.ChainContext, lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, tradingStateDb *tradingstate.TradingStateDB, lendingBook common.Hash, order *lendingstate.LendingItem) (*lendingstate.LendingTrade, error) {
+	if !chain.Config().IsTIPTomoXMultiCollateral(header.Number) {
+		return nil, fmt.Errorf("ProcessBasketTopUp: collateral baskets are not supported yet")
+	}
+	lendingTradeId := order.LendingTradeId
+	lendingTrade := lendingStateDB.GetLendingTrade(lendingBook, common.Uint64ToHash(lendingTradeId))
+	if lendingTrade == lendingstate.EmptyLendingTrade || lendingTrade.TradeId != lendingTradeId {
+		return nil, fmt.Errorf("ProcessBasketTopUp for emptyLendingTrade is not allowed. lendingTradeId: %v", lendingTradeId)
+	}
+	if order.UserAddress != lendingTrade.Borrower {
+		return nil, fmt.Errorf("ProcessBasketTopUp: invalid userAddress . UserAddress: %s . Borrower: %s", order.UserAddress.Hex(), lendingTrade.Borrower.Hex())
+	}
+	if order.Relayer != lendingTrade.BorrowingRelayer {
+		return nil, fmt.Errorf("ProcessBasketTopUp: invalid relayerAddress . Got: %s . Expect: %s", order.Relayer.Hex(), lendingTrade.BorrowingRelayer.Hex())
+	}
+	if order.Quantity == nil || order.Quantity.Sign() <= 0 {
+		return nil, fmt.Errorf("ProcessBasketTopUp: invalid quantity %v", order.Quantity)
+	}
+	if err := verifyBasketToken(statedb, &lendingTrade, order.CollateralToken); err != nil {
+		return nil, err
+	}
+	if balance := lendingstate.GetTokenBalance(lendingTrade.Borrower, order.CollateralToken, statedb); balance.Cmp(order.Quantity) < 0 {
+		return nil, fmt.Errorf("not enough balance deposit. lendingTradeId: %v , Quantity : %v , tokenBalance : %v", lendingTradeId, order.Quantity, balance)
+	}
+	if !lendingstate.IsBasketPosition(statedb, lendingBook, lendingTradeId) {
+		// the health of a basket position doesn't depend on a single price anymore
+		orderbook := tradingstate.GetTradingOrderBookHash(lendingTrade.CollateralToken, lendingTrade.LendingToken)
+		if err := tradingStateDb.RemoveLiquidationPrice(orderbook, lendingTrade.LiquidationPrice, lendingBook, lendingTradeId); err != nil {
+			return nil, err
+		}
+	}
+	lendingstate.SubTokenBalance(lendingTrade.Borrower, order.Quantity, order.CollateralToken, statedb)
+	lendingstate.AddTokenBalance(common.HexToAddress(common.LendingLockAddress), order.Quantity, order.CollateralToken, statedb)
+	lendingstate.AddBasketCollateral(statedb, lendingBook, lendingTradeId, order.CollateralToken, order.Quantity)
+
+	extraData, _ := json.Marshal(struct {
+		Collaterals []lendingstate.BasketCollateral
+	}{
+		Collaterals: lendingstate.GetPositionCollaterals(statedb, lendingBook, &lendingTrade),
+	})
+	lendingTrade.ExtraData = string(extraData)
+	log.Debug("ProcessBasketTopUp", "lendingTradeId", lendingTradeId, "token", order.CollateralToken.Hex(), "quantity", order.Quantity)
+	return &lendingTrade, nil
+}
+
+// verifyBasketToken checks that the token is a collateral of the pair of the trade.
+func verifyBasketToken(statedb *state.StateDB, lendingTrade *lendingstate.LendingTrade, token common.Address) error {
+	if token == lendingTrade.LendingToken || token.String() == lendingstate.EmptyAddress {
+		return fmt.Errorf("invalid basket collateral %s", token.Hex())
+	}
+	collaterals, _ := lendingstate.GetCollaterals(statedb, lendingTrade.BorrowingRelayer, lendingTrade.LendingToken, lendingTrade.Term)
+	for _, collateral := range collaterals {
+		if collateral == token {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid basket collateral %s", token.Hex())
+}
+
+// GetBasketValue prices all the collaterals of the trade in its lending token.
+// The collateral prices of the pairs in priceOverrides, keyed by their trading
+// orderbook, are used instead of the ones of GetCollateralPrices, and the last
+// price set in the lending contract is used when neither gives one. The basket
+// is incomplete if a collateral still has no price or no liquidation rate: its
+// value and threshold are then lower bounds only.
+func (l *Lending) GetBasketValue(header *types.Header, chain consensus.ChainContext, statedb *state.StateDB, tradingState *tradingstate.TradingStateDB, lendingBook common.Hash, trade *lendingstate.LendingTrade, priceOverrides map[common.Hash]*big.Int) (*BasketValue, error) {
+	result := &BasketValue{
+		Collaterals: lendingstate.GetPositionCollaterals(statedb, lendingBook, trade),
+		Value:       new(big.Int),
+		Threshold:   new(big.Int),
+	}
+	for _, collateral := range result.Collaterals {
+		price, ok := priceOverrides[tradingstate.GetTradingOrderBookHash(collateral.Token, trade.LendingToken)]
+		if !ok {
+			var err error
+			if _, price, err = l.GetCollateralPrices(header, chain, statedb, tradingState, collateral.Token, trade.LendingToken); err != nil {
+				log.Debug("GetBasketValue: cannot get collateral price", "token", collateral.Token.Hex(), "err", err)
+				price = nil
+			}
+		}
+		if price == nil || price.Sign() <= 0 {
+			price = l.lastCollateralPrice(chain, statedb, collateral.Token, trade.LendingToken)
+		}
+		if price == nil {
+			result.Prices = append(result.Prices, nil)
+			result.Incomplete = true
+			continue
+		}
+		result.Prices = append(result.Prices, price)
+		decimal, err := l.tomox.GetTokenDecimal(chain, statedb, collateral.Token)
+		if err != nil {
+			return nil, err
+		}
+		// value = amount * price / decimal
+		value := new(big.Int).Div(new(big.Int).Mul(collateral.Amount, price), decimal)
+		result.Value.Add(result.Value, value)
+		// threshold = value * 100 / liquidationRate
+		_, liquidationRate, _ := lendingstate.GetCollateralDetail(statedb, collateral.Token)
+		if liquidationRate == nil || liquidationRate.Sign() <= 0 {
+			result.Incomplete = true
+			continue
+		}
+		result.Threshold.Add(result.Threshold, new(big.Int).Div(new(big.Int).Mul(value, big.NewInt(100)), liquidationRate))
+	}
+	return result, nil
+}
+
+// lastCollateralPrice returns the last collateral/lending token price set in
+// the lending contract however old it is, nil if it was never set.
+func (l *Lending) lastCollateralPrice(chain consensus.ChainContext, statedb *state.StateDB, collateralToken common.Address, lendingToken common.Address) *big.Int {
+	if price, _ := lendingstate.GetCollateralPrice(statedb, collateralToken, lendingToken); price.Sign() > 0 {
+		return price
+	}
+	inversePrice, _ := lendingstate.GetCollateralPrice(statedb, lendingToken, collateralToken)
+	if inversePrice.Sign() <= 0 {
+		return nil
+	}
+	lendingTokenDecimal, err := l.tomox.GetTokenDecimal(chain, statedb, lendingToken)
+	if err != nil {
+		return nil
+	}
+	collateralTokenDecimal, err := l.tomox.GetTokenDecimal(chain, statedb, collateralToken)
+	if err != nil {
+		return nil
+	}
+	price := new(big.Int).Div(new(big.Int).Mul(lendingTokenDecimal, collateralTokenDecimal), inversePrice)
+	if price.Sign() <= 0 {
+		return nil
+	}
+	return price
+}
+
+// LiquidateBasket closes a basket position. Its collaterals are given to the
+// investor in liquidation priority order until they cover the total repay
+// value plus the liquidation bonus of each token, the rest goes back to the
+// borrower. Collaterals without a known price go back to the borrower too, so
+// the investor never gets more than the repay value plus the bonus. A basket
+// with unknown prices isn't liquidated by price.
+func (l *Lending) LiquidateBasket(header *types.Header, chain consensus.ChainContext, lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, tradingStateDb *tradingstate.TradingStateDB, lendingBook common.Hash, lendingTradeId uint64, priceOverrides map[common.Hash]*big.Int, reason uint64) (*lendingstate.LendingTrade, error) {
+	lendingTrade := lendingStateDB.GetLendingTrade(lendingBook, common.Uint64ToHash(lendingTradeId))
+	if lendingTrade.TradeId != lendingTradeId {
+		return nil, fmt.Errorf("Lending Trade Id not found : %d ", lendingTradeId)
+	}
+	basket, err := l.GetBasketValue(header, chain, statedb, tradingStateDb, lendingBook, &lendingTrade, priceOverrides)
+	if err != nil {
+		return nil, err
+	}
+	if basket.Incomplete && reason == lendingstate.LiquidatedByPrice {
+		return nil, fmt.Errorf("LiquidateBasket: lendingTrade %d has collaterals without a known price", lendingTradeId)
+	}
+	debt := l.repayValue(header, chain, statedb, &lendingTrade)
+	lockAddress := common.HexToAddress(common.LendingLockAddress)
+	data := lendingstate.BasketLiquidationData{Reason: reason}
+	for i, collateral := range basket.Collaterals {
+		liquidationAmount := new(big.Int)
+		switch price := basket.Prices[i]; {
+		case debt.Sign() == 0:
+			// the debt is covered already
+		case price == nil:
+			log.Warn("LiquidateBasket: collateral without a known price is given back", "lendingTradeId", lendingTradeId, "token", collateral.Token.Hex())
+		default:
+			decimal, err := l.tomox.GetTokenDecimal(chain, statedb, collateral.Token)
+			if err != nil {
+				return nil, err
+			}
+			bonus := lendingstate.GetLiquidationBonus(statedb, collateral.Token)
+			// needed = debt * (100 + bonus) * decimal / (100 * price), rounded up
+			needed := new(big.Int).Mul(debt, new(big.Int).Add(big.NewInt(100), bonus))
+			needed = new(big.Int).Mul(needed, decimal)
+			needed, remainder := new(big.Int).QuoRem(needed, new(big.Int).Mul(big.NewInt(100), price), new(big.Int))
+			if remainder.Sign() > 0 {
+				needed = new(big.Int).Add(needed, common.Big1)
+			}
+			if needed.Cmp(collateral.Amount) < 0 {
+				liquidationAmount = needed
+				debt = new(big.Int)
+				break
+			}
+			liquidationAmount = collateral.Amount
+			// covered = amount * price * 100 / ((100 + bonus) * decimal)
+			covered := new(big.Int).Mul(new(big.Int).Mul(collateral.Amount, price), big.NewInt(100))
+			covered = new(big.Int).Div(covered, new(big.Int).Mul(new(big.Int).Add(big.NewInt(100), bonus), decimal))
+			if debt = new(big.Int).Sub(debt, covered); debt.Sign() < 0 {
+				debt = new(big.Int)
+			}
+		}
+		recallAmount := new(big.Int).Sub(collateral.Amount, liquidationAmount)
+		lendingstate.SubTokenBalance(lockAddress, collateral.Amount, collateral.Token, statedb)
+		lendingstate.AddTokenBalance(lendingTrade.Investor, liquidationAmount, collateral.Token, statedb)
+		lendingstate.AddTokenBalance(lendingTrade.Borrower, recallAmount, collateral.Token, statedb)
+		data.Collaterals = append(data.Collaterals, lendingstate.BasketLiquidation{
+			Token:             collateral.Token,
+			CollateralPrice:   basket.Prices[i],
+			LiquidationAmount: liquidationAmount,
+			RecallAmount:      recallAmount,
+		})
+	}
+	if err := l.closeBasketPosition(lendingStateDB, statedb, lendingBook, &lendingTrade); err != nil {
+		return nil, err
+	}
+	lendingTrade.Status = lendingstate.TradeStatusLiquidated
+	extraData, _ := json.Marshal(data)
+	lendingTrade.ExtraData = string(extraData)
+	log.Debug("LiquidateBasket", "lendingTradeId", lendingTradeId, "reason", reason, "collaterals", len(data.Collaterals))
+	return &lendingTrade, nil
+}
+
+// releaseBasket gives the collateral basket of the trade back to the borrower.
+// The collateral token of the trade itself is released by the caller.
+func releaseBasket(statedb *state.StateDB, lendingBook common.Hash, lendingTrade *lendingstate.LendingTrade) {
+	lockAddress := common.HexToAddress(common.LendingLockAddress)
+	for _, collateral := range lendingstate.GetCollateralBasket(statedb, lendingBook, lendingTrade.TradeId) {
+		lendingstate.SubTokenBalance(lockAddress, collateral.Amount, collateral.Token, statedb)
+		lendingstate.AddTokenBalance(lendingTrade.Borrower, collateral.Amount, collateral.Token, statedb)
+	}
+}
+
+// closeBasketPosition removes a basket position whose collaterals were
+// released from the lending state.
+func (l *Lending) closeBasketPosition(lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, lendingBook common.Hash, lendingTrade *lendingstate.LendingTrade) error {
+	if err := lendingStateDB.RemoveLiquidationTime(lendingBook, lendingTrade.TradeId, lendingTrade.LiquidationTime); err != nil {
+		log.Debug("closeBasketPosition RemoveLiquidationTime", "err", err)
+		return err
+	}
+	if err := lendingStateDB.CancelLendingTrade(lendingBook, lendingTrade.TradeId); err != nil {
+		log.Debug("closeBasketPosition CancelLendingTrade", "err", err)
+		return err
+	}
+	lendingstate.RemoveCollateralBasket(statedb, lendingBook, lendingTrade.TradeId)
+	return nil
+}
@@ -0,0 +1,117 @@
+package tomoxlending
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/params"
+	"github.com/tomochain/tomochain/tomox"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+func TestLiquidateBasketUnknownPrice(t *testing.T) {
+	var (
+		borrower     = common.HexToAddress("0x0000000000000000000000000000000000000044")
+		investor     = common.HexToAddress("0x0000000000000000000000000000000000000022")
+		lendingToken = common.HexToAddress(common.TomoNativeAddress)
+		tokenA       = common.HexToAddress("0xaa00000000000000000000000000000000000000")
+		tokenB       = common.HexToAddress("0xbb00000000000000000000000000000000000000")
+		lockAddress  = common.HexToAddress(common.LendingLockAddress)
+		term         = uint64(30 * 86400)
+		expiry       = uint64(1000000)
+		amount       = new(big.Int).Mul(big.NewInt(1000), common.BasePrice)
+		lockedA      = new(big.Int).Mul(big.NewInt(600), common.BasePrice)
+		lockedB      = new(big.Int).Mul(big.NewInt(1000), common.BasePrice)
+		lendingBook  = lendingstate.GetLendingOrderBookHash(lendingToken, term)
+	)
+	config := *params.TestChainConfig
+	config.Posv = &params.PosvConfig{Epoch: 900}
+	chain := posvTestChain{&config}
+	header := &types.Header{Number: big.NewInt(2000), Time: new(big.Int).SetUint64(expiry)}
+	// tokenA is worth 1 TOMO, tokenB has no price at all
+	overrides := map[common.Hash]*big.Int{tradingstate.GetTradingOrderBookHash(tokenA, lendingToken): common.BasePrice}
+
+	tests := []struct {
+		lastPriceB *big.Int
+		incomplete bool
+		investorB  *big.Int
+	}{
+		// without any price for tokenB, it goes back to the borrower
+		{nil, true, new(big.Int)},
+		// the last price of tokenB (2 TOMO) covers the rest of the debt
+		{new(big.Int).Mul(big.NewInt(2), common.BasePrice), false, new(big.Int).Mul(big.NewInt(200), common.BasePrice)},
+	}
+	for i, tt := range tests {
+		db := rawdb.NewMemoryDatabase()
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+		lendingState, _ := lendingstate.New(lendingstate.EmptyRoot, lendingstate.NewDatabase(db))
+		tradingState, _ := tradingstate.New(tradingstate.EmptyRoot, tradingstate.NewDatabase(db))
+
+		setLendingList(statedb, lendingstate.DefaultCollateralSlot, tokenA.Hash())
+		setLendingList(statedb, lendingstate.DefaultCollateralSlot, tokenB.Hash())
+		for _, token := range []common.Address{tokenA, tokenB} {
+			collateral := lendingstate.GetLocMappingAtKey(token.Hash(), lendingstate.CollateralMapSlot)
+			loc := state.GetLocOfStructElement(collateral, lendingstate.CollateralStructSlots["liquidationRate"])
+			statedb.SetState(common.HexToAddress(common.LendingRegistrationSMC), loc, common.BigToHash(big.NewInt(110)))
+			statedb.SetNonce(token, 1)
+		}
+		if tt.lastPriceB != nil {
+			// set in an earlier epoch, only usable as the last known price
+			setCollateralPrice(statedb, tokenB, lendingToken, tt.lastPriceB, 1)
+		}
+		lendingstate.AddTokenBalance(lockAddress, new(big.Int).Set(lockedA), tokenA, statedb)
+		lendingstate.AddTokenBalance(lockAddress, new(big.Int).Set(lockedB), tokenB, statedb)
+
+		trade := lendingstate.LendingTrade{
+			Borrower:               borrower,
+			Investor:               investor,
+			LendingToken:           lendingToken,
+			CollateralToken:        tokenA,
+			Term:                   term,
+			CollateralLockedAmount: new(big.Int).Set(lockedA),
+			LiquidationPrice:       new(big.Int),
+			LiquidationTime:        expiry,
+			Amount:                 amount,
+			TradeId:                1,
+			Hash:                   common.HexToHash("0x01"),
+		}
+		lendingState.InsertTradingItem(lendingBook, trade.TradeId, trade)
+		lendingState.InsertLiquidationTime(lendingBook, new(big.Int).SetUint64(trade.LiquidationTime), trade.TradeId)
+		lendingstate.AddBasketCollateral(statedb, lendingBook, trade.TradeId, tokenB, new(big.Int).Set(lockedB))
+
+		tx := tomox.New(&tomox.DefaultConfig)
+		tx.SetTokenDecimal(tokenA, common.BasePrice)
+		tx.SetTokenDecimal(tokenB, common.BasePrice)
+		l := &Lending{tomox: tx}
+
+		basket, err := l.GetBasketValue(header, chain, statedb, tradingState, lendingBook, &trade, overrides)
+		if err != nil {
+			t.Fatalf("test %d: failed to get basket value: %v", i, err)
+		}
+		if basket.Incomplete != tt.incomplete {
+			t.Errorf("test %d: incomplete mismatch: have %v, want %v", i, basket.Incomplete, tt.incomplete)
+		}
+		if _, err := l.LiquidateBasket(header, chain, lendingState, statedb, tradingState, lendingBook, trade.TradeId, overrides, lendingstate.LiquidatedByPrice); (err != nil) != tt.incomplete {
+			t.Errorf("test %d: liquidation by price error mismatch: have %v, want error %v", i, err, tt.incomplete)
+		}
+		if tt.incomplete {
+			if _, err := l.LiquidateBasket(header, chain, lendingState, statedb, tradingState, lendingBook, trade.TradeId, overrides, lendingstate.LiquidatedByTime); err != nil {
+				t.Fatalf("test %d: failed to liquidate expired basket: %v", i, err)
+			}
+		}
+		if balance := lendingstate.GetTokenBalance(investor, tokenA, statedb); balance.Cmp(lockedA) != 0 {
+			t.Errorf("test %d: investor tokenA mismatch: have %v, want %v", i, balance, lockedA)
+		}
+		if balance := lendingstate.GetTokenBalance(investor, tokenB, statedb); balance.Cmp(tt.investorB) != 0 {
+			t.Errorf("test %d: investor tokenB mismatch: have %v, want %v", i, balance, tt.investorB)
+		}
+		if balance := lendingstate.GetTokenBalance(borrower, tokenB, statedb); balance.Cmp(new(big.Int).Sub(lockedB, tt.investorB)) != 0 {
+			t.Errorf("test %d: borrower tokenB mismatch: have %v, want %v", i, balance, new(big.Int).Sub(lockedB, tt.investorB))
+		}
+	}
+}
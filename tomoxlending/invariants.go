@@ -0,0 +1,273 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tomoxlending
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/tomox"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+// tradeKey identifies a lending trade across the lending books.
+type tradeKey struct {
+	lendingBook common.Hash
+	tradeId     uint64
+}
+
+// CheckLendingInvariants verifies the lending books and the lending trades,
+// returning the violations found:
+//   - the collateral locked by the open trades and their baskets sums up to the
+//     balance of the lending lock address, for every collateral token
+//   - every open trade is in the liquidation time tree of its lending book once,
+//     at its liquidation time
+//   - every open trade which isn't a basket position is in the liquidation price
+//     tree of its pair once, at its liquidation price
+//   - the trees reference open trades only
+//   - the lending orders are stored with the quantity they are listed with
+func CheckLendingInvariants(statedb *state.StateDB, tradingState *tradingstate.TradingStateDB, lendingState *lendingstate.LendingStateDB) ([]tomox.InvariantViolation, error) {
+	// no lending book is supported yet if the lists are empty
+	books, _ := lendingstate.GetAllLendingBooks(statedb)
+	lendingBooks := make([]common.Hash, 0, len(books))
+	for lendingBook := range books {
+		lendingBooks = append(lendingBooks, lendingBook)
+	}
+	sortHashes(lendingBooks)
+
+	var (
+		violations  []tomox.InvariantViolation
+		openTrades  = make(map[tradeKey]lendingstate.LendingTrade)
+		locked      = make(map[common.Address]*big.Int)
+		orderBooks  = make(map[common.Hash]bool)
+		timeEntries = make(map[tradeKey][]uint64)
+	)
+	for _, collateral := range lendingstate.GetAllCollateral(statedb) {
+		locked[collateral] = new(big.Int)
+	}
+	for _, lendingBook := range lendingBooks {
+		if !lendingState.Exist(lendingBook) {
+			continue
+		}
+		trades, err := lendingState.DumpLendingTradeTrie(lendingBook)
+		if err != nil {
+			return nil, err
+		}
+		for _, trade := range trades {
+			// cancelled trades are kept in the trie with no amount
+			if trade.Amount == nil || trade.Amount.Sign() == 0 {
+				continue
+			}
+			openTrades[tradeKey{lendingBook, trade.TradeId}] = trade
+			orderBooks[tradingstate.GetTradingOrderBookHash(trade.CollateralToken, trade.LendingToken)] = true
+			for _, collateral := range lendingstate.GetPositionCollaterals(statedb, lendingBook, &trade) {
+				if locked[collateral.Token] == nil {
+					locked[collateral.Token] = new(big.Int)
+				}
+				locked[collateral.Token].Add(locked[collateral.Token], collateral.Amount)
+			}
+		}
+		liquidationTimes, err := lendingState.DumpLiquidationTimeTrie(lendingBook)
+		if err != nil {
+			return nil, err
+		}
+		for liquidationTime, list := range liquidationTimes {
+			for tradeId := range list.Orders {
+				key := tradeKey{lendingBook, tradeId.Uint64()}
+				timeEntries[key] = append(timeEntries[key], liquidationTime.Uint64())
+			}
+		}
+		violations = append(violations, checkLendingOrderTree(lendingState, lendingBook, lendingstate.Investing)...)
+		violations = append(violations, checkLendingOrderTree(lendingState, lendingBook, lendingstate.Borrowing)...)
+	}
+	violations = append(violations, checkCollateralLock(statedb, locked)...)
+
+	priceEntries, err := liquidationPriceEntries(tradingState, statedb, orderBooks)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]tradeKey, 0, len(openTrades))
+	for key := range openTrades {
+		keys = append(keys, key)
+	}
+	for key := range timeEntries {
+		if _, ok := openTrades[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	for key := range priceEntries {
+		if _, ok := openTrades[key]; !ok {
+			if _, ok := timeEntries[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sortTradeKeys(keys)
+	for _, key := range keys {
+		trade, open := openTrades[key]
+		if !open {
+			if len(timeEntries[key]) > 0 {
+				violations = append(violations, tomox.InvariantViolation{Check: "liquidationTime", Hash: key.lendingBook, Message: fmt.Sprintf("trade %d isn't open but is listed at %v", key.tradeId, timeEntries[key])})
+			}
+			if len(priceEntries[key]) > 0 {
+				violations = append(violations, tomox.InvariantViolation{Check: "liquidationPrice", Hash: key.lendingBook, Message: fmt.Sprintf("trade %d isn't open but is listed at %v", key.tradeId, priceEntries[key])})
+			}
+			continue
+		}
+		if times := timeEntries[key]; len(times) != 1 || times[0] != trade.LiquidationTime {
+			violations = append(violations, tomox.InvariantViolation{Check: "liquidationTime", Hash: trade.Hash, Message: fmt.Sprintf("trade %d of lending book %s expires at %d, listed at %v", trade.TradeId, key.lendingBook.Hex(), trade.LiquidationTime, times)})
+		}
+		prices := priceEntries[key]
+		if lendingstate.IsBasketPosition(statedb, key.lendingBook, key.tradeId) {
+			// the health of a basket position doesn't depend on a single price
+			if len(prices) != 0 {
+				violations = append(violations, tomox.InvariantViolation{Check: "liquidationPrice", Hash: trade.Hash, Message: fmt.Sprintf("basket position %d of lending book %s is listed at %v", trade.TradeId, key.lendingBook.Hex(), prices)})
+			}
+			continue
+		}
+		if len(prices) != 1 || trade.LiquidationPrice == nil || prices[0].Cmp(trade.LiquidationPrice) != 0 {
+			violations = append(violations, tomox.InvariantViolation{Check: "liquidationPrice", Hash: trade.Hash, Message: fmt.Sprintf("trade %d of lending book %s is liquidated at %v, listed at %v", trade.TradeId, key.lendingBook.Hex(), trade.LiquidationPrice, prices)})
+		}
+	}
+	return violations, nil
+}
+
+// liquidationPriceEntries returns the liquidation prices each trade is listed
+// at in the orderbooks of the lending pairs and of the open trades.
+func liquidationPriceEntries(tradingState *tradingstate.TradingStateDB, statedb *state.StateDB, orderBooks map[common.Hash]bool) (map[tradeKey][]*big.Int, error) {
+	pairs, _ := lendingstate.GetAllLendingPairs(statedb)
+	for _, pair := range pairs {
+		orderBooks[tradingstate.GetTradingOrderBookHash(pair.CollateralToken, pair.LendingToken)] = true
+	}
+	entries := make(map[tradeKey][]*big.Int)
+	for orderBook := range orderBooks {
+		if !tradingState.Exist(orderBook) {
+			continue
+		}
+		tree, err := tradingState.DumpLiquidationPriceTrie(orderBook)
+		if err != nil {
+			return nil, err
+		}
+		for price, books := range tree {
+			for lendingBook, list := range books.LendingBooks {
+				for tradeId := range list.Orders {
+					key := tradeKey{lendingBook, tradeId.Uint64()}
+					entries[key] = append(entries[key], price)
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+// checkCollateralLock compares the collateral locked by the trades with the
+// balances of the lending lock address.
+func checkCollateralLock(statedb *state.StateDB, locked map[common.Address]*big.Int) []tomox.InvariantViolation {
+	var violations []tomox.InvariantViolation
+	lockAddress := common.HexToAddress(common.LendingLockAddress)
+	tokens := make([]common.Address, 0, len(locked))
+	for token := range locked {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return bytes.Compare(tokens[i].Bytes(), tokens[j].Bytes()) < 0
+	})
+	for _, token := range tokens {
+		if balance := lendingstate.GetTokenBalance(lockAddress, token, statedb); balance.Cmp(locked[token]) != 0 {
+			violations = append(violations, tomox.InvariantViolation{Check: "collateralLock", Hash: token.Hash(), Message: fmt.Sprintf("lock address holds %v, trades lock %v", balance, locked[token])})
+		}
+	}
+	return violations
+}
+
+// checkLendingOrderTree verifies that the volume of each interest level is the
+// sum of its orders, and that every order of the level is stored with its
+// remaining quantity.
+func checkLendingOrderTree(lendingState *lendingstate.LendingStateDB, lendingBook common.Hash, side string) []tomox.InvariantViolation {
+	var (
+		tree map[*big.Int]lendingstate.DumpOrderList
+		err  error
+	)
+	if side == lendingstate.Investing {
+		tree, err = lendingState.DumpInvestingTrie(lendingBook)
+	} else {
+		tree, err = lendingState.DumpBorrowingTrie(lendingBook)
+	}
+	if err != nil {
+		return []tomox.InvariantViolation{{Check: "lendingOrder", Hash: lendingBook, Message: err.Error()}}
+	}
+	var violations []tomox.InvariantViolation
+	interests := make([]*big.Int, 0, len(tree))
+	for interest := range tree {
+		interests = append(interests, interest)
+	}
+	for _, interest := range sortBigInts(interests) {
+		list := tree[interest]
+		orderIds := make([]*big.Int, 0, len(list.Orders))
+		for orderId := range list.Orders {
+			orderIds = append(orderIds, orderId)
+		}
+		volume := new(big.Int)
+		for _, orderId := range sortBigInts(orderIds) {
+			amount := list.Orders[orderId]
+			volume.Add(volume, amount)
+
+			orderIdHash := common.BigToHash(orderId)
+			order := lendingState.GetLendingOrder(lendingBook, orderIdHash)
+			switch {
+			case order.Quantity == nil:
+				violations = append(violations, tomox.InvariantViolation{Check: "lendingOrder", Hash: orderIdHash, Message: fmt.Sprintf("order %v of lending book %s at interest %v is missing", orderId, lendingBook.Hex(), interest)})
+			case order.Side != side || order.Interest == nil || order.Interest.Cmp(interest) != 0:
+				violations = append(violations, tomox.InvariantViolation{Check: "lendingOrder", Hash: order.Hash, Message: fmt.Sprintf("order %v of lending book %s is a %s order at interest %v, listed as %s at %v", orderId, lendingBook.Hex(), order.Side, order.Interest, side, interest)})
+			case amount.Sign() <= 0 || order.Quantity.Cmp(amount) != 0:
+				violations = append(violations, tomox.InvariantViolation{Check: "lendingOrder", Hash: order.Hash, Message: fmt.Sprintf("order %v of lending book %s has a remaining quantity of %v, listed with %v", orderId, lendingBook.Hex(), order.Quantity, amount)})
+			case order.FilledAmount != nil && order.FilledAmount.Sign() < 0:
+				violations = append(violations, tomox.InvariantViolation{Check: "lendingOrder", Hash: order.Hash, Message: fmt.Sprintf("order %v of lending book %s has a negative filled amount %v", orderId, lendingBook.Hex(), order.FilledAmount)})
+			}
+		}
+		if list.Volume == nil || list.Volume.Cmp(volume) != 0 {
+			violations = append(violations, tomox.InvariantViolation{Check: "volume", Hash: lendingBook, Message: fmt.Sprintf("%s volume at interest %v is %v, orders sum up to %v", side, interest, list.Volume, volume)})
+		}
+	}
+	return violations
+}
+
+func sortHashes(hashes []common.Hash) {
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i].Bytes(), hashes[j].Bytes()) < 0
+	})
+}
+
+func sortBigInts(values []*big.Int) []*big.Int {
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Cmp(values[j]) < 0
+	})
+	return values
+}
+
+func sortTradeKeys(keys []tradeKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if c := bytes.Compare(keys[i].lendingBook.Bytes(), keys[j].lendingBook.Bytes()); c != 0 {
+			return c < 0
+		}
+		return keys[i].tradeId < keys[j].tradeId
+	})
+}
@@ -0,0 +1,93 @@
+package tomoxlending
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+func TestCheckLendingInvariants(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	lendingState, _ := lendingstate.New(lendingstate.EmptyRoot, lendingstate.NewDatabase(db))
+	tradingState, _ := tradingstate.New(tradingstate.EmptyRoot, tradingstate.NewDatabase(db))
+
+	var (
+		lendingToken    = common.HexToAddress(common.TomoNativeAddress)
+		collateralToken = common.HexToAddress("0x0000000000000000000000000000000000000033")
+		lockAddress     = common.HexToAddress(common.LendingLockAddress)
+		term            = uint64(30 * 86400)
+		locked          = new(big.Int).Mul(big.NewInt(1500), common.BasePrice)
+		price           = new(big.Int).Mul(big.NewInt(2), common.BasePrice)
+	)
+	setLendingList(statedb, lendingstate.SupportedBaseSlot, lendingToken.Hash())
+	setLendingList(statedb, lendingstate.SupportedTermSlot, common.BigToHash(new(big.Int).SetUint64(term)))
+	setLendingList(statedb, lendingstate.DefaultCollateralSlot, collateralToken.Hash())
+	statedb.SetNonce(collateralToken, 1)
+	lendingstate.AddTokenBalance(lockAddress, locked, collateralToken, statedb)
+
+	lendingBook := lendingstate.GetLendingOrderBookHash(lendingToken, term)
+	orderbook := tradingstate.GetTradingOrderBookHash(collateralToken, lendingToken)
+	trade := lendingstate.LendingTrade{
+		LendingToken:           lendingToken,
+		CollateralToken:        collateralToken,
+		Term:                   term,
+		LiquidationPrice:       price,
+		CollateralLockedAmount: locked,
+		LiquidationTime:        1000000 + term,
+		Amount:                 new(big.Int).Mul(big.NewInt(1000), common.BasePrice),
+		TradeId:                1,
+		Hash:                   common.HexToHash("0x01"),
+	}
+	lendingState.InsertTradingItem(lendingBook, trade.TradeId, trade)
+	lendingState.InsertLiquidationTime(lendingBook, new(big.Int).SetUint64(trade.LiquidationTime), trade.TradeId)
+	tradingState.InsertLiquidationPrice(orderbook, price, lendingBook, trade.TradeId)
+
+	check := func() []string {
+		lendingState.IntermediateRoot()
+		tradingState.IntermediateRoot()
+		violations, err := CheckLendingInvariants(statedb, tradingState, lendingState)
+		if err != nil {
+			t.Fatalf("failed to check invariants: %v", err)
+		}
+		checks := make([]string, len(violations))
+		for i, violation := range violations {
+			checks[i] = violation.Check
+		}
+		return checks
+	}
+	if checks := check(); len(checks) != 0 {
+		t.Fatalf("consistent state reported violations: %v", checks)
+	}
+
+	// the lock address must hold the locked collateral
+	lendingstate.SubTokenBalance(lockAddress, common.Big1, collateralToken, statedb)
+	if checks := check(); len(checks) != 1 || checks[0] != "collateralLock" {
+		t.Errorf("collateral lock violations mismatch: have %v", checks)
+	}
+	lendingstate.AddTokenBalance(lockAddress, common.Big1, collateralToken, statedb)
+
+	// the trade must be listed once in the liquidation trees
+	tradingState.InsertLiquidationPrice(orderbook, new(big.Int).Add(price, common.Big1), lendingBook, trade.TradeId)
+	if checks := check(); len(checks) != 1 || checks[0] != "liquidationPrice" {
+		t.Errorf("duplicated liquidation price violations mismatch: have %v", checks)
+	}
+	tradingState.RemoveLiquidationPrice(orderbook, new(big.Int).Add(price, common.Big1), lendingBook, trade.TradeId)
+	lendingState.RemoveLiquidationTime(lendingBook, trade.TradeId, trade.LiquidationTime)
+	if checks := check(); len(checks) != 1 || checks[0] != "liquidationTime" {
+		t.Errorf("missing liquidation time violations mismatch: have %v", checks)
+	}
+	lendingState.InsertLiquidationTime(lendingBook, new(big.Int).SetUint64(trade.LiquidationTime), trade.TradeId)
+
+	// a closed trade must not be listed anymore
+	lendingState.CancelLendingTrade(lendingBook, trade.TradeId)
+	lendingstate.SubTokenBalance(lockAddress, locked, collateralToken, statedb)
+	if checks := check(); len(checks) != 2 || checks[0] != "liquidationTime" || checks[1] != "liquidationPrice" {
+		t.Errorf("closed trade violations mismatch: have %v", checks)
+	}
+}
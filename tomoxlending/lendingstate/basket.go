@@ -0,0 +1,215 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lendingstate
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+var slotCollateralBasketMapping = map[string]uint64{
+	"positions":      0, // list of position keys
+	"positionIndex":  1, // position key => index in the list + 1
+	"positionBooks":  2, // position key => lending book
+	"positionTrades": 3, // position key => trade id
+	"tokens":         4, // position key => list of pledged tokens
+	"amounts":        5, // hash(position key, token) => pledged amount
+}
+
+// BasketCollateral is an amount of a collateral token locked for a lending trade.
+type BasketCollateral struct {
+	Token  common.Address `json:"token"`
+	Amount *big.Int       `json:"amount"`
+}
+
+// BasketPosition identifies a lending trade backed by a collateral basket.
+type BasketPosition struct {
+	LendingBook common.Hash
+	TradeId     uint64
+}
+
+// BasketLiquidation describes what a liquidation did with a basket collateral.
+type BasketLiquidation struct {
+	Token             common.Address
+	CollateralPrice   *big.Int
+	LiquidationAmount *big.Int // Given to the investor
+	RecallAmount      *big.Int // Given back to the borrower
+}
+
+// BasketLiquidationData is the extra data of a liquidated basket position.
+type BasketLiquidationData struct {
+	Reason      uint64
+	Collaterals []BasketLiquidation
+}
+
+func basketAddress() common.Address {
+	return common.HexToAddress(common.LendingCollateralBasket)
+}
+
+func basketPositionKey(lendingBook common.Hash, tradeId uint64) common.Hash {
+	return crypto.Keccak256Hash(lendingBook.Bytes(), common.Uint64ToHash(tradeId).Bytes())
+}
+
+func basketMappingLoc(key common.Hash, name string) common.Hash {
+	return common.BigToHash(GetLocMappingAtKey(key, slotCollateralBasketMapping[name]))
+}
+
+func basketAmountLoc(key common.Hash, token common.Address) common.Hash {
+	return basketMappingLoc(crypto.Keccak256Hash(key.Bytes(), token.Bytes()), "amounts")
+}
+
+// IsBasketPosition returns whether collateral was pledged to the basket of the trade.
+func IsBasketPosition(statedb *state.StateDB, lendingBook common.Hash, tradeId uint64) bool {
+	key := basketPositionKey(lendingBook, tradeId)
+	return statedb.GetState(basketAddress(), basketMappingLoc(key, "positionIndex")) != (common.Hash{})
+}
+
+// GetBasketPositions returns the lending trades backed by a collateral basket.
+func GetBasketPositions(statedb *state.StateDB) []BasketPosition {
+	slot := state.GetLocSimpleVariable(slotCollateralBasketMapping["positions"])
+	length := statedb.GetState(basketAddress(), slot).Big().Uint64()
+	positions := make([]BasketPosition, 0, length)
+	for i := uint64(0); i < length; i++ {
+		key := statedb.GetState(basketAddress(), state.GetLocDynamicArrAtElement(slot, i, 1))
+		positions = append(positions, BasketPosition{
+			LendingBook: statedb.GetState(basketAddress(), basketMappingLoc(key, "positionBooks")),
+			TradeId:     statedb.GetState(basketAddress(), basketMappingLoc(key, "positionTrades")).Big().Uint64(),
+		})
+	}
+	return positions
+}
+
+// GetCollateralBasket returns the collaterals pledged to the basket of the
+// trade, in the order they were first pledged.
+func GetCollateralBasket(statedb *state.StateDB, lendingBook common.Hash, tradeId uint64) []BasketCollateral {
+	key := basketPositionKey(lendingBook, tradeId)
+	slot := basketMappingLoc(key, "tokens")
+	length := statedb.GetState(basketAddress(), slot).Big().Uint64()
+	basket := make([]BasketCollateral, 0, length)
+	for i := uint64(0); i < length; i++ {
+		token := common.BytesToAddress(statedb.GetState(basketAddress(), state.GetLocDynamicArrAtElement(slot, i, 1)).Bytes())
+		basket = append(basket, BasketCollateral{
+			Token:  token,
+			Amount: statedb.GetState(basketAddress(), basketAmountLoc(key, token)).Big(),
+		})
+	}
+	return basket
+}
+
+// AddBasketCollateral pledges an amount of token to the basket of the trade,
+// turning the trade into a basket position if it isn't one yet.
+func AddBasketCollateral(statedb *state.StateDB, lendingBook common.Hash, tradeId uint64, token common.Address, amount *big.Int) {
+	basket := basketAddress()
+	key := basketPositionKey(lendingBook, tradeId)
+	indexLoc := basketMappingLoc(key, "positionIndex")
+	if statedb.GetState(basket, indexLoc) == (common.Hash{}) {
+		slot := state.GetLocSimpleVariable(slotCollateralBasketMapping["positions"])
+		length := statedb.GetState(basket, slot).Big().Uint64()
+		statedb.SetState(basket, state.GetLocDynamicArrAtElement(slot, length, 1), key)
+		statedb.SetState(basket, slot, common.BigToHash(new(big.Int).SetUint64(length+1)))
+		statedb.SetState(basket, indexLoc, common.BigToHash(new(big.Int).SetUint64(length+1)))
+		statedb.SetState(basket, basketMappingLoc(key, "positionBooks"), lendingBook)
+		statedb.SetState(basket, basketMappingLoc(key, "positionTrades"), common.Uint64ToHash(tradeId))
+	}
+	amountLoc := basketAmountLoc(key, token)
+	current := statedb.GetState(basket, amountLoc).Big()
+	if current.Sign() == 0 {
+		slot := basketMappingLoc(key, "tokens")
+		length := statedb.GetState(basket, slot).Big().Uint64()
+		statedb.SetState(basket, state.GetLocDynamicArrAtElement(slot, length, 1), token.Hash())
+		statedb.SetState(basket, slot, common.BigToHash(new(big.Int).SetUint64(length+1)))
+	}
+	statedb.SetState(basket, amountLoc, common.BigToHash(new(big.Int).Add(current, amount)))
+	if statedb.GetNonce(basket) == 0 {
+		// keep the basket from being swept as an empty account
+		statedb.SetNonce(basket, 1)
+	}
+}
+
+// RemoveCollateralBasket forgets the basket of the trade once it's closed.
+// The locked collateral must have been released by the caller.
+func RemoveCollateralBasket(statedb *state.StateDB, lendingBook common.Hash, tradeId uint64) {
+	basket := basketAddress()
+	key := basketPositionKey(lendingBook, tradeId)
+	indexLoc := basketMappingLoc(key, "positionIndex")
+	index := statedb.GetState(basket, indexLoc).Big().Uint64()
+	if index == 0 {
+		return
+	}
+	tokens := basketMappingLoc(key, "tokens")
+	length := statedb.GetState(basket, tokens).Big().Uint64()
+	for i := uint64(0); i < length; i++ {
+		loc := state.GetLocDynamicArrAtElement(tokens, i, 1)
+		statedb.SetState(basket, basketAmountLoc(key, common.BytesToAddress(statedb.GetState(basket, loc).Bytes())), common.Hash{})
+		statedb.SetState(basket, loc, common.Hash{})
+	}
+	statedb.SetState(basket, tokens, common.Hash{})
+
+	// move the last position into the freed one
+	slot := state.GetLocSimpleVariable(slotCollateralBasketMapping["positions"])
+	count := statedb.GetState(basket, slot).Big().Uint64()
+	last := statedb.GetState(basket, state.GetLocDynamicArrAtElement(slot, count-1, 1))
+	statedb.SetState(basket, state.GetLocDynamicArrAtElement(slot, index-1, 1), last)
+	statedb.SetState(basket, basketMappingLoc(last, "positionIndex"), common.BigToHash(new(big.Int).SetUint64(index)))
+	statedb.SetState(basket, state.GetLocDynamicArrAtElement(slot, count-1, 1), common.Hash{})
+	statedb.SetState(basket, slot, common.BigToHash(new(big.Int).SetUint64(count-1)))
+	statedb.SetState(basket, indexLoc, common.Hash{})
+	statedb.SetState(basket, basketMappingLoc(key, "positionBooks"), common.Hash{})
+	statedb.SetState(basket, basketMappingLoc(key, "positionTrades"), common.Hash{})
+}
+
+// SortByLiquidationPriority orders the collaterals the way a liquidation
+// consumes them: following the list of whitelisted collaterals of the lending
+// contract, tokens missing from it last.
+func SortByLiquidationPriority(statedb *state.StateDB, collaterals []BasketCollateral) []BasketCollateral {
+	priority := map[common.Address]int{}
+	for i, token := range GetAllCollateral(statedb) {
+		if _, ok := priority[token]; !ok {
+			priority[token] = i
+		}
+	}
+	rank := func(token common.Address) int {
+		if i, ok := priority[token]; ok {
+			return i
+		}
+		return len(priority)
+	}
+	sorted := make([]BasketCollateral, len(collaterals))
+	copy(sorted, collaterals)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i].Token) < rank(sorted[j].Token)
+	})
+	return sorted
+}
+
+// GetPositionCollaterals returns all the collaterals locked for the trade, its
+// collateral token and the basket pledged to it, merged by token and ordered by
+// liquidation priority.
+func GetPositionCollaterals(statedb *state.StateDB, lendingBook common.Hash, trade *LendingTrade) []BasketCollateral {
+	collaterals := []BasketCollateral{{Token: trade.CollateralToken, Amount: new(big.Int).Set(trade.CollateralLockedAmount)}}
+	for _, collateral := range GetCollateralBasket(statedb, lendingBook, trade.TradeId) {
+		if collateral.Token == trade.CollateralToken {
+			collaterals[0].Amount.Add(collaterals[0].Amount, collateral.Amount)
+			continue
+		}
+		collaterals = append(collaterals, collateral)
+	}
+	return SortByLiquidationPriority(statedb, collaterals)
+}
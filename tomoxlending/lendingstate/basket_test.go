@@ -0,0 +1,82 @@
+package lendingstate
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+)
+
+func TestCollateralBasket(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	lendingBook := common.HexToHash("0x01")
+	tokenA := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	tokenB := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+
+	if IsBasketPosition(statedb, lendingBook, 1) {
+		t.Fatal("trade 1 should not be a basket position yet")
+	}
+	AddBasketCollateral(statedb, lendingBook, 1, tokenB, big.NewInt(10))
+	AddBasketCollateral(statedb, lendingBook, 1, tokenA, big.NewInt(20))
+	AddBasketCollateral(statedb, lendingBook, 1, tokenB, big.NewInt(5))
+	AddBasketCollateral(statedb, lendingBook, 2, tokenA, big.NewInt(7))
+
+	if !IsBasketPosition(statedb, lendingBook, 1) || !IsBasketPosition(statedb, lendingBook, 2) {
+		t.Fatal("trades 1 and 2 should be basket positions")
+	}
+	basket := GetCollateralBasket(statedb, lendingBook, 1)
+	if len(basket) != 2 {
+		t.Fatalf("basket length mismatch: have %d, want 2", len(basket))
+	}
+	if basket[0].Token != tokenB || basket[0].Amount.Cmp(big.NewInt(15)) != 0 {
+		t.Errorf("first collateral mismatch: have %s %v, want %s 15", basket[0].Token.Hex(), basket[0].Amount, tokenB.Hex())
+	}
+	if basket[1].Token != tokenA || basket[1].Amount.Cmp(big.NewInt(20)) != 0 {
+		t.Errorf("second collateral mismatch: have %s %v, want %s 20", basket[1].Token.Hex(), basket[1].Amount, tokenA.Hex())
+	}
+
+	RemoveCollateralBasket(statedb, lendingBook, 1)
+	if IsBasketPosition(statedb, lendingBook, 1) {
+		t.Error("trade 1 should not be a basket position after removal")
+	}
+	if basket := GetCollateralBasket(statedb, lendingBook, 1); len(basket) != 0 {
+		t.Errorf("removed basket should be empty, have %d collaterals", len(basket))
+	}
+	positions := GetBasketPositions(statedb)
+	if len(positions) != 1 || positions[0].LendingBook != lendingBook || positions[0].TradeId != 2 {
+		t.Errorf("positions mismatch: have %v, want trade 2 only", positions)
+	}
+	// the moved position can still be removed
+	RemoveCollateralBasket(statedb, lendingBook, 2)
+	if positions := GetBasketPositions(statedb); len(positions) != 0 {
+		t.Errorf("positions should be empty, have %v", positions)
+	}
+}
+
+func TestSortByLiquidationPriority(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	contract := common.HexToAddress(common.LendingRegistrationSMC)
+	tokenA := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	tokenB := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	unknown := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+
+	// whitelist tokenA before tokenB
+	slot := state.GetLocSimpleVariable(DefaultCollateralSlot)
+	statedb.SetState(contract, slot, common.BigToHash(big.NewInt(2)))
+	statedb.SetState(contract, state.GetLocDynamicArrAtElement(slot, 0, 1), tokenA.Hash())
+	statedb.SetState(contract, state.GetLocDynamicArrAtElement(slot, 1, 1), tokenB.Hash())
+
+	sorted := SortByLiquidationPriority(statedb, []BasketCollateral{
+		{Token: unknown, Amount: big.NewInt(1)},
+		{Token: tokenB, Amount: big.NewInt(2)},
+		{Token: tokenA, Amount: big.NewInt(3)},
+	})
+	want := []common.Address{tokenA, tokenB, unknown}
+	for i, collateral := range sorted {
+		if collateral.Token != want[i] {
+			t.Errorf("collateral %d mismatch: have %s, want %s", i, collateral.Token.Hex(), want[i].Hex())
+		}
+	}
+}
@@ -42,8 +42,9 @@ type lendingObject struct {
 
 // liquidation reasons
 const (
-	LiquidatedByTime  = uint64(0)
-	LiquidatedByPrice = uint64(1)
+	LiquidatedByTime    = uint64(0)
+	LiquidatedByPrice   = uint64(1)
+	LiquidatedPartially = uint64(2)
 )
 
 type LiquidationData struct {
@@ -51,6 +52,16 @@ type LiquidationData struct {
 	LiquidationAmount *big.Int
 	CollateralPrice   *big.Int
 	Reason            uint64
+	RepaidAmount      *big.Int `json:",omitempty"` // Part of the trade amount covered by a partial liquidation
+	BonusAmount       *big.Int `json:",omitempty"` // Collateral given to the investor on top of RepaidAmount
+}
+
+// PartialRepayData is the extra data of a lending trade after a partial
+// repayment.
+type PartialRepayData struct {
+	RepayAmount     *big.Int // Lending token paid by the borrower, interest included
+	PrincipalAmount *big.Int // Part of the trade amount paid back
+	RecallAmount    *big.Int // Collateral released to the borrower
 }
 
 var (
@@ -113,6 +124,7 @@ type LendingItemHistoryItem struct {
 
 type LendingTradeHistoryItem struct {
 	TxHash                 common.Hash
+	Amount                 *big.Int
 	CollateralLockedAmount *big.Int
 	LiquidationPrice       *big.Int
 	Status                 string
@@ -73,6 +73,20 @@ type (
 		tradeId   common.Hash
 		prev      *big.Int
 	}
+	tradeAmountChange struct {
+		orderBook common.Hash
+		tradeId   common.Hash
+		prev      *big.Int
+	}
+	tradeInvestorChange struct {
+		orderBook common.Hash
+		tradeId   common.Hash
+		prev      common.Address
+	}
+	borrowerPolicyChange struct {
+		hash common.Hash
+		prev *BorrowerPolicy
+	}
 )
 
 func (ch insertOrder) undo(s *LendingStateDB) {
@@ -136,3 +150,32 @@ func (ch collateralLockedAmount) undo(s *LendingStateDB) {
 	}
 	stateLendingTrade.SetCollateralLockedAmount(ch.prev)
 }
+
+func (ch tradeAmountChange) undo(s *LendingStateDB) {
+	stateOrderBook := s.getLendingExchange(ch.orderBook)
+	if stateOrderBook == nil {
+		return
+	}
+	stateLendingTrade := stateOrderBook.getLendingTrade(s.db, ch.tradeId)
+	if stateLendingTrade == nil {
+		return
+	}
+	stateLendingTrade.SetAmount(ch.prev)
+}
+
+func (ch tradeInvestorChange) undo(s *LendingStateDB) {
+	stateOrderBook := s.getLendingExchange(ch.orderBook)
+	if stateOrderBook == nil {
+		return
+	}
+	stateLendingTrade := stateOrderBook.getLendingTrade(s.db, ch.tradeId)
+	if stateLendingTrade == nil {
+		return
+	}
+	stateLendingTrade.SetInvestor(ch.prev)
+}
+
+func (ch borrowerPolicyChange) undo(s *LendingStateDB) {
+	s.borrowerPolicies[ch.hash] = ch.prev
+	s.borrowerPoliciesDirty[ch.hash] = struct{}{}
+}
@@ -17,7 +17,12 @@ const (
 	Borrowing                  = "BORROW"
 	TopUp                      = "TOPUP"
 	Repay                      = "REPAY"
+	PartialRepay               = "PARTIAL_REPAY"
 	Recall                     = "RECALL"
+	Rollover                   = "ROLLOVER"
+	BasketTopUp                = "BASKET_TOPUP"
+	Transfer                   = "TRANSFER"
+	Policy                     = "POLICY"
 	LendingStatusNew           = "NEW"
 	LendingStatusOpen          = "OPEN"
 	LendingStatusReject        = "REJECTED"
@@ -34,11 +39,16 @@ var ValidInputLendingStatus = map[string]bool{
 }
 
 var ValidInputLendingType = map[string]bool{
-	Market: true,
-	Limit:  true,
-	Repay:  true,
-	TopUp:  true,
-	Recall: true,
+	Market:       true,
+	Limit:        true,
+	Repay:        true,
+	PartialRepay: true,
+	TopUp:        true,
+	Recall:       true,
+	Rollover:     true,
+	BasketTopUp:  true,
+	Transfer:     true,
+	Policy:       true,
 }
 
 // Signature struct
@@ -205,7 +215,8 @@ func (l *LendingItem) VerifyLendingItem(state *state.StateDB) error {
 		if err := l.VerifyLendingType(); err != nil {
 			return err
 		}
-		if l.Type != Repay {
+		// the price of a transfer may be zero, a policy has no quantity
+		if l.Type != Repay && l.Type != Rollover && l.Type != Transfer && l.Type != Policy {
 			if err := l.VerifyLendingQuantity(); err != nil {
 				return err
 			}
@@ -379,6 +390,18 @@ func VerifyBalance(isTomoXLendingFork bool, statedb *state.StateDB, lendingState
 				lendingTradeId, lendingTrade.LendingToken.Hex(), paymentBalance.String(), tokenBalance.String())
 
 		}
+	case PartialRepay:
+		lendingBook := GetLendingOrderBookHash(lendingToken, term)
+		lendingTrade := lendingStateDb.GetLendingTrade(lendingBook, common.Uint64ToHash(lendingTradeId))
+		if lendingTrade == EmptyLendingTrade {
+			return fmt.Errorf("VerifyBalance: process payment for emptyLendingTrade is not allowed. lendingTradeId: %v", lendingTradeId)
+		}
+		tokenBalance := GetTokenBalance(lendingTrade.Borrower, lendingTrade.LendingToken, statedb)
+		if tokenBalance.Cmp(quantity) < 0 {
+			return fmt.Errorf("VerifyBalance: not enough balance to process partial payment for lendingTrade."+
+				"lendingTradeId: %v. Token: %s. ExpectedBalance: %s. ActualBalance: %s",
+				lendingTradeId, lendingTrade.LendingToken.Hex(), quantity.String(), tokenBalance.String())
+		}
 	case Market, Limit:
 		switch side {
 		case Investing:
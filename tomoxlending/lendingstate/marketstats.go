@@ -0,0 +1,231 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lendingstate
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+// MarketStatsPeriod is the length in seconds of a period of the lending market
+// statistics kept by SDK nodes.
+const MarketStatsPeriod = uint64(3600)
+
+// LendingMarketStats aggregates the lending trades of a lending book over a
+// period. The stats of Time 0 cover the whole history of the book.
+type LendingMarketStats struct {
+	Hash           common.Hash    `bson:"hash" json:"hash"`
+	LendingToken   common.Address `bson:"lendingToken" json:"lendingToken"`
+	Term           uint64         `bson:"term" json:"term"`
+	Time           uint64         `bson:"time" json:"time"`                     // Start of the period
+	Volume         *big.Int       `bson:"volume" json:"volume"`                 // Matched principal
+	InterestVolume *big.Int       `bson:"interestVolume" json:"interestVolume"` // Sum of matched principal * interest
+	TradeCount     uint64         `bson:"tradeCount" json:"tradeCount"`
+	Outstanding    *big.Int       `bson:"outstanding" json:"outstanding"` // Principal of the open trades at the end of the period
+	Liquidations   uint64         `bson:"liquidations" json:"liquidations"`
+	AutoTopUps     uint64         `bson:"autoTopUps" json:"autoTopUps"`
+	InterestOpen   uint64         `bson:"interestOpen" json:"interestOpen"`
+	InterestHigh   uint64         `bson:"interestHigh" json:"interestHigh"`
+	InterestLow    uint64         `bson:"interestLow" json:"interestLow"`
+	InterestClose  uint64         `bson:"interestClose" json:"interestClose"`
+}
+
+type LendingMarketStatsBSON struct {
+	Hash           string `bson:"hash" json:"hash"`
+	LendingToken   string `bson:"lendingToken" json:"lendingToken"`
+	Term           string `bson:"term" json:"term"`
+	Time           string `bson:"time" json:"time"`
+	Volume         string `bson:"volume" json:"volume"`
+	InterestVolume string `bson:"interestVolume" json:"interestVolume"`
+	TradeCount     string `bson:"tradeCount" json:"tradeCount"`
+	Outstanding    string `bson:"outstanding" json:"outstanding"`
+	Liquidations   string `bson:"liquidations" json:"liquidations"`
+	AutoTopUps     string `bson:"autoTopUps" json:"autoTopUps"`
+	InterestOpen   string `bson:"interestOpen" json:"interestOpen"`
+	InterestHigh   string `bson:"interestHigh" json:"interestHigh"`
+	InterestLow    string `bson:"interestLow" json:"interestLow"`
+	InterestClose  string `bson:"interestClose" json:"interestClose"`
+}
+
+// GetMarketStatsHash returns the key of the stats of a lending book at the
+// period starting at time.
+func GetMarketStatsHash(lendingBook common.Hash, time uint64) common.Hash {
+	return crypto.Keccak256Hash(lendingBook.Bytes(), new(big.Int).SetUint64(time).Bytes())
+}
+
+// NewLendingMarketStats returns empty stats of a lending book.
+func NewLendingMarketStats(lendingToken common.Address, term uint64, time uint64) *LendingMarketStats {
+	return &LendingMarketStats{
+		Hash:           GetMarketStatsHash(GetLendingOrderBookHash(lendingToken, term), time),
+		LendingToken:   lendingToken,
+		Term:           term,
+		Time:           time,
+		Volume:         new(big.Int),
+		InterestVolume: new(big.Int),
+		Outstanding:    new(big.Int),
+	}
+}
+
+// Copy returns a deep copy of the stats.
+func (s *LendingMarketStats) Copy() *LendingMarketStats {
+	cpy := *s
+	cpy.Volume = new(big.Int).Set(s.Volume)
+	cpy.InterestVolume = new(big.Int).Set(s.InterestVolume)
+	cpy.Outstanding = new(big.Int).Set(s.Outstanding)
+	return &cpy
+}
+
+// WeightedInterest returns the average interest of the matched trades weighted
+// by their principal.
+func (s *LendingMarketStats) WeightedInterest() uint64 {
+	if s.Volume.Sign() == 0 {
+		return 0
+	}
+	return new(big.Int).Div(s.InterestVolume, s.Volume).Uint64()
+}
+
+// ApplyTrade accounts for the update of a lending trade from prev, which is
+// nil for a newly matched trade.
+func (s *LendingMarketStats) ApplyTrade(prev, trade *LendingTrade, autoTopUp bool) {
+	outstanding := func(t *LendingTrade) *big.Int {
+		if t == nil || t.Status != TradeStatusOpen || t.Amount == nil {
+			return common.Big0
+		}
+		return t.Amount
+	}
+	if prev == nil && trade.Status == TradeStatusOpen {
+		s.Volume = new(big.Int).Add(s.Volume, trade.Amount)
+		s.InterestVolume = new(big.Int).Add(s.InterestVolume, new(big.Int).Mul(trade.Amount, new(big.Int).SetUint64(trade.Interest)))
+		if s.TradeCount == 0 {
+			s.InterestOpen, s.InterestHigh, s.InterestLow = trade.Interest, trade.Interest, trade.Interest
+		}
+		if trade.Interest > s.InterestHigh {
+			s.InterestHigh = trade.Interest
+		}
+		if trade.Interest < s.InterestLow {
+			s.InterestLow = trade.Interest
+		}
+		s.InterestClose = trade.Interest
+		s.TradeCount++
+	}
+	s.Outstanding = new(big.Int).Add(s.Outstanding, new(big.Int).Sub(outstanding(trade), outstanding(prev)))
+	if trade.Status == TradeStatusLiquidated && (prev == nil || prev.Status != TradeStatusLiquidated) {
+		s.Liquidations++
+	}
+	if autoTopUp {
+		s.AutoTopUps++
+	}
+}
+
+// Merge adds the stats of the following period next to s.
+func (s *LendingMarketStats) Merge(next *LendingMarketStats) {
+	if next.TradeCount > 0 {
+		if s.TradeCount == 0 {
+			s.InterestOpen, s.InterestHigh, s.InterestLow = next.InterestOpen, next.InterestHigh, next.InterestLow
+		}
+		if next.InterestHigh > s.InterestHigh {
+			s.InterestHigh = next.InterestHigh
+		}
+		if next.InterestLow < s.InterestLow {
+			s.InterestLow = next.InterestLow
+		}
+		s.InterestClose = next.InterestClose
+	}
+	s.Volume = new(big.Int).Add(s.Volume, next.Volume)
+	s.InterestVolume = new(big.Int).Add(s.InterestVolume, next.InterestVolume)
+	s.TradeCount += next.TradeCount
+	s.Outstanding = new(big.Int).Set(next.Outstanding)
+	s.Liquidations += next.Liquidations
+	s.AutoTopUps += next.AutoTopUps
+}
+
+// AggregateMarketStats merges the stats of the periods, sorted by time, into
+// candles of resolution seconds covering [from, to). Candles without any
+// period are skipped.
+func AggregateMarketStats(periods []*LendingMarketStats, from, to, resolution uint64) []*LendingMarketStats {
+	candles := []*LendingMarketStats{}
+	var candle *LendingMarketStats
+	for _, period := range periods {
+		if period.Time < from || period.Time >= to {
+			continue
+		}
+		start := from + (period.Time-from)/resolution*resolution
+		if candle == nil || candle.Time != start {
+			candle = NewLendingMarketStats(period.LendingToken, period.Term, start)
+			candle.Hash = common.Hash{}
+			candles = append(candles, candle)
+		}
+		candle.Merge(period)
+	}
+	return candles
+}
+
+func (s *LendingMarketStats) GetBSON() (interface{}, error) {
+	return LendingMarketStatsBSON{
+		Hash:           s.Hash.Hex(),
+		LendingToken:   s.LendingToken.Hex(),
+		Term:           strconv.FormatUint(s.Term, 10),
+		Time:           strconv.FormatUint(s.Time, 10),
+		Volume:         s.Volume.String(),
+		InterestVolume: s.InterestVolume.String(),
+		TradeCount:     strconv.FormatUint(s.TradeCount, 10),
+		Outstanding:    s.Outstanding.String(),
+		Liquidations:   strconv.FormatUint(s.Liquidations, 10),
+		AutoTopUps:     strconv.FormatUint(s.AutoTopUps, 10),
+		InterestOpen:   strconv.FormatUint(s.InterestOpen, 10),
+		InterestHigh:   strconv.FormatUint(s.InterestHigh, 10),
+		InterestLow:    strconv.FormatUint(s.InterestLow, 10),
+		InterestClose:  strconv.FormatUint(s.InterestClose, 10),
+	}, nil
+}
+
+func (s *LendingMarketStats) SetBSON(raw bson.Raw) error {
+	decoded := new(LendingMarketStatsBSON)
+	if err := raw.Unmarshal(decoded); err != nil {
+		return fmt.Errorf("failed to decode LendingMarketStats. Err: %v", err)
+	}
+	s.Hash = common.HexToHash(decoded.Hash)
+	s.LendingToken = common.HexToAddress(decoded.LendingToken)
+	s.Volume = ToBigInt(decoded.Volume)
+	s.InterestVolume = ToBigInt(decoded.InterestVolume)
+	s.Outstanding = ToBigInt(decoded.Outstanding)
+	for _, field := range []struct {
+		value string
+		dest  *uint64
+	}{
+		{decoded.Term, &s.Term},
+		{decoded.Time, &s.Time},
+		{decoded.TradeCount, &s.TradeCount},
+		{decoded.Liquidations, &s.Liquidations},
+		{decoded.AutoTopUps, &s.AutoTopUps},
+		{decoded.InterestOpen, &s.InterestOpen},
+		{decoded.InterestHigh, &s.InterestHigh},
+		{decoded.InterestLow, &s.InterestLow},
+		{decoded.InterestClose, &s.InterestClose},
+	} {
+		value, err := strconv.ParseUint(field.value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse LendingMarketStats. Err: %v", err)
+		}
+		*field.dest = value
+	}
+	return nil
+}
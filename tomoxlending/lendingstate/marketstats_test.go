@@ -0,0 +1,71 @@
+package lendingstate
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+)
+
+func TestLendingMarketStatsApplyTrade(t *testing.T) {
+	stats := NewLendingMarketStats(common.HexToAddress("0x01"), 86400, 3600)
+	first := &LendingTrade{Amount: big.NewInt(100), Interest: 10, Status: TradeStatusOpen}
+	second := &LendingTrade{Amount: big.NewInt(300), Interest: 6, Status: TradeStatusOpen}
+	stats.ApplyTrade(nil, first, false)
+	stats.ApplyTrade(nil, second, false)
+
+	// the first trade is partially repaid then liquidated, the second one is topped up then repaid
+	repaid := &LendingTrade{Amount: big.NewInt(40), Interest: 10, Status: TradeStatusOpen}
+	stats.ApplyTrade(first, repaid, false)
+	stats.ApplyTrade(repaid, &LendingTrade{Amount: big.NewInt(40), Interest: 10, Status: TradeStatusLiquidated}, false)
+	stats.ApplyTrade(second, second, true)
+	stats.ApplyTrade(second, &LendingTrade{Amount: big.NewInt(300), Interest: 6, Status: TradeStatusClosed}, false)
+
+	if stats.Volume.Cmp(big.NewInt(400)) != 0 {
+		t.Errorf("volume mismatch: have %v, want 400", stats.Volume)
+	}
+	if stats.TradeCount != 2 {
+		t.Errorf("trade count mismatch: have %d, want 2", stats.TradeCount)
+	}
+	// (100 * 10 + 300 * 6) / 400
+	if interest := stats.WeightedInterest(); interest != 7 {
+		t.Errorf("weighted interest mismatch: have %d, want 7", interest)
+	}
+	if stats.Outstanding.Sign() != 0 {
+		t.Errorf("outstanding mismatch: have %v, want 0", stats.Outstanding)
+	}
+	if stats.Liquidations != 1 || stats.AutoTopUps != 1 {
+		t.Errorf("liquidations/autoTopUps mismatch: have %d/%d, want 1/1", stats.Liquidations, stats.AutoTopUps)
+	}
+	if stats.InterestOpen != 10 || stats.InterestHigh != 10 || stats.InterestLow != 6 || stats.InterestClose != 6 {
+		t.Errorf("interest candle mismatch: have %d/%d/%d/%d, want 10/10/6/6", stats.InterestOpen, stats.InterestHigh, stats.InterestLow, stats.InterestClose)
+	}
+}
+
+func TestAggregateMarketStats(t *testing.T) {
+	lendingToken := common.HexToAddress("0x01")
+	period := func(time uint64, volume int64, interest uint64, outstanding int64) *LendingMarketStats {
+		stats := NewLendingMarketStats(lendingToken, 86400, time)
+		stats.ApplyTrade(nil, &LendingTrade{Amount: big.NewInt(volume), Interest: interest, Status: TradeStatusOpen}, false)
+		stats.Outstanding = big.NewInt(outstanding)
+		return stats
+	}
+	periods := []*LendingMarketStats{
+		period(3600, 10, 5, 10),
+		period(7200, 20, 8, 30),
+		period(14400, 30, 3, 60),
+	}
+	candles := AggregateMarketStats(periods, 3600, 18000, 2*MarketStatsPeriod)
+	if len(candles) != 2 {
+		t.Fatalf("candle count mismatch: have %d, want 2", len(candles))
+	}
+	if candles[0].Time != 3600 || candles[0].Volume.Cmp(big.NewInt(30)) != 0 || candles[0].Outstanding.Cmp(big.NewInt(30)) != 0 {
+		t.Errorf("first candle mismatch: have time %d volume %v outstanding %v", candles[0].Time, candles[0].Volume, candles[0].Outstanding)
+	}
+	if candles[0].InterestOpen != 5 || candles[0].InterestHigh != 8 || candles[0].InterestLow != 5 || candles[0].InterestClose != 8 {
+		t.Errorf("first candle interest mismatch: have %d/%d/%d/%d", candles[0].InterestOpen, candles[0].InterestHigh, candles[0].InterestLow, candles[0].InterestClose)
+	}
+	if candles[1].Time != 10800 || candles[1].TradeCount != 1 {
+		t.Errorf("second candle mismatch: have time %d trades %d", candles[1].Time, candles[1].TradeCount)
+	}
+}
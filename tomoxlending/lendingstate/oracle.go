@@ -0,0 +1,261 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lendingstate
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+var (
+	// LendingModeratorSlot is the slot of MODERATOR in the lending contract,
+	// the moderator manages the price feeders of the oracle and the
+	// liquidation bonuses of the collaterals
+	LendingModeratorSlot = uint64(7)
+
+	slotPriceOracleMapping = map[string]uint64{
+		"feeders":      0, // feeder list
+		"feederIndex":  1, // feeder => index in the list + 1
+		"prices":       2, // hash(token, lendingToken, feeder) => price
+		"priceBlocks":  3, // hash(token, lendingToken, feeder) => block number of the price
+		"minFeeders":   4,
+		"maxAge":       5,
+		"maxDeviation": 6,
+		"bonuses":      7, // collateral token => liquidation bonus in percent
+	}
+)
+
+// OracleConfig holds the bounds the feeder prices are aggregated with.
+type OracleConfig struct {
+	MinFeeders   uint64 // Fresh prices needed to trust the median
+	MaxAge       uint64 // Blocks after which a feeder price is stale
+	MaxDeviation uint64 // Percentage a price may deviate from the median
+}
+
+// OraclePrice is the result of aggregating the feeder prices of a pair.
+type OraclePrice struct {
+	Median    *big.Int // Median of the fresh prices within bounds, nil if there are too few
+	Reference *big.Int // Median of the recent prices, bounding the fallback prices, nil if none
+	Feeders   int      // Number of prices the median is taken from
+}
+
+func oracleAddress() common.Address {
+	return common.HexToAddress(common.LendingPriceOracle)
+}
+
+func oraclePriceKey(token, lendingToken, feeder common.Address) common.Hash {
+	return crypto.Keccak256Hash(token.Bytes(), lendingToken.Bytes(), feeder.Bytes())
+}
+
+func oracleMappingLoc(key common.Hash, name string) common.Hash {
+	return common.BigToHash(GetLocMappingAtKey(key, slotPriceOracleMapping[name]))
+}
+
+// GetLendingModerator returns the moderator of the lending contract.
+func GetLendingModerator(statedb *state.StateDB) common.Address {
+	loc := state.GetLocSimpleVariable(LendingModeratorSlot)
+	return common.BytesToAddress(statedb.GetState(common.HexToAddress(common.LendingRegistrationSMC), loc).Bytes())
+}
+
+// GetOracleFeeders returns the whitelisted price feeders.
+func GetOracleFeeders(statedb *state.StateDB) []common.Address {
+	slot := state.GetLocSimpleVariable(slotPriceOracleMapping["feeders"])
+	length := statedb.GetState(oracleAddress(), slot).Big().Uint64()
+	feeders := make([]common.Address, 0, length)
+	for i := uint64(0); i < length; i++ {
+		loc := state.GetLocDynamicArrAtElement(slot, i, 1)
+		feeders = append(feeders, common.BytesToAddress(statedb.GetState(oracleAddress(), loc).Bytes()))
+	}
+	return feeders
+}
+
+// IsOracleFeeder returns whether the address may submit prices.
+func IsOracleFeeder(statedb *state.StateDB, feeder common.Address) bool {
+	return statedb.GetState(oracleAddress(), oracleMappingLoc(feeder.Hash(), "feederIndex")) != (common.Hash{})
+}
+
+// SetOracleFeeder adds the feeder to the whitelist or removes it. The prices of
+// a removed feeder are ignored from then on.
+func SetOracleFeeder(statedb *state.StateDB, feeder common.Address, enabled bool) {
+	oracle := oracleAddress()
+	slot := state.GetLocSimpleVariable(slotPriceOracleMapping["feeders"])
+	length := statedb.GetState(oracle, slot).Big().Uint64()
+	indexLoc := oracleMappingLoc(feeder.Hash(), "feederIndex")
+	index := statedb.GetState(oracle, indexLoc).Big().Uint64()
+
+	switch {
+	case enabled && index == 0:
+		statedb.SetState(oracle, state.GetLocDynamicArrAtElement(slot, length, 1), feeder.Hash())
+		statedb.SetState(oracle, indexLoc, common.BigToHash(new(big.Int).SetUint64(length+1)))
+		statedb.SetState(oracle, slot, common.BigToHash(new(big.Int).SetUint64(length+1)))
+	case !enabled && index != 0:
+		// move the last feeder into the freed position
+		last := statedb.GetState(oracle, state.GetLocDynamicArrAtElement(slot, length-1, 1))
+		statedb.SetState(oracle, state.GetLocDynamicArrAtElement(slot, index-1, 1), last)
+		statedb.SetState(oracle, oracleMappingLoc(common.BytesToAddress(last.Bytes()).Hash(), "feederIndex"), common.BigToHash(new(big.Int).SetUint64(index)))
+		statedb.SetState(oracle, state.GetLocDynamicArrAtElement(slot, length-1, 1), common.Hash{})
+		statedb.SetState(oracle, indexLoc, common.Hash{})
+		statedb.SetState(oracle, slot, common.BigToHash(new(big.Int).SetUint64(length-1)))
+	}
+	keepOracleAlive(statedb)
+}
+
+// GetOracleConfig returns the aggregation bounds, using the defaults for the
+// ones the moderator never set.
+func GetOracleConfig(statedb *state.StateDB) OracleConfig {
+	get := func(name string, def uint64) uint64 {
+		if value := statedb.GetState(oracleAddress(), state.GetLocSimpleVariable(slotPriceOracleMapping[name])).Big().Uint64(); value != 0 {
+			return value
+		}
+		return def
+	}
+	return OracleConfig{
+		MinFeeders:   get("minFeeders", common.OracleMinFeeders),
+		MaxAge:       get("maxAge", common.OraclePriceMaxAge),
+		MaxDeviation: get("maxDeviation", common.OracleMaxDeviation),
+	}
+}
+
+// SetOracleConfig sets the aggregation bounds, zero values restore the defaults.
+func SetOracleConfig(statedb *state.StateDB, config OracleConfig) {
+	set := func(name string, value uint64) {
+		statedb.SetState(oracleAddress(), state.GetLocSimpleVariable(slotPriceOracleMapping[name]), common.BigToHash(new(big.Int).SetUint64(value)))
+	}
+	set("minFeeders", config.MinFeeders)
+	set("maxAge", config.MaxAge)
+	set("maxDeviation", config.MaxDeviation)
+	keepOracleAlive(statedb)
+}
+
+// GetFeederPrice returns the last price of token in lendingToken submitted by
+// the feeder and the block it was submitted in.
+func GetFeederPrice(statedb *state.StateDB, feeder, token, lendingToken common.Address) (*big.Int, uint64) {
+	key := oraclePriceKey(token, lendingToken, feeder)
+	price := statedb.GetState(oracleAddress(), oracleMappingLoc(key, "prices")).Big()
+	number := statedb.GetState(oracleAddress(), oracleMappingLoc(key, "priceBlocks")).Big().Uint64()
+	return price, number
+}
+
+// SetFeederPrice records the price of token in lendingToken submitted by the
+// feeder at the given block.
+func SetFeederPrice(statedb *state.StateDB, feeder, token, lendingToken common.Address, price *big.Int, number uint64) {
+	key := oraclePriceKey(token, lendingToken, feeder)
+	statedb.SetState(oracleAddress(), oracleMappingLoc(key, "prices"), common.BigToHash(price))
+	statedb.SetState(oracleAddress(), oracleMappingLoc(key, "priceBlocks"), common.BigToHash(new(big.Int).SetUint64(number)))
+	keepOracleAlive(statedb)
+}
+
+// GetLiquidationBonus returns the percentage of the repaid debt a liquidator
+// receives on top in collateral when liquidating the given collateral token.
+func GetLiquidationBonus(statedb *state.StateDB, token common.Address) *big.Int {
+	return statedb.GetState(oracleAddress(), oracleMappingLoc(token.Hash(), "bonuses")).Big()
+}
+
+// SetLiquidationBonus sets the liquidation bonus of the collateral token in
+// percent, zero disables it.
+func SetLiquidationBonus(statedb *state.StateDB, token common.Address, bonus *big.Int) {
+	statedb.SetState(oracleAddress(), oracleMappingLoc(token.Hash(), "bonuses"), common.BigToHash(bonus))
+	keepOracleAlive(statedb)
+}
+
+// keepOracleAlive keeps the oracle from being swept as an empty account.
+func keepOracleAlive(statedb *state.StateDB) {
+	if statedb.GetNonce(oracleAddress()) == 0 {
+		statedb.SetNonce(oracleAddress(), 1)
+	}
+}
+
+// GetOraclePrice aggregates the feeder prices of token in lendingToken at the
+// given block. The median is taken from the prices submitted within MaxAge
+// blocks, without the ones deviating more than MaxDeviation percent from the
+// median of all of them, and only if at least MinFeeders prices remain. The
+// reference is the median of the prices submitted within OracleFallbackAges
+// times MaxAge blocks.
+func GetOraclePrice(statedb *state.StateDB, token, lendingToken common.Address, number uint64) OraclePrice {
+	config := GetOracleConfig(statedb)
+	var fresh, recent []*big.Int
+	for _, feeder := range GetOracleFeeders(statedb) {
+		price, updated := GetFeederPrice(statedb, feeder, token, lendingToken)
+		if price.Sign() <= 0 || updated > number {
+			continue
+		}
+		if number-updated <= config.MaxAge*common.OracleFallbackAges {
+			recent = append(recent, price)
+		}
+		if number-updated <= config.MaxAge {
+			fresh = append(fresh, price)
+		}
+	}
+	result := OraclePrice{Reference: median(recent)}
+	if len(fresh) == 0 {
+		return result
+	}
+	center := median(fresh)
+	var bounded []*big.Int
+	for _, price := range fresh {
+		if WithinDeviation(price, center, config.MaxDeviation) {
+			bounded = append(bounded, price)
+		}
+	}
+	if uint64(len(bounded)) >= config.MinFeeders {
+		result.Median = median(bounded)
+		result.Feeders = len(bounded)
+	}
+	return result
+}
+
+// WithinDeviation returns whether price deviates at most deviation percent
+// from reference.
+func WithinDeviation(price, reference *big.Int, deviation uint64) bool {
+	diff := new(big.Int).Abs(new(big.Int).Sub(price, reference))
+	limit := new(big.Int).Mul(reference, new(big.Int).SetUint64(deviation))
+	return new(big.Int).Mul(diff, big.NewInt(100)).Cmp(limit) <= 0
+}
+
+// ClampToDeviation bounds price to deviation percent around reference.
+func ClampToDeviation(price, reference *big.Int, deviation uint64) *big.Int {
+	delta := new(big.Int).Mul(reference, new(big.Int).SetUint64(deviation))
+	delta = new(big.Int).Div(delta, big.NewInt(100))
+	if low := new(big.Int).Sub(reference, delta); price.Cmp(low) < 0 {
+		return low
+	}
+	if high := new(big.Int).Add(reference, delta); price.Cmp(high) > 0 {
+		return high
+	}
+	return price
+}
+
+// median returns the median of the prices, the mean of the two middle ones for
+// an even count, or nil if there are none.
+func median(prices []*big.Int) *big.Int {
+	if len(prices) == 0 {
+		return nil
+	}
+	sorted := make([]*big.Int, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cmp(sorted[j]) < 0 })
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return new(big.Int).Set(sorted[mid])
+	}
+	sum := new(big.Int).Add(sorted[mid-1], sorted[mid])
+	return sum.Div(sum, big.NewInt(2))
+}
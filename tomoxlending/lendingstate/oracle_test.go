@@ -0,0 +1,117 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lendingstate
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+)
+
+func TestOracleFeeders(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	a, b, c := common.HexToAddress("0xa1"), common.HexToAddress("0xb2"), common.HexToAddress("0xc3")
+	for _, feeder := range []common.Address{a, b, c} {
+		SetOracleFeeder(statedb, feeder, true)
+	}
+	SetOracleFeeder(statedb, a, true)
+	SetOracleFeeder(statedb, a, false)
+
+	feeders := GetOracleFeeders(statedb)
+	if len(feeders) != 2 || feeders[0] != c || feeders[1] != b {
+		t.Fatalf("feeders mismatch: have %v, want [%v %v]", feeders, c, b)
+	}
+	if IsOracleFeeder(statedb, a) || !IsOracleFeeder(statedb, b) || !IsOracleFeeder(statedb, c) {
+		t.Fatalf("feeder whitelist mismatch")
+	}
+	SetOracleFeeder(statedb, c, false)
+	if feeders := GetOracleFeeders(statedb); len(feeders) != 1 || feeders[0] != b {
+		t.Fatalf("feeders mismatch: have %v, want [%v]", feeders, b)
+	}
+}
+
+func TestLiquidationBonus(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	token, other := common.HexToAddress("0xa1"), common.HexToAddress("0xb2")
+	SetLiquidationBonus(statedb, token, big.NewInt(5))
+
+	if bonus := GetLiquidationBonus(statedb, token); bonus.Cmp(big.NewInt(5)) != 0 {
+		t.Fatalf("bonus mismatch: have %v, want 5", bonus)
+	}
+	if bonus := GetLiquidationBonus(statedb, other); bonus.Sign() != 0 {
+		t.Fatalf("bonus of unset collateral mismatch: have %v, want 0", bonus)
+	}
+	// the lending contract storage of the collateral is left untouched
+	depositRate, liquidationRate, recallRate := GetCollateralDetail(statedb, token)
+	if depositRate.Sign() != 0 || liquidationRate.Sign() != 0 || recallRate.Sign() != 0 {
+		t.Fatalf("collateral detail changed: %v %v %v", depositRate, liquidationRate, recallRate)
+	}
+	SetLiquidationBonus(statedb, token, new(big.Int))
+	if bonus := GetLiquidationBonus(statedb, token); bonus.Sign() != 0 {
+		t.Fatalf("bonus mismatch: have %v, want 0", bonus)
+	}
+}
+
+func TestGetOraclePrice(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	token, lendingToken := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	SetOracleConfig(statedb, OracleConfig{MinFeeders: 3, MaxAge: 10, MaxDeviation: 10})
+
+	submit := func(feeder byte, price int64, number uint64) {
+		addr := common.BytesToAddress([]byte{feeder})
+		SetOracleFeeder(statedb, addr, true)
+		SetFeederPrice(statedb, addr, token, lendingToken, big.NewInt(price), number)
+	}
+	submit(1, 100, 95)
+	submit(2, 102, 98)
+	submit(3, 98, 100)
+	submit(4, 150, 100) // outlier
+	submit(5, 300, 60)  // too old for the median, within the fallback window
+
+	tests := []struct {
+		number    uint64
+		median    int64 // 0 if there is no quorum
+		reference int64 // 0 if there are no recent prices
+	}{
+		{100, 100, 102},
+		{106, 0, 101}, // feeder 1 stale, quorum lost
+		{200, 0, 0},
+	}
+	for i, tt := range tests {
+		price := GetOraclePrice(statedb, token, lendingToken, tt.number)
+		if (price.Median == nil) != (tt.median == 0) || (price.Median != nil && price.Median.Int64() != tt.median) {
+			t.Errorf("test %d: median mismatch: have %v, want %d", i, price.Median, tt.median)
+		}
+		if (price.Reference == nil) != (tt.reference == 0) || (price.Reference != nil && price.Reference.Int64() != tt.reference) {
+			t.Errorf("test %d: reference mismatch: have %v, want %d", i, price.Reference, tt.reference)
+		}
+	}
+	if nonce := statedb.GetNonce(common.HexToAddress(common.LendingPriceOracle)); nonce != 1 {
+		t.Errorf("oracle nonce mismatch: have %d, want 1", nonce)
+	}
+}
+
+func TestClampToDeviation(t *testing.T) {
+	reference := big.NewInt(1000)
+	for _, tt := range []struct{ price, want int64 }{{800, 900}, {950, 950}, {1200, 1100}} {
+		if have := ClampToDeviation(big.NewInt(tt.price), reference, 10); have.Int64() != tt.want {
+			t.Errorf("clamp %d: have %v, want %d", tt.price, have, tt.want)
+		}
+	}
+}
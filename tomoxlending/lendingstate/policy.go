@@ -0,0 +1,119 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lendingstate
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+var ErrInvalidBorrowerPolicy = errors.New("invalid borrower policy")
+
+// BorrowerPolicy is how the liquidation processing manages the collateral of
+// the trades of a borrower. It is registered either for a trade or for all the
+// trades of a borrower backed by a collateral token, the policy of a trade
+// taking precedence.
+type BorrowerPolicy struct {
+	TargetCollateralRatio uint64   `json:"targetCollateralRatio"` // Collateral value in percent of the debt restored by auto top-ups and recalls, 0 for the default rates
+	MaxTopUpPerEpoch      *big.Int `json:"maxTopUpPerEpoch"`      // Collateral amount topped up automatically per epoch, 0 for no limit
+	AutoRecall            bool     `json:"autoRecall"`            // Give back the excess collateral of the trades with AutoTopUp
+	NoAutoRepay           bool     `json:"noAutoRepay"`           // Liquidate expired trades instead of repaying them from the lending token balance
+	Epoch                 uint64   `json:"epoch"`                 // Epoch accounted in EpochTopUp
+	EpochTopUp            *big.Int `json:"epochTopUp"`            // Collateral amount topped up automatically during Epoch
+}
+
+// GetAccountPolicyHash returns the key of the policy of the trades of borrower
+// backed by collateralToken.
+func GetAccountPolicyHash(borrower, collateralToken common.Address) common.Hash {
+	return crypto.Keccak256Hash([]byte(Policy), borrower.Bytes(), collateralToken.Bytes())
+}
+
+// GetTradePolicyHash returns the key of the policy of a lending trade.
+func GetTradePolicyHash(lendingBook common.Hash, tradeId uint64) common.Hash {
+	return crypto.Keccak256Hash([]byte(Policy), lendingBook.Bytes(), common.Uint64ToHash(tradeId).Bytes())
+}
+
+// DecodeBorrowerPolicy decodes the policy carried in the extra data of a
+// policy item. An empty extra data removes the policy, it returns nil.
+func DecodeBorrowerPolicy(extraData string) (*BorrowerPolicy, error) {
+	if extraData == "" {
+		return nil, nil
+	}
+	policy := &BorrowerPolicy{}
+	if err := json.Unmarshal([]byte(extraData), policy); err != nil {
+		return nil, ErrInvalidBorrowerPolicy
+	}
+	if policy.TargetCollateralRatio != 0 && policy.TargetCollateralRatio <= 100 {
+		return nil, ErrInvalidBorrowerPolicy
+	}
+	if policy.MaxTopUpPerEpoch == nil {
+		policy.MaxTopUpPerEpoch = new(big.Int)
+	}
+	if policy.MaxTopUpPerEpoch.Sign() < 0 {
+		return nil, ErrInvalidBorrowerPolicy
+	}
+	// the usage is accounted by the chain
+	policy.Epoch, policy.EpochTopUp = 0, new(big.Int)
+	return policy, nil
+}
+
+// Copy returns a deep copy of the policy.
+func (p *BorrowerPolicy) Copy() *BorrowerPolicy {
+	cpy := *p
+	cpy.MaxTopUpPerEpoch = new(big.Int).Set(p.MaxTopUpPerEpoch)
+	cpy.EpochTopUp = new(big.Int).Set(p.EpochTopUp)
+	return &cpy
+}
+
+// TargetLiquidationPrice returns the liquidation price bringing the collateral
+// value at price back to the target ratio, nil if the policy has no target.
+func (p *BorrowerPolicy) TargetLiquidationPrice(price, liquidationRate *big.Int) *big.Int {
+	if p == nil || p.TargetCollateralRatio == 0 {
+		return nil
+	}
+	// price * liquidationRate / targetCollateralRatio
+	target := new(big.Int).Mul(price, liquidationRate)
+	return target.Div(target, new(big.Int).SetUint64(p.TargetCollateralRatio))
+}
+
+// TopUpAllowance returns the collateral amount which may still be topped up
+// automatically during epoch, nil if there is no limit.
+func (p *BorrowerPolicy) TopUpAllowance(epoch uint64) *big.Int {
+	if p == nil || p.MaxTopUpPerEpoch.Sign() == 0 {
+		return nil
+	}
+	allowance := new(big.Int).Set(p.MaxTopUpPerEpoch)
+	if p.Epoch == epoch {
+		allowance.Sub(allowance, p.EpochTopUp)
+	}
+	if allowance.Sign() < 0 {
+		return new(big.Int)
+	}
+	return allowance
+}
+
+// AddTopUp accounts for an amount of collateral topped up automatically
+// during epoch.
+func (p *BorrowerPolicy) AddTopUp(epoch uint64, amount *big.Int) {
+	if p.Epoch != epoch {
+		p.Epoch, p.EpochTopUp = epoch, new(big.Int)
+	}
+	p.EpochTopUp = new(big.Int).Add(p.EpochTopUp, amount)
+}
@@ -0,0 +1,109 @@
+package lendingstate
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+)
+
+func TestDecodeBorrowerPolicy(t *testing.T) {
+	policy, err := DecodeBorrowerPolicy(`{"targetCollateralRatio":150,"maxTopUpPerEpoch":1000,"autoRecall":true,"noAutoRepay":true,"epoch":7,"epochTopUp":500}`)
+	if err != nil {
+		t.Fatalf("valid policy rejected: %v", err)
+	}
+	if policy.TargetCollateralRatio != 150 || policy.MaxTopUpPerEpoch.Cmp(big.NewInt(1000)) != 0 || !policy.AutoRecall || !policy.NoAutoRepay {
+		t.Errorf("policy mismatch: have %+v", policy)
+	}
+	// the usage is accounted by the chain only
+	if policy.Epoch != 0 || policy.EpochTopUp.Sign() != 0 {
+		t.Errorf("usage mismatch: have %d/%v, want 0/0", policy.Epoch, policy.EpochTopUp)
+	}
+	if policy, err := DecodeBorrowerPolicy(""); policy != nil || err != nil {
+		t.Errorf("empty policy: have %v/%v, want nil/nil", policy, err)
+	}
+	for _, extraData := range []string{"{", `{"targetCollateralRatio":100}`, `{"maxTopUpPerEpoch":-1}`} {
+		if _, err := DecodeBorrowerPolicy(extraData); err != ErrInvalidBorrowerPolicy {
+			t.Errorf("policy %s: have %v, want %v", extraData, err, ErrInvalidBorrowerPolicy)
+		}
+	}
+}
+
+func TestBorrowerPolicyTopUpAllowance(t *testing.T) {
+	policy, _ := DecodeBorrowerPolicy(`{"targetCollateralRatio":200,"maxTopUpPerEpoch":100}`)
+	if price := policy.TargetLiquidationPrice(big.NewInt(1000), big.NewInt(110)); price.Cmp(big.NewInt(550)) != 0 {
+		t.Errorf("target liquidation price mismatch: have %v, want 550", price)
+	}
+	policy.AddTopUp(3, big.NewInt(60))
+	policy.AddTopUp(3, big.NewInt(30))
+	if allowance := policy.TopUpAllowance(3); allowance.Cmp(big.NewInt(10)) != 0 {
+		t.Errorf("allowance mismatch: have %v, want 10", allowance)
+	}
+	// the limit is reset every epoch
+	if allowance := policy.TopUpAllowance(4); allowance.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("allowance of the next epoch mismatch: have %v, want 100", allowance)
+	}
+	unlimited, _ := DecodeBorrowerPolicy(`{"noAutoRepay":true}`)
+	if allowance := unlimited.TopUpAllowance(3); allowance != nil {
+		t.Errorf("unlimited allowance: have %v, want nil", allowance)
+	}
+	var none *BorrowerPolicy
+	if none.TopUpAllowance(3) != nil || none.TargetLiquidationPrice(big.NewInt(1000), big.NewInt(110)) != nil {
+		t.Errorf("missing policy should not limit top-ups")
+	}
+}
+
+func TestBorrowerPolicyState(t *testing.T) {
+	stateCache := NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, _ := New(common.Hash{}, stateCache)
+
+	borrower := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	collateral := common.HexToAddress("0x0000000000000000000000000000000000000022")
+	lendingBook := GetLendingOrderBookHash(common.HexToAddress("0x0000000000000000000000000000000000000033"), 86400)
+	trade := &LendingTrade{TradeId: 5, Borrower: borrower, CollateralToken: collateral}
+
+	statedb.SetNonce(lendingBook, 1)
+	account, _ := DecodeBorrowerPolicy(`{"noAutoRepay":true}`)
+	statedb.SetBorrowerPolicy(GetAccountPolicyHash(borrower, collateral), account)
+	if hash, policy := statedb.GetTradeBorrowerPolicy(lendingBook, trade); hash != GetAccountPolicyHash(borrower, collateral) || policy == nil || !policy.NoAutoRepay {
+		t.Fatalf("account policy mismatch: have %x %+v", hash, policy)
+	}
+
+	// the policy of the trade takes precedence, and is journaled
+	snap := statedb.Snapshot()
+	own, _ := DecodeBorrowerPolicy(`{"autoRecall":true}`)
+	statedb.SetBorrowerPolicy(GetTradePolicyHash(lendingBook, trade.TradeId), own)
+	if _, policy := statedb.GetTradeBorrowerPolicy(lendingBook, trade); policy == nil || policy.NoAutoRepay || !policy.AutoRecall {
+		t.Fatalf("trade policy mismatch: have %+v", policy)
+	}
+	statedb.RevertToSnapshot(snap)
+	if _, policy := statedb.GetTradeBorrowerPolicy(lendingBook, trade); policy == nil || !policy.NoAutoRepay {
+		t.Fatalf("reverted trade policy mismatch: have %+v", policy)
+	}
+
+	account.AddTopUp(2, big.NewInt(42))
+	statedb.SetBorrowerPolicy(GetAccountPolicyHash(borrower, collateral), account)
+	root, err := statedb.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stateCache.TrieDB().Commit(root, false); err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ = New(root, stateCache)
+	policy := statedb.GetBorrowerPolicy(GetAccountPolicyHash(borrower, collateral))
+	if policy == nil || policy.Epoch != 2 || policy.EpochTopUp.Cmp(big.NewInt(42)) != 0 {
+		t.Fatalf("committed policy mismatch: have %+v", policy)
+	}
+	if nonce := statedb.GetNonce(lendingBook); nonce != 1 {
+		t.Errorf("lending book nonce mismatch: have %d, want 1", nonce)
+	}
+
+	// a nil policy removes it
+	statedb.SetBorrowerPolicy(GetAccountPolicyHash(borrower, collateral), nil)
+	statedb.IntermediateRoot()
+	if _, policy := statedb.GetTradeBorrowerPolicy(lendingBook, trade); policy != nil {
+		t.Errorf("removed policy still applied: %+v", policy)
+	}
+}
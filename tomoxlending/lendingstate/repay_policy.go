@@ -0,0 +1,64 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lendingstate
+
+import (
+	"math/big"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+// slotRepayPolicyMapping maps hash(relayer, lendingToken, term) to the interest
+// policy of early repayments of the pair.
+var slotRepayPolicyMapping = uint64(0)
+
+func repayPolicyAddress() common.Address {
+	return common.HexToAddress(common.LendingRepayPolicy)
+}
+
+func repayPolicyLoc(coinbase common.Address, baseToken common.Address, term uint64) common.Hash {
+	key := crypto.Keccak256Hash(coinbase.Bytes(), baseToken.Bytes(), common.Uint64ToHash(term).Bytes())
+	return common.BigToHash(GetLocMappingAtKey(key, slotRepayPolicyMapping))
+}
+
+// @function GetRepayPolicy
+// @param statedb : current state
+// @param coinbase: coinbase address of relayer
+// @param baseToken: address of baseToken
+// @param terms: term
+// @return: interest policy of early repayments of the given pair, RepayInterestHalfTerm unless the relayer set another one
+func GetRepayPolicy(statedb *state.StateDB, coinbase common.Address, baseToken common.Address, term uint64) uint64 {
+	if validPair, _ := IsValidPair(statedb, coinbase, baseToken, term); !validPair {
+		return RepayInterestHalfTerm
+	}
+	policy := statedb.GetState(repayPolicyAddress(), repayPolicyLoc(coinbase, baseToken, term)).Big()
+	if !policy.IsUint64() || policy.Uint64() > RepayInterestFullTerm {
+		return RepayInterestHalfTerm
+	}
+	return policy.Uint64()
+}
+
+// SetRepayPolicy sets the interest policy of early repayments of a pair of the
+// relayer.
+func SetRepayPolicy(statedb *state.StateDB, coinbase common.Address, baseToken common.Address, term uint64, policy uint64) {
+	statedb.SetState(repayPolicyAddress(), repayPolicyLoc(coinbase, baseToken, term), common.BigToHash(new(big.Int).SetUint64(policy)))
+	// keep the contract from being swept as an empty account
+	if statedb.GetNonce(repayPolicyAddress()) == 0 {
+		statedb.SetNonce(repayPolicyAddress(), 1)
+	}
+}
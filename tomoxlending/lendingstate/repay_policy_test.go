@@ -0,0 +1,46 @@
+package lendingstate
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+)
+
+func TestGetRepayPolicy(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	contract := common.HexToAddress(common.LendingRegistrationSMC)
+	relayer := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	lendingToken := common.HexToAddress("0x0000000000000000000000000000000000000022")
+
+	// the relayer lists two pairs, only the first one has a policy, the policy
+	// of the third pair is ignored as the relayer doesn't list it
+	locRelayer := state.GetLocMappingAtKey(relayer.Hash(), LendingRelayerListSlot)
+	setArray := func(field string, values ...common.Hash) {
+		loc := state.GetLocOfStructElement(locRelayer, LendingRelayerStructSlots[field])
+		statedb.SetState(contract, loc, common.BigToHash(big.NewInt(int64(len(values)))))
+		for i, value := range values {
+			statedb.SetState(contract, state.GetLocDynamicArrAtElement(loc, uint64(i), 1), value)
+		}
+	}
+	setArray("bases", lendingToken.Hash(), lendingToken.Hash())
+	setArray("terms", common.BigToHash(big.NewInt(86400)), common.BigToHash(big.NewInt(7*86400)))
+	SetRepayPolicy(statedb, relayer, lendingToken, 86400, RepayInterestProRata)
+	SetRepayPolicy(statedb, relayer, lendingToken, 30*86400, RepayInterestFullTerm)
+
+	tests := []struct {
+		term uint64
+		want uint64
+	}{
+		{86400, RepayInterestProRata},
+		{7 * 86400, RepayInterestHalfTerm},
+		{30 * 86400, RepayInterestHalfTerm}, // invalid pair
+	}
+	for _, tt := range tests {
+		if got := GetRepayPolicy(statedb, relayer, lendingToken, tt.term); got != tt.want {
+			t.Errorf("term %d: GetRepayPolicy() = %d, want %d", tt.term, got, tt.want)
+		}
+	}
+}
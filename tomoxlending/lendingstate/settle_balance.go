@@ -9,6 +9,14 @@ import (
 )
 
 const DefaultFeeRate = 100 // 100 / TomoXBaseFee = 100 / 10000 = 1%
+
+// Interest policies of early repayments, set per pair by the lending relayer
+const (
+	RepayInterestHalfTerm = uint64(0) // APR * (T + T1) / 2
+	RepayInterestProRata  = uint64(1) // APR * T1
+	RepayInterestFullTerm = uint64(2) // APR * T
+)
+
 var (
 	ErrQuantityTradeTooSmall  = errors.New("quantity trade too small")
 	ErrInvalidCollateralPrice = errors.New("unable to retrieve price of this collateral. Please try another collateral")
@@ -244,7 +252,26 @@ func CalculateInterestRate(finalizeTime, liquidationTime, term uint64, apr uint6
 }
 
 func CalculateTotalRepayValue(finalizeTime, liquidationTime, term uint64, apr uint64, tradeAmount *big.Int) *big.Int {
-	interestRate := CalculateInterestRate(finalizeTime, liquidationTime, term, apr)
+	return CalculateRepayValue(RepayInterestHalfTerm, finalizeTime, liquidationTime, term, apr, tradeAmount)
+}
+
+// CalculateRepayValue returns the amount plus the interest a borrower pays back
+// at finalizeTime under the given early repayment policy.
+func CalculateRepayValue(policy uint64, finalizeTime, liquidationTime, term uint64, apr uint64, tradeAmount *big.Int) *big.Int {
+	var interestRate *big.Int
+	switch policy {
+	case RepayInterestProRata:
+		// APR * T1, the interest of the borrowing time only
+		borrowingTime := finalizeTime - (liquidationTime - term)
+		interestRate = new(big.Int).Mul(new(big.Int).SetUint64(apr), new(big.Int).SetUint64(borrowingTime))
+		interestRate = new(big.Int).Div(interestRate, new(big.Int).SetUint64(common.OneYear))
+	case RepayInterestFullTerm:
+		// APR * T, the interest of the whole term
+		interestRate = new(big.Int).Mul(new(big.Int).SetUint64(apr), new(big.Int).SetUint64(term))
+		interestRate = new(big.Int).Div(interestRate, new(big.Int).SetUint64(common.OneYear))
+	default:
+		interestRate = CalculateInterestRate(finalizeTime, liquidationTime, term, apr)
+	}
 
 	// interest 10%
 	// user should send: 10 * common.BaseLendingInterest
@@ -459,3 +459,25 @@ func TestCalculateTotalRepayValue(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculateRepayValue(t *testing.T) {
+	// apr = 10% per year, term 365 days, repay 1000 USDT after one day
+	halfTerm, _ := new(big.Int).SetString("1050136986300000000000", 10) // 10% * (365 + 1) / 2 / 365
+	proRata, _ := new(big.Int).SetString("1000273972600000000000", 10)  // 10% * 1 / 365
+	fullTerm, _ := new(big.Int).SetString("1100000000000000000000", 10) // 10%
+
+	tradeAmount := new(big.Int).Mul(big.NewInt(1000), common.BasePrice)
+	tests := []struct {
+		policy uint64
+		want   *big.Int
+	}{
+		{RepayInterestHalfTerm, halfTerm},
+		{RepayInterestProRata, proRata},
+		{RepayInterestFullTerm, fullTerm},
+	}
+	for _, tt := range tests {
+		if got := CalculateRepayValue(tt.policy, 86400, common.OneYear, common.OneYear, 10*1e8, tradeAmount); got.Cmp(tt.want) != 0 {
+			t.Errorf("policy %d: CalculateRepayValue() = %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+}
@@ -605,6 +605,12 @@ func (self *lendingExchangeState) deepCopy(db *LendingStateDB, onDirty func(hash
 	if self.lendingItemTrie != nil {
 		stateExchanges.lendingItemTrie = db.db.CopyTrie(self.lendingItemTrie)
 	}
+	if self.lendingTradeTrie != nil {
+		stateExchanges.lendingTradeTrie = db.db.CopyTrie(self.lendingTradeTrie)
+	}
+	if self.liquidationTimeTrie != nil {
+		stateExchanges.liquidationTimeTrie = db.db.CopyTrie(self.liquidationTimeTrie)
+	}
 	for key, value := range self.borrowingStates {
 		stateExchanges.borrowingStates[key] = value.deepCopy(db, self.MarkBorrowingDirty)
 	}
@@ -76,3 +76,11 @@ func (self *lendingTradeState) SetAmount(amount *big.Int) {
 		self.onDirty = nil
 	}
 }
+
+func (self *lendingTradeState) SetInvestor(investor common.Address) {
+	self.data.Investor = investor
+	if self.onDirty != nil {
+		self.onDirty(self.tradeId)
+		self.onDirty = nil
+	}
+}
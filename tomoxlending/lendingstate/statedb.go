@@ -41,6 +41,10 @@ type LendingStateDB struct {
 	lendingExchangeStates      map[common.Hash]*lendingExchangeState
 	lendingExchangeStatesDirty map[common.Hash]struct{}
 
+	// Borrower policies, a nil policy is a removed one.
+	borrowerPolicies      map[common.Hash]*BorrowerPolicy
+	borrowerPoliciesDirty map[common.Hash]struct{}
+
 	// DB error.
 	// State objects are used by the consensus core and VM which are
 	// unable to deal with database-level errors. Any error that occurs
@@ -69,6 +73,8 @@ func New(root common.Hash, db Database) (*LendingStateDB, error) {
 		trie:                       tr,
 		lendingExchangeStates:      make(map[common.Hash]*lendingExchangeState),
 		lendingExchangeStatesDirty: make(map[common.Hash]struct{}),
+		borrowerPolicies:           make(map[common.Hash]*BorrowerPolicy),
+		borrowerPoliciesDirty:      make(map[common.Hash]struct{}),
 	}, nil
 }
 
@@ -213,6 +219,100 @@ func (self *LendingStateDB) UpdateCollateralLockedAmount(orderBook common.Hash,
 	})
 	stateLendingTrade.SetCollateralLockedAmount(amount)
 }
+func (self *LendingStateDB) UpdateTradeAmount(orderBook common.Hash, tradeId uint64, amount *big.Int) {
+	tradeIdHash := common.Uint64ToHash(tradeId)
+	stateExchange := self.getLendingExchange(orderBook)
+	if stateExchange == nil {
+		stateExchange = self.createLendingExchangeObject(orderBook)
+	}
+	stateLendingTrade := stateExchange.getLendingTrade(self.db, tradeIdHash)
+	self.journal = append(self.journal, tradeAmountChange{
+		orderBook: orderBook,
+		tradeId:   tradeIdHash,
+		prev:      stateLendingTrade.data.Amount,
+	})
+	stateLendingTrade.SetAmount(amount)
+}
+func (self *LendingStateDB) UpdateTradeInvestor(orderBook common.Hash, tradeId uint64, investor common.Address) {
+	tradeIdHash := common.Uint64ToHash(tradeId)
+	stateExchange := self.getLendingExchange(orderBook)
+	if stateExchange == nil {
+		stateExchange = self.createLendingExchangeObject(orderBook)
+	}
+	stateLendingTrade := stateExchange.getLendingTrade(self.db, tradeIdHash)
+	self.journal = append(self.journal, tradeInvestorChange{
+		orderBook: orderBook,
+		tradeId:   tradeIdHash,
+		prev:      stateLendingTrade.data.Investor,
+	})
+	stateLendingTrade.SetInvestor(investor)
+}
+
+// GetBorrowerPolicy returns a copy of the policy stored at hash, nil if there is none.
+func (self *LendingStateDB) GetBorrowerPolicy(hash common.Hash) *BorrowerPolicy {
+	policy, ok := self.borrowerPolicies[hash]
+	if !ok {
+		enc, err := self.trie.TryGet(hash[:])
+		if len(enc) == 0 {
+			self.setError(err)
+			return nil
+		}
+		policy = new(BorrowerPolicy)
+		if err := rlp.DecodeBytes(enc, policy); err != nil {
+			log.Error("Failed to decode borrower policy", "hash", hash, "err", err)
+			return nil
+		}
+		self.borrowerPolicies[hash] = policy
+	}
+	if policy == nil {
+		return nil
+	}
+	return policy.Copy()
+}
+
+// SetBorrowerPolicy stores the policy at hash, a nil policy removes it.
+func (self *LendingStateDB) SetBorrowerPolicy(hash common.Hash, policy *BorrowerPolicy) {
+	self.journal = append(self.journal, borrowerPolicyChange{
+		hash: hash,
+		prev: self.GetBorrowerPolicy(hash),
+	})
+	if policy != nil {
+		policy = policy.Copy()
+	}
+	self.borrowerPolicies[hash] = policy
+	self.borrowerPoliciesDirty[hash] = struct{}{}
+}
+
+// GetTradeBorrowerPolicy returns the policy applied to a lending trade and the
+// key it is stored at, the policy of the trade or else the one of its borrower.
+func (self *LendingStateDB) GetTradeBorrowerPolicy(lendingBook common.Hash, trade *LendingTrade) (common.Hash, *BorrowerPolicy) {
+	hash := GetTradePolicyHash(lendingBook, trade.TradeId)
+	if policy := self.GetBorrowerPolicy(hash); policy != nil {
+		return hash, policy
+	}
+	hash = GetAccountPolicyHash(trade.Borrower, trade.CollateralToken)
+	if policy := self.GetBorrowerPolicy(hash); policy != nil {
+		return hash, policy
+	}
+	return common.Hash{}, nil
+}
+
+// updateBorrowerPolicies writes the dirty borrower policies to the trie.
+func (self *LendingStateDB) updateBorrowerPolicies() {
+	for hash := range self.borrowerPoliciesDirty {
+		if policy := self.borrowerPolicies[hash]; policy != nil {
+			data, err := rlp.EncodeToBytes(policy)
+			if err != nil {
+				panic(fmt.Errorf("can't encode borrower policy at %x: %v", hash[:], err))
+			}
+			self.setError(self.trie.TryUpdate(hash[:], data))
+		} else {
+			self.setError(self.trie.TryDelete(hash[:]))
+		}
+		delete(self.borrowerPoliciesDirty, hash)
+	}
+}
+
 func (self *LendingStateDB) GetLendingOrder(orderBook common.Hash, orderId common.Hash) LendingItem {
 	stateObject := self.GetOrNewLendingExchangeObject(orderBook)
 	if stateObject == nil {
@@ -471,6 +571,8 @@ func (self *LendingStateDB) Copy() *LendingStateDB {
 		trie:                       self.db.CopyTrie(self.trie),
 		lendingExchangeStates:      make(map[common.Hash]*lendingExchangeState, len(self.lendingExchangeStatesDirty)),
 		lendingExchangeStatesDirty: make(map[common.Hash]struct{}, len(self.lendingExchangeStatesDirty)),
+		borrowerPolicies:           make(map[common.Hash]*BorrowerPolicy, len(self.borrowerPolicies)),
+		borrowerPoliciesDirty:      make(map[common.Hash]struct{}, len(self.borrowerPoliciesDirty)),
 	}
 	// Copy the dirty states, logs, and preimages
 	for addr := range self.lendingExchangeStatesDirty {
@@ -479,6 +581,15 @@ func (self *LendingStateDB) Copy() *LendingStateDB {
 	for addr, exchangeObject := range self.lendingExchangeStates {
 		state.lendingExchangeStates[addr] = exchangeObject.deepCopy(state, state.MarkLendingExchangeObjectDirty)
 	}
+	for hash, policy := range self.borrowerPolicies {
+		if policy != nil {
+			policy = policy.Copy()
+		}
+		state.borrowerPolicies[hash] = policy
+	}
+	for hash := range self.borrowerPoliciesDirty {
+		state.borrowerPoliciesDirty[hash] = struct{}{}
+	}
 
 	return state
 }
@@ -534,6 +645,7 @@ func (s *LendingStateDB) Finalise() {
 			//delete(s.investingStatesDirty, addr)
 		}
 	}
+	s.updateBorrowerPolicies()
 	s.clearJournalAndRefund()
 }
 
@@ -572,6 +684,7 @@ func (s *LendingStateDB) Commit() (root common.Hash, err error) {
 			delete(s.lendingExchangeStatesDirty, addr)
 		}
 	}
+	s.updateBorrowerPolicies()
 	// Write trie changes.
 	root, err = s.trie.Commit(func(leaf []byte, parent common.Hash) error {
 		var exchange lendingObject
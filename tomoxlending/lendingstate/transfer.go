@@ -0,0 +1,76 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lendingstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+var (
+	ErrInvalidTransferOffer = errors.New("invalid transfer offer")
+	ErrInvalidTransferBuyer = errors.New("invalid transfer buyer")
+)
+
+// TransferOffer is the extra data of a transfer item. The buyer accepts to
+// pay the price of the transfer by signing TransferAcceptanceHash.
+type TransferOffer struct {
+	Buyer     common.Address `json:"buyer"`
+	Signature *Signature     `json:"signature"`
+}
+
+// TransferAcceptanceHash returns the hash the buyer of a lending trade claim
+// signs. It commits to the seller nonce so that an acceptance can't be
+// replayed if the claim comes back to the seller.
+func TransferAcceptanceHash(tradeHash common.Hash, seller, buyer common.Address, price *big.Int, nonce uint64) common.Hash {
+	hash := crypto.Keccak256(
+		tradeHash.Bytes(),
+		seller.Bytes(),
+		buyer.Bytes(),
+		common.BigToHash(price).Bytes(),
+		common.Uint64ToHash(nonce).Bytes(),
+	)
+	return common.BytesToHash(crypto.Keccak256([]byte("\x19Ethereum Signed Message:\n32"), hash))
+}
+
+// VerifyTransferOffer decodes the offer of a transfer of the trade from seller
+// and checks that the buyer accepted the price. It returns the buyer.
+func VerifyTransferOffer(trade *LendingTrade, seller common.Address, extraData string, price *big.Int, nonce uint64) (common.Address, error) {
+	var offer TransferOffer
+	if err := json.Unmarshal([]byte(extraData), &offer); err != nil || offer.Signature == nil {
+		return common.Address{}, ErrInvalidTransferOffer
+	}
+	if offer.Buyer == (common.Address{}) || offer.Buyer == seller || offer.Buyer == trade.Borrower {
+		return common.Address{}, ErrInvalidTransferBuyer
+	}
+	if price == nil || price.Sign() < 0 {
+		return common.Address{}, fmt.Errorf("invalid transfer price %v", price)
+	}
+	sig := append(append(offer.Signature.R.Bytes(), offer.Signature.S.Bytes()...), offer.Signature.V-27)
+	pubKey, err := crypto.SigToPub(TransferAcceptanceHash(trade.Hash, seller, offer.Buyer, price, nonce).Bytes(), sig)
+	if err != nil {
+		return common.Address{}, ErrInvalidTransferOffer
+	}
+	if crypto.PubkeyToAddress(*pubKey) != offer.Buyer {
+		return common.Address{}, ErrInvalidTransferBuyer
+	}
+	return offer.Buyer, nil
+}
@@ -0,0 +1,55 @@
+package lendingstate
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+func TestVerifyTransferOffer(t *testing.T) {
+	buyerKey, _ := crypto.GenerateKey()
+	buyer := crypto.PubkeyToAddress(buyerKey.PublicKey)
+	seller := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	trade := &LendingTrade{
+		Hash:     common.HexToHash("0x01"),
+		Borrower: common.HexToAddress("0x0000000000000000000000000000000000000022"),
+		Investor: seller,
+	}
+	price := big.NewInt(1000)
+
+	sign := func(price *big.Int, nonce uint64) string {
+		sig, err := crypto.Sign(TransferAcceptanceHash(trade.Hash, seller, buyer, price, nonce).Bytes(), buyerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offer, _ := json.Marshal(TransferOffer{
+			Buyer:     buyer,
+			Signature: &Signature{V: sig[64] + 27, R: common.BytesToHash(sig[:32]), S: common.BytesToHash(sig[32:64])},
+		})
+		return string(offer)
+	}
+
+	got, err := VerifyTransferOffer(trade, seller, sign(price, 5), price, 5)
+	if err != nil {
+		t.Fatalf("valid offer rejected: %v", err)
+	}
+	if got != buyer {
+		t.Errorf("buyer mismatch: have %s, want %s", got.Hex(), buyer.Hex())
+	}
+	// the acceptance commits to the price and to the seller nonce
+	if _, err := VerifyTransferOffer(trade, seller, sign(price, 5), big.NewInt(999), 5); err != ErrInvalidTransferBuyer {
+		t.Errorf("offer with another price: have %v, want %v", err, ErrInvalidTransferBuyer)
+	}
+	if _, err := VerifyTransferOffer(trade, seller, sign(price, 5), price, 6); err != ErrInvalidTransferBuyer {
+		t.Errorf("replayed offer: have %v, want %v", err, ErrInvalidTransferBuyer)
+	}
+	if _, err := VerifyTransferOffer(trade, seller, "", price, 5); err != ErrInvalidTransferOffer {
+		t.Errorf("empty offer: have %v, want %v", err, ErrInvalidTransferOffer)
+	}
+	if _, err := VerifyTransferOffer(trade, buyer, sign(price, 5), price, 5); err != ErrInvalidTransferBuyer {
+		t.Errorf("transfer to the seller: have %v, want %v", err, ErrInvalidTransferBuyer)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tomoxlending
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+// maxMarketStatsPeriods limits the number of periods of a stats history request.
+const maxMarketStatsPeriods = 1000
+
+var errNoMarketStats = errors.New("lending market stats are only kept by SDK nodes")
+
+// MarketStats summarizes a lending market over the last day.
+type MarketStats struct {
+	LendingToken        common.Address                   `json:"lendingToken"`
+	Term                uint64                           `json:"term"`
+	Outstanding         *big.Int                         `json:"outstanding"` // Principal of the open trades
+	Volume24h           *big.Int                         `json:"volume24h"`
+	TradeCount24h       uint64                           `json:"tradeCount24h"`
+	WeightedInterest24h uint64                           `json:"weightedInterest24h"`
+	Liquidations24h     uint64                           `json:"liquidations24h"`
+	AutoTopUps24h       uint64                           `json:"autoTopUps24h"`
+	AllTime             *lendingstate.LendingMarketStats `json:"allTime"`
+}
+
+// updateMarketStats accounts for the updated trades in the all-time stats and
+// in the stats of the current period of their lending book. The previous stats
+// are kept to roll them back on reorg.
+func (l *Lending) updateMarketStats(txhash common.Hash, txTime time.Time, prevTrades, trades map[common.Hash]*lendingstate.LendingTrade, autoTopUps map[common.Hash]bool) error {
+	db := l.GetMongoDB()
+	var history map[common.Hash]*lendingstate.LendingMarketStats
+	if c, ok := l.marketStatsHistory.Get(txhash); ok && c != nil {
+		history = c.(map[common.Hash]*lendingstate.LendingMarketStats)
+	} else {
+		history = make(map[common.Hash]*lendingstate.LendingMarketStats)
+	}
+	dirty := map[common.Hash]*lendingstate.LendingMarketStats{}
+	var load func(lendingToken common.Address, term uint64, start uint64) *lendingstate.LendingMarketStats
+	load = func(lendingToken common.Address, term uint64, start uint64) *lendingstate.LendingMarketStats {
+		hash := lendingstate.GetMarketStatsHash(lendingstate.GetLendingOrderBookHash(lendingToken, term), start)
+		if stats, ok := dirty[hash]; ok {
+			return stats
+		}
+		stats := lendingstate.NewLendingMarketStats(lendingToken, term, start)
+		if val, err := db.GetObject(hash, &lendingstate.LendingMarketStats{}); err == nil && val != nil {
+			stats = val.(*lendingstate.LendingMarketStats).Copy()
+			if _, ok := history[hash]; !ok {
+				history[hash] = stats.Copy()
+			}
+		} else {
+			if start != 0 {
+				// a new period starts with the outstanding principal of the book
+				stats.Outstanding = new(big.Int).Set(load(lendingToken, term, 0).Outstanding)
+			}
+			if _, ok := history[hash]; !ok {
+				history[hash] = nil
+			}
+		}
+		dirty[hash] = stats
+		return stats
+	}
+	period := uint64(txTime.Unix()) / lendingstate.MarketStatsPeriod * lendingstate.MarketStatsPeriod
+	for hash, trade := range trades {
+		allTime := load(trade.LendingToken, trade.Term, 0)
+		current := load(trade.LendingToken, trade.Term, period)
+		allTime.ApplyTrade(prevTrades[hash], trade, autoTopUps[hash])
+		current.ApplyTrade(prevTrades[hash], trade, autoTopUps[hash])
+	}
+	for hash, stats := range dirty {
+		if err := db.PutObject(hash, stats); err != nil {
+			return err
+		}
+	}
+	l.marketStatsHistory.Add(txhash, history)
+	return nil
+}
+
+// GetMarketStats returns the summary of the lending market of lendingToken and
+// term over the day before now.
+func (l *Lending) GetMarketStats(lendingToken common.Address, term uint64, now uint64) (*MarketStats, error) {
+	if !l.tomox.IsSDKNode() {
+		return nil, errNoMarketStats
+	}
+	db := l.GetMongoDB()
+	allTime := lendingstate.NewLendingMarketStats(lendingToken, term, 0)
+	if val, err := db.GetObject(allTime.Hash, &lendingstate.LendingMarketStats{}); err == nil && val != nil {
+		allTime = val.(*lendingstate.LendingMarketStats).Copy()
+	}
+	// the periods overlapping the last day, in a single candle
+	from := uint64(0)
+	if now > 24*3600 {
+		from = (now - 24*3600) / lendingstate.MarketStatsPeriod * lendingstate.MarketStatsPeriod
+	}
+	to := now/lendingstate.MarketStatsPeriod*lendingstate.MarketStatsPeriod + lendingstate.MarketStatsPeriod
+	history, err := l.GetMarketStatsHistory(lendingToken, term, from, to, to-from)
+	if err != nil {
+		return nil, err
+	}
+	stats := &MarketStats{
+		LendingToken: lendingToken,
+		Term:         term,
+		Outstanding:  allTime.Outstanding,
+		Volume24h:    new(big.Int),
+		AllTime:      allTime,
+	}
+	if len(history) > 0 {
+		day := history[0]
+		stats.Volume24h = day.Volume
+		stats.TradeCount24h = day.TradeCount
+		stats.WeightedInterest24h = day.WeightedInterest()
+		stats.Liquidations24h = day.Liquidations
+		stats.AutoTopUps24h = day.AutoTopUps
+	}
+	return stats, nil
+}
+
+// GetMarketStatsHistory returns the stats of the lending market of lendingToken
+// and term in candles of resolution seconds covering [from, to).
+func (l *Lending) GetMarketStatsHistory(lendingToken common.Address, term uint64, from, to, resolution uint64) ([]*lendingstate.LendingMarketStats, error) {
+	if !l.tomox.IsSDKNode() {
+		return nil, errNoMarketStats
+	}
+	if from >= to {
+		return nil, errors.New("from must be before to")
+	}
+	if resolution == 0 || resolution%lendingstate.MarketStatsPeriod != 0 {
+		return nil, fmt.Errorf("resolution must be a multiple of %d seconds", lendingstate.MarketStatsPeriod)
+	}
+	start := from / lendingstate.MarketStatsPeriod * lendingstate.MarketStatsPeriod
+	if (to-start)/lendingstate.MarketStatsPeriod > maxMarketStatsPeriods {
+		return nil, fmt.Errorf("too many periods requested, the limit is %d", maxMarketStatsPeriods)
+	}
+	lendingBook := lendingstate.GetLendingOrderBookHash(lendingToken, term)
+	hashes := []string{}
+	for t := start; t < to; t += lendingstate.MarketStatsPeriod {
+		if t == 0 {
+			// reserved for the all-time stats
+			continue
+		}
+		hashes = append(hashes, lendingstate.GetMarketStatsHash(lendingBook, t).Hex())
+	}
+	periods := []*lendingstate.LendingMarketStats{}
+	if items := l.GetMongoDB().GetListItemByHashes(hashes, &lendingstate.LendingMarketStats{}); items != nil {
+		periods = items.([]*lendingstate.LendingMarketStats)
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Time < periods[j].Time })
+	return lendingstate.AggregateMarketStats(periods, start, to, resolution), nil
+}
@@ -70,7 +70,7 @@ func (l *Lending) ApplyOrder(header *types.Header, coinbase common.Address, chai
 		}
 		trades = append(trades, newLendingTrade)
 		return trades, rejects, nil
-	case lendingstate.Repay:
+	case lendingstate.Repay, lendingstate.PartialRepay:
 		lendingTrade, err := l.ProcessRepay(header, chain, lendingStateDB, statedb, tradingStateDb, lendingOrderBook, order)
 		if err != nil {
 			log.Debug("Can not process payment", "err", err)
@@ -78,6 +78,36 @@ func (l *Lending) ApplyOrder(header *types.Header, coinbase common.Address, chai
 		}
 		trades = append(trades, lendingTrade)
 		return trades, rejects, nil
+	case lendingstate.BasketTopUp:
+		lendingTrade, err := l.ProcessBasketTopUp(header, chain, lendingStateDB, statedb, tradingStateDb, lendingOrderBook, order)
+		if err != nil {
+			log.Debug("Can not process basket top-up", "err", err)
+			rejects = append(rejects, order)
+		}
+		trades = append(trades, lendingTrade)
+		return trades, rejects, nil
+	case lendingstate.Transfer:
+		lendingTrade, err := l.ProcessTransfer(header, chain, lendingStateDB, statedb, lendingOrderBook, order)
+		if err != nil {
+			log.Debug("Can not process transfer", "err", err)
+			rejects = append(rejects, order)
+		}
+		trades = append(trades, lendingTrade)
+		return trades, rejects, nil
+	case lendingstate.Policy:
+		if err := l.ProcessPolicy(header, chain, lendingStateDB, statedb, lendingOrderBook, order); err != nil {
+			log.Debug("Can not process borrower policy", "err", err)
+			rejects = append(rejects, order)
+		}
+		return trades, rejects, nil
+	case lendingstate.Rollover:
+		rolledTrades, err := l.ProcessRollover(header, coinbase, chain, lendingStateDB, statedb, tradingStateDb, lendingOrderBook, order)
+		if err != nil {
+			log.Debug("Can not process rollover", "err", err)
+			rejects = append(rejects, order)
+		}
+		trades = append(trades, rolledTrades...)
+		return trades, rejects, nil
 	default:
 	}
 
@@ -781,6 +811,9 @@ func (l *Lending) ProcessTopUp(lendingStateDB *lendingstate.LendingStateDB, stat
 		log.Debug("ProcessTopUp: invalid quantity", "Quantity", order.Quantity, "lendingTradeId", lendingTradeId.Hex())
 		return nil, true, nil
 	}
+	if lendingstate.IsBasketPosition(statedb, lendingBook, lendingTrade.TradeId) {
+		return fmt.Errorf("ProcessTopUp: lendingTrade %v is a basket position", lendingTrade.TradeId), true, nil
+	}
 	return l.ProcessTopUpLendingTrade(lendingStateDB, statedb, tradingStateDb, lendingTradeId, lendingBook, order.Quantity)
 }
 
@@ -798,9 +831,39 @@ func (l *Lending) ProcessRepay(header *types.Header, chain consensus.ChainContex
 	if order.Relayer.String() != lendingTrade.BorrowingRelayer.String() {
 		return nil, fmt.Errorf("ProcessRepay: invalid relayerAddress . Got: %s . Expect: %s", order.Relayer.Hex(), lendingTrade.BorrowingRelayer.Hex())
 	}
+	// a partial repay order pays back at most its quantity, a repay order always closes the trade
+	if order.Type == lendingstate.PartialRepay {
+		if !chain.Config().IsTIPTomoXPartialRepay(header.Number) {
+			return nil, fmt.Errorf("ProcessRepay: partial repay is not enabled. lendingTradeId: %v", lendingTradeId)
+		}
+		if order.Quantity == nil || order.Quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("ProcessRepay: invalid partial repay quantity. lendingTradeId: %v", lendingTradeId)
+		}
+		if lendingTrade.LiquidationTime <= header.Time.Uint64() {
+			return nil, fmt.Errorf("ProcessRepay: partial repay after the liquidation time. lendingTradeId: %v", lendingTradeId)
+		}
+		if paymentBalance := l.repayValue(header, chain, statedb, &lendingTrade); order.Quantity.Cmp(paymentBalance) < 0 {
+			return l.ProcessPartialRepayLendingTrade(header, chain, lendingStateDB, statedb, tradingstateDB, lendingBook, lendingTradeId, order.Quantity)
+		}
+	}
 	return l.ProcessRepayLendingTrade(header, chain, lendingStateDB, statedb, tradingstateDB, lendingBook, lendingTradeId)
 }
 
+// repayPolicy returns the interest policy of an early repayment of the trade.
+// Early repayments follow the policy of the borrowing relayer since TIPTomoXRollover.
+func (l *Lending) repayPolicy(header *types.Header, chain consensus.ChainContext, statedb *state.StateDB, lendingTrade *lendingstate.LendingTrade) uint64 {
+	if !chain.Config().IsTIPTomoXRollover(header.Number) {
+		return lendingstate.RepayInterestHalfTerm
+	}
+	return lendingstate.GetRepayPolicy(statedb, lendingTrade.BorrowingRelayer, lendingTrade.LendingToken, lendingTrade.Term)
+}
+
+// repayValue returns the amount plus the interest the borrower pays back at the given header.
+func (l *Lending) repayValue(header *types.Header, chain consensus.ChainContext, statedb *state.StateDB, lendingTrade *lendingstate.LendingTrade) *big.Int {
+	policy := l.repayPolicy(header, chain, statedb, lendingTrade)
+	return lendingstate.CalculateRepayValue(policy, header.Time.Uint64(), lendingTrade.LiquidationTime, lendingTrade.Term, lendingTrade.Interest, lendingTrade.Amount)
+}
+
 // return liquidatedTrade
 func (l *Lending) LiquidationExpiredTrade(header *types.Header, chain consensus.ChainContext, lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, tradingstateDB *tradingstate.TradingStateDB, lendingBook common.Hash, lendingTradeId uint64) (*lendingstate.LendingTrade, error) {
 	lendingTradeIdHash := common.Uint64ToHash(lendingTradeId)
@@ -808,6 +871,9 @@ func (l *Lending) LiquidationExpiredTrade(header *types.Header, chain consensus.
 	if lendingTrade.TradeId != lendingTradeId {
 		return nil, fmt.Errorf("Lending Trade Id not found : %d ", lendingTradeId)
 	}
+	if lendingstate.IsBasketPosition(statedb, lendingBook, lendingTradeId) {
+		return l.LiquidateBasket(header, chain, lendingStateDB, statedb, tradingstateDB, lendingBook, lendingTradeId, nil, lendingstate.LiquidatedByTime)
+	}
 	repayAmount := lendingTrade.CollateralLockedAmount
 
 	_, collateralPrice, err := l.GetCollateralPrices(header, chain, statedb, tradingstateDB, lendingTrade.CollateralToken, lendingTrade.LendingToken)
@@ -891,6 +957,113 @@ func (l *Lending) LiquidationTrade(lendingStateDB *lendingstate.LendingStateDB,
 	return &lendingTrade, nil
 }
 
+// PartialLiquidationTrade gives the investor only the collateral needed to bring
+// the trade back to its deposit rate at the current price, plus the liquidation
+// bonus of the collateral token, and keeps the rest of the trade open. It
+// returns a nil trade if the trade can't be restored, it must be liquidated
+// entirely then.
+func (l *Lending) PartialLiquidationTrade(lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, tradingstateDB *tradingstate.TradingStateDB, lendingBook common.Hash, lendingTradeId uint64, collateralPrice *big.Int) (*lendingstate.LendingTrade, error) {
+	lendingTradeIdHash := common.Uint64ToHash(lendingTradeId)
+	lendingTrade := lendingStateDB.GetLendingTrade(lendingBook, lendingTradeIdHash)
+	if lendingTrade.TradeId != lendingTradeId {
+		return nil, fmt.Errorf("Lending Trade Id not found : %d ", lendingTradeId)
+	}
+	depositRate, liquidationRate, _ := lendingstate.GetCollateralDetail(statedb, lendingTrade.CollateralToken)
+	liquidationBonus := lendingstate.GetLiquidationBonus(statedb, lendingTrade.CollateralToken)
+	repaidAmount, liquidationAmount, bonusAmount := getPartialLiquidationAmounts(lendingTrade.Amount, lendingTrade.CollateralLockedAmount, lendingTrade.LiquidationPrice, collateralPrice, depositRate, liquidationRate, liquidationBonus)
+	if repaidAmount == nil {
+		return nil, nil
+	}
+	newAmount := new(big.Int).Sub(lendingTrade.Amount, repaidAmount)
+	newLockedAmount := new(big.Int).Sub(lendingTrade.CollateralLockedAmount, liquidationAmount)
+	// newLiquidationPrice = LiquidationPrice * newAmount * CollateralLockedAmount / (Amount * newLockedAmount)
+	newLiquidationPrice := new(big.Int).Mul(lendingTrade.LiquidationPrice, newAmount)
+	newLiquidationPrice = new(big.Int).Mul(newLiquidationPrice, lendingTrade.CollateralLockedAmount)
+	newLiquidationPrice = new(big.Int).Div(newLiquidationPrice, new(big.Int).Mul(lendingTrade.Amount, newLockedAmount))
+
+	orderbook := tradingstate.GetTradingOrderBookHash(lendingTrade.CollateralToken, lendingTrade.LendingToken)
+	if err := tradingstateDB.RemoveLiquidationPrice(orderbook, lendingTrade.LiquidationPrice, lendingBook, lendingTradeId); err != nil {
+		log.Debug("PartialLiquidationTrade RemoveLiquidationPrice", "err", err)
+		return nil, err
+	}
+	lendingstate.SubTokenBalance(common.HexToAddress(common.LendingLockAddress), liquidationAmount, lendingTrade.CollateralToken, statedb)
+	lendingstate.AddTokenBalance(lendingTrade.Investor, liquidationAmount, lendingTrade.CollateralToken, statedb)
+
+	lendingStateDB.UpdateTradeAmount(lendingBook, lendingTradeId, newAmount)
+	lendingStateDB.UpdateCollateralLockedAmount(lendingBook, lendingTradeId, newLockedAmount)
+	lendingStateDB.UpdateLiquidationPrice(lendingBook, lendingTradeId, newLiquidationPrice)
+	tradingstateDB.InsertLiquidationPrice(orderbook, newLiquidationPrice, lendingBook, lendingTradeId)
+
+	newLendingTrade := lendingTrade
+	newLendingTrade.Amount = newAmount
+	newLendingTrade.CollateralLockedAmount = newLockedAmount
+	newLendingTrade.LiquidationPrice = newLiquidationPrice
+	newLendingTrade.Status = lendingstate.TradeStatusOpen
+	extraData, _ := json.Marshal(lendingstate.LiquidationData{
+		RecallAmount:      common.Big0,
+		LiquidationAmount: liquidationAmount,
+		CollateralPrice:   collateralPrice,
+		Reason:            lendingstate.LiquidatedPartially,
+		RepaidAmount:      repaidAmount,
+		BonusAmount:       bonusAmount,
+	})
+	newLendingTrade.ExtraData = string(extraData)
+	log.Debug("PartialLiquidationTrade", "lendingTradeId", lendingTradeId, "repaidAmount", repaidAmount, "liquidationAmount", liquidationAmount, "bonusAmount", bonusAmount, "newLiquidationPrice", newLiquidationPrice)
+	return &newLendingTrade, nil
+}
+
+// getPartialLiquidationAmounts returns the part of the trade amount to cover,
+// the collateral to seize for it, bonus included, and the bonus alone, so that
+// the remaining collateral is worth depositRate percent of the remaining amount
+// at the current price. The collateral value follows from the liquidation
+// price, which is always collateralValue * liquidationRate / Amount:
+//
+//	collateralValue = Amount * collateralPrice * liquidationRate / (LiquidationPrice * 100)
+//
+// and restoring the deposit rate while paying a bonus on the seized part gives:
+//
+//	repaidAmount = Amount * (depositRate * LiquidationPrice - collateralPrice * liquidationRate) / (LiquidationPrice * (depositRate - 100 - bonus))
+//
+// All results are nil if the trade can't be restored partially.
+func getPartialLiquidationAmounts(amount, lockedAmount, liquidationPrice, collateralPrice, depositRate, liquidationRate, bonus *big.Int) (repaidAmount, liquidationAmount, bonusAmount *big.Int) {
+	if amount.Sign() <= 0 || liquidationPrice.Sign() <= 0 || collateralPrice.Sign() <= 0 || liquidationRate.Sign() <= 0 {
+		return nil, nil, nil
+	}
+	if bonus == nil {
+		bonus = common.Big0
+	}
+	denominator := new(big.Int).Sub(depositRate, big.NewInt(100))
+	denominator = new(big.Int).Sub(denominator, bonus)
+	if denominator.Sign() <= 0 {
+		return nil, nil, nil
+	}
+	shortfall := new(big.Int).Sub(new(big.Int).Mul(depositRate, liquidationPrice), new(big.Int).Mul(collateralPrice, liquidationRate))
+	if shortfall.Sign() <= 0 {
+		return nil, nil, nil
+	}
+	repaidAmount = new(big.Int).Mul(amount, shortfall)
+	denominator = new(big.Int).Mul(liquidationPrice, denominator)
+	// round up so that the deposit rate is fully restored
+	repaidAmount, remainder := new(big.Int).QuoRem(repaidAmount, denominator, new(big.Int))
+	if remainder.Sign() > 0 {
+		repaidAmount = new(big.Int).Add(repaidAmount, common.Big1)
+	}
+	if repaidAmount.Cmp(amount) >= 0 {
+		return nil, nil, nil
+	}
+	// liquidationAmount = repaidAmount * (100 + bonus) * lockedAmount * LiquidationPrice / (Amount * collateralPrice * liquidationRate)
+	collateral := new(big.Int).Mul(lockedAmount, liquidationPrice)
+	value := new(big.Int).Mul(new(big.Int).Mul(amount, collateralPrice), liquidationRate)
+	liquidationAmount = new(big.Int).Mul(repaidAmount, new(big.Int).Add(big.NewInt(100), bonus))
+	liquidationAmount = new(big.Int).Div(new(big.Int).Mul(liquidationAmount, collateral), value)
+	bonusAmount = new(big.Int).Mul(repaidAmount, bonus)
+	bonusAmount = new(big.Int).Div(new(big.Int).Mul(bonusAmount, collateral), value)
+	if liquidationAmount.Cmp(lockedAmount) >= 0 {
+		return nil, nil, nil
+	}
+	return repaidAmount, liquidationAmount, bonusAmount
+}
+
 // cancellation fee = 1/10 borrowing fee
 // deprecated after hardfork at TIPTomoXCancellationFee
 func getCancelFeeV1(collateralTokenDecimal *big.Int, collateralPrice, borrowFee *big.Int, order *lendingstate.LendingItem) *big.Int {
@@ -958,7 +1131,34 @@ func (l *Lending) GetMediumTradePriceBeforeEpoch(chain consensus.ChainContext, s
 //- Have pairs with TOMO:
 //-  lendToken/TOMO and CollateralToken/TOMO
 //-  TOMO/lendToken and TOMO/CollateralToken
+//Once the price oracle is enabled, the median of the oracle feeders is used if
+//enough of them submitted recent prices, the prices above are kept as a fallback
+//bounded by the recent feeder prices.
 func (l *Lending) GetCollateralPrices(header *types.Header, chain consensus.ChainContext, statedb *state.StateDB, tradingStateDb *tradingstate.TradingStateDB, collateralToken common.Address, lendingToken common.Address) (*big.Int, *big.Int, error) {
+	if !chain.Config().IsTIPTomoXPriceOracle(header.Number) {
+		return l.getCollateralPrices(header, chain, statedb, tradingStateDb, collateralToken, lendingToken)
+	}
+	oraclePrice := lendingstate.GetOraclePrice(statedb, collateralToken, lendingToken, header.Number.Uint64())
+	if oraclePrice.Median != nil {
+		lendTokenTOMOPrice, err := l.GetTOMOBasePrices(header, chain, statedb, tradingStateDb, lendingToken)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Debug("Getting collateral/lending token price from oracle", "price", oraclePrice.Median, "feeders", oraclePrice.Feeders)
+		return lendTokenTOMOPrice, oraclePrice.Median, nil
+	}
+	lendTokenTOMOPrice, collateralPrice, err := l.getCollateralPrices(header, chain, statedb, tradingStateDb, collateralToken, lendingToken)
+	if err != nil || collateralPrice == nil || collateralPrice.Sign() <= 0 || oraclePrice.Reference == nil {
+		return lendTokenTOMOPrice, collateralPrice, err
+	}
+	bounded := lendingstate.ClampToDeviation(collateralPrice, oraclePrice.Reference, lendingstate.GetOracleConfig(statedb).MaxDeviation)
+	log.Debug("Bounding fallback collateral/lending token price to oracle reference", "price", collateralPrice, "reference", oraclePrice.Reference, "bounded", bounded)
+	return lendTokenTOMOPrice, bounded, nil
+}
+
+// getCollateralPrices returns the lendToken/TOMO and collateral/lendToken prices
+// from the lending contract and the TomoX pairs.
+func (l *Lending) getCollateralPrices(header *types.Header, chain consensus.ChainContext, statedb *state.StateDB, tradingStateDb *tradingstate.TradingStateDB, collateralToken common.Address, lendingToken common.Address) (*big.Int, *big.Int, error) {
 	// lendTokenTOMOPrice: price of ticker lendToken/TOMO
 	// collateralTOMOPrice: price of ticker collateralToken/TOMO
 	// collateralPrice: price of ticker collateralToken/lendToken
@@ -1059,7 +1259,10 @@ func (l *Lending) GetTOMOBasePrices(header *types.Header, chain consensus.ChainC
 	return nil, nil
 }
 
-func (l *Lending) AutoTopUp(statedb *state.StateDB, tradingState *tradingstate.TradingStateDB, lendingState *lendingstate.LendingStateDB, lendingBook, lendingTradeId common.Hash, currentPrice *big.Int) (*lendingstate.LendingTrade, error) {
+// AutoTopUp locks more collateral of the borrower to bring the liquidation price
+// of the trade back under currentPrice. The policy of the trade, which may be
+// nil, sets the target of the top-up and limits the amount locked during epoch.
+func (l *Lending) AutoTopUp(statedb *state.StateDB, tradingState *tradingstate.TradingStateDB, lendingState *lendingstate.LendingStateDB, lendingBook, lendingTradeId common.Hash, currentPrice *big.Int, allowPartial bool, policy *lendingstate.BorrowerPolicy, epoch uint64) (*lendingstate.LendingTrade, error) {
 	lendingTrade := lendingState.GetLendingTrade(lendingBook, lendingTradeId)
 	if lendingTrade == lendingstate.EmptyLendingTrade {
 		return nil, fmt.Errorf("process deposit for emptyLendingTrade is not allowed. lendingTradeId: %v", lendingTradeId.Hex())
@@ -1070,15 +1273,39 @@ func (l *Lending) AutoTopUp(statedb *state.StateDB, tradingState *tradingstate.T
 	// newLiquidationPrice = currentPrice * 90%
 	newLiquidationPrice := new(big.Int).Mul(currentPrice, common.RateTopUp)
 	newLiquidationPrice = new(big.Int).Div(newLiquidationPrice, common.BaseTopUp)
+	if target := policy.TargetLiquidationPrice(currentPrice, lendingTrade.LiquidationRate); target != nil && target.Sign() > 0 && target.Cmp(currentPrice) < 0 {
+		newLiquidationPrice = target
+	}
 	// newLockedAmount = CollateralLockedAmount *  LiquidationPrice / newLiquidationPrice
 	newLockedAmount := new(big.Int).Mul(lendingTrade.CollateralLockedAmount, lendingTrade.LiquidationPrice)
 	newLockedAmount = new(big.Int).Div(newLockedAmount, newLiquidationPrice)
 
 	requiredDepositAmount := new(big.Int).Sub(newLockedAmount, lendingTrade.CollateralLockedAmount)
 	tokenBalance := lendingstate.GetTokenBalance(lendingTrade.Borrower, lendingTrade.CollateralToken, statedb)
+	// the borrower can't lock more than the policy allows in this epoch
+	availableAmount := tokenBalance
+	allowance := policy.TopUpAllowance(epoch)
+	if allowance != nil && allowance.Cmp(availableAmount) < 0 {
+		availableAmount = allowance
+	}
+	if availableAmount.Cmp(requiredDepositAmount) < 0 && allowPartial {
+		// lock the whole balance if it's enough to bring the liquidation price below the current price
+		// minDepositAmount = CollateralLockedAmount * LiquidationPrice / currentPrice + 1 - CollateralLockedAmount
+		minDepositAmount := new(big.Int).Mul(lendingTrade.CollateralLockedAmount, lendingTrade.LiquidationPrice)
+		minDepositAmount = new(big.Int).Div(minDepositAmount, currentPrice)
+		minDepositAmount = new(big.Int).Add(minDepositAmount, common.Big1)
+		minDepositAmount = new(big.Int).Sub(minDepositAmount, lendingTrade.CollateralLockedAmount)
+		if availableAmount.Cmp(minDepositAmount) >= 0 {
+			log.Debug("AutoTopUp partially", "requiredDepositAmount", requiredDepositAmount, "tokenBalance", tokenBalance, "allowance", allowance, "lendingTradeId", lendingTradeId.Hex())
+			requiredDepositAmount = availableAmount
+		}
+	}
 	if tokenBalance.Cmp(requiredDepositAmount) < 0 {
 		return nil, fmt.Errorf("not enough balance to AutoTopUp. requiredDepositAmount: %v . tokenBalance: %v . Token: %s", requiredDepositAmount, tokenBalance, lendingTrade.CollateralToken.Hex())
 	}
+	if allowance != nil && allowance.Cmp(requiredDepositAmount) < 0 {
+		return nil, fmt.Errorf("AutoTopUp exceeds the borrower policy. requiredDepositAmount: %v . allowance: %v . Token: %s", requiredDepositAmount, allowance, lendingTrade.CollateralToken.Hex())
+	}
 	err, _, newTrade := l.ProcessTopUpLendingTrade(lendingState, statedb, tradingState, lendingTradeId, lendingBook, requiredDepositAmount)
 	return newTrade, err
 }
@@ -1121,7 +1348,7 @@ func (l *Lending) ProcessRepayLendingTrade(header *types.Header, chain consensus
 	}
 	time := header.Time.Uint64()
 	tokenBalance := lendingstate.GetTokenBalance(lendingTrade.Borrower, lendingTrade.LendingToken, statedb)
-	paymentBalance := lendingstate.CalculateTotalRepayValue(time, lendingTrade.LiquidationTime, lendingTrade.Term, lendingTrade.Interest, lendingTrade.Amount)
+	paymentBalance := l.repayValue(header, chain, statedb, &lendingTrade)
 	log.Debug("ProcessRepay", "totalInterest", new(big.Int).Sub(paymentBalance, lendingTrade.Amount), "totalRepayValue", paymentBalance, "token", lendingTrade.LendingToken.Hex())
 
 	if tokenBalance.Cmp(paymentBalance) < 0 {
@@ -1159,20 +1386,27 @@ func (l *Lending) ProcessRepayLendingTrade(header *types.Header, chain consensus
 		lendingstate.SubTokenBalance(common.HexToAddress(common.LendingLockAddress), lendingTrade.CollateralLockedAmount, lendingTrade.CollateralToken, statedb)
 		lendingstate.AddTokenBalance(lendingTrade.Borrower, lendingTrade.CollateralLockedAmount, lendingTrade.CollateralToken, statedb)
 
-		err = lendingStateDB.RemoveLiquidationTime(lendingBook, lendingTradeId, lendingTrade.LiquidationTime)
-		if err != nil {
-			log.Debug("ProcessRepay RemoveLiquidationTime", "err", err, "lendingHash", lendingTrade.Hash, "trade", lendingstate.ToJSON(lendingTrade))
-			return nil, err
-		}
-		err = tradingstateDB.RemoveLiquidationPrice(tradingstate.GetTradingOrderBookHash(lendingTrade.CollateralToken, lendingTrade.LendingToken), lendingTrade.LiquidationPrice, lendingBook, lendingTradeId)
-		if err != nil {
-			log.Debug("ProcessRepay RemoveLiquidationPrice", "err", err)
-			return nil, err
-		}
-		err = lendingStateDB.CancelLendingTrade(lendingBook, lendingTradeId)
-		if err != nil {
-			log.Debug("ProcessRepay CancelLendingTrade", "err", err)
-			return nil, err
+		if lendingstate.IsBasketPosition(statedb, lendingBook, lendingTradeId) {
+			releaseBasket(statedb, lendingBook, &lendingTrade)
+			if err := l.closeBasketPosition(lendingStateDB, statedb, lendingBook, &lendingTrade); err != nil {
+				return nil, err
+			}
+		} else {
+			err = lendingStateDB.RemoveLiquidationTime(lendingBook, lendingTradeId, lendingTrade.LiquidationTime)
+			if err != nil {
+				log.Debug("ProcessRepay RemoveLiquidationTime", "err", err, "lendingHash", lendingTrade.Hash, "trade", lendingstate.ToJSON(lendingTrade))
+				return nil, err
+			}
+			err = tradingstateDB.RemoveLiquidationPrice(tradingstate.GetTradingOrderBookHash(lendingTrade.CollateralToken, lendingTrade.LendingToken), lendingTrade.LiquidationPrice, lendingBook, lendingTradeId)
+			if err != nil {
+				log.Debug("ProcessRepay RemoveLiquidationPrice", "err", err)
+				return nil, err
+			}
+			err = lendingStateDB.CancelLendingTrade(lendingBook, lendingTradeId)
+			if err != nil {
+				log.Debug("ProcessRepay CancelLendingTrade", "err", err)
+				return nil, err
+			}
 		}
 		lendingTrade.Status = lendingstate.TradeStatusClosed
 		extraData, _ := json.Marshal(struct {
@@ -1185,6 +1419,294 @@ func (l *Lending) ProcessRepayLendingTrade(header *types.Header, chain consensus
 	return &lendingTrade, nil
 }
 
+// ProcessPartialRepayLendingTrade pays back a part of a lending trade. The
+// quantity, interest included, goes to the investor and releases the same share
+// of the collateral, the rest of the trade stays open.
+func (l *Lending) ProcessPartialRepayLendingTrade(header *types.Header, chain consensus.ChainContext, lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, tradingstateDB *tradingstate.TradingStateDB, lendingBook common.Hash, lendingTradeId uint64, quantity *big.Int) (*lendingstate.LendingTrade, error) {
+	lendingTradeIdHash := common.Uint64ToHash(lendingTradeId)
+	lendingTrade := lendingStateDB.GetLendingTrade(lendingBook, lendingTradeIdHash)
+	if lendingTrade == lendingstate.EmptyLendingTrade {
+		return nil, fmt.Errorf("ProcessPartialRepayLendingTrade for emptyLendingTrade is not allowed. lendingTradeId: %v", lendingTradeId)
+	}
+	if lendingstate.IsBasketPosition(statedb, lendingBook, lendingTradeId) {
+		return nil, fmt.Errorf("ProcessPartialRepayLendingTrade: lendingTrade %v is a basket position", lendingTradeId)
+	}
+	tokenBalance := lendingstate.GetTokenBalance(lendingTrade.Borrower, lendingTrade.LendingToken, statedb)
+	if tokenBalance.Cmp(quantity) < 0 {
+		return nil, fmt.Errorf("Not enough balance need : %s , have : %s ", quantity, tokenBalance)
+	}
+	paymentBalance := l.repayValue(header, chain, statedb, &lendingTrade)
+	if quantity.Cmp(paymentBalance) >= 0 {
+		return nil, fmt.Errorf("partial repayment must be lower than the total repay value. quantity: %v , totalRepayValue: %v", quantity, paymentBalance)
+	}
+	// principalAmount = Amount * quantity / totalRepayValue
+	principalAmount := new(big.Int).Mul(lendingTrade.Amount, quantity)
+	principalAmount = new(big.Int).Div(principalAmount, paymentBalance)
+	if principalAmount.Sign() == 0 {
+		return nil, fmt.Errorf("partial repayment is too small. quantity: %v", quantity)
+	}
+	newAmount := new(big.Int).Sub(lendingTrade.Amount, principalAmount)
+	// recallAmount = CollateralLockedAmount * principalAmount / Amount
+	recallAmount := new(big.Int).Mul(lendingTrade.CollateralLockedAmount, principalAmount)
+	recallAmount = new(big.Int).Div(recallAmount, lendingTrade.Amount)
+	newLockedAmount := new(big.Int).Sub(lendingTrade.CollateralLockedAmount, recallAmount)
+	if newLockedAmount.Sign() <= 0 {
+		return nil, fmt.Errorf("partial repayment releases all collateral. quantity: %v", quantity)
+	}
+	// the liquidation price follows the ratio between the debt and the collateral
+	// newLiquidationPrice = LiquidationPrice * newAmount * CollateralLockedAmount / (Amount * newLockedAmount)
+	newLiquidationPrice := new(big.Int).Mul(lendingTrade.LiquidationPrice, newAmount)
+	newLiquidationPrice = new(big.Int).Mul(newLiquidationPrice, lendingTrade.CollateralLockedAmount)
+	newLiquidationPrice = new(big.Int).Div(newLiquidationPrice, new(big.Int).Mul(lendingTrade.Amount, newLockedAmount))
+
+	orderbook := tradingstate.GetTradingOrderBookHash(lendingTrade.CollateralToken, lendingTrade.LendingToken)
+	if err := tradingstateDB.RemoveLiquidationPrice(orderbook, lendingTrade.LiquidationPrice, lendingBook, lendingTradeId); err != nil {
+		log.Debug("ProcessPartialRepay RemoveLiquidationPrice", "err", err)
+		return nil, err
+	}
+	lendingstate.SubTokenBalance(lendingTrade.Borrower, quantity, lendingTrade.LendingToken, statedb)
+	lendingstate.AddTokenBalance(lendingTrade.Investor, quantity, lendingTrade.LendingToken, statedb)
+
+	lendingstate.SubTokenBalance(common.HexToAddress(common.LendingLockAddress), recallAmount, lendingTrade.CollateralToken, statedb)
+	lendingstate.AddTokenBalance(lendingTrade.Borrower, recallAmount, lendingTrade.CollateralToken, statedb)
+
+	lendingStateDB.UpdateTradeAmount(lendingBook, lendingTradeId, newAmount)
+	lendingStateDB.UpdateCollateralLockedAmount(lendingBook, lendingTradeId, newLockedAmount)
+	lendingStateDB.UpdateLiquidationPrice(lendingBook, lendingTradeId, newLiquidationPrice)
+	tradingstateDB.InsertLiquidationPrice(orderbook, newLiquidationPrice, lendingBook, lendingTradeId)
+
+	newLendingTrade := lendingTrade
+	newLendingTrade.Amount = newAmount
+	newLendingTrade.CollateralLockedAmount = newLockedAmount
+	newLendingTrade.LiquidationPrice = newLiquidationPrice
+	newLendingTrade.Status = lendingstate.TradeStatusOpen
+	extraData, _ := json.Marshal(lendingstate.PartialRepayData{
+		RepayAmount:     quantity,
+		PrincipalAmount: principalAmount,
+		RecallAmount:    recallAmount,
+	})
+	newLendingTrade.ExtraData = string(extraData)
+	log.Debug("ProcessPartialRepay", "lendingTradeId", lendingTradeId, "repayAmount", quantity, "newAmount", newAmount, "recallAmount", recallAmount, "newLiquidationPrice", newLiquidationPrice)
+	return &newLendingTrade, nil
+}
+
+// ProcessRollover repays a lending trade before its liquidation time and
+// borrows the same amount again for a new term, matched against the best
+// investing order of the lending book. The new trade is settled like a matched
+// borrowing order without locking collateral again: the investor of that
+// order funds the principal and the relayers' fees are charged as usual. The
+// borrower pays the interest of the closed trade, the collateral stays locked
+// for the new trade.
+func (l *Lending) ProcessRollover(header *types.Header, coinbase common.Address, chain consensus.ChainContext, lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, tradingstateDB *tradingstate.TradingStateDB, lendingBook common.Hash, order *lendingstate.LendingItem) ([]*lendingstate.LendingTrade, error) {
+	if !chain.Config().IsTIPTomoXRollover(header.Number) {
+		return nil, fmt.Errorf("ProcessRollover: rollover is not supported yet")
+	}
+	lendingTradeId := order.LendingTradeId
+	lendingTrade := lendingStateDB.GetLendingTrade(lendingBook, common.Uint64ToHash(lendingTradeId))
+	if lendingTrade == lendingstate.EmptyLendingTrade || lendingTrade.TradeId != lendingTradeId {
+		return nil, fmt.Errorf("ProcessRollover for emptyLendingTrade is not allowed. lendingTradeId: %v", lendingTradeId)
+	}
+	if lendingstate.IsBasketPosition(statedb, lendingBook, lendingTradeId) {
+		return nil, fmt.Errorf("ProcessRollover: lendingTrade %v is a basket position", lendingTradeId)
+	}
+	if order.UserAddress != lendingTrade.Borrower {
+		return nil, fmt.Errorf("ProcessRollover: invalid userAddress . UserAddress: %s . Borrower: %s", order.UserAddress.Hex(), lendingTrade.Borrower.Hex())
+	}
+	if order.Relayer != lendingTrade.BorrowingRelayer {
+		return nil, fmt.Errorf("ProcessRollover: invalid relayerAddress . Got: %s . Expect: %s", order.Relayer.Hex(), lendingTrade.BorrowingRelayer.Hex())
+	}
+	if lendingTrade.LiquidationTime <= header.Time.Uint64() {
+		return nil, fmt.Errorf("ProcessRollover: lendingTrade expired. lendingTradeId: %v . liquidationTime: %v", lendingTradeId, lendingTrade.LiquidationTime)
+	}
+	// the collateral locked for the closed trade must still cover the new one
+	lendTokenTOMOPrice, collateralPrice, err := l.GetCollateralPrices(header, chain, statedb, tradingstateDB, lendingTrade.CollateralToken, lendingTrade.LendingToken)
+	if err != nil {
+		return nil, err
+	}
+	if collateralPrice == nil || collateralPrice.Cmp(lendingTrade.LiquidationPrice) <= 0 {
+		return nil, fmt.Errorf("ProcessRollover: collateral price %v is not above the liquidation price %v", collateralPrice, lendingTrade.LiquidationPrice)
+	}
+
+	// only the oldest order at the best investing rate is matched, it must cover the whole amount
+	interest, _ := lendingStateDB.GetBestInvestingRate(lendingBook)
+	if interest.Sign() == 0 {
+		return nil, fmt.Errorf("ProcessRollover: no investing order in lendingBook %s", lendingBook.Hex())
+	}
+	orderId, amount, err := lendingStateDB.GetBestLendingIdAndAmount(lendingBook, interest, lendingstate.Investing)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Cmp(lendingTrade.Amount) < 0 {
+		return nil, fmt.Errorf("ProcessRollover: best investing order is too small. have: %v , want: %v", amount, lendingTrade.Amount)
+	}
+	investingOrder := lendingStateDB.GetLendingOrder(lendingBook, orderId)
+	if investingOrder == lendingstate.EmptyLendingOrder {
+		return nil, fmt.Errorf("ProcessRollover: investing order not found. orderId: %s", orderId.Hex())
+	}
+	if balance := lendingstate.GetTokenBalance(investingOrder.UserAddress, lendingTrade.LendingToken, statedb); balance.Cmp(lendingTrade.Amount) < 0 {
+		return nil, fmt.Errorf("ProcessRollover: investor doesn't have enough lendingToken. Investor: %s . have: %v , want: %v", investingOrder.UserAddress.Hex(), balance, lendingTrade.Amount)
+	}
+	lendingTokenDecimal, err := l.tomox.GetTokenDecimal(chain, statedb, lendingTrade.LendingToken)
+	if err != nil || lendingTokenDecimal.Sign() == 0 {
+		return nil, fmt.Errorf("Fail to get tokenDecimal. Token: %v . Err: %v", lendingTrade.LendingToken.String(), err)
+	}
+	collateralTokenDecimal, err := l.tomox.GetTokenDecimal(chain, statedb, lendingTrade.CollateralToken)
+	if err != nil || collateralTokenDecimal.Sign() == 0 {
+		return nil, fmt.Errorf("Fail to get tokenDecimal. Token: %v . Err: %v", lendingTrade.CollateralToken.String(), err)
+	}
+	if err := lendingstate.CheckRelayerFee(lendingTrade.BorrowingRelayer, common.RelayerLendingFee, statedb); err != nil {
+		return nil, fmt.Errorf("ProcessRollover: relayer %s doesn't have enough fee: %v", lendingTrade.BorrowingRelayer.Hex(), err)
+	}
+	depositRate, _, _ := lendingstate.GetCollateralDetail(statedb, lendingTrade.CollateralToken)
+	borrowFeeRate := lendingstate.GetFee(statedb, lendingTrade.BorrowingRelayer)
+	settleBalance, err := lendingstate.GetSettleBalance(true, lendingstate.Borrowing, lendTokenTOMOPrice, collateralPrice, depositRate, borrowFeeRate, lendingTrade.LendingToken, lendingTrade.CollateralToken, lendingTokenDecimal, collateralTokenDecimal, lendingTrade.Amount)
+	if err != nil {
+		return nil, err
+	}
+	// the collateral of the closed trade carries over, nothing is locked again
+	settleBalance.Taker.OutTotal = lendingstate.Zero
+	settleBalance.CollateralLockedAmount = lendingstate.Zero
+
+	paymentBalance := l.repayValue(header, chain, statedb, &lendingTrade)
+	interestAmount := new(big.Int).Sub(paymentBalance, lendingTrade.Amount)
+	borrowerPayment := new(big.Int).Sub(paymentBalance, settleBalance.Taker.InTotal)
+	if balance := lendingstate.GetTokenBalance(lendingTrade.Borrower, lendingTrade.LendingToken, statedb); balance.Cmp(borrowerPayment) < 0 {
+		return nil, fmt.Errorf("Not enough balance need : %s , have : %s ", borrowerPayment, balance)
+	}
+	takerOrder := *order
+	takerOrder.Side = lendingstate.Borrowing
+	if err := DoSettleBalance(coinbase, &takerOrder, &investingOrder, settleBalance, statedb); err != nil {
+		return nil, err
+	}
+
+	// close the current trade
+	if err := lendingStateDB.RemoveLiquidationTime(lendingBook, lendingTradeId, lendingTrade.LiquidationTime); err != nil {
+		log.Debug("ProcessRollover RemoveLiquidationTime", "err", err)
+		return nil, err
+	}
+	orderbook := tradingstate.GetTradingOrderBookHash(lendingTrade.CollateralToken, lendingTrade.LendingToken)
+	if err := tradingstateDB.RemoveLiquidationPrice(orderbook, lendingTrade.LiquidationPrice, lendingBook, lendingTradeId); err != nil {
+		log.Debug("ProcessRollover RemoveLiquidationPrice", "err", err)
+		return nil, err
+	}
+	if err := lendingStateDB.CancelLendingTrade(lendingBook, lendingTradeId); err != nil {
+		log.Debug("ProcessRollover CancelLendingTrade", "err", err)
+		return nil, err
+	}
+	if err := lendingStateDB.SubAmountLendingItem(lendingBook, orderId, interest, lendingTrade.Amount, lendingstate.Investing); err != nil {
+		return nil, err
+	}
+	lendingstate.SubTokenBalance(lendingTrade.Borrower, paymentBalance, lendingTrade.LendingToken, statedb)
+	lendingstate.AddTokenBalance(lendingTrade.Investor, paymentBalance, lendingTrade.LendingToken, statedb)
+
+	// the amount, the collateral and so the liquidation price carry over to the new trade
+	tradingId := lendingStateDB.GetTradeNonce(lendingBook) + 1
+	liquidationTime := header.Time.Uint64() + lendingTrade.Term
+	newLendingTrade := lendingstate.LendingTrade{
+		TradeId:                tradingId,
+		Term:                   lendingTrade.Term,
+		LendingToken:           lendingTrade.LendingToken,
+		CollateralToken:        lendingTrade.CollateralToken,
+		Amount:                 lendingTrade.Amount,
+		LiquidationTime:        liquidationTime,
+		LiquidationPrice:       lendingTrade.LiquidationPrice,
+		Interest:               interest.Uint64(),
+		DepositRate:            lendingTrade.DepositRate,
+		LiquidationRate:        lendingTrade.LiquidationRate,
+		RecallRate:             lendingTrade.RecallRate,
+		CollateralLockedAmount: lendingTrade.CollateralLockedAmount,
+		CollateralPrice:        collateralPrice,
+		Status:                 lendingstate.TradeStatusOpen,
+		TakerOrderSide:         lendingstate.Borrowing,
+		TakerOrderType:         lendingstate.Rollover,
+		MakerOrderType:         investingOrder.Type,
+		InvestingFee:           settleBalance.Maker.Fee,
+		BorrowingFee:           settleBalance.Taker.Fee,
+		BorrowingOrderHash:     order.Hash,
+		InvestingOrderHash:     investingOrder.Hash,
+		BorrowingRelayer:       lendingTrade.BorrowingRelayer,
+		InvestingRelayer:       investingOrder.Relayer,
+		Borrower:               lendingTrade.Borrower,
+		Investor:               investingOrder.UserAddress,
+		AutoTopUp:              lendingTrade.AutoTopUp,
+	}
+	newLendingTrade.Hash = newLendingTrade.ComputeHash()
+	lendingStateDB.InsertTradingItem(lendingBook, tradingId, newLendingTrade)
+	lendingStateDB.InsertLiquidationTime(lendingBook, new(big.Int).SetUint64(liquidationTime), tradingId)
+	lendingStateDB.SetTradeNonce(lendingBook, tradingId)
+	tradingstateDB.InsertLiquidationPrice(orderbook, newLendingTrade.LiquidationPrice, lendingBook, tradingId)
+
+	lendingTrade.Status = lendingstate.TradeStatusClosed
+	extraData, _ := json.Marshal(struct {
+		Profit *big.Int
+	}{
+		Profit: interestAmount,
+	})
+	lendingTrade.ExtraData = string(extraData)
+	log.Debug("ProcessRollover", "lendingTradeId", lendingTradeId, "newTradeId", tradingId, "interest", interest, "repayValue", paymentBalance, "borrowFee", settleBalance.Taker.Fee)
+	return []*lendingstate.LendingTrade{&lendingTrade, &newLendingTrade}, nil
+}
+
+// ProcessTransfer reassigns the claim of the investor of a lending trade to the
+// buyer of the transfer offer. The buyer pays the price of the order quantity
+// to the seller, less the fee of the investing relayer.
+func (l *Lending) ProcessTransfer(header *types.Header, chain consensus.ChainContext, lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, lendingBook common.Hash, order *lendingstate.LendingItem) (*lendingstate.LendingTrade, error) {
+	if !chain.Config().IsTIPTomoXTradeTransfer(header.Number) {
+		return nil, fmt.Errorf("ProcessTransfer: transfer is not supported yet")
+	}
+	lendingTradeId := order.LendingTradeId
+	lendingTrade := lendingStateDB.GetLendingTrade(lendingBook, common.Uint64ToHash(lendingTradeId))
+	if lendingTrade == lendingstate.EmptyLendingTrade || lendingTrade.TradeId != lendingTradeId {
+		return nil, fmt.Errorf("ProcessTransfer for emptyLendingTrade is not allowed. lendingTradeId: %v", lendingTradeId)
+	}
+	if order.UserAddress != lendingTrade.Investor {
+		return nil, fmt.Errorf("ProcessTransfer: invalid userAddress . UserAddress: %s . Investor: %s", order.UserAddress.Hex(), lendingTrade.Investor.Hex())
+	}
+	if order.Relayer != lendingTrade.InvestingRelayer {
+		return nil, fmt.Errorf("ProcessTransfer: invalid relayerAddress . Got: %s . Expect: %s", order.Relayer.Hex(), lendingTrade.InvestingRelayer.Hex())
+	}
+	if lendingTrade.LiquidationTime <= header.Time.Uint64() {
+		return nil, fmt.Errorf("ProcessTransfer: lendingTrade expired. lendingTradeId: %v . liquidationTime: %v", lendingTradeId, lendingTrade.LiquidationTime)
+	}
+	price := order.Quantity
+	if price == nil {
+		price = new(big.Int)
+	}
+	buyer, err := lendingstate.VerifyTransferOffer(&lendingTrade, order.UserAddress, order.ExtraData, price, order.Nonce.Uint64())
+	if err != nil {
+		return nil, err
+	}
+	if balance := lendingstate.GetTokenBalance(buyer, lendingTrade.LendingToken, statedb); balance.Cmp(price) < 0 {
+		return nil, fmt.Errorf("ProcessTransfer: buyer doesn't have enough lendingToken. Buyer: %s . have: %v , want: %v", buyer.Hex(), balance, price)
+	}
+	// fee = price * investingFeeRate / baseFee
+	fee := new(big.Int).Mul(price, lendingstate.GetFee(statedb, lendingTrade.InvestingRelayer))
+	fee = new(big.Int).Div(fee, common.TomoXBaseFee)
+	exOwner := lendingstate.GetRelayerOwner(lendingTrade.InvestingRelayer, statedb)
+	if fee.Sign() > 0 && common.EmptyHash(exOwner.Hash()) {
+		return nil, fmt.Errorf("ProcessTransfer: empty owner of relayer %s", lendingTrade.InvestingRelayer.Hex())
+	}
+	lendingstate.SubTokenBalance(buyer, price, lendingTrade.LendingToken, statedb)
+	lendingstate.AddTokenBalance(lendingTrade.Investor, new(big.Int).Sub(price, fee), lendingTrade.LendingToken, statedb)
+	lendingstate.AddTokenBalance(exOwner, fee, lendingTrade.LendingToken, statedb)
+	lendingStateDB.UpdateTradeInvestor(lendingBook, lendingTradeId, buyer)
+
+	seller := lendingTrade.Investor
+	lendingTrade.Investor = buyer
+	extraData, _ := json.Marshal(struct {
+		Seller common.Address
+		Price  *big.Int
+		Fee    *big.Int
+	}{
+		Seller: seller,
+		Price:  price,
+		Fee:    fee,
+	})
+	lendingTrade.ExtraData = string(extraData)
+	log.Debug("ProcessTransfer", "lendingTradeId", lendingTradeId, "seller", seller.Hex(), "buyer", buyer.Hex(), "price", price, "fee", fee)
+	return &lendingTrade, nil
+}
+
 func (l *Lending) ProcessRecallLendingTrade(lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, tradingStateDb *tradingstate.TradingStateDB, lendingBook common.Hash, lendingTradeId common.Hash, newLiquidationPrice *big.Int) (error, bool, *lendingstate.LendingTrade) {
 	log.Debug("ProcessRecallLendingTrade", "lendingTradeId", lendingTradeId.Hex(), "lendingBook", lendingBook.Hex(), "newLiquidationPrice", newLiquidationPrice)
 	lendingTrade := lendingStateDB.GetLendingTrade(lendingBook, lendingTradeId)
@@ -2,7 +2,12 @@ package tomoxlending
 
 import (
 	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus"
 	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/crypto"
+	"github.com/tomochain/tomochain/params"
 	"github.com/tomochain/tomochain/tomox"
 	"github.com/tomochain/tomochain/tomox/tradingstate"
 	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
@@ -539,3 +544,372 @@ func TestGetLendQuantity(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessPartialRepayLendingTrade(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	lendingState, _ := lendingstate.New(lendingstate.EmptyRoot, lendingstate.NewDatabase(db))
+	tradingState, _ := tradingstate.New(tradingstate.EmptyRoot, tradingstate.NewDatabase(db))
+
+	var (
+		borrower        = common.HexToAddress("0x0000000000000000000000000000000000000044")
+		investor        = common.HexToAddress("0x0000000000000000000000000000000000000022")
+		lendingToken    = common.HexToAddress(common.TomoNativeAddress)
+		collateralToken = common.HexToAddress("0x0000000000000000000000000000000000000033")
+		lockAddress     = common.HexToAddress(common.LendingLockAddress)
+		term            = uint64(30 * 86400)
+		now             = uint64(1000000)
+		amount          = new(big.Int).Mul(big.NewInt(1000), common.BasePrice)
+		locked          = new(big.Int).Mul(big.NewInt(1500), common.BasePrice)
+		price           = new(big.Int).Mul(big.NewInt(2), common.BasePrice)
+	)
+	statedb.SetNonce(collateralToken, 1)
+	lendingstate.AddTokenBalance(borrower, amount, lendingToken, statedb)
+	lendingstate.AddTokenBalance(lockAddress, locked, collateralToken, statedb)
+
+	lendingBook := lendingstate.GetLendingOrderBookHash(lendingToken, term)
+	trade := lendingstate.LendingTrade{
+		Borrower:               borrower,
+		Investor:               investor,
+		LendingToken:           lendingToken,
+		CollateralToken:        collateralToken,
+		Term:                   term,
+		Interest:               10 * common.BaseLendingInterest.Uint64(),
+		LiquidationPrice:       price,
+		CollateralLockedAmount: locked,
+		LiquidationTime:        now + term,
+		Amount:                 amount,
+		TradeId:                1,
+	}
+	lendingState.InsertTradingItem(lendingBook, trade.TradeId, trade)
+	orderbook := tradingstate.GetTradingOrderBookHash(collateralToken, lendingToken)
+	tradingState.InsertLiquidationPrice(orderbook, price, lendingBook, trade.TradeId)
+
+	l := &Lending{}
+	header := &types.Header{Number: big.NewInt(1), Time: new(big.Int).SetUint64(now)}
+	paymentBalance := lendingstate.CalculateTotalRepayValue(now, trade.LiquidationTime, term, trade.Interest, amount)
+	if _, err := l.ProcessPartialRepayLendingTrade(header, testChain{}, lendingState, statedb, tradingState, lendingBook, trade.TradeId, paymentBalance); err == nil {
+		t.Fatalf("repaying the total repay value partially should fail")
+	}
+	quantity := new(big.Int).Div(paymentBalance, big.NewInt(2))
+	newTrade, err := l.ProcessPartialRepayLendingTrade(header, testChain{}, lendingState, statedb, tradingState, lendingBook, trade.TradeId, quantity)
+	if err != nil {
+		t.Fatalf("failed to repay partially: %v", err)
+	}
+	half := func(x *big.Int) *big.Int { return new(big.Int).Div(x, big.NewInt(2)) }
+	if newTrade.Amount.Cmp(half(amount)) != 0 || newTrade.CollateralLockedAmount.Cmp(half(locked)) != 0 {
+		t.Errorf("trade mismatch: have amount %v locked %v, want %v %v", newTrade.Amount, newTrade.CollateralLockedAmount, half(amount), half(locked))
+	}
+	if newTrade.LiquidationPrice.Cmp(price) != 0 || newTrade.Status != lendingstate.TradeStatusOpen {
+		t.Errorf("trade mismatch: have liquidation price %v status %s, want %v %s", newTrade.LiquidationPrice, newTrade.Status, price, lendingstate.TradeStatusOpen)
+	}
+	stored := lendingState.GetLendingTrade(lendingBook, common.Uint64ToHash(trade.TradeId))
+	if stored.Amount.Cmp(newTrade.Amount) != 0 || stored.CollateralLockedAmount.Cmp(newTrade.CollateralLockedAmount) != 0 {
+		t.Errorf("stored trade mismatch: have amount %v locked %v", stored.Amount, stored.CollateralLockedAmount)
+	}
+	if balance := lendingstate.GetTokenBalance(investor, lendingToken, statedb); balance.Cmp(quantity) != 0 {
+		t.Errorf("investor balance mismatch: have %v, want %v", balance, quantity)
+	}
+	if balance := lendingstate.GetTokenBalance(borrower, collateralToken, statedb); balance.Cmp(half(locked)) != 0 {
+		t.Errorf("released collateral mismatch: have %v, want %v", balance, half(locked))
+	}
+	tradingState.IntermediateRoot()
+	if highest, data := tradingState.GetHighestLiquidationPriceData(orderbook, common.Big1); highest.Cmp(newTrade.LiquidationPrice) != 0 || len(data[lendingBook]) != 1 {
+		t.Errorf("liquidation price index mismatch: have %v (%d trades), want %v", highest, len(data[lendingBook]), newTrade.LiquidationPrice)
+	}
+}
+
+// setLendingRelayer registers the relayer with the given owner and lending fee
+// rate, with a deposit covering the relayer fees of a few trades.
+func setLendingRelayer(statedb *state.StateDB, relayer, owner common.Address, fee int64) {
+	registration := common.HexToAddress(common.RelayerRegistrationSMC)
+	loc := lendingstate.GetLocMappingAtKey(relayer.Hash(), lendingstate.RelayerMappingSlot["RELAYER_LIST"])
+	deposit := new(big.Int).Mul(new(big.Int).Add(common.RelayerLockedFund, common.Big1), common.BasePrice)
+	statedb.SetState(registration, common.BigToHash(new(big.Int).Add(loc, lendingstate.RelayerStructMappingSlot["_owner"])), owner.Hash())
+	statedb.SetState(registration, common.BigToHash(new(big.Int).Add(loc, lendingstate.RelayerStructMappingSlot["_deposit"])), common.BigToHash(deposit))
+	statedb.AddBalance(registration, deposit)
+
+	lendingLoc := state.GetLocMappingAtKey(relayer.Hash(), lendingstate.LendingRelayerListSlot)
+	lendingLoc = lendingLoc.Add(lendingLoc, lendingstate.LendingRelayerStructSlots["fee"])
+	statedb.SetState(common.HexToAddress(common.LendingRegistrationSMC), common.BigToHash(lendingLoc), common.BigToHash(big.NewInt(fee)))
+}
+
+func TestProcessRollover(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	lendingState, _ := lendingstate.New(lendingstate.EmptyRoot, lendingstate.NewDatabase(db))
+	tradingState, _ := tradingstate.New(tradingstate.EmptyRoot, tradingstate.NewDatabase(db))
+
+	var (
+		borrower          = common.HexToAddress("0x0000000000000000000000000000000000000044")
+		investor          = common.HexToAddress("0x0000000000000000000000000000000000000022")
+		newInvestor       = common.HexToAddress("0x0000000000000000000000000000000000000023")
+		borrowingRelayer  = common.HexToAddress("0x0000000000000000000000000000000000000055")
+		investingRelayer  = common.HexToAddress("0x0000000000000000000000000000000000000056")
+		borrowingOwner    = common.HexToAddress("0x0000000000000000000000000000000000000065")
+		investingOwner    = common.HexToAddress("0x0000000000000000000000000000000000000066")
+		lendingToken      = common.HexToAddress(common.TomoNativeAddress)
+		collateralToken   = common.HexToAddress("0x0000000000000000000000000000000000000033")
+		lockAddress       = common.HexToAddress(common.LendingLockAddress)
+		term              = uint64(30 * 86400)
+		now               = uint64(1000000)
+		amount            = new(big.Int).Mul(big.NewInt(1000), common.BasePrice)
+		locked            = new(big.Int).Mul(big.NewInt(1500), common.BasePrice)
+		liquidationPrice  = common.BasePrice
+		borrowerBalance   = new(big.Int).Mul(big.NewInt(100), common.BasePrice)
+		newInvestorAmount = new(big.Int).Mul(big.NewInt(2000), common.BasePrice)
+		lendingBook       = lendingstate.GetLendingOrderBookHash(lendingToken, term)
+	)
+	config := *params.TestChainConfig
+	config.Posv = &params.PosvConfig{Epoch: 900}
+	chain := posvTestChain{&config}
+	header := &types.Header{Number: new(big.Int).Set(common.TIPTomoXRollover), Time: new(big.Int).SetUint64(now)}
+
+	setLendingRelayer(statedb, borrowingRelayer, borrowingOwner, 100)
+	setLendingRelayer(statedb, investingRelayer, investingOwner, 100)
+	setCollateralPrice(statedb, collateralToken, lendingToken, new(big.Int).Mul(big.NewInt(2), common.BasePrice), header.Number.Uint64())
+	statedb.SetNonce(collateralToken, 1)
+	lendingstate.AddTokenBalance(borrower, borrowerBalance, lendingToken, statedb)
+	lendingstate.AddTokenBalance(newInvestor, newInvestorAmount, lendingToken, statedb)
+	lendingstate.AddTokenBalance(lockAddress, locked, collateralToken, statedb)
+
+	trade := lendingstate.LendingTrade{
+		Borrower:               borrower,
+		Investor:               investor,
+		BorrowingRelayer:       borrowingRelayer,
+		InvestingRelayer:       investingRelayer,
+		LendingToken:           lendingToken,
+		CollateralToken:        collateralToken,
+		Term:                   term,
+		Interest:               10 * common.BaseLendingInterest.Uint64(),
+		LiquidationPrice:       liquidationPrice,
+		CollateralLockedAmount: locked,
+		LiquidationTime:        now + term/2,
+		Amount:                 amount,
+		TradeId:                1,
+	}
+	lendingState.InsertTradingItem(lendingBook, trade.TradeId, trade)
+	lendingState.InsertLiquidationTime(lendingBook, new(big.Int).SetUint64(trade.LiquidationTime), trade.TradeId)
+	lendingState.SetTradeNonce(lendingBook, trade.TradeId)
+	orderbook := tradingstate.GetTradingOrderBookHash(collateralToken, lendingToken)
+	tradingState.InsertLiquidationPrice(orderbook, liquidationPrice, lendingBook, trade.TradeId)
+
+	investing := lendingstate.LendingItem{
+		Quantity:     newInvestorAmount,
+		Interest:     new(big.Int).Mul(big.NewInt(8), common.BaseLendingInterest),
+		Side:         lendingstate.Investing,
+		Type:         lendingstate.Limit,
+		LendingToken: lendingToken,
+		Term:         term,
+		UserAddress:  newInvestor,
+		Relayer:      investingRelayer,
+		LendingId:    1,
+		Hash:         common.HexToHash("0x02"),
+	}
+	lendingState.InsertLendingItem(lendingBook, common.Uint64ToHash(investing.LendingId), investing)
+
+	tx := tomox.New(&tomox.DefaultConfig)
+	tx.SetTokenDecimal(lendingToken, common.BasePrice)
+	tx.SetTokenDecimal(collateralToken, common.BasePrice)
+	l := &Lending{tomox: tx}
+
+	order := &lendingstate.LendingItem{
+		Type:           lendingstate.Rollover,
+		UserAddress:    borrower,
+		Relayer:        borrowingRelayer,
+		LendingToken:   lendingToken,
+		Term:           term,
+		LendingTradeId: trade.TradeId,
+		Hash:           common.HexToHash("0x03"),
+	}
+	trades, err := l.ProcessRollover(header, common.Address{}, chain, lendingState, statedb, tradingState, lendingBook, order)
+	if err != nil {
+		t.Fatalf("failed to roll over: %v", err)
+	}
+	if len(trades) != 2 || trades[0].Status != lendingstate.TradeStatusClosed {
+		t.Fatalf("trades mismatch: have %v", trades)
+	}
+	rolled := trades[1]
+	// the borrowing fee is charged like on a matched borrowing order
+	borrowFee := new(big.Int).Div(amount, big.NewInt(100))
+	if rolled.BorrowingFee.Cmp(borrowFee) != 0 || rolled.InvestingFee == nil || rolled.InvestingFee.Sign() != 0 {
+		t.Errorf("fees mismatch: have borrowing %v investing %v, want %v 0", rolled.BorrowingFee, rolled.InvestingFee, borrowFee)
+	}
+	if rolled.Investor != newInvestor || rolled.InvestingRelayer != investingRelayer || rolled.Amount.Cmp(amount) != 0 || rolled.CollateralLockedAmount.Cmp(locked) != 0 {
+		t.Errorf("rolled trade mismatch: have investor %s relayer %s amount %v locked %v", rolled.Investor.Hex(), rolled.InvestingRelayer.Hex(), rolled.Amount, rolled.CollateralLockedAmount)
+	}
+	paymentBalance := lendingstate.CalculateTotalRepayValue(now, trade.LiquidationTime, term, trade.Interest, amount)
+	wantBorrower := new(big.Int).Sub(new(big.Int).Add(borrowerBalance, new(big.Int).Sub(amount, borrowFee)), paymentBalance)
+	for _, tt := range []struct {
+		name    string
+		address common.Address
+		token   common.Address
+		want    *big.Int
+	}{
+		{"investor", investor, lendingToken, paymentBalance},
+		{"new investor", newInvestor, lendingToken, new(big.Int).Sub(newInvestorAmount, amount)},
+		{"borrower", borrower, lendingToken, wantBorrower},
+		{"borrowing relayer", borrowingOwner, lendingToken, borrowFee},
+		{"investing relayer", investingOwner, lendingToken, new(big.Int)},
+		{"lock", lockAddress, collateralToken, locked},
+	} {
+		if balance := lendingstate.GetTokenBalance(tt.address, tt.token, statedb); balance.Cmp(tt.want) != 0 {
+			t.Errorf("%s balance mismatch: have %v, want %v", tt.name, balance, tt.want)
+		}
+	}
+	// the relayer fee of the match is taken from the deposit of the borrowing relayer
+	deposit := func(relayer common.Address) *big.Int {
+		loc := lendingstate.GetLocMappingAtKey(relayer.Hash(), lendingstate.RelayerMappingSlot["RELAYER_LIST"])
+		loc = loc.Add(loc, lendingstate.RelayerStructMappingSlot["_deposit"])
+		return statedb.GetState(common.HexToAddress(common.RelayerRegistrationSMC), common.BigToHash(loc)).Big()
+	}
+	full := new(big.Int).Mul(new(big.Int).Add(common.RelayerLockedFund, common.Big1), common.BasePrice)
+	if have, want := deposit(borrowingRelayer), new(big.Int).Sub(full, common.RelayerLendingFee); have.Cmp(want) != 0 {
+		t.Errorf("borrowing relayer deposit mismatch: have %v, want %v", have, want)
+	}
+	if have := deposit(investingRelayer); have.Cmp(full) != 0 {
+		t.Errorf("investing relayer deposit mismatch: have %v, want %v", have, full)
+	}
+}
+
+func TestGetPartialLiquidationAmounts(t *testing.T) {
+	var (
+		amount           = new(big.Int).Mul(big.NewInt(1000), common.BasePrice)
+		locked           = new(big.Int).Mul(big.NewInt(500), common.BasePrice)
+		liquidationPrice = big.NewInt(2200000000000000000) // 2.2
+		depositRate      = big.NewInt(150)
+		liquidationRate  = big.NewInt(110)
+		bonus            = big.NewInt(5)
+	)
+	// the collateral is worth 1095 at 2.19, 900 of the trade must be covered to restore 150%
+	repaid, liquidated, bonusAmount := getPartialLiquidationAmounts(amount, locked, liquidationPrice, big.NewInt(2190000000000000000), depositRate, liquidationRate, bonus)
+	if want := new(big.Int).Mul(big.NewInt(900), common.BasePrice); repaid == nil || repaid.Cmp(want) != 0 {
+		t.Fatalf("repaid amount mismatch: have %v, want %v", repaid, want)
+	}
+	if want, _ := new(big.Int).SetString("431506849315068493150", 10); liquidated.Cmp(want) != 0 {
+		t.Errorf("liquidation amount mismatch: have %v, want %v", liquidated, want)
+	}
+	if want, _ := new(big.Int).SetString("20547945205479452054", 10); bonusAmount.Cmp(want) != 0 {
+		t.Errorf("bonus amount mismatch: have %v, want %v", bonusAmount, want)
+	}
+	// at 2.1 the whole trade is needed, it can't be liquidated partially
+	if repaid, _, _ := getPartialLiquidationAmounts(amount, locked, liquidationPrice, big.NewInt(2100000000000000000), depositRate, liquidationRate, bonus); repaid != nil {
+		t.Errorf("expected full liquidation, have repaid amount %v", repaid)
+	}
+	// a bonus eating the whole deposit margin disables partial liquidation
+	if repaid, _, _ := getPartialLiquidationAmounts(amount, locked, liquidationPrice, big.NewInt(2190000000000000000), depositRate, liquidationRate, big.NewInt(50)); repaid != nil {
+		t.Errorf("expected full liquidation, have repaid amount %v", repaid)
+	}
+}
+
+type testChain struct{}
+
+func (testChain) Engine() consensus.Engine                    { return nil }
+func (testChain) GetHeader(common.Hash, uint64) *types.Header { return nil }
+func (testChain) CurrentHeader() *types.Header                { return nil }
+func (testChain) Config() *params.ChainConfig                 { return params.TestChainConfig }
+
+type posvTestChain struct{ config *params.ChainConfig }
+
+func (posvTestChain) Engine() consensus.Engine                    { return nil }
+func (posvTestChain) GetHeader(common.Hash, uint64) *types.Header { return nil }
+func (posvTestChain) CurrentHeader() *types.Header                { return nil }
+func (c posvTestChain) Config() *params.ChainConfig               { return c.config }
+
+// setCollateralPrice stores the collateral/lending token price of the lending
+// contract as set at the given block.
+func setCollateralPrice(statedb *state.StateDB, collateralToken, lendingToken common.Address, price *big.Int, block uint64) {
+	contract := common.HexToAddress(common.LendingRegistrationSMC)
+	collateral := lendingstate.GetLocMappingAtKey(collateralToken.Hash(), lendingstate.CollateralMapSlot)
+	prices := collateral.Add(collateral, lendingstate.CollateralStructSlots["price"])
+	loc := new(big.Int).SetBytes(crypto.Keccak256(lendingToken.Hash().Bytes(), common.BigToHash(prices).Bytes()))
+	statedb.SetState(contract, common.BigToHash(new(big.Int).Add(loc, lendingstate.PriceStructSlots["price"])), common.BigToHash(price))
+	statedb.SetState(contract, common.BigToHash(new(big.Int).Add(loc, lendingstate.PriceStructSlots["blockNumber"])), common.BigToHash(new(big.Int).SetUint64(block)))
+}
+
+func TestProcessRepayOrderType(t *testing.T) {
+	var (
+		borrower        = common.HexToAddress("0x0000000000000000000000000000000000000044")
+		investor        = common.HexToAddress("0x0000000000000000000000000000000000000022")
+		relayer         = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		lendingToken    = common.HexToAddress(common.TomoNativeAddress)
+		collateralToken = common.HexToAddress("0x0000000000000000000000000000000000000033")
+		lockAddress     = common.HexToAddress(common.LendingLockAddress)
+		term            = uint64(30 * 86400)
+		now             = uint64(1000000)
+		amount          = new(big.Int).Mul(big.NewInt(1000), common.BasePrice)
+		locked          = new(big.Int).Mul(big.NewInt(1500), common.BasePrice)
+		price           = new(big.Int).Mul(big.NewInt(2), common.BasePrice)
+		lendingBook     = lendingstate.GetLendingOrderBookHash(lendingToken, term)
+		fork            = common.TIPTomoXPartialRepay
+		l               = &Lending{}
+	)
+	repay := func(number *big.Int, orderType string, quantity *big.Int) (*lendingstate.LendingTrade, *big.Int, error) {
+		db := rawdb.NewMemoryDatabase()
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+		lendingState, _ := lendingstate.New(lendingstate.EmptyRoot, lendingstate.NewDatabase(db))
+		tradingState, _ := tradingstate.New(tradingstate.EmptyRoot, tradingstate.NewDatabase(db))
+
+		statedb.SetNonce(collateralToken, 1)
+		lendingstate.AddTokenBalance(borrower, new(big.Int).Mul(amount, big.NewInt(2)), lendingToken, statedb)
+		lendingstate.AddTokenBalance(lockAddress, locked, collateralToken, statedb)
+		trade := lendingstate.LendingTrade{
+			Borrower:               borrower,
+			Investor:               investor,
+			BorrowingRelayer:       relayer,
+			LendingToken:           lendingToken,
+			CollateralToken:        collateralToken,
+			Term:                   term,
+			Interest:               10 * common.BaseLendingInterest.Uint64(),
+			LiquidationPrice:       price,
+			CollateralLockedAmount: locked,
+			LiquidationTime:        now + term,
+			Amount:                 amount,
+			TradeId:                1,
+		}
+		lendingState.InsertTradingItem(lendingBook, trade.TradeId, trade)
+		lendingState.InsertLiquidationTime(lendingBook, new(big.Int).SetUint64(trade.LiquidationTime), trade.TradeId)
+		tradingState.InsertLiquidationPrice(tradingstate.GetTradingOrderBookHash(collateralToken, lendingToken), price, lendingBook, trade.TradeId)
+
+		header := &types.Header{Number: number, Time: new(big.Int).SetUint64(now)}
+		order := &lendingstate.LendingItem{
+			Relayer:        relayer,
+			UserAddress:    borrower,
+			LendingToken:   lendingToken,
+			Term:           term,
+			Quantity:       quantity,
+			Type:           orderType,
+			LendingTradeId: trade.TradeId,
+		}
+		newTrade, err := l.ProcessRepay(header, testChain{}, lendingState, statedb, tradingState, lendingBook, order)
+		return newTrade, lendingstate.GetTokenBalance(investor, lendingToken, statedb), err
+	}
+	quantity := new(big.Int).Div(amount, big.NewInt(2))
+
+	// a repay order with a quantity below the repay value still closes the trade
+	trade, repaid, err := repay(fork, lendingstate.Repay, quantity)
+	if err != nil {
+		t.Fatalf("failed to repay: %v", err)
+	}
+	if trade.Status != lendingstate.TradeStatusClosed {
+		t.Errorf("repay order status mismatch: have %s, want %s", trade.Status, lendingstate.TradeStatusClosed)
+	}
+	if repaid.Cmp(amount) <= 0 {
+		t.Errorf("repay order paid back %v, want more than the amount %v", repaid, amount)
+	}
+	// a partial repay order only pays back its quantity
+	trade, repaid, err = repay(fork, lendingstate.PartialRepay, quantity)
+	if err != nil {
+		t.Fatalf("failed to repay partially: %v", err)
+	}
+	if trade.Status != lendingstate.TradeStatusOpen || trade.Amount.Cmp(amount) >= 0 {
+		t.Errorf("partial repay order mismatch: have status %s amount %v, want %s below %v", trade.Status, trade.Amount, lendingstate.TradeStatusOpen, amount)
+	}
+	if repaid.Cmp(quantity) != 0 {
+		t.Errorf("partial repay order paid back %v, want %v", repaid, quantity)
+	}
+	// partial repay orders are rejected before the fork
+	if _, _, err := repay(new(big.Int).Sub(fork, common.Big1), lendingstate.PartialRepay, quantity); err == nil {
+		t.Errorf("partial repay order before the fork should fail")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tomoxlending
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+// ProcessPolicy registers the borrower policy carried in the extra data of the
+// order, for the trade LendingTradeId or, if it's zero, for all the trades of
+// the borrower backed by the collateral token of the order. The automatic top-ups
+// already accounted in the current epoch are kept.
+func (l *Lending) ProcessPolicy(header *types.Header, chain consensus.ChainContext, lendingStateDB *lendingstate.LendingStateDB, statedb *state.StateDB, lendingBook common.Hash, order *lendingstate.LendingItem) error {
+	if !chain.Config().IsTIPTomoXBorrowerPolicy(header.Number) {
+		return fmt.Errorf("ProcessPolicy: borrower policy is not supported yet")
+	}
+	policy, err := lendingstate.DecodeBorrowerPolicy(order.ExtraData)
+	if err != nil {
+		return err
+	}
+	var (
+		policyHash      common.Hash
+		liquidationRate *big.Int
+	)
+	if order.LendingTradeId != 0 {
+		lendingTrade := lendingStateDB.GetLendingTrade(lendingBook, common.Uint64ToHash(order.LendingTradeId))
+		if lendingTrade == lendingstate.EmptyLendingTrade || lendingTrade.TradeId != order.LendingTradeId {
+			return fmt.Errorf("ProcessPolicy for emptyLendingTrade is not allowed. lendingTradeId: %v", order.LendingTradeId)
+		}
+		if order.UserAddress != lendingTrade.Borrower {
+			return fmt.Errorf("ProcessPolicy: invalid userAddress . UserAddress: %s . Borrower: %s", order.UserAddress.Hex(), lendingTrade.Borrower.Hex())
+		}
+		if order.Relayer != lendingTrade.BorrowingRelayer {
+			return fmt.Errorf("ProcessPolicy: invalid relayerAddress . Got: %s . Expect: %s", order.Relayer.Hex(), lendingTrade.BorrowingRelayer.Hex())
+		}
+		policyHash = lendingstate.GetTradePolicyHash(lendingBook, order.LendingTradeId)
+		liquidationRate = lendingTrade.LiquidationRate
+	} else {
+		if err := order.VerifyCollateral(statedb); err != nil {
+			return err
+		}
+		policyHash = lendingstate.GetAccountPolicyHash(order.UserAddress, order.CollateralToken)
+		_, liquidationRate, _ = lendingstate.GetCollateralDetail(statedb, order.CollateralToken)
+	}
+	if policy != nil {
+		// the target must keep the trades above their liquidation price
+		if policy.TargetCollateralRatio != 0 && liquidationRate != nil && new(big.Int).SetUint64(policy.TargetCollateralRatio).Cmp(liquidationRate) <= 0 {
+			return fmt.Errorf("ProcessPolicy: target collateral ratio must be higher than the liquidation rate. target: %v , liquidationRate: %v", policy.TargetCollateralRatio, liquidationRate)
+		}
+		if prev := lendingStateDB.GetBorrowerPolicy(policyHash); prev != nil {
+			policy.Epoch, policy.EpochTopUp = prev.Epoch, prev.EpochTopUp
+		}
+	}
+	lendingStateDB.SetBorrowerPolicy(policyHash, policy)
+	log.Debug("ProcessPolicy", "borrower", order.UserAddress.Hex(), "lendingTradeId", order.LendingTradeId, "policyHash", policyHash.Hex(), "policy", lendingstate.ToJSON(policy))
+	return nil
+}
+
+// tradePolicy returns the borrower policy applied to a lending trade by the
+// liquidation processing at header and the key it is stored at, nil if there
+// is none.
+func tradePolicy(header *types.Header, chain consensus.ChainContext, lendingState *lendingstate.LendingStateDB, lendingBook common.Hash, trade *lendingstate.LendingTrade) (common.Hash, *lendingstate.BorrowerPolicy) {
+	if !chain.Config().IsTIPTomoXBorrowerPolicy(header.Number) {
+		return common.Hash{}, nil
+	}
+	return lendingState.GetTradeBorrowerPolicy(lendingBook, trade)
+}
+
+// policyEpoch returns the epoch of header, the period of the top-up limit of
+// the borrower policies.
+func policyEpoch(header *types.Header, chain consensus.ChainContext) uint64 {
+	if chain.Config().Posv == nil || chain.Config().Posv.Epoch == 0 {
+		return 0
+	}
+	return header.Number.Uint64() / chain.Config().Posv.Epoch
+}
+
+// processExpiredTrade repays a lending trade whose term has ended from the
+// lending token balance of the borrower, or liquidates it if the balance isn't
+// enough or the policy of the trade opts out of automatic repayments.
+func (l *Lending) processExpiredTrade(header *types.Header, chain consensus.ChainContext, lendingState *lendingstate.LendingStateDB, statedb *state.StateDB, tradingState *tradingstate.TradingStateDB, lendingBook common.Hash, lendingTradeId uint64) (*lendingstate.LendingTrade, error) {
+	lendingTrade := lendingState.GetLendingTrade(lendingBook, common.Uint64ToHash(lendingTradeId))
+	if _, policy := tradePolicy(header, chain, lendingState, lendingBook, &lendingTrade); policy == nil || !policy.NoAutoRepay {
+		return l.ProcessRepayLendingTrade(header, chain, lendingState, statedb, tradingState, lendingBook, lendingTradeId)
+	}
+	newLendingTrade, err := l.LiquidationExpiredTrade(header, chain, lendingState, statedb, tradingState, lendingBook, lendingTradeId)
+	if err != nil {
+		return nil, err
+	}
+	if newLendingTrade != nil {
+		newLendingTrade.Status = lendingstate.TradeStatusLiquidated
+	}
+	return newLendingTrade, nil
+}
+
+// sortedLendingBooks returns the lending books of the liquidation data in
+// ascending order.
+func sortedLendingBooks(liquidationData map[common.Hash][]common.Hash) []common.Hash {
+	lendingBooks := make([]common.Hash, 0, len(liquidationData))
+	for lendingBook := range liquidationData {
+		lendingBooks = append(lendingBooks, lendingBook)
+	}
+	sort.Slice(lendingBooks, func(i, j int) bool {
+		return bytes.Compare(lendingBooks[i].Bytes(), lendingBooks[j].Bytes()) < 0
+	})
+	return lendingBooks
+}
@@ -0,0 +1,96 @@
+package tomoxlending
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/params"
+	"github.com/tomochain/tomochain/tomox"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+func TestProcessExpiredTradePolicy(t *testing.T) {
+	var (
+		borrower        = common.HexToAddress("0x0000000000000000000000000000000000000044")
+		investor        = common.HexToAddress("0x0000000000000000000000000000000000000022")
+		lendingToken    = common.HexToAddress(common.TomoNativeAddress)
+		collateralToken = common.HexToAddress("0x0000000000000000000000000000000000000033")
+		lockAddress     = common.HexToAddress(common.LendingLockAddress)
+		term            = uint64(30 * 86400)
+		expiry          = uint64(1000000)
+		amount          = new(big.Int).Mul(big.NewInt(1000), common.BasePrice)
+		locked          = new(big.Int).Mul(big.NewInt(1500), common.BasePrice)
+		lendingBook     = lendingstate.GetLendingOrderBookHash(lendingToken, term)
+	)
+	config := *params.TestChainConfig
+	config.Posv = &params.PosvConfig{Epoch: 900}
+	chain := posvTestChain{&config}
+	header := &types.Header{Number: new(big.Int).Set(common.TIPTomoXBorrowerPolicy), Time: new(big.Int).SetUint64(expiry)}
+
+	tests := []struct {
+		policy string
+		repaid bool
+	}{
+		{"", true},
+		// a policy without the field keeps repaying expired trades
+		{`{"autoRecall":true}`, true},
+		{`{"noAutoRepay":true}`, false},
+	}
+	for i, tt := range tests {
+		db := rawdb.NewMemoryDatabase()
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+		lendingState, _ := lendingstate.New(lendingstate.EmptyRoot, lendingstate.NewDatabase(db))
+		tradingState, _ := tradingstate.New(tradingstate.EmptyRoot, tradingstate.NewDatabase(db))
+
+		collateral := lendingstate.GetLocMappingAtKey(collateralToken.Hash(), lendingstate.CollateralMapSlot)
+		loc := state.GetLocOfStructElement(collateral, lendingstate.CollateralStructSlots["liquidationRate"])
+		statedb.SetState(common.HexToAddress(common.LendingRegistrationSMC), loc, common.BigToHash(big.NewInt(110)))
+		setCollateralPrice(statedb, collateralToken, lendingToken, new(big.Int).Mul(big.NewInt(2), common.BasePrice), header.Number.Uint64())
+		statedb.SetNonce(collateralToken, 1)
+		lendingstate.AddTokenBalance(borrower, new(big.Int).Mul(amount, big.NewInt(2)), lendingToken, statedb)
+		lendingstate.AddTokenBalance(lockAddress, locked, collateralToken, statedb)
+
+		trade := lendingstate.LendingTrade{
+			Borrower:               borrower,
+			Investor:               investor,
+			LendingToken:           lendingToken,
+			CollateralToken:        collateralToken,
+			Term:                   term,
+			Interest:               10 * common.BaseLendingInterest.Uint64(),
+			LiquidationPrice:       common.BasePrice,
+			CollateralLockedAmount: locked,
+			LiquidationTime:        expiry,
+			Amount:                 amount,
+			TradeId:                1,
+		}
+		lendingState.InsertTradingItem(lendingBook, trade.TradeId, trade)
+		lendingState.InsertLiquidationTime(lendingBook, new(big.Int).SetUint64(trade.LiquidationTime), trade.TradeId)
+		tradingState.InsertLiquidationPrice(tradingstate.GetTradingOrderBookHash(collateralToken, lendingToken), trade.LiquidationPrice, lendingBook, trade.TradeId)
+		if tt.policy != "" {
+			policy, err := lendingstate.DecodeBorrowerPolicy(tt.policy)
+			if err != nil {
+				t.Fatalf("test %d: invalid policy: %v", i, err)
+			}
+			lendingState.SetBorrowerPolicy(lendingstate.GetAccountPolicyHash(borrower, collateralToken), policy)
+		}
+
+		tx := tomox.New(&tomox.DefaultConfig)
+		tx.SetTokenDecimal(lendingToken, common.BasePrice)
+		tx.SetTokenDecimal(collateralToken, common.BasePrice)
+		l := &Lending{tomox: tx}
+
+		if _, err := l.processExpiredTrade(header, chain, lendingState, statedb, tradingState, lendingBook, trade.TradeId); err != nil {
+			t.Fatalf("test %d: failed to process expired trade: %v", i, err)
+		}
+		repaid := lendingstate.GetTokenBalance(investor, lendingToken, statedb).Sign() > 0
+		liquidated := lendingstate.GetTokenBalance(investor, collateralToken, statedb).Sign() > 0
+		if repaid != tt.repaid || liquidated == tt.repaid {
+			t.Errorf("test %d: outcome mismatch: have repaid %v liquidated %v, want repaid %v", i, repaid, liquidated, tt.repaid)
+		}
+	}
+}
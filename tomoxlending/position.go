@@ -0,0 +1,167 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tomoxlending
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/consensus"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+// PositionHealth describes how close a lending trade is to being liquidated.
+type PositionHealth struct {
+	TradeId                uint64         `json:"tradeId"`
+	Hash                   common.Hash    `json:"hash"`
+	Borrower               common.Address `json:"borrower"`
+	LendingToken           common.Address `json:"lendingToken"`
+	CollateralToken        common.Address `json:"collateralToken"`
+	Term                   uint64         `json:"term"`
+	Amount                 *big.Int       `json:"amount"`
+	CollateralLockedAmount *big.Int       `json:"collateralLockedAmount"`
+	CollateralPrice        *big.Int       `json:"collateralPrice"`  // Current price of the collateral in lending token
+	LiquidationPrice       *big.Int       `json:"liquidationPrice"` // Collateral price below which the trade is liquidated
+	TotalRepayValue        *big.Int       `json:"totalRepayValue"`  // Amount plus interest if the trade was repaid now
+	AccruedInterest        *big.Int       `json:"accruedInterest"`
+	LTV                    float64        `json:"ltv"` // Total repay value over collateral value, in percent
+	LiquidationTime        uint64         `json:"liquidationTime"`
+	TimeToLiquidation      uint64         `json:"timeToLiquidation"` // Seconds left until the trade expires
+	AutoTopUp              bool           `json:"autoTopUp"`
+	AutoTopUpTriggered     bool           `json:"autoTopUpTriggered"` // Whether the trade would be topped up at the current price
+
+	Collaterals []lendingstate.BasketCollateral `json:"collaterals,omitempty"` // All the collaterals of a basket position, by liquidation priority
+	BasketValue *big.Int                        `json:"basketValue,omitempty"` // Value of the basket in lending token
+}
+
+// LiquidationSimulation lists the trades of a lending book the liquidation
+// process of a block would change.
+type LiquidationSimulation struct {
+	Liquidated []*lendingstate.LendingTrade `json:"liquidated"`
+	AutoRepaid []*lendingstate.LendingTrade `json:"autoRepaid"`
+	ToppedUp   []*lendingstate.LendingTrade `json:"toppedUp"`
+	Recalled   []*lendingstate.LendingTrade `json:"recalled"`
+}
+
+// GetPositionHealth computes the health of a lending trade at the given header.
+// None of the states are modified, the auto top-up is dry-run on copies.
+func (l *Lending) GetPositionHealth(header *types.Header, chain consensus.ChainContext, statedb *state.StateDB, tradingState *tradingstate.TradingStateDB, lendingState *lendingstate.LendingStateDB, trade *lendingstate.LendingTrade) (*PositionHealth, error) {
+	_, collateralPrice, err := l.GetCollateralPrices(header, chain, statedb, tradingState, trade.CollateralToken, trade.LendingToken)
+	if err != nil {
+		return nil, err
+	}
+	if collateralPrice == nil || collateralPrice.Sign() == 0 {
+		return nil, errors.New("collateral price not found")
+	}
+	collateralDecimal, err := l.tomox.GetTokenDecimal(chain, statedb, trade.CollateralToken)
+	if err != nil {
+		return nil, err
+	}
+	now := header.Time.Uint64()
+	finalizeTime := now
+	if finalizeTime > trade.LiquidationTime {
+		finalizeTime = trade.LiquidationTime
+	}
+	policy := l.repayPolicy(header, chain, statedb, trade)
+	totalRepayValue := lendingstate.CalculateRepayValue(policy, finalizeTime, trade.LiquidationTime, trade.Term, trade.Interest, trade.Amount)
+
+	health := &PositionHealth{
+		TradeId:                trade.TradeId,
+		Hash:                   trade.Hash,
+		Borrower:               trade.Borrower,
+		LendingToken:           trade.LendingToken,
+		CollateralToken:        trade.CollateralToken,
+		Term:                   trade.Term,
+		Amount:                 trade.Amount,
+		CollateralLockedAmount: trade.CollateralLockedAmount,
+		CollateralPrice:        collateralPrice,
+		LiquidationPrice:       trade.LiquidationPrice,
+		TotalRepayValue:        totalRepayValue,
+		AccruedInterest:        new(big.Int).Sub(totalRepayValue, trade.Amount),
+		LiquidationTime:        trade.LiquidationTime,
+		AutoTopUp:              trade.AutoTopUp,
+	}
+	if trade.LiquidationTime > now {
+		health.TimeToLiquidation = trade.LiquidationTime - now
+	}
+	// LTV = totalRepayValue / (CollateralLockedAmount * collateralPrice / collateralDecimal) * 100
+	if trade.CollateralLockedAmount.Sign() > 0 {
+		debt := new(big.Int).Mul(totalRepayValue, collateralDecimal)
+		debt = new(big.Int).Mul(debt, big.NewInt(100))
+		value := new(big.Int).Mul(trade.CollateralLockedAmount, collateralPrice)
+		health.LTV, _ = new(big.Float).Quo(new(big.Float).SetInt(debt), new(big.Float).SetInt(value)).Float64()
+	}
+	lendingBook := lendingstate.GetLendingOrderBookHash(trade.LendingToken, trade.Term)
+	if lendingstate.IsBasketPosition(statedb, lendingBook, trade.TradeId) {
+		// a basket position is only liquidated on the value of all its collaterals
+		basket, err := l.GetBasketValue(header, chain, statedb, tradingState, lendingBook, trade, nil)
+		if err != nil {
+			return nil, err
+		}
+		health.Collaterals = basket.Collaterals
+		health.BasketValue = basket.Value
+		health.LTV = 0
+		if basket.Value.Sign() > 0 {
+			debt := new(big.Int).Mul(totalRepayValue, big.NewInt(100))
+			health.LTV, _ = new(big.Float).Quo(new(big.Float).SetInt(debt), new(big.Float).SetInt(basket.Value)).Float64()
+		}
+		return health, nil
+	}
+	if trade.AutoTopUp && collateralPrice.Cmp(trade.LiquidationPrice) < 0 {
+		tradeIdHash := common.BigToHash(new(big.Int).SetUint64(trade.TradeId))
+		_, policy := tradePolicy(header, chain, lendingState, lendingBook, trade)
+		_, err := l.AutoTopUp(statedb.Copy(), tradingState.Copy(), lendingState.Copy(), lendingBook, tradeIdHash, collateralPrice, chain.Config().IsTIPTomoXPartialRepay(header.Number), policy, policyEpoch(header, chain))
+		health.AutoTopUpTriggered = err == nil
+	}
+	return health, nil
+}
+
+// SimulateLiquidations dry-runs the liquidation process at the given header on
+// copies of the states and returns the changed trades of the lending book of
+// lendingToken and term. The collateral prices in lendingToken of the
+// collateral tokens in priceOverrides replace the current ones.
+func (l *Lending) SimulateLiquidations(header *types.Header, chain consensus.ChainContext, statedb *state.StateDB, tradingState *tradingstate.TradingStateDB, lendingState *lendingstate.LendingStateDB, lendingToken common.Address, term uint64, priceOverrides map[common.Address]*big.Int) (*LiquidationSimulation, error) {
+	overrides := make(map[common.Hash]*big.Int, len(priceOverrides))
+	for collateralToken, price := range priceOverrides {
+		if price == nil || price.Sign() <= 0 {
+			return nil, errors.New("invalid collateral price")
+		}
+		overrides[tradingstate.GetTradingOrderBookHash(collateralToken, lendingToken)] = price
+	}
+	_, liquidated, autoRepaid, toppedUp, recalled, err := l.processLiquidationData(header, chain, statedb.Copy(), tradingState.Copy(), lendingState.Copy(), overrides)
+	if err != nil {
+		return nil, err
+	}
+	inBook := func(trades []*lendingstate.LendingTrade) []*lendingstate.LendingTrade {
+		filtered := []*lendingstate.LendingTrade{}
+		for _, trade := range trades {
+			if trade.LendingToken == lendingToken && trade.Term == term {
+				filtered = append(filtered, trade)
+			}
+		}
+		return filtered
+	}
+	return &LiquidationSimulation{
+		Liquidated: inBook(liquidated),
+		AutoRepaid: inBook(autoRepaid),
+		ToppedUp:   inBook(toppedUp),
+		Recalled:   inBook(recalled),
+	}, nil
+}
@@ -0,0 +1,100 @@
+package tomoxlending
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/core/rawdb"
+	"github.com/tomochain/tomochain/core/state"
+	"github.com/tomochain/tomochain/core/types"
+	"github.com/tomochain/tomochain/tomox/tradingstate"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+// setLendingList appends the value to the array at the slot of the lending contract.
+func setLendingList(statedb *state.StateDB, slot uint64, value common.Hash) {
+	contract := common.HexToAddress(common.LendingRegistrationSMC)
+	loc := state.GetLocSimpleVariable(slot)
+	length := statedb.GetState(contract, loc).Big().Uint64()
+	statedb.SetState(contract, state.GetLocDynamicArrAtElement(loc, length, 1), value)
+	statedb.SetState(contract, loc, common.BigToHash(new(big.Int).SetUint64(length+1)))
+}
+
+func TestSimulateLiquidations(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	lendingState, _ := lendingstate.New(lendingstate.EmptyRoot, lendingstate.NewDatabase(db))
+	tradingState, _ := tradingstate.New(tradingstate.EmptyRoot, tradingstate.NewDatabase(db))
+
+	var (
+		borrower        = common.HexToAddress("0x0000000000000000000000000000000000000044")
+		investor        = common.HexToAddress("0x0000000000000000000000000000000000000022")
+		lendingToken    = common.HexToAddress(common.TomoNativeAddress)
+		collateralToken = common.HexToAddress("0x0000000000000000000000000000000000000033")
+		lockAddress     = common.HexToAddress(common.LendingLockAddress)
+		term            = uint64(30 * 86400)
+		now             = uint64(1000000)
+		amount          = new(big.Int).Mul(big.NewInt(1000), common.BasePrice)
+		locked          = new(big.Int).Mul(big.NewInt(1500), common.BasePrice)
+		price           = big.NewInt(2200000000000000000) // 2.2
+	)
+	setLendingList(statedb, lendingstate.SupportedBaseSlot, lendingToken.Hash())
+	setLendingList(statedb, lendingstate.SupportedTermSlot, common.BigToHash(new(big.Int).SetUint64(term)))
+	setLendingList(statedb, lendingstate.DefaultCollateralSlot, collateralToken.Hash())
+	collateral := lendingstate.GetLocMappingAtKey(collateralToken.Hash(), lendingstate.CollateralMapSlot)
+	for name, rate := range map[string]int64{"depositRate": 150, "liquidationRate": 110, "recallRate": 200} {
+		loc := state.GetLocOfStructElement(collateral, lendingstate.CollateralStructSlots[name])
+		statedb.SetState(common.HexToAddress(common.LendingRegistrationSMC), loc, common.BigToHash(big.NewInt(rate)))
+	}
+	statedb.SetNonce(collateralToken, 1)
+	lendingstate.AddTokenBalance(lockAddress, locked, collateralToken, statedb)
+
+	lendingBook := lendingstate.GetLendingOrderBookHash(lendingToken, term)
+	trade := lendingstate.LendingTrade{
+		Borrower:               borrower,
+		Investor:               investor,
+		LendingToken:           lendingToken,
+		CollateralToken:        collateralToken,
+		Term:                   term,
+		Interest:               10 * common.BaseLendingInterest.Uint64(),
+		LiquidationPrice:       price,
+		CollateralLockedAmount: locked,
+		LiquidationTime:        now + term,
+		Amount:                 amount,
+		TradeId:                1,
+		Hash:                   common.HexToHash("0x01"),
+	}
+	lendingState.InsertTradingItem(lendingBook, trade.TradeId, trade)
+	lendingState.InsertLiquidationTime(lendingBook, new(big.Int).SetUint64(trade.LiquidationTime), trade.TradeId)
+	tradingState.InsertLiquidationPrice(tradingstate.GetTradingOrderBookHash(collateralToken, lendingToken), price, lendingBook, trade.TradeId)
+	lendingState.IntermediateRoot()
+	tradingState.IntermediateRoot()
+
+	l := &Lending{}
+	header := &types.Header{Number: big.NewInt(1), Time: new(big.Int).SetUint64(now)}
+	tests := []struct {
+		price      int64
+		liquidated int
+	}{
+		{2300000000000000000, 0},
+		{2100000000000000000, 1},
+	}
+	for i, tt := range tests {
+		overrides := map[common.Address]*big.Int{collateralToken: big.NewInt(tt.price)}
+		result, err := l.SimulateLiquidations(header, testChain{}, statedb, tradingState, lendingState, lendingToken, term, overrides)
+		if err != nil {
+			t.Fatalf("test %d: failed to simulate liquidations: %v", i, err)
+		}
+		if len(result.Liquidated) != tt.liquidated {
+			t.Errorf("test %d: liquidated trades mismatch: have %d, want %d", i, len(result.Liquidated), tt.liquidated)
+		}
+	}
+	// the states the simulation ran on must be left untouched
+	if stored := lendingState.GetLendingTrade(lendingBook, common.Uint64ToHash(trade.TradeId)); stored.TradeId != trade.TradeId {
+		t.Errorf("simulated liquidation removed the trade")
+	}
+	if balance := lendingstate.GetTokenBalance(lockAddress, collateralToken, statedb); balance.Cmp(locked) != 0 {
+		t.Errorf("locked collateral mismatch: have %v, want %v", balance, locked)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2018 Tomochain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tomoxlending
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/tomox"
+	"github.com/tomochain/tomochain/tomoxlending/lendingstate"
+)
+
+// GetRelayerIncome returns the lending fees earned by the relayer on the trades
+// opened in [from, to), per lending token. Lending trades are only kept by SDK
+// nodes.
+func (l *Lending) GetRelayerIncome(relayer common.Address, from, to time.Time) (map[common.Address]*big.Int, error) {
+	return l.tomox.SumRelayerIncome(relayer, from, to, &lendingstate.LendingTrade{}, func(items interface{}) []tomox.RelayerFee {
+		var fees []tomox.RelayerFee
+		for _, trade := range items.([]*lendingstate.LendingTrade) {
+			fees = append(fees,
+				tomox.RelayerFee{Relayer: trade.BorrowingRelayer, Token: trade.LendingToken, Amount: trade.BorrowingFee},
+				tomox.RelayerFee{Relayer: trade.InvestingRelayer, Token: trade.LendingToken, Amount: trade.InvestingFee},
+			)
+		}
+		return fees
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/core/state"
 	"github.com/tomochain/tomochain/log"
+	"github.com/tomochain/tomochain/metrics"
 	"github.com/tomochain/tomochain/rpc"
 )
 
@@ -35,6 +36,11 @@ var (
 	ErrNonceTooLow  = errors.New("nonce too low")
 )
 
+var (
+	processOrdersTimer = metrics.NewRegisteredTimer("tomox/lending/process/orders", nil)
+	liquidationTimer   = metrics.NewRegisteredTimer("tomox/lending/liquidation", nil)
+)
+
 type Lending struct {
 	Triegc     *prque.Prque          // Priority queue mapping block numbers to tries to gc
 	StateCache lendingstate.Database // State database to reuse between imports (contains state cache)    *lendingstate.TradingStateDB
@@ -44,6 +50,7 @@ type Lending struct {
 	tomox               *tomox.TomoX
 	lendingItemHistory  *lru.Cache
 	lendingTradeHistory *lru.Cache
+	marketStatsHistory  *lru.Cache
 }
 
 func (l *Lending) Protocols() []p2p.Protocol {
@@ -64,11 +71,13 @@ func (l *Lending) Stop() error {
 func New(tomox *tomox.TomoX) *Lending {
 	itemCache, _ := lru.New(defaultCacheLimit)
 	lendingTradeCache, _ := lru.New(defaultCacheLimit)
+	marketStatsCache, _ := lru.New(defaultCacheLimit)
 	lending := &Lending{
 		orderNonce:          make(map[common.Address]*big.Int),
 		Triegc:              prque.New(),
 		lendingItemHistory:  itemCache,
 		lendingTradeHistory: lendingTradeCache,
+		marketStatsHistory:  marketStatsCache,
 	}
 	lending.StateCache = lendingstate.NewDatabase(tomox.GetLevelDB())
 	lending.tomox = tomox
@@ -103,6 +112,7 @@ func (l *Lending) Version() uint64 {
 func (l *Lending) ProcessOrderPending(header *types.Header, coinbase common.Address, chain consensus.ChainContext, pending map[common.Address]types.LendingTransactions, statedb *state.StateDB, lendingStatedb *lendingstate.LendingStateDB, tradingStateDb *tradingstate.TradingStateDB) ([]*lendingstate.LendingItem, map[common.Hash]lendingstate.MatchingResult) {
 	lendingItems := []*lendingstate.LendingItem{}
 	matchingResults := map[common.Hash]lendingstate.MatchingResult{}
+	defer processOrdersTimer.UpdateSince(time.Now())
 
 	txs := types.NewLendingTransactionByNonce(types.LendingTxSigner{}, pending)
 	for {
@@ -269,10 +279,15 @@ func (l *Lending) SyncDataToSDKNode(chain consensus.ChainContext, statedb *state
 		if tradeRecord == nil {
 			continue
 		}
-		if updatedTakerLendingItem.Type == lendingstate.Repay || updatedTakerLendingItem.Type == lendingstate.TopUp || updatedTakerLendingItem.Type == lendingstate.Recall {
+		// a rollover closes the rolled trade and opens a new one, filling an investing order
+		isRolledTrade := updatedTakerLendingItem.Type == lendingstate.Rollover && tradeRecord.Status == lendingstate.TradeStatusOpen
+		if !isRolledTrade && (updatedTakerLendingItem.Type == lendingstate.Repay || updatedTakerLendingItem.Type == lendingstate.PartialRepay || updatedTakerLendingItem.Type == lendingstate.TopUp || updatedTakerLendingItem.Type == lendingstate.Recall || updatedTakerLendingItem.Type == lendingstate.Rollover || updatedTakerLendingItem.Type == lendingstate.BasketTopUp || updatedTakerLendingItem.Type == lendingstate.Transfer) {
 			// repay, topup: assign hash = trade.hash
 			updatedTakerLendingItem.Hash = tradeRecord.Hash
-			updatedTakerLendingItem.CollateralToken = tradeRecord.CollateralToken
+			if updatedTakerLendingItem.Type != lendingstate.BasketTopUp {
+				// a basket topUp item keeps the pledged token
+				updatedTakerLendingItem.CollateralToken = tradeRecord.CollateralToken
+			}
 			updatedTakerLendingItem.FilledAmount = updatedTakerLendingItem.Quantity
 			updatedTakerLendingItem.Interest = new(big.Int).SetUint64(tradeRecord.Interest)
 			switch updatedTakerLendingItem.Type {
@@ -286,17 +301,40 @@ func (l *Lending) SyncDataToSDKNode(chain consensus.ChainContext, statedb *state
 				updatedTakerLendingItem.ExtraData = string(extraData)
 				// manual topUp item
 				updatedTakerLendingItem.AutoTopUp = false
-			case lendingstate.Repay:
+			case lendingstate.Repay, lendingstate.PartialRepay:
 				updatedTakerLendingItem.Status = lendingstate.Repay
-				paymentBalance := lendingstate.CalculateTotalRepayValue(block.Time().Uint64(), tradeRecord.LiquidationTime, tradeRecord.Term, tradeRecord.Interest, tradeRecord.Amount)
-				updatedTakerLendingItem.Quantity = paymentBalance
-				updatedTakerLendingItem.FilledAmount = paymentBalance
+				// a partially repaid trade stays open, the item keeps the repaid quantity
+				if tradeRecord.Status != lendingstate.TradeStatusOpen {
+					paymentBalance := lendingstate.CalculateTotalRepayValue(block.Time().Uint64(), tradeRecord.LiquidationTime, tradeRecord.Term, tradeRecord.Interest, tradeRecord.Amount)
+					// the profit of a closed trade follows the repay policy of the relayer
+					var repayData struct {
+						Profit *big.Int
+					}
+					if err := json.Unmarshal([]byte(tradeRecord.ExtraData), &repayData); err == nil && repayData.Profit != nil {
+						paymentBalance = new(big.Int).Add(tradeRecord.Amount, repayData.Profit)
+					}
+					updatedTakerLendingItem.Quantity = paymentBalance
+					updatedTakerLendingItem.FilledAmount = paymentBalance
+				}
 				// manual repay item
 				updatedTakerLendingItem.AutoTopUp = false
 			case lendingstate.Recall:
 				updatedTakerLendingItem.Status = lendingstate.Recall
 				// manual recall item
 				updatedTakerLendingItem.AutoTopUp = false
+			case lendingstate.BasketTopUp:
+				updatedTakerLendingItem.Status = lendingstate.BasketTopUp
+				updatedTakerLendingItem.ExtraData = tradeRecord.ExtraData
+				updatedTakerLendingItem.AutoTopUp = false
+			case lendingstate.Transfer:
+				// the trade now belongs to the buyer
+				updatedTakerLendingItem.Status = lendingstate.Transfer
+				updatedTakerLendingItem.ExtraData = tradeRecord.ExtraData
+			case lendingstate.Rollover:
+				updatedTakerLendingItem.Status = lendingstate.Rollover
+				updatedTakerLendingItem.Quantity = tradeRecord.Amount
+				updatedTakerLendingItem.FilledAmount = tradeRecord.Amount
+				updatedTakerLendingItem.AutoTopUp = false
 			}
 
 			log.Debug("UpdateLendingTrade:", "type", updatedTakerLendingItem.Type, "hash", tradeRecord.Hash.Hex(), "status", tradeRecord.Status, "tradeId", tradeRecord.TradeId)
@@ -320,7 +358,7 @@ func (l *Lending) SyncDataToSDKNode(chain consensus.ChainContext, statedb *state
 		// maker dirty order
 		makerFilledAmount := big.NewInt(0)
 		makerOrderHash := common.Hash{}
-		if updatedTakerLendingItem.Side == lendingstate.Borrowing {
+		if updatedTakerLendingItem.Side == lendingstate.Borrowing || isRolledTrade {
 			makerOrderHash = tradeRecord.InvestingOrderHash
 		} else {
 			makerOrderHash = tradeRecord.BorrowingOrderHash
@@ -363,7 +401,11 @@ func (l *Lending) SyncDataToSDKNode(chain consensus.ChainContext, statedb *state
 		"Interest", updatedTakerLendingItem.Interest, "quantity", updatedTakerLendingItem.Quantity, "filledAmount", updatedTakerLendingItem.FilledAmount, "status", updatedTakerLendingItem.Status,
 		"hash", updatedTakerLendingItem.Hash.Hex(), "txHash", updatedTakerLendingItem.TxHash.Hex())
 
-	if !(updatedTakerLendingItem.Type == lendingstate.Repay || updatedTakerLendingItem.Type == lendingstate.TopUp || updatedTakerLendingItem.Type == lendingstate.Recall) || updatedTakerLendingItem.Status != lendingstate.LendingStatusOpen {
+	if updatedTakerLendingItem.Type == lendingstate.Policy && updatedTakerLendingItem.Status == lendingstate.LendingStatusOpen {
+		// a policy isn't an order of the book
+		updatedTakerLendingItem.Status = lendingstate.Policy
+	}
+	if !(updatedTakerLendingItem.Type == lendingstate.Repay || updatedTakerLendingItem.Type == lendingstate.PartialRepay || updatedTakerLendingItem.Type == lendingstate.TopUp || updatedTakerLendingItem.Type == lendingstate.Recall || updatedTakerLendingItem.Type == lendingstate.Rollover || updatedTakerLendingItem.Type == lendingstate.BasketTopUp || updatedTakerLendingItem.Type == lendingstate.Transfer) || updatedTakerLendingItem.Status != lendingstate.LendingStatusOpen {
 		if err := db.PutObject(updatedTakerLendingItem.Hash, updatedTakerLendingItem); err != nil {
 			return fmt.Errorf("SDKNode: failed to put processed takerOrder. Hash: %s Error: %s", updatedTakerLendingItem.Hash.Hex(), err.Error())
 		}
@@ -482,7 +524,11 @@ func (l *Lending) UpdateLiquidatedTrade(blockTime uint64, result lendingstate.Fi
 
 	txhash := result.TxHash
 	txTime := time.Unix(int64(blockTime), 0).UTC()
-	if err := l.UpdateLendingTrade(trades, txhash, txTime); err != nil {
+	autoTopUps := make(map[common.Hash]bool, len(result.AutoTopUp))
+	for _, hash := range result.AutoTopUp {
+		autoTopUps[hash] = true
+	}
+	if err := l.updateLendingTrades(trades, txhash, txTime, autoTopUps); err != nil {
 		return err
 	}
 
@@ -623,6 +669,13 @@ func (l *Lending) UpdateLiquidatedTrade(blockTime uint64, result lendingstate.Fi
 }
 
 func (l *Lending) UpdateLendingTrade(trades map[common.Hash]*lendingstate.LendingTrade, txhash common.Hash, txTime time.Time) error {
+	return l.updateLendingTrades(trades, txhash, txTime, nil)
+}
+
+// updateLendingTrades stores the updated trades and accounts for them in the
+// lending market stats. autoTopUps are the hashes of the trades topped up by
+// the liquidation process.
+func (l *Lending) updateLendingTrades(trades map[common.Hash]*lendingstate.LendingTrade, txhash common.Hash, txTime time.Time, autoTopUps map[common.Hash]bool) error {
 	db := l.GetMongoDB()
 	hashQuery := []string{}
 	if len(trades) == 0 {
@@ -631,40 +684,48 @@ func (l *Lending) UpdateLendingTrade(trades map[common.Hash]*lendingstate.Lendin
 	for _, trade := range trades {
 		hashQuery = append(hashQuery, trade.Hash.Hex())
 	}
+	prevTrades := map[common.Hash]*lendingstate.LendingTrade{}
 	items := db.GetListItemByHashes(hashQuery, &lendingstate.LendingTrade{})
 	if items != nil && len(items.([]*lendingstate.LendingTrade)) > 0 {
 		for _, trade := range items.([]*lendingstate.LendingTrade) {
 			history := lendingstate.LendingTradeHistoryItem{
 				TxHash:                 trade.TxHash,
+				Amount:                 trade.Amount,
 				CollateralLockedAmount: trade.CollateralLockedAmount,
 				LiquidationPrice:       trade.LiquidationPrice,
 				Status:                 trade.Status,
 				UpdatedAt:              trade.UpdatedAt,
 			}
 			l.UpdateLendingTradeCache(trade.Hash, txhash, history)
+			prevTrade := *trade
+			prevTrades[trade.Hash] = &prevTrade
 			trade.TxHash = txhash
 			trade.UpdatedAt = txTime
 
 			newTrade := trades[trade.Hash]
+			trade.Amount = newTrade.Amount
 			trade.CollateralLockedAmount = newTrade.CollateralLockedAmount
 			trade.Status = newTrade.Status
 			trade.LiquidationPrice = newTrade.LiquidationPrice
 			trade.ExtraData = newTrade.ExtraData
+			trade.Investor = newTrade.Investor
 
 			if err := db.PutObject(trade.Hash, trade); err != nil {
 				return err
 			}
 		}
 		log.Debug("UpdateLendingTrade successfully", "txhash", txhash, "hash", hashQuery)
-	} else {
-		// not update, just upsert
-		for _, trade := range trades {
-			if err := db.PutObject(trade.Hash, trade); err != nil {
-				return err
-			}
+	}
+	// not update, just upsert
+	for hash, trade := range trades {
+		if _, ok := prevTrades[hash]; ok {
+			continue
+		}
+		if err := db.PutObject(trade.Hash, trade); err != nil {
+			return err
 		}
 	}
-	return nil
+	return l.updateMarketStats(txhash, txTime, prevTrades, trades, autoTopUps)
 }
 
 func (l *Lending) GetLendingState(block *types.Block, author common.Address) (*lendingstate.LendingStateDB, error) {
@@ -806,6 +867,9 @@ func (l *Lending) RollbackLendingData(txhash common.Hash) error {
 			}
 			trade.TxHash = lendingTradeHistoryItem.TxHash
 			trade.Status = lendingTradeHistoryItem.Status
+			if lendingTradeHistoryItem.Amount != nil {
+				trade.Amount = lendingstate.CloneBigInt(lendingTradeHistoryItem.Amount)
+			}
 			trade.CollateralLockedAmount = lendingstate.CloneBigInt(lendingTradeHistoryItem.CollateralLockedAmount)
 			trade.LiquidationPrice = lendingstate.CloneBigInt(lendingTradeHistoryItem.LiquidationPrice)
 			trade.UpdatedAt = lendingTradeHistoryItem.UpdatedAt
@@ -816,6 +880,22 @@ func (l *Lending) RollbackLendingData(txhash common.Hash) error {
 		}
 	}
 
+	// rollback lending market stats
+	if c, ok := l.marketStatsHistory.Get(txhash); ok {
+		for hash, prev := range c.(map[common.Hash]*lendingstate.LendingMarketStats) {
+			if prev == nil {
+				if err := db.DeleteObject(hash, &lendingstate.LendingMarketStats{}); err != nil {
+					return fmt.Errorf("failed to remove reorg LendingMarketStats. Err: %v . Hash: %s", err.Error(), hash.Hex())
+				}
+				continue
+			}
+			if err := db.PutObject(hash, prev); err != nil {
+				return fmt.Errorf("failed to update reorg LendingMarketStats. Err: %v . Hash: %s", err.Error(), hash.Hex())
+			}
+		}
+		l.marketStatsHistory.Remove(txhash)
+	}
+
 	// remove repay/topup/recall history
 	db.DeleteItemByTxHash(txhash, &lendingstate.LendingItem{Type: lendingstate.Repay})
 	db.DeleteItemByTxHash(txhash, &lendingstate.LendingItem{Type: lendingstate.TopUp})
@@ -828,6 +908,14 @@ func (l *Lending) RollbackLendingData(txhash common.Hash) error {
 }
 
 func (l *Lending) ProcessLiquidationData(header *types.Header, chain consensus.ChainContext, statedb *state.StateDB, tradingState *tradingstate.TradingStateDB, lendingState *lendingstate.LendingStateDB) (updatedTrades map[common.Hash]*lendingstate.LendingTrade, liquidatedTrades, autoRepayTrades, autoTopUpTrades, autoRecallTrades []*lendingstate.LendingTrade, err error) {
+	defer liquidationTimer.UpdateSince(time.Now())
+	return l.processLiquidationData(header, chain, statedb, tradingState, lendingState, nil)
+}
+
+// processLiquidationData liquidates, repays, tops up and recalls the lending
+// trades. The collateral prices of the pairs in priceOverrides, keyed by their
+// trading orderbook, are used instead of the ones of GetCollateralPrices.
+func (l *Lending) processLiquidationData(header *types.Header, chain consensus.ChainContext, statedb *state.StateDB, tradingState *tradingstate.TradingStateDB, lendingState *lendingstate.LendingStateDB, priceOverrides map[common.Hash]*big.Int) (updatedTrades map[common.Hash]*lendingstate.LendingTrade, liquidatedTrades, autoRepayTrades, autoTopUpTrades, autoRecallTrades []*lendingstate.LendingTrade, err error) {
 	time := header.Time
 	updatedTrades = map[common.Hash]*lendingstate.LendingTrade{} // sum of liquidatedTrades, autoRepayTrades, autoTopUpTrades, autoRecallTrades
 	liquidatedTrades = []*lendingstate.LendingTrade{}
@@ -853,7 +941,7 @@ func (l *Lending) ProcessLiquidationData(header *types.Header, chain consensus.C
 		for lowestTime.Sign() > 0 && lowestTime.Cmp(time) < 0 {
 			for _, tradingId := range tradingIds {
 				log.Debug("ProcessRepay", "lowestTime", lowestTime, "time", time, "lendingBook", lendingBook.Hex(), "tradingId", tradingId.Hex())
-				trade, err := l.ProcessRepayLendingTrade(header, chain, lendingState, statedb, tradingState, lendingBook, tradingId.Big().Uint64())
+				trade, err := l.processExpiredTrade(header, chain, lendingState, statedb, tradingState, lendingBook, tradingId.Big().Uint64())
 				if err != nil {
 					log.Error("Fail when process payment ", "time", time, "lendingBook", lendingBook.Hex(), "tradingId", tradingId, "error", err)
 					return updatedTrades, liquidatedTrades, autoRepayTrades, autoTopUpTrades, autoRecallTrades, err
@@ -871,9 +959,15 @@ func (l *Lending) ProcessLiquidationData(header *types.Header, chain consensus.C
 		}
 	}
 
+	epoch := policyEpoch(header, chain)
 	for _, lendingPair := range allPairs {
 		orderbook := tradingstate.GetTradingOrderBookHash(lendingPair.CollateralToken, lendingPair.LendingToken)
-		_, collateralPrice, err := l.GetCollateralPrices(header, chain, statedb, tradingState, lendingPair.CollateralToken, lendingPair.LendingToken)
+		var collateralPrice *big.Int
+		if price, ok := priceOverrides[orderbook]; ok {
+			collateralPrice, err = price, nil
+		} else {
+			_, collateralPrice, err = l.GetCollateralPrices(header, chain, statedb, tradingState, lendingPair.CollateralToken, lendingPair.LendingToken)
+		}
 		if err != nil || collateralPrice == nil || collateralPrice.Sign() == 0 {
 			log.Error("Fail when get price collateral/lending ", "CollateralToken", lendingPair.CollateralToken.Hex(), "LendingToken", lendingPair.LendingToken.Hex(), "error", err)
 			// ignore this pair, do not throw error
@@ -882,11 +976,18 @@ func (l *Lending) ProcessLiquidationData(header *types.Header, chain consensus.C
 		// liquidate trades
 		highestLiquidatePrice, liquidationData := tradingState.GetHighestLiquidationPriceData(orderbook, collateralPrice)
 		for highestLiquidatePrice.Sign() > 0 && collateralPrice.Cmp(highestLiquidatePrice) < 0 {
-			for lendingBook, tradingIds := range liquidationData {
+			// the trades of a borrower share its balance and policies, process them in a deterministic order
+			for _, lendingBook := range sortedLendingBooks(liquidationData) {
+				tradingIds := liquidationData[lendingBook]
 				for _, tradingIdHash := range tradingIds {
 					trade := lendingState.GetLendingTrade(lendingBook, tradingIdHash)
 					if trade.AutoTopUp {
-						if newTrade, err := l.AutoTopUp(statedb, tradingState, lendingState, lendingBook, tradingIdHash, collateralPrice); err == nil {
+						policyHash, policy := tradePolicy(header, chain, lendingState, lendingBook, &trade)
+						if newTrade, err := l.AutoTopUp(statedb, tradingState, lendingState, lendingBook, tradingIdHash, collateralPrice, chain.Config().IsTIPTomoXPartialRepay(header.Number), policy, epoch); err == nil {
+							if policy != nil {
+								policy.AddTopUp(epoch, new(big.Int).Sub(newTrade.CollateralLockedAmount, trade.CollateralLockedAmount))
+								lendingState.SetBorrowerPolicy(policyHash, policy)
+							}
 							// if this action complete successfully, do not liquidate this trade in this epoch
 							log.Debug("AutoTopUp", "borrower", trade.Borrower.Hex(), "collateral", newTrade.CollateralToken.Hex(), "tradingIdHash", tradingIdHash.Hex(), "newLockedAmount", newTrade.CollateralLockedAmount)
 							autoTopUpTrades = append(autoTopUpTrades, newTrade)
@@ -894,6 +995,18 @@ func (l *Lending) ProcessLiquidationData(header *types.Header, chain consensus.C
 							continue
 						}
 					}
+					if chain.Config().IsTIPTomoXPartialLiquidation(header.Number) {
+						newTrade, err := l.PartialLiquidationTrade(lendingState, statedb, tradingState, lendingBook, tradingIdHash.Big().Uint64(), collateralPrice)
+						if err != nil {
+							log.Error("Fail when liquidate trade partially", "time", time, "lendingBook", lendingBook.Hex(), "tradingIdHash", tradingIdHash.Hex(), "error", err)
+							return updatedTrades, liquidatedTrades, autoRepayTrades, autoTopUpTrades, autoRecallTrades, err
+						}
+						if newTrade != nil {
+							liquidatedTrades = append(liquidatedTrades, newTrade)
+							updatedTrades[newTrade.Hash] = newTrade
+							continue
+						}
+					}
 					log.Debug("LiquidationTrade", "highestLiquidatePrice", highestLiquidatePrice, "lendingBook", lendingBook.Hex(), "tradingIdHash", tradingIdHash.Hex())
 					newTrade, err := l.LiquidationTrade(lendingState, statedb, tradingState, lendingBook, tradingIdHash.Big().Uint64())
 					if err != nil {
@@ -932,10 +1045,18 @@ func (l *Lending) ProcessLiquidationData(header *types.Header, chain consensus.C
 						log.Debug("Process Recall", "price", price, "lendingBook", lendingBook, "tradingIdHash", tradingIdHash.Hex())
 						trade := lendingState.GetLendingTrade(lendingBook, tradingIdHash)
 						log.Debug("TestRecall", "borrower", trade.Borrower.Hex(), "lendingToken", trade.LendingToken.Hex(), "collateral", trade.CollateralToken.Hex(), "price", price, "tradingIdHash", tradingIdHash.Hex())
-						if trade.AutoTopUp {
-							err, _, newTrade := l.ProcessRecallLendingTrade(lendingState, statedb, tradingState, lendingBook, tradingIdHash, newLiquidatePrice)
+						autoRecall, recallPrice := trade.AutoTopUp, newLiquidatePrice
+						if _, policy := tradePolicy(header, chain, lendingState, lendingBook, &trade); policy != nil {
+							if target := policy.TargetLiquidationPrice(collateralPrice, trade.LiquidationRate); target != nil {
+								recallPrice = target
+							}
+							// a target under the liquidation price of the trade leaves nothing to recall
+							autoRecall = policy.AutoRecall && recallPrice.Cmp(trade.LiquidationPrice) > 0
+						}
+						if autoRecall {
+							err, _, newTrade := l.ProcessRecallLendingTrade(lendingState, statedb, tradingState, lendingBook, tradingIdHash, recallPrice)
 							if err != nil {
-								log.Error("ProcessRecallLendingTrade", "lendingBook", lendingBook.Hex(), "tradingIdHash", tradingIdHash.Hex(), "newLiquidatePrice", newLiquidatePrice, "err", err)
+								log.Error("ProcessRecallLendingTrade", "lendingBook", lendingBook.Hex(), "tradingIdHash", tradingIdHash.Hex(), "newLiquidatePrice", recallPrice, "err", err)
 								return updatedTrades, liquidatedTrades, autoRepayTrades, autoTopUpTrades, autoRecallTrades, err
 							}
 							// if this action complete successfully, do not liquidate this trade in this epoch
@@ -949,6 +1070,39 @@ func (l *Lending) ProcessLiquidationData(header *types.Header, chain consensus.C
 		}
 	}
 
+	// liquidate basket positions, they are priced with all their collaterals
+	if chain.Config().IsTIPTomoXMultiCollateral(header.Number) {
+		for _, position := range lendingstate.GetBasketPositions(statedb) {
+			trade := lendingState.GetLendingTrade(position.LendingBook, common.Uint64ToHash(position.TradeId))
+			if trade.TradeId != position.TradeId {
+				continue
+			}
+			basket, err := l.GetBasketValue(header, chain, statedb, tradingState, position.LendingBook, &trade, priceOverrides)
+			if err != nil {
+				log.Error("Fail when get basket value", "lendingBook", position.LendingBook.Hex(), "tradeId", position.TradeId, "error", err)
+				continue
+			}
+			if basket.Incomplete {
+				// an unknown price must not liquidate a healthy position
+				log.Warn("Skip liquidating basket with unknown prices", "lendingBook", position.LendingBook.Hex(), "tradeId", position.TradeId)
+				continue
+			}
+			if basket.Threshold.Cmp(l.repayValue(header, chain, statedb, &trade)) >= 0 {
+				continue
+			}
+			log.Debug("LiquidateBasket", "lendingBook", position.LendingBook.Hex(), "tradeId", position.TradeId, "value", basket.Value, "threshold", basket.Threshold)
+			newTrade, err := l.LiquidateBasket(header, chain, lendingState, statedb, tradingState, position.LendingBook, position.TradeId, priceOverrides, lendingstate.LiquidatedByPrice)
+			if err != nil {
+				log.Error("Fail when liquidate basket", "lendingBook", position.LendingBook.Hex(), "tradeId", position.TradeId, "error", err)
+				return updatedTrades, liquidatedTrades, autoRepayTrades, autoTopUpTrades, autoRecallTrades, err
+			}
+			if newTrade != nil && newTrade.Hash != (common.Hash{}) {
+				liquidatedTrades = append(liquidatedTrades, newTrade)
+				updatedTrades[newTrade.Hash] = newTrade
+			}
+		}
+	}
+
 	log.Debug("ProcessLiquidationData", "updatedTrades", len(updatedTrades), "liquidated", len(liquidatedTrades), "autoRepay", len(autoRepayTrades), "autoTopUp", len(autoTopUpTrades), "autoRecall", len(autoRecallTrades))
 	return updatedTrades, liquidatedTrades, autoRepayTrades, autoTopUpTrades, autoRecallTrades, nil
 }
@@ -113,6 +113,13 @@ func MustDecodeNode(hash, buf []byte) Node {
 	return n
 }
 
+// DecodeNode parses the RLP encoding of a trie Node, returning an error instead
+// of panicking if the blob is not a valid node (e.g. contract code sharing the
+// same key space).
+func DecodeNode(hash, buf []byte) (Node, error) {
+	return decodeNode(hash, buf)
+}
+
 // decodeNode parses the RLP encoding of a trie Node.
 func decodeNode(hash, buf []byte) (Node, error) {
 	if len(buf) == 0 {